@@ -0,0 +1,197 @@
+// Package audit makes the audit log tamper-evident.
+//
+// Events are chained per tenant: every event stores the SHA-256 hash of the
+// previous event in its tenant, and its own hash covers that link, so
+// editing or deleting an event breaks every hash after it. Because someone
+// with database access could recompute the whole chain, the server also
+// signs the head of each chain with an Ed25519 key from time to time. Verify
+// walks the chains and checks them against those signed checkpoints.
+package audit
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/David-mwas/SafeEnv/store"
+)
+
+// SystemTenant holds events that belong to no user, e.g. failed logins for
+// unknown email addresses.
+const SystemTenant = "system"
+
+// ErrNoSigningKey is returned by Checkpoint when no signing key is configured.
+var ErrNoSigningKey = errors.New("audit: no checkpoint signing key configured")
+
+// maxAppendAttempts bounds retries when another writer extends the chain first.
+const maxAppendAttempts = 5
+
+// Tenant returns the chain an event belongs to: the owner of the resource
+// it touched, falling back to the actor.
+func Tenant(e *store.AuditEvent) string {
+	switch {
+	case e.OwnerID != "":
+		return e.OwnerID
+	case e.ActorID != "":
+		return e.ActorID
+	default:
+		return SystemTenant
+	}
+}
+
+// Hash computes an event's chain hash. It covers every recorded field and
+// the previous hash, but not the store-assigned id. Fields are
+// length-prefixed so values can't bleed into each other.
+func Hash(e *store.AuditEvent) string {
+	h := sha256.New()
+	h.Write([]byte("safeenv-audit:v1"))
+	for _, f := range []string{
+		e.Tenant,
+		strconv.FormatInt(e.Seq, 10),
+		e.PrevHash,
+		e.ActorID,
+		e.OwnerID,
+		e.Action,
+		e.Resource,
+		e.IP,
+		e.UserAgent,
+		e.Outcome,
+		strconv.Itoa(e.Status),
+		e.Detail,
+		strconv.FormatInt(e.CreatedAt.UnixMilli(), 10),
+	} {
+		h.Write(binary.BigEndian.AppendUint32(nil, uint32(len(f))))
+		h.Write([]byte(f))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// KeyID is a short fingerprint of a checkpoint public key.
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// checkpointMessage is what a checkpoint signature covers.
+func checkpointMessage(cp *store.AuditCheckpoint) []byte {
+	return []byte(fmt.Sprintf("safeenv-audit-checkpoint:v1\n%s\n%d\n%s\n%d",
+		cp.Tenant, cp.Seq, cp.Hash, cp.CreatedAt.UnixMilli()))
+}
+
+// Log appends chained events to a store and signs checkpoints.
+type Log struct {
+	store  store.Store
+	signer ed25519.PrivateKey // nil disables checkpoints
+
+	mu sync.Mutex // serializes appends from this process
+}
+
+// New returns a Log writing to st. signer may be nil, in which case events
+// are still chained but no checkpoints are signed.
+func New(st store.Store, signer ed25519.PrivateKey) *Log {
+	return &Log{store: st, signer: signer}
+}
+
+// Append links e to its tenant's chain and stores it. Another server
+// extending the same chain concurrently makes the store reject the write,
+// in which case the link is recomputed and retried.
+func (l *Log) Append(ctx context.Context, e *store.AuditEvent) error {
+	e.Tenant = Tenant(e)
+	// Hash what the store can round-trip; MongoDB keeps milliseconds
+	e.CreatedAt = e.CreatedAt.UTC().Truncate(time.Millisecond)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for attempt := 0; attempt < maxAppendAttempts; attempt++ {
+		last, err := l.store.LastAudit(ctx, e.Tenant)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			e.Seq, e.PrevHash = 1, ""
+		case err != nil:
+			return err
+		default:
+			e.Seq, e.PrevHash = last.Seq+1, last.Hash
+		}
+		e.Hash = Hash(e)
+
+		err = l.store.AppendAudit(ctx, e)
+		if !errors.Is(err, store.ErrDuplicate) {
+			return err
+		}
+	}
+	return fmt.Errorf("audit: tenant %s chain kept changing, gave up after %d attempts", e.Tenant, maxAppendAttempts)
+}
+
+// Checkpoint signs the head of every chain that has grown since its last
+// checkpoint and reports how many checkpoints it wrote.
+func (l *Log) Checkpoint(ctx context.Context) (int, error) {
+	if l.signer == nil {
+		return 0, ErrNoSigningKey
+	}
+
+	tenants, err := l.store.AuditTenants(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	keyID := KeyID(l.signer.Public().(ed25519.PublicKey))
+	written := 0
+	for _, tenant := range tenants {
+		head, err := l.store.LastAudit(ctx, tenant)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return written, err
+		}
+
+		checkpoints, err := l.store.ListAuditCheckpoints(ctx, tenant)
+		if err != nil {
+			return written, err
+		}
+		if n := len(checkpoints); n > 0 && checkpoints[n-1].Seq >= head.Seq {
+			continue
+		}
+
+		cp := &store.AuditCheckpoint{
+			Tenant:    tenant,
+			Seq:       head.Seq,
+			Hash:      head.Hash,
+			KeyID:     keyID,
+			CreatedAt: time.Now().UTC().Truncate(time.Millisecond),
+		}
+		cp.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(l.signer, checkpointMessage(cp)))
+		if err := l.store.SaveAuditCheckpoint(ctx, cp); err != nil {
+			return written, err
+		}
+		written++
+	}
+	return written, nil
+}
+
+// Run signs checkpoints every interval until ctx is done. Errors are
+// reported through logf rather than stopping the loop.
+func (l *Log) Run(ctx context.Context, interval time.Duration, logf func(format string, args ...interface{})) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := l.Checkpoint(ctx); err != nil {
+				logf("Audit checkpoint failed: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package audit
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/David-mwas/SafeEnv/store"
+)
+
+// tamperedStore serves a tenant's chain and checkpoints from memory, edited
+// the way someone with write access to the database could.
+type tamperedStore struct {
+	store.Store
+	events      []*store.AuditEvent
+	checkpoints []*store.AuditCheckpoint
+}
+
+func (s *tamperedStore) ScanAudit(ctx context.Context, tenant string, afterSeq int64, limit int) ([]*store.AuditEvent, error) {
+	var out []*store.AuditEvent
+	for _, e := range s.events {
+		if e.Tenant == tenant && e.Seq > afterSeq && len(out) < limit {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+func (s *tamperedStore) ListAuditCheckpoints(ctx context.Context, tenant string) ([]*store.AuditCheckpoint, error) {
+	return s.checkpoints, nil
+}
+
+// newChain writes five events for alice to a fresh Bolt store, with
+// checkpoints signed at seq 3 and 5.
+func newChain(t *testing.T) (store.Store, ed25519.PublicKey) {
+	t.Helper()
+	st, err := store.OpenBolt(filepath.Join(t.TempDir(), "audit.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close(context.Background()) })
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := New(st, priv)
+	ctx := context.Background()
+
+	for i := 1; i <= 5; i++ {
+		err := log.Append(ctx, &store.AuditEvent{
+			ActorID:   "alice",
+			Action:    "variable.retrieve",
+			Resource:  fmt.Sprintf("keys/KEY_%d", i),
+			Outcome:   store.AuditSuccess,
+			Status:    200,
+			CreatedAt: time.Now(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 3 || i == 5 {
+			if n, err := log.Checkpoint(ctx); err != nil || n != 1 {
+				t.Fatalf("checkpoint: %d %v", n, err)
+			}
+		}
+	}
+	return st, pub
+}
+
+// load reads alice's chain into a tamperedStore.
+func load(t *testing.T, st store.Store) *tamperedStore {
+	t.Helper()
+	ctx := context.Background()
+	events, err := st.ScanAudit(ctx, "alice", 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkpoints, err := st.ListAuditCheckpoints(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tamperedStore{Store: st, events: events, checkpoints: checkpoints}
+}
+
+func TestVerifyUntouchedChain(t *testing.T) {
+	st, pub := newChain(t)
+	res, err := Verify(context.Background(), st, "alice", pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Broken != nil || res.Events != 5 || res.Checkpoints != 2 {
+		t.Fatalf("verify: %+v, broken %v", res, res.Broken)
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	st, pub := newChain(t)
+
+	tests := []struct {
+		name    string
+		tamper  func(s *tamperedStore)
+		wantSeq int64
+		want    string
+	}{
+		{
+			name:    "payload changed",
+			tamper:  func(s *tamperedStore) { s.events[1].Resource = "keys/SOMETHING_ELSE" },
+			wantSeq: 2,
+			want:    "contents do not match",
+		},
+		{
+			name:    "middle entry deleted",
+			tamper:  func(s *tamperedStore) { s.events = append(s.events[:2], s.events[3:]...) },
+			wantSeq: 3,
+			want:    "missing",
+		},
+		{
+			name:    "tail truncated after a checkpoint",
+			tamper:  func(s *tamperedStore) { s.events = s.events[:4] },
+			wantSeq: 5,
+			want:    "checkpoint covers seq 5",
+		},
+		{
+			// Recomputing the hashes keeps the chain consistent, but not
+			// with what was signed
+			name: "chain rewritten from an entry on",
+			tamper: func(s *tamperedStore) {
+				s.events[3].Detail = "nothing to see"
+				for i := 3; i < len(s.events); i++ {
+					s.events[i].PrevHash = s.events[i-1].Hash
+					s.events[i].Hash = Hash(s.events[i])
+				}
+			},
+			wantSeq: 5,
+			want:    "does not match checkpoint",
+		},
+		{
+			name: "checkpoint signature forged",
+			tamper: func(s *tamperedStore) {
+				_, other, err := ed25519.GenerateKey(rand.Reader)
+				if err != nil {
+					t.Fatal(err)
+				}
+				cp := s.checkpoints[1]
+				cp.Seq, cp.Hash = s.events[3].Seq, s.events[3].Hash
+				cp.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(other, checkpointMessage(cp)))
+			},
+			wantSeq: 4,
+			want:    "invalid signature",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := load(t, st)
+			tt.tamper(s)
+			res, err := Verify(context.Background(), s, "alice", pub)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Broken == nil {
+				t.Fatal("tampered chain verified")
+			}
+			if res.Broken.Seq != tt.wantSeq || !strings.Contains(res.Broken.Reason, tt.want) {
+				t.Fatalf("broken at %v, want seq %d: %q", res.Broken, tt.wantSeq, tt.want)
+			}
+		})
+	}
+}
@@ -1,7 +1,45 @@
+const TOKEN_KEY = "safeEnv";
+const REFRESH_KEY = "safeEnvRefresh";
+
+type Tokens = { token: string; refreshToken?: string; expiresIn?: number };
+
+let refreshTimer: ReturnType<typeof setTimeout> | undefined;
+
+// saveTokens stores a login or refresh response and schedules the next
+// refresh shortly before the access token expires.
+export const saveTokens = ({ token, refreshToken, expiresIn }: Tokens) => {
+  localStorage.setItem(TOKEN_KEY, token);
+  if (refreshToken) localStorage.setItem(REFRESH_KEY, refreshToken);
+
+  clearTimeout(refreshTimer);
+  if (refreshToken && expiresIn) {
+    refreshTimer = setTimeout(refreshSession, expiresIn * 800);
+  }
+};
+
+// refreshSession trades the stored refresh token for new tokens. Refresh
+// tokens work once, so a failure means the session is gone.
+export const refreshSession = async () => {
+  const refreshToken = localStorage.getItem(REFRESH_KEY);
+  if (!refreshToken) return;
+
+  const res = await fetch(`${import.meta.env.VITE_BACKEND_URL}/token/refresh`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ refreshToken }),
+  });
+  if (res.ok) {
+    saveTokens(await res.json());
+  } else if (res.status == 401) {
+    localStorage.removeItem(TOKEN_KEY);
+    localStorage.removeItem(REFRESH_KEY);
+  }
+};
+
 const useAuthToken = () => {
   const getItem = () => {
     if (typeof window !== "undefined") {
-      const token = localStorage?.getItem("safeEnv");
+      const token = localStorage?.getItem(TOKEN_KEY);
       //   const chatid = localStorage?.getItem("chatId");
       return { token }; // Return an object with token and chatid
     } else {
@@ -12,14 +50,28 @@ const useAuthToken = () => {
 
   const clearAuthToken = () => {
     if (typeof window !== "undefined") {
-      // Remove the token from local storage
-      localStorage.removeItem("safeEnv");
+      // Remove the tokens from local storage
+      clearTimeout(refreshTimer);
+      localStorage.removeItem(TOKEN_KEY);
+      localStorage.removeItem(REFRESH_KEY);
       //   localStorage.removeItem("chatId");
     }
   };
 
+  // End the session on the server too, so the refresh token stops working
+  const logout = async () => {
+    const token = localStorage.getItem(TOKEN_KEY);
+    if (token) {
+      await fetch(`${import.meta.env.VITE_BACKEND_URL}/logout`, {
+        method: "POST",
+        headers: { Authorization: `Bearer ${token}` },
+      }).catch(() => undefined);
+    }
+    clearAuthToken();
+  };
+
   // Return the token and functions to update and clear it
-  return { clearAuthToken, getItem };
+  return { clearAuthToken, getItem, logout };
 };
 
 export default useAuthToken;
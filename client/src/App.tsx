@@ -9,6 +9,9 @@ import { QueryClient, QueryClientProvider } from "@tanstack/react-query";
 import Key from "./components/[key]";
 import ForgotPasswordPage from "./pages/ForgotPasswordPage";
 import ResetPassword from "./pages/ResetPasswordPage";
+import SSOCallback from "./pages/SSOCallbackPage";
+import SSOLink from "./pages/SSOLinkPage";
+import AcceptInvite from "./pages/AcceptInvitePage";
 
 function App() {
   const queryClient = new QueryClient();
@@ -18,12 +21,15 @@ function App() {
         <Routes>
           <Route path="/" element={<HomePage />} />
           <Route path="/login" element={<LoginPage />} />
+          <Route path="/login/sso" element={<SSOCallback />} />
+          <Route path="/settings/sso" element={<SSOLink />} />
           <Route path="/register" element={<RegisterPage />} />
           <Route path="/shareregister" element={<ShareKeyRegister />} />
           <Route path="/sharelogin" element={<ShareKeyLogin />} />
           <Route path="/share/retrieve/:key" element={<Key />} />
           <Route path="/forgot-password" element={<ForgotPasswordPage />} />
           <Route path="/reset-password" element={<ResetPassword />} />
+          <Route path="/invite" element={<AcceptInvite />} />
           <Route path="*" element={<NotFound />} />
         </Routes>
       </Router>
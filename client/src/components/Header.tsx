@@ -7,12 +7,13 @@ import { useQuery } from "@tanstack/react-query";
 import { useState } from "react";
 import NavBar from "./NavBar";
 import toast from "react-hot-toast";
+import { passkeysSupported, registerPasskey } from "../lib/passkeys";
 
 function Header() {
   const [isOpen, setIsOpen] = useState(false);
 
   const navigate = useNavigate();
-  const { getItem, clearAuthToken } = useAuthToken();
+  const { getItem, clearAuthToken, logout } = useAuthToken();
   const { token } = getItem() || { user: null, token: null };
 
   const { data } = useQuery({
@@ -42,8 +43,20 @@ function Header() {
     enabled: !!token,
   });
 
-  const handleLogout = () => {
-    clearAuthToken();
+  const handleAddPasskey = async () => {
+    if (!token) return;
+    const name = window.prompt("Name this passkey", "My device");
+    if (name === null) return;
+    try {
+      await registerPasskey(token, name);
+      toast.success("Passkey added, you can now use it to log in");
+    } catch (err) {
+      toast.error("Failed to add passkey: " + (err instanceof Error ? err.message : err));
+    }
+  };
+
+  const handleLogout = async () => {
+    await logout();
     navigate("/login");
   };
 
@@ -90,6 +103,15 @@ function Header() {
           </div>
         </div>
 
+        {passkeysSupported() && (
+          <button
+            onClick={handleAddPasskey}
+            className="border border-green-600 px-4 py-2 text-white rounded-md hover:bg-gray-700 transition ml-4 hidden sm:flex"
+          >
+            Add passkey
+          </button>
+        )}
+
         <button
           onClick={handleLogout}
           className="bg-red-500 px-8 py-2 text-white rounded-md hover:bg-red-600 transition ml-4 hidden sm:flex"
@@ -2,9 +2,12 @@ import { useState } from "react";
 import { motion } from "framer-motion";
 import { useNavigate } from "react-router-dom";
 import axios from "axios";
+import { saveTokens } from "../../hooks/useAuth";
 import toast, { Toaster } from "react-hot-toast";
 import { FaEye, FaEyeSlash, FaSpinner } from "react-icons/fa";
 import { FlipText } from "./magicui/flip-text";
+import MFAForm, { type MFAChallenge } from "./MFAForm";
+import { loginWithPasskey, passkeysSupported } from "../lib/passkeys";
 
 function Login() {
   const [email, setEmail] = useState("");
@@ -12,6 +15,7 @@ function Login() {
   const [loading, setLoading] = useState(false);
   const [showPassword, setShowPassword] = useState(false);
   const [error, setError] = useState("");
+  const [mfa, setMfa] = useState<MFAChallenge | null>(null);
   const navigate = useNavigate();
 
   const handleLogin = async (e: React.FormEvent<HTMLFormElement>) => {
@@ -33,9 +37,11 @@ function Login() {
           password,
         }
       );
-      if (res.status == 200) {
+      if (res.status == 200 && res.data.mfaRequired) {
+        setMfa(res.data);
+      } else if (res.status == 200) {
         toast.success("Login successful");
-        localStorage.setItem("safeEnv", res.data.token);
+        saveTokens(res.data);
         window.location.href = "/";
       }
       if (res.status == 401) {
@@ -51,10 +57,46 @@ function Login() {
     }
   };
 
+  const handlePasskeyLogin = async () => {
+    setError("");
+    try {
+      const data = await loginWithPasskey(email || undefined);
+      if (data.mfaRequired) {
+        setMfa(data);
+        return;
+      }
+      toast.success("Login successful");
+      saveTokens(data);
+      window.location.href = "/";
+    } catch (err) {
+      setError("Passkey login failed: " + (err instanceof Error ? err.message : err));
+    }
+  };
+
+  const handleSSOLogin = async () => {
+    setError("");
+    try {
+      const res = await axios.post(`${import.meta.env.VITE_BACKEND_URL}/sso/begin`);
+      sessionStorage.setItem("ssoState", res.data.state);
+      sessionStorage.removeItem("ssoFlow");
+      window.location.href = res.data.authorizationUrl;
+    } catch (err) {
+      setError("Single sign-on is unavailable " + err);
+    }
+  };
+
   const toogleEye = () => {
     setShowPassword(!showPassword);
    }
 
+  if (mfa) {
+    return (
+      <div className="min-h-screen flex items-center justify-center bg-gray-900 text-white overflow-y-hidden">
+        <MFAForm {...mfa} redirectTo="/" />
+      </div>
+    );
+  }
+
   return (
     <div className="min-h-screen flex items-center justify-center bg-gray-900 text-white overflow-y-hidden">
       <Toaster />
@@ -122,6 +164,26 @@ function Login() {
           )}
         </button>
 
+        {passkeysSupported() && (
+          <button
+            type="button"
+            className="w-full mt-2 border border-green-600 py-2 rounded hover:bg-gray-700 transition"
+            onClick={handlePasskeyLogin}
+          >
+            Log in with a passkey
+          </button>
+        )}
+
+        {import.meta.env.VITE_OIDC_ENABLED === "true" && (
+          <button
+            type="button"
+            className="w-full mt-2 border border-green-600 py-2 rounded hover:bg-gray-700 transition"
+            onClick={handleSSOLogin}
+          >
+            Log in with SSO
+          </button>
+        )}
+
         <p className="mt-4 text-center text-sm">
           Don't have an account?
           <span
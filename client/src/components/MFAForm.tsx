@@ -0,0 +1,136 @@
+import { useEffect, useState } from "react";
+import { motion } from "framer-motion";
+import axios from "axios";
+import { saveTokens } from "../../hooks/useAuth";
+import { FaSpinner } from "react-icons/fa";
+
+export type MFAChallenge = { mfaToken: string; enrolled: boolean };
+
+type Props = MFAChallenge & { redirectTo: string };
+
+// MFAForm is the second login step: a code from the authenticator app or a
+// recovery code. Users who must use 2FA but haven't set it up enroll here.
+function MFAForm({ mfaToken, enrolled, redirectTo }: Props) {
+  const [code, setCode] = useState("");
+  const [useRecovery, setUseRecovery] = useState(false);
+  const [setup, setSetup] = useState<{ secret: string; uri: string } | null>(null);
+  const [recoveryCodes, setRecoveryCodes] = useState<string[]>([]);
+  const [loading, setLoading] = useState(false);
+  const [error, setError] = useState("");
+
+  useEffect(() => {
+    if (enrolled) return;
+    axios
+      .post(`${import.meta.env.VITE_BACKEND_URL}/login/mfa/enroll`, { mfaToken })
+      .then((res) => setSetup(res.data))
+      .catch((err) => setError(err.response?.data?.error ?? "Failed to start setup"));
+  }, [enrolled, mfaToken]);
+
+  const handleSubmit = async (e: React.FormEvent<HTMLFormElement>) => {
+    e.preventDefault();
+    setLoading(true);
+    setError("");
+
+    try {
+      const res = await axios.post(`${import.meta.env.VITE_BACKEND_URL}/login/mfa`, {
+        mfaToken,
+        ...(useRecovery ? { recoveryCode: code } : { code }),
+      });
+      saveTokens(res.data);
+      if (res.data.recoveryCodes) {
+        // shown once, so make the user acknowledge them before leaving
+        setRecoveryCodes(res.data.recoveryCodes);
+      } else {
+        window.location.href = redirectTo;
+      }
+    } catch (err) {
+      const message = axios.isAxiosError(err) ? err.response?.data?.error : undefined;
+      setError(message ?? "Invalid code");
+    } finally {
+      setLoading(false);
+      setCode("");
+    }
+  };
+
+  if (recoveryCodes.length > 0) {
+    return (
+      <div className="bg-gray-800 p-6 rounded-xl shadow-lg w-96">
+        <h2 className="text-green-500 font-extrabold text-lg mb-2">Recovery codes</h2>
+        <p className="text-sm mb-4">
+          Save these somewhere safe. Each one can be used once if you lose your authenticator.
+        </p>
+        <pre className="bg-gray-900 p-3 rounded mb-4">{recoveryCodes.join("\n")}</pre>
+        <button
+          className="w-full bg-green-600 py-2 rounded hover:bg-green-700 transition"
+          onClick={() => (window.location.href = redirectTo)}
+        >
+          I've saved them
+        </button>
+      </div>
+    );
+  }
+
+  return (
+    <motion.form
+      className="bg-gray-800 p-6 rounded-xl shadow-lg w-96"
+      initial={{ opacity: 0, scale: 0.9 }}
+      animate={{ opacity: 1, scale: 1 }}
+      onSubmit={(e) => handleSubmit(e)}
+    >
+      <h2 className="text-green-500 font-extrabold text-lg mb-2">Two-factor authentication</h2>
+
+      {error && <p className="text-red-500 text-sm text-center">{error}</p>}
+
+      {setup && (
+        <div className="text-sm mb-4">
+          <p className="mb-2">
+            This server requires two-factor authentication. Add this key to your authenticator app:
+          </p>
+          <code className="block bg-gray-900 p-2 rounded break-all">{setup.secret}</code>
+          <a className="text-blue-400 underline" href={setup.uri}>
+            Open in authenticator
+          </a>
+        </div>
+      )}
+
+      <label htmlFor="code" className="text-green-500 font-extrabold text-lg">
+        {useRecovery ? "Recovery code *" : "Code *"}
+      </label>
+      <input
+        id="code"
+        placeholder={useRecovery ? "xxxxx-xxxxx" : "123456"}
+        autoComplete="one-time-code"
+        required
+        value={code}
+        onChange={(e) => setCode(e.target.value)}
+        className="w-full border rounded text-white placeholder:text-white p-2 outline-0"
+      />
+
+      {enrolled && (
+        <p
+          className="mt-4 text-sm text-end underline cursor-pointer"
+          onClick={() => setUseRecovery(!useRecovery)}
+        >
+          {useRecovery ? "Use authenticator code" : "Use a recovery code"}
+        </p>
+      )}
+
+      <button
+        type="submit"
+        className="w-full mt-4 bg-green-600 py-2 rounded hover:bg-green-700 transition"
+        disabled={loading}
+      >
+        {loading ? (
+          <div className="flex items-center justify-center gap-2">
+            <FaSpinner className="animate-spin" />
+            Verifying ...
+          </div>
+        ) : (
+          "Verify"
+        )}
+      </button>
+    </motion.form>
+  );
+}
+
+export default MFAForm;
@@ -2,13 +2,16 @@ import { useState } from "react";
 import { motion } from "framer-motion";
 import { useNavigate } from "react-router-dom";
 import axios from "axios";
+import { saveTokens } from "../../hooks/useAuth";
 import { FaSpinner } from "react-icons/fa";
+import MFAForm, { type MFAChallenge } from "./MFAForm";
 
 function Login() {
   const [email, setEmail] = useState<string>("");
   const [password, setPassword] = useState<string>("");
   const [loading, setLoading] = useState<boolean>(false);
   const [error, setError] = useState<string>("");
+  const [mfa, setMfa] = useState<MFAChallenge | null>(null);
   const navigate = useNavigate();
 
   const sl: string | null = localStorage.getItem("sharelink");
@@ -33,8 +36,10 @@ function Login() {
         }
       );
 
-      if (res.status == 200) {
-        localStorage.setItem("safeEnv", res?.data?.token);
+      if (res.status == 200 && res.data.mfaRequired) {
+        setMfa(res.data);
+      } else if (res.status == 200) {
+        saveTokens(res.data);
         if (sl) {
           window.location.href = sl;
         } else {
@@ -50,6 +55,14 @@ function Login() {
     }
   };
 
+  if (mfa && sl) {
+    return (
+      <div className="min-h-screen flex items-center justify-center bg-gray-900 text-white">
+        <MFAForm {...mfa} redirectTo={sl} />
+      </div>
+    );
+  }
+
   return (
     <div className="min-h-screen flex items-center justify-center bg-gray-900 text-white">
       <motion.form
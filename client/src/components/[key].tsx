@@ -7,32 +7,80 @@ import axios from "axios";
 import { FaEye, FaEyeSlash, FaCopy } from "react-icons/fa";
 import { motion } from "framer-motion";
 
+// One-time shares arrive encrypted; the key is in the link's #fragment,
+// which browsers never send to the server.
+const fromBase64 = (b64: string) => {
+  const std = b64.replace(/-/g, "+").replace(/_/g, "/");
+  const padded = std + "=".repeat((4 - (std.length % 4)) % 4);
+  return Uint8Array.from(atob(padded), (c) => c.charCodeAt(0));
+};
+
+async function openOneTime(fragmentKey: string, ciphertext: string) {
+  const key = await crypto.subtle.importKey(
+    "raw",
+    fromBase64(fragmentKey),
+    "AES-GCM",
+    false,
+    ["decrypt"]
+  );
+  const sealed = fromBase64(ciphertext);
+  const plain = await crypto.subtle.decrypt(
+    { name: "AES-GCM", iv: sealed.slice(0, 12) },
+    key,
+    sealed.slice(12)
+  );
+  return new TextDecoder().decode(plain);
+}
+
 function Key() {
   const { key } = useParams();
   const location = useLocation();
   const [showValue, setShowValue] = useState(false);
   const [copied, setCopied] = useState(false); // Track copy status
+  const [passphrase, setPassphrase] = useState("");
+  const [submitted, setSubmitted] = useState<string | null>(null);
+  const [tries, setTries] = useState(0);
 
   const { getItem } = useAuthToken();
   const { token } = getItem() || { token: null };
 
-  const url = `${import.meta.env.VITE_FRONTEND_URL}${location.pathname}`;
+  const url = `${import.meta.env.VITE_FRONTEND_URL}${location.pathname}${location.hash}`;
   localStorage.setItem("sharelink", url);
 
   // Fetch shared key details
-  const { data } = useQuery({
-    queryKey: ["sharedEnvVar", key],
+  const { data, error } = useQuery({
+    queryKey: ["sharedEnvVar", key, submitted, tries],
     queryFn: async () => {
       if (!token) return null;
-      const res = await axios.get(
-        `${import.meta.env.VITE_BACKEND_URL}/share/retrieve/${key}`,
-        { headers: { Authorization: `Bearer ${token}` } }
-      );
+      // Passphrase protected shares take the passphrase in a POST body
+      const res = await axios.request({
+        method: submitted ? "POST" : "GET",
+        url: `${import.meta.env.VITE_BACKEND_URL}/share/retrieve/${key}`,
+        data: submitted ? { passphrase: submitted } : undefined,
+        headers: { Authorization: `Bearer ${token}` },
+      });
+      if (res.data.oneTime) {
+        const value = await openOneTime(
+          location.hash.slice(1),
+          res.data.ciphertext
+        );
+        return { key: res.data.key, value };
+      }
       return res.data;
     },
     enabled: !!token,
+    // A one-time share can only be fetched once
+    retry: false,
+    staleTime: Infinity,
+    refetchOnWindowFocus: false,
   });
 
+  const errorMessage = axios.isAxiosError(error)
+    ? error.response?.data?.error
+    : error && "Could not decrypt the shared key.";
+  const needsPassphrase =
+    axios.isAxiosError(error) && !!error.response?.data?.passphraseRequired;
+
   // Copy key to clipboard
   const copyToClipboard = () => {
     if (data?.value) {
@@ -53,6 +101,30 @@ function Key() {
           <h1 className="text-3xl font-bold mb-4 text-center">
             Shared Key Details
           </h1>
+          {needsPassphrase && (
+            <form
+              onSubmit={(e) => {
+                e.preventDefault();
+                setSubmitted(passphrase);
+                setTries((t) => t + 1);
+              }}
+              className="flex gap-2 mb-4"
+            >
+              <input
+                type="password"
+                value={passphrase}
+                onChange={(e) => setPassphrase(e.target.value)}
+                placeholder="Passphrase"
+                className="flex-1 p-2 rounded bg-gray-800 border border-gray-700"
+              />
+              <button
+                type="submit"
+                className="bg-blue-600 text-white px-4 py-2 rounded hover:bg-blue-700 transition"
+              >
+                Unlock
+              </button>
+            </form>
+          )}
           <div className="overflow-x-auto">
             <table className="w-full border-collapse border border-gray-700">
               <thead>
@@ -87,7 +159,7 @@ function Key() {
                 ) : (
                   <tr>
                     <td colSpan={3} className="p-4 text-center text-gray-400">
-                      No shared key found.
+                      {errorMessage || "No shared key found."}
                     </td>
                   </tr>
                 )}
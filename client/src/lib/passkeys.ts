@@ -0,0 +1,104 @@
+// WebAuthn helpers. The API sends and expects binary fields as base64url,
+// the browser APIs want ArrayBuffers.
+
+const API = import.meta.env.VITE_BACKEND_URL;
+
+const toBuffer = (s: string) => {
+  const b64 = s.replace(/-/g, "+").replace(/_/g, "/");
+  return Uint8Array.from(atob(b64.padEnd(Math.ceil(b64.length / 4) * 4, "=")), (c) =>
+    c.charCodeAt(0)
+  ).buffer;
+};
+
+const toBase64url = (buf: ArrayBuffer | null) =>
+  buf
+    ? btoa(String.fromCharCode(...new Uint8Array(buf)))
+        .replace(/\+/g, "-")
+        .replace(/\//g, "_")
+        .replace(/=+$/, "")
+    : undefined;
+
+type Descriptor = { id: string; type: "public-key"; transports?: AuthenticatorTransport[] };
+
+const descriptors = (list: Descriptor[] = []) =>
+  list.map((d) => ({ ...d, id: toBuffer(d.id) }));
+
+const post = async (path: string, body: unknown, token?: string) => {
+  const res = await fetch(`${API}${path}`, {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+      ...(token ? { Authorization: `Bearer ${token}` } : {}),
+    },
+    body: JSON.stringify(body),
+  });
+  const data = await res.json();
+  if (!res.ok) throw new Error(data.error ?? "Request failed");
+  return data;
+};
+
+export const passkeysSupported = () => typeof window.PublicKeyCredential !== "undefined";
+
+// registerPasskey adds a passkey to the logged in account.
+export const registerPasskey = async (token: string, name: string) => {
+  const { challengeId, publicKey } = await post("/passkeys/register/begin", {}, token);
+
+  const cred = (await navigator.credentials.create({
+    publicKey: {
+      ...publicKey,
+      challenge: toBuffer(publicKey.challenge),
+      user: { ...publicKey.user, id: toBuffer(publicKey.user.id) },
+      excludeCredentials: descriptors(publicKey.excludeCredentials),
+    },
+  })) as PublicKeyCredential;
+  const response = cred.response as AuthenticatorAttestationResponse;
+
+  return post(
+    "/passkeys/register/finish",
+    {
+      challengeId,
+      name,
+      credential: {
+        id: cred.id,
+        rawId: toBase64url(cred.rawId),
+        type: cred.type,
+        response: {
+          clientDataJSON: toBase64url(response.clientDataJSON),
+          attestationObject: toBase64url(response.attestationObject),
+          transports: response.getTransports?.() ?? [],
+        },
+      },
+    },
+    token
+  );
+};
+
+// loginWithPasskey runs the login ceremony and returns the same response
+// as a password login: tokens, or an mfaRequired challenge.
+export const loginWithPasskey = async (email?: string) => {
+  const { challengeId, publicKey } = await post("/login/passkey/begin", { email });
+
+  const cred = (await navigator.credentials.get({
+    publicKey: {
+      ...publicKey,
+      challenge: toBuffer(publicKey.challenge),
+      allowCredentials: descriptors(publicKey.allowCredentials),
+    },
+  })) as PublicKeyCredential;
+  const response = cred.response as AuthenticatorAssertionResponse;
+
+  return post("/login/passkey/finish", {
+    challengeId,
+    credential: {
+      id: cred.id,
+      rawId: toBase64url(cred.rawId),
+      type: cred.type,
+      response: {
+        clientDataJSON: toBase64url(response.clientDataJSON),
+        authenticatorData: toBase64url(response.authenticatorData),
+        signature: toBase64url(response.signature),
+        userHandle: toBase64url(response.userHandle),
+      },
+    },
+  });
+};
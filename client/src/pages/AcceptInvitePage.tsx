@@ -0,0 +1,116 @@
+import { useState } from "react";
+import { useSearchParams, useNavigate } from "react-router-dom";
+
+const AcceptInvite = () => {
+  const [username, setUsername] = useState("");
+  const [password, setPassword] = useState("");
+  const [confirmPassword, setConfirmPassword] = useState("");
+  const [register, setRegister] = useState(false);
+  const [error, setError] = useState("");
+  const [message, setMessage] = useState("");
+  const [loading, setLoading] = useState(false);
+  const [searchParams] = useSearchParams();
+  const navigate = useNavigate();
+
+  const token = searchParams.get("token");
+
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault();
+
+    if (register && password !== confirmPassword) {
+      setError("Passwords do not match!");
+      return;
+    }
+
+    setLoading(true);
+    setError("");
+    setMessage("");
+
+    try {
+      const response = await fetch(`${import.meta.env.VITE_BACKEND_URL}/invites/accept`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify(
+          register ? { token, username, password } : { token }
+        ),
+      });
+
+      const data = await response.json();
+
+      // New users pick a username and password first
+      if (data.register) {
+        setRegister(true);
+        return;
+      }
+
+      if (!response.ok) {
+        setError(data.error || "Failed to accept invite.");
+        return;
+      }
+
+      setMessage(`${data.message}! Redirecting to login...`);
+      setTimeout(() => navigate("/login"), 3000);
+    } catch (err: any) {
+      console.error(err);
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  return (
+    <div className="flex items-center justify-center min-h-screen bg-gray-900">
+      <div className="bg-white p-6 rounded-lg shadow-lg max-w-md w-full">
+        <h2 className="text-2xl font-semibold text-center">Join Organization</h2>
+        {error && <p className="text-red-500 text-sm mt-2">{error}</p>}
+        {message && <p className="text-green-500 text-sm mt-2">{message}</p>}
+
+        <form onSubmit={handleSubmit} className="mt-4">
+          {register && (
+            <>
+              <p className="text-sm text-gray-600 mb-4">
+                Create your account to accept the invitation.
+              </p>
+
+              <label className="block mb-2">Username</label>
+              <input
+                type="text"
+                className="w-full px-3 py-2 border rounded-md"
+                value={username}
+                onChange={(e) => setUsername(e.target.value)}
+                required
+              />
+
+              <label className="block mt-4 mb-2">Password</label>
+              <input
+                type="password"
+                className="w-full px-3 py-2 border rounded-md"
+                value={password}
+                onChange={(e) => setPassword(e.target.value)}
+                required
+              />
+
+              <label className="block mt-4 mb-2">Confirm Password</label>
+              <input
+                type="password"
+                className="w-full px-3 py-2 border rounded-md"
+                value={confirmPassword}
+                onChange={(e) => setConfirmPassword(e.target.value)}
+                required
+              />
+            </>
+          )}
+
+          <button
+            type="submit"
+            className="mt-4 w-full bg-blue-600 text-white py-2 rounded-md hover:bg-blue-700"
+            disabled={loading || !token}
+          >
+            {loading ? "Accepting..." : register ? "Create Account and Join" : "Accept Invitation"}
+          </button>
+        </form>
+      </div>
+    </div>
+  );
+};
+
+export default AcceptInvite;
@@ -0,0 +1,83 @@
+import { useEffect, useRef, useState } from "react";
+import { useNavigate, useSearchParams } from "react-router-dom";
+import { saveTokens } from "../../hooks/useAuth";
+import MFAForm, { type MFAChallenge } from "../components/MFAForm";
+
+// The identity provider redirects back here with ?code=...&state=...
+// Links are handed over to their own page, which finishes them with the
+// logged-in session; this page only logs in.
+const SSOCallback = () => {
+  const [searchParams] = useSearchParams();
+  const [error, setError] = useState("");
+  const [mfa, setMfa] = useState<MFAChallenge | null>(null);
+  const navigate = useNavigate();
+  const started = useRef(false);
+
+  useEffect(() => {
+    // a code can only be exchanged once, so don't run twice in strict mode
+    if (started.current) return;
+    started.current = true;
+
+    if (sessionStorage.getItem("ssoFlow") === "link") {
+      navigate(`/settings/sso?${searchParams.toString()}`, { replace: true });
+      return;
+    }
+
+    const code = searchParams.get("code");
+    const state = searchParams.get("state");
+    const expected = sessionStorage.getItem("ssoState");
+    sessionStorage.removeItem("ssoState");
+
+    if (searchParams.get("error")) {
+      setError(searchParams.get("error_description") || "Login was cancelled");
+      return;
+    }
+    if (!code || !state || state !== expected) {
+      setError("Login expired, please try again");
+      return;
+    }
+
+    (async () => {
+      try {
+        const res = await fetch(`${import.meta.env.VITE_BACKEND_URL}/sso/callback`, {
+          method: "POST",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify({ code, state }),
+        });
+        const data = await res.json();
+        if (!res.ok) {
+          setError(data.error || "Single sign-on failed");
+        } else if (data.mfaRequired) {
+          setMfa(data);
+        } else {
+          saveTokens(data);
+          window.location.href = "/";
+        }
+      } catch {
+        setError("Something went wrong. Please try again.");
+      }
+    })();
+  }, [searchParams, navigate]);
+
+  return (
+    <div className="min-h-screen flex items-center justify-center bg-gray-900 text-white">
+      {mfa ? (
+        <MFAForm {...mfa} redirectTo="/" />
+      ) : error ? (
+        <div className="bg-gray-800 p-6 rounded-xl shadow-lg w-96 text-center">
+          <p className="text-red-500 mb-4">{error}</p>
+          <button
+            className="w-full bg-green-600 py-2 rounded hover:bg-green-700 transition"
+            onClick={() => navigate("/login")}
+          >
+            Back to login
+          </button>
+        </div>
+      ) : (
+        <p>Logging in ...</p>
+      )}
+    </div>
+  );
+};
+
+export default SSOCallback;
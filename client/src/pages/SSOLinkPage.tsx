@@ -0,0 +1,103 @@
+import { useEffect, useRef, useState } from "react";
+import { useNavigate, useSearchParams } from "react-router-dom";
+import useAuthToken from "../../hooks/useAuth";
+
+const backend = import.meta.env.VITE_BACKEND_URL;
+
+// Links a single sign-on account to the logged-in user. Without a code it
+// offers to start the link; the provider's redirect is handed over here
+// from /login/sso with ?code=...&state=..., and the link is finished with
+// the same session that started it.
+const SSOLink = () => {
+  const [searchParams] = useSearchParams();
+  const [error, setError] = useState("");
+  const [message, setMessage] = useState("");
+  const navigate = useNavigate();
+  const started = useRef(false);
+
+  const code = searchParams.get("code");
+  const state = searchParams.get("state");
+  const token = useAuthToken().getItem()?.token;
+
+  const beginLink = async () => {
+    setError("");
+    try {
+      const res = await fetch(`${backend}/sso/link`, {
+        method: "POST",
+        headers: { Authorization: `Bearer ${token}` },
+      });
+      const data = await res.json();
+      if (!res.ok) {
+        setError(data.error || "Single sign-on is unavailable");
+        return;
+      }
+      sessionStorage.setItem("ssoState", data.state);
+      sessionStorage.setItem("ssoFlow", "link");
+      window.location.href = data.authorizationUrl;
+    } catch {
+      setError("Something went wrong. Please try again.");
+    }
+  };
+
+  useEffect(() => {
+    if (!code || started.current) return;
+    // a code can only be exchanged once, so don't run twice in strict mode
+    started.current = true;
+
+    const expected = sessionStorage.getItem("ssoState");
+    sessionStorage.removeItem("ssoState");
+    sessionStorage.removeItem("ssoFlow");
+    if (!token) {
+      setError("Log in to link single sign-on");
+      return;
+    }
+    if (!state || state !== expected) {
+      setError("Link expired, please try again");
+      return;
+    }
+
+    (async () => {
+      try {
+        const res = await fetch(`${backend}/sso/link/callback`, {
+          method: "POST",
+          headers: { "Content-Type": "application/json", Authorization: `Bearer ${token}` },
+          body: JSON.stringify({ code, state }),
+        });
+        const data = await res.json();
+        if (!res.ok) {
+          setError(data.error || "Linking single sign-on failed");
+        } else {
+          setMessage(data.message || "Single sign-on linked");
+        }
+      } catch {
+        setError("Something went wrong. Please try again.");
+      }
+    })();
+  }, [code, state, token]);
+
+  return (
+    <div className="min-h-screen flex items-center justify-center bg-gray-900 text-white">
+      <div className="bg-gray-800 p-6 rounded-xl shadow-lg w-96 text-center">
+        {error && <p className="text-red-500 mb-4">{error}</p>}
+        {message && <p className="text-green-500 mb-4">{message}</p>}
+        {!code && token && (
+          <button
+            className="w-full bg-green-600 py-2 mb-2 rounded hover:bg-green-700 transition"
+            onClick={beginLink}
+          >
+            Link single sign-on
+          </button>
+        )}
+        {code && !error && !message && <p>Linking ...</p>}
+        <button
+          className="w-full bg-gray-600 py-2 rounded hover:bg-gray-700 transition"
+          onClick={() => navigate(token ? "/" : "/login")}
+        >
+          {token ? "Back to SafeEnv" : "Log in"}
+        </button>
+      </div>
+    </div>
+  );
+};
+
+export default SSOLink;
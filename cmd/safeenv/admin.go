@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/David-mwas/SafeEnv/audit"
+	"github.com/David-mwas/SafeEnv/config"
+	"github.com/David-mwas/SafeEnv/server"
+	"github.com/David-mwas/SafeEnv/store"
+)
+
+// openServer opens the configured store and builds a Server around it.
+// The caller must close the returned store.
+func openServer(ctx context.Context) (*server.Server, store.Store, error) {
+	cfg := config.FromEnv()
+	keys, keyring, err := cfg.Keys()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	signer, err := cfg.AuditSigner()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	st, err := store.Open(ctx, cfg.Store)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	srv := server.New(st, server.Config{
+		Keys:        keys,
+		Keyring:     keyring,
+		JWTSecret:   cfg.JWTSecret,
+		FrontendURL: cfg.FrontendURL,
+		AdminEmails: cfg.AdminEmails,
+
+		VersionRetention: cfg.VersionRetention,
+		ShareMaxAttempts: cfg.ShareMaxAttempts,
+		AccessTokenTTL:   cfg.AccessTokenTTL,
+		SessionTTL:       cfg.SessionTTL,
+		RequireMFA:       cfg.RequireMFA,
+		WebAuthnRPID:     cfg.WebAuthnRPID,
+		WebAuthnOrigins:  cfg.WebAuthnOrigins,
+		Audit:            audit.New(st, signer),
+	})
+	return srv, st, nil
+}
+
+func runMigrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	fs.Parse(args)
+
+	ctx := context.Background()
+	srv, st, err := openServer(ctx)
+	if err != nil {
+		return err
+	}
+	defer st.Close(ctx)
+
+	n, err := srv.MigrateEncryption(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Migrated %d variables\n", n)
+	return nil
+}
+
+func runReencrypt(args []string) error {
+	fs := flag.NewFlagSet("reencrypt", flag.ExitOnError)
+	restart := fs.Bool("restart", false, "start over instead of resuming an unfinished job")
+	fs.Parse(args)
+
+	ctx := context.Background()
+	srv, st, err := openServer(ctx)
+	if err != nil {
+		return err
+	}
+	defer st.Close(ctx)
+
+	job, err := srv.Reencrypt(ctx, *restart, func(j *store.Job) {
+		fmt.Printf("\r%d/%d processed, %d re-encrypted", j.Processed, j.Total, j.Changed)
+	})
+	fmt.Println()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("All data keys are now wrapped with key %q\n", job.Target)
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/David-mwas/SafeEnv/audit"
+	"github.com/David-mwas/SafeEnv/config"
+	"github.com/David-mwas/SafeEnv/store"
+)
+
+var auditCommands = []command{
+	{"verify", "walk every audit chain and report the first broken link", runAuditVerify},
+	{"checkpoint", "sign the current head of every audit chain", runAuditCheckpoint},
+	{"keygen", "print a new checkpoint signing key pair", runAuditKeygen},
+}
+
+func runAudit(args []string) error {
+	if len(args) > 0 {
+		for _, c := range auditCommands {
+			if c.name == args[0] {
+				return c.run(args[1:])
+			}
+		}
+	}
+
+	fmt.Fprintln(os.Stderr, "Usage: safeenv audit <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, c := range auditCommands {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", c.name, c.usage)
+	}
+	os.Exit(2)
+	return nil
+}
+
+func runAuditVerify(args []string) error {
+	fs := flag.NewFlagSet("audit verify", flag.ExitOnError)
+	tenant := fs.String("tenant", "", "only verify this tenant's chain")
+	publicKey := fs.String("public-key", "", "base64 Ed25519 public key (default SAFEENV_AUDIT_PUBLIC_KEY)")
+	fs.Parse(args)
+
+	cfg := config.FromEnv()
+	if *publicKey != "" {
+		cfg.AuditPublicKey = *publicKey
+	}
+	pub, err := cfg.AuditVerifier()
+	if err != nil {
+		return err
+	}
+	if pub == nil {
+		fmt.Fprintln(os.Stderr, "warning: no public key configured, checkpoint signatures are not checked")
+	}
+
+	ctx := context.Background()
+	st, err := store.Open(ctx, cfg.Store)
+	if err != nil {
+		return err
+	}
+	defer st.Close(ctx)
+
+	tenants := []string{*tenant}
+	if *tenant == "" {
+		if tenants, err = st.AuditTenants(ctx); err != nil {
+			return err
+		}
+	}
+
+	broken := 0
+	for _, t := range tenants {
+		res, err := audit.Verify(ctx, st, t, pub)
+		if err != nil {
+			return fmt.Errorf("tenant %s: %w", t, err)
+		}
+		if res.Broken != nil {
+			broken++
+			fmt.Printf("%s: BROKEN at %s\n", t, res.Broken)
+			continue
+		}
+		fmt.Printf("%s: ok, %d events, %d checkpoints\n", t, res.Events, res.Checkpoints)
+	}
+
+	if broken > 0 {
+		return fmt.Errorf("%d of %d audit chains failed verification", broken, len(tenants))
+	}
+	return nil
+}
+
+func runAuditCheckpoint(args []string) error {
+	fs := flag.NewFlagSet("audit checkpoint", flag.ExitOnError)
+	fs.Parse(args)
+
+	cfg := config.FromEnv()
+	signer, err := cfg.AuditSigner()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	st, err := store.Open(ctx, cfg.Store)
+	if err != nil {
+		return err
+	}
+	defer st.Close(ctx)
+
+	n, err := audit.New(st, signer).Checkpoint(ctx)
+	if errors.Is(err, audit.ErrNoSigningKey) {
+		return errors.New("set SAFEENV_AUDIT_SIGNING_KEY to sign checkpoints")
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Signed %d checkpoints\n", n)
+	return nil
+}
+
+func runAuditKeygen(args []string) error {
+	fs := flag.NewFlagSet("audit keygen", flag.ExitOnError)
+	fs.Parse(args)
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("SAFEENV_AUDIT_SIGNING_KEY=%s\n", base64.StdEncoding.EncodeToString(priv.Seed()))
+	fmt.Printf("SAFEENV_AUDIT_PUBLIC_KEY=%s\n", base64.StdEncoding.EncodeToString(pub))
+	return nil
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const defaultServer = "http://localhost:8080"
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// credentials is what login saves for the other client commands.
+type credentials struct {
+	Server       string `json:"server"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken,omitempty"`
+}
+
+// credentialsPath is SAFEENV_CONFIG, or safeenv/credentials.json in the
+// user's config directory (~/.config on Linux, ~/Library/Application
+// Support on macOS, %AppData% on Windows).
+func credentialsPath() (string, error) {
+	if path := os.Getenv("SAFEENV_CONFIG"); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "safeenv", "credentials.json"), nil
+}
+
+func loadCredentials() (*credentials, error) {
+	path, err := credentialsPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errors.New("not logged in, run safeenv login")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var creds credentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &creds, nil
+}
+
+// saveCredentials writes creds readable by the current user only.
+func saveCredentials(creds *credentials) error {
+	path, err := credentialsPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(creds, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writePrivate(path, data)
+}
+
+// writePrivate writes a file only the current user can read.
+func writePrivate(path string, data []byte) error {
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return err
+	}
+	// WriteFile keeps the mode of a file that already existed
+	return os.Chmod(path, 0o600)
+}
+
+// apiError is an error response from the API.
+type apiError struct {
+	Status  int
+	Message string
+}
+
+func (e *apiError) Error() string { return e.Message }
+
+// isStatus reports whether err is an API error with the given status.
+func isStatus(err error, status int) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && apiErr.Status == status
+}
+
+// client talks to the API as the logged in user.
+type client struct {
+	creds *credentials
+	save  bool // whether refreshed tokens go back to the credentials file
+	http  *http.Client
+}
+
+// newClient loads the saved login. SAFEENV_TOKEN, e.g. an API token in CI,
+// takes its place, and SAFEENV_URL overrides the server.
+func newClient() (*client, error) {
+	c := &client{http: httpClient}
+
+	if token := os.Getenv("SAFEENV_TOKEN"); token != "" {
+		c.creds = &credentials{Server: defaultServer, Token: token}
+	} else {
+		creds, err := loadCredentials()
+		if err != nil {
+			return nil, err
+		}
+		c.creds, c.save = creds, true
+	}
+
+	if server := os.Getenv("SAFEENV_URL"); server != "" {
+		c.creds.Server = server
+	}
+	return c, nil
+}
+
+// call sends body to path under /api/v1 and returns the raw reply,
+// decoding it into out if that is set. An expired access token is
+// refreshed once.
+func (c *client) call(method, path string, body, out any) (json.RawMessage, error) {
+	raw, err := c.send(method, path, body)
+	if isStatus(err, http.StatusUnauthorized) && c.creds.RefreshToken != "" {
+		if err := c.refresh(); err != nil {
+			return nil, err
+		}
+		raw, err = c.send(method, path, body)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if out != nil {
+		if err := json.Unmarshal(raw, out); err != nil {
+			return nil, fmt.Errorf("unexpected response: %w", err)
+		}
+	}
+	return raw, nil
+}
+
+func (c *client) send(method, path string, body any) (json.RawMessage, error) {
+	// Bytes are sent as they are, e.g. a file to import; anything else as JSON
+	var reader io.Reader
+	contentType := "application/json"
+	switch body := body.(type) {
+	case nil:
+	case []byte:
+		reader, contentType = bytes.NewReader(body), "text/plain; charset=utf-8"
+	default:
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(c.creds.Server, "/")+"/api/v1"+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if reader != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.creds.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.creds.Token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		var reply struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(raw, &reply) != nil || reply.Error == "" {
+			reply.Error = resp.Status
+		}
+		return nil, &apiError{Status: resp.StatusCode, Message: reply.Error}
+	}
+	return raw, nil
+}
+
+// refresh trades the refresh token for new tokens and saves them.
+func (c *client) refresh() error {
+	var tokens struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refreshToken"`
+	}
+	c.creds.Token = ""
+	raw, err := c.send(http.MethodPost, "/token/refresh", map[string]string{"refreshToken": c.creds.RefreshToken})
+	if isStatus(err, http.StatusUnauthorized) {
+		return errors.New("session expired, run safeenv login")
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, &tokens); err != nil {
+		return fmt.Errorf("unexpected response: %w", err)
+	}
+
+	c.creds.Token, c.creds.RefreshToken = tokens.Token, tokens.RefreshToken
+	if c.save {
+		return saveCredentials(c.creds)
+	}
+	return nil
+}
+
+// printJSON writes an API reply indented for --json.
+func printJSON(raw json.RawMessage) error {
+	var out bytes.Buffer
+	if err := json.Indent(&out, raw, "", "  "); err != nil {
+		return err
+	}
+	out.WriteByte('\n')
+	_, err := out.WriteTo(os.Stdout)
+	return err
+}
+
+var stdin = bufio.NewReader(os.Stdin)
+
+// prompt asks for a line on the terminal.
+func prompt(label string) (string, error) {
+	fmt.Fprint(os.Stderr, label)
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// promptSecret asks for a line without echoing it where stty is available.
+func promptSecret(label string) (string, error) {
+	hide := exec.Command("stty", "-echo")
+	hide.Stdin = os.Stdin
+	if hide.Run() == nil {
+		defer func() {
+			show := exec.Command("stty", "echo")
+			show.Stdin = os.Stdin
+			show.Run()
+			fmt.Fprintln(os.Stderr)
+		}()
+	}
+	return prompt(label)
+}
+
+// parseFlags parses args allowing flags after the positional arguments,
+// as in "safeenv get DB_URL --json", and returns the positional ones.
+// Everything after "--" is positional.
+func parseFlags(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		rest := fs.Args()
+		if len(rest) == 0 {
+			return positional
+		}
+		if n := len(args) - len(rest); n > 0 && args[n-1] == "--" {
+			return append(positional, rest...)
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
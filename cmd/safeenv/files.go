@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/David-mwas/SafeEnv/envfile"
+)
+
+// fileFormat is the --format flag, or else the format FILE's extension
+// suggests.
+func fileFormat(flagValue, filename string) (envfile.Format, error) {
+	if flagValue != "" {
+		return envfile.ParseFormat(flagValue)
+	}
+	if filename == "" || filename == "-" {
+		return envfile.Dotenv, nil
+	}
+	return envfile.FormatOf(filename), nil
+}
+
+// runImport stores the variables in a dotenv, JSON or YAML file, showing
+// what changes first with --dry-run.
+func runImport(args []string) error {
+	vc := newVariableCommand("import")
+	format := vc.fs.String("format", "", "dotenv, json or yaml (default: from the file name)")
+	dryRun := vc.fs.Bool("dry-run", false, "only show what would change")
+	note := vc.fs.String("note", "", "note recorded with the new versions")
+	positional, err := vc.parse(args, "FILE|-", 1, 1)
+	if err != nil {
+		return err
+	}
+
+	f, err := fileFormat(*format, positional[0])
+	if err != nil {
+		return err
+	}
+	var data []byte
+	if positional[0] == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(positional[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{"format": {string(f)}}
+	if *dryRun {
+		query.Set("dryRun", "true")
+	}
+	if *note != "" {
+		query.Set("note", *note)
+	}
+	var diff struct {
+		Added     []string `json:"added"`
+		Changed   []string `json:"changed"`
+		Unchanged []string `json:"unchanged"`
+	}
+	raw, err := vc.client.call(http.MethodPost, vc.prefix+"/import?"+query.Encode(), data, &diff)
+	if err != nil {
+		return err
+	}
+	if *vc.asJSON {
+		return printJSON(raw)
+	}
+
+	for _, key := range diff.Added {
+		fmt.Printf("+ %s\n", key)
+	}
+	for _, key := range diff.Changed {
+		fmt.Printf("~ %s\n", key)
+	}
+	fmt.Printf("%d added, %d changed, %d unchanged\n", len(diff.Added), len(diff.Changed), len(diff.Unchanged))
+	if *dryRun {
+		fmt.Println("Dry run, nothing was stored")
+	}
+	return nil
+}
+
+// runExport writes the scope's variables to stdout or, readable only by
+// the current user, to a file.
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	sc := addScopeFlags(fs)
+	format := fs.String("format", "", "dotenv, json, yaml, shell or docker (default: from --output, else dotenv)")
+	output := fs.String("output", "", "file to write instead of stdout")
+	if len(parseFlags(fs, args)) > 0 {
+		return fmt.Errorf("usage: safeenv export [--format F] [--output FILE]")
+	}
+
+	f, err := fileFormat(*format, *output)
+	if err != nil {
+		return err
+	}
+	prefix, err := sc.prefix()
+	if err != nil {
+		return err
+	}
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	data, err := c.call(http.MethodGet, prefix+"/export?format="+url.QueryEscape(string(f)), nil, nil)
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return writePrivate(*output, data)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/David-mwas/SafeEnv/envfile"
+)
+
+func TestFileFormat(t *testing.T) {
+	tests := []struct {
+		flag, filename string
+		want           envfile.Format
+	}{
+		{"", "", envfile.Dotenv},
+		{"", "-", envfile.Dotenv},
+		{"", ".env.production", envfile.Dotenv},
+		{"", "config/vars.JSON", envfile.JSON},
+		{"", "values.yml", envfile.YAML},
+		{"", "env.sh", envfile.Shell},
+		{"yaml", "vars.json", envfile.YAML},
+		{"docker", "-", envfile.Docker},
+	}
+	for _, tt := range tests {
+		got, err := fileFormat(tt.flag, tt.filename)
+		if err != nil || got != tt.want {
+			t.Errorf("fileFormat(%q, %q) = %s, %v, want %s", tt.flag, tt.filename, got, err, tt.want)
+		}
+	}
+	if _, err := fileFormat("toml", "vars.toml"); err == nil {
+		t.Error("fileFormat accepted an unknown --format")
+	}
+}
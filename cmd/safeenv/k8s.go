@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/David-mwas/SafeEnv/envfile"
+)
+
+var k8sCommands = []command{
+	{"render", "print the variables as a Secret or ConfigMap", runK8sRender},
+	{"apply", "update a Secret or ConfigMap manifest file, showing the changes", runK8sApply},
+}
+
+func runK8s(args []string) error {
+	if len(args) > 0 {
+		for _, c := range k8sCommands {
+			if c.name == args[0] {
+				return c.run(args[1:])
+			}
+		}
+	}
+
+	fmt.Fprintln(os.Stderr, "Usage: safeenv k8s <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, c := range k8sCommands {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", c.name, c.usage)
+	}
+	os.Exit(2)
+	return nil
+}
+
+// labelFlags collects repeated --label key=value flags.
+type labelFlags []string
+
+func (l *labelFlags) String() string     { return strings.Join(*l, ",") }
+func (l *labelFlags) Set(v string) error { *l = append(*l, v); return nil }
+
+// manifestFlags are the options a manifest is rendered with.
+type manifestFlags struct {
+	scope     *scope
+	kind      string
+	name      string
+	namespace string
+	labels    labelFlags
+}
+
+func addManifestFlags(fs *flag.FlagSet) *manifestFlags {
+	mf := &manifestFlags{scope: addScopeFlags(fs)}
+	fs.StringVar(&mf.kind, "kind", "", "secret or configmap (default secret)")
+	fs.StringVar(&mf.name, "name", "", "object name (default: the project and environment)")
+	fs.StringVar(&mf.namespace, "namespace", "", "object namespace")
+	fs.Var(&mf.labels, "label", "key=value label, may be repeated")
+	return mf
+}
+
+// render asks the API for the manifest.
+func (mf *manifestFlags) render() ([]byte, error) {
+	prefix, err := mf.scope.prefix()
+	if err != nil {
+		return nil, err
+	}
+	c, err := newClient()
+	if err != nil {
+		return nil, err
+	}
+
+	kind := envfile.Secret
+	if mf.kind != "" {
+		if kind, err = envfile.ParseKind(mf.kind); err != nil {
+			return nil, err
+		}
+	}
+	query := url.Values{"format": {strings.ToLower(string(kind))}, "label": mf.labels}
+	if mf.name != "" {
+		query.Set("name", mf.name)
+	}
+	if mf.namespace != "" {
+		query.Set("namespace", mf.namespace)
+	}
+	return c.call(http.MethodGet, prefix+"/export?"+query.Encode(), nil, nil)
+}
+
+func runK8sRender(args []string) error {
+	fs := flag.NewFlagSet("k8s render", flag.ExitOnError)
+	mf := addManifestFlags(fs)
+	output := fs.String("output", "", "file to write instead of stdout")
+	if len(parseFlags(fs, args)) > 0 {
+		return errors.New("usage: safeenv k8s render [--kind K] [--name N] [--namespace NS] [--label k=v...] [--output FILE]")
+	}
+
+	data, err := mf.render()
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return writePrivate(*output, data)
+}
+
+// runK8sApply renders the manifest and compares it with the one in FILE,
+// then replaces FILE unless --dry-run is given. Unset options are taken
+// from FILE, so it keeps its kind, name, namespace and labels.
+func runK8sApply(args []string) error {
+	fs := flag.NewFlagSet("k8s apply", flag.ExitOnError)
+	mf := addManifestFlags(fs)
+	file := fs.String("file", "", "manifest file to update")
+	dryRun := fs.Bool("dry-run", false, "only show what would change")
+	if len(parseFlags(fs, args)) > 0 || *file == "" {
+		return errors.New("usage: safeenv k8s apply --file FILE [--dry-run] [--kind K] [--name N] [--namespace NS] [--label k=v...]")
+	}
+
+	old := &envfile.Manifest{Data: map[string]string{}}
+	data, err := os.ReadFile(*file)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		fmt.Printf("%s doesn't exist, it will be created\n", *file)
+	case err != nil:
+		return err
+	default:
+		if old, err = envfile.ParseManifest(data); err != nil {
+			return fmt.Errorf("%s: %w", *file, err)
+		}
+		if mf.kind == "" {
+			mf.kind = string(old.Kind)
+		}
+		if mf.name == "" {
+			mf.name = old.Name
+		}
+		if mf.namespace == "" {
+			mf.namespace = old.Namespace
+		}
+		if len(mf.labels) == 0 {
+			for key, value := range old.Labels {
+				mf.labels = append(mf.labels, key+"="+value)
+			}
+		}
+	}
+
+	rendered, err := mf.render()
+	if err != nil {
+		return err
+	}
+	current, err := envfile.ParseManifest(rendered)
+	if err != nil {
+		return err
+	}
+
+	if n := printManifestDiff(os.Stdout, old, current); n == 0 {
+		fmt.Println("No changes")
+		return nil
+	}
+	if *dryRun {
+		fmt.Println("Dry run, nothing was written")
+		return nil
+	}
+	if err := writePrivate(*file, rendered); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s\n", *file)
+	return nil
+}
+
+// printManifestDiff writes how current differs from old to w, naming keys
+// but never values, and returns the number of differences.
+func printManifestDiff(w io.Writer, old, current *envfile.Manifest) int {
+	n := 0
+	field := func(name, from, to string) {
+		if from != to {
+			fmt.Fprintf(w, "~ %s: %q -> %q\n", name, from, to)
+			n++
+		}
+	}
+	field("kind", string(old.Kind), string(current.Kind))
+	field("name", old.Name, current.Name)
+	field("namespace", old.Namespace, current.Namespace)
+	for _, key := range unionKeys(old.Labels, current.Labels) {
+		from, had := old.Labels[key]
+		to, has := current.Labels[key]
+		switch {
+		case !had:
+			fmt.Fprintf(w, "+ label %s=%s\n", key, to)
+			n++
+		case !has:
+			fmt.Fprintf(w, "- label %s\n", key)
+			n++
+		default:
+			field("label "+key, from, to)
+		}
+	}
+
+	for _, key := range unionKeys(old.Data, current.Data) {
+		from, had := old.Data[key]
+		to, has := current.Data[key]
+		switch {
+		case !had:
+			fmt.Fprintf(w, "+ %s\n", key)
+		case !has:
+			fmt.Fprintf(w, "- %s\n", key)
+		case from != to:
+			fmt.Fprintf(w, "~ %s\n", key)
+		default:
+			continue
+		}
+		n++
+	}
+	return n
+}
+
+func unionKeys(a, b map[string]string) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/David-mwas/SafeEnv/envfile"
+)
+
+func TestPrintManifestDiff(t *testing.T) {
+	old := &envfile.Manifest{
+		Kind:   envfile.Secret,
+		Name:   "web-prod",
+		Labels: map[string]string{"app": "web", "team": "payments"},
+		Data:   map[string]string{"DB_URL": "old-secret", "KEEP": "same", "GONE": "x"},
+	}
+	current := &envfile.Manifest{
+		Kind:      envfile.Secret,
+		Name:      "web-prod",
+		Namespace: "prod",
+		Labels:    map[string]string{"app": "api", "tier": "backend"},
+		Data:      map[string]string{"DB_URL": "new-secret", "KEEP": "same", "NEW": "y"},
+	}
+
+	var out strings.Builder
+	n := printManifestDiff(&out, old, current)
+	want := strings.Join([]string{
+		`~ namespace: "" -> "prod"`,
+		`~ label app: "web" -> "api"`,
+		`- label team`,
+		`+ label tier=backend`,
+		`~ DB_URL`,
+		`- GONE`,
+		`+ NEW`,
+	}, "\n") + "\n"
+	if out.String() != want || n != 7 {
+		t.Fatalf("%d differences:\n%s\nwant:\n%s", n, out.String(), want)
+	}
+	for _, value := range []string{"old-secret", "new-secret", "same"} {
+		if strings.Contains(out.String(), value) {
+			t.Fatalf("diff shows the value %q", value)
+		}
+	}
+
+	out.Reset()
+	if n := printManifestDiff(&out, current, current); n != 0 || out.Len() != 0 {
+		t.Fatalf("no changes: %d %q", n, out.String())
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+func runLogin(args []string) error {
+	fs := flag.NewFlagSet("login", flag.ExitOnError)
+	server := fs.String("server", envOr("SAFEENV_URL", defaultServer), "SafeEnv API URL")
+	email := fs.String("email", "", "account email (prompted if empty)")
+	passwordStdin := fs.Bool("password-stdin", false, "read the password from stdin instead of prompting")
+	parseFlags(fs, args)
+
+	var err error
+	if *email == "" {
+		if *email, err = prompt("Email: "); err != nil {
+			return err
+		}
+	}
+	var password string
+	if *passwordStdin {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		password = strings.TrimRight(string(data), "\r\n")
+	} else if password, err = promptSecret("Password: "); err != nil {
+		return err
+	}
+
+	c := &client{creds: &credentials{Server: *server}, save: true, http: httpClient}
+
+	var reply struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refreshToken"`
+		MFARequired  bool   `json:"mfaRequired"`
+		MFAToken     string `json:"mfaToken"`
+		Enrolled     bool   `json:"enrolled"`
+	}
+	_, err = c.call(http.MethodPost, "/login", map[string]string{"email": *email, "password": password}, &reply)
+	if err != nil {
+		return err
+	}
+
+	if reply.MFARequired {
+		if !reply.Enrolled {
+			return errors.New("two-factor authentication has to be set up in the web app first")
+		}
+		if *passwordStdin {
+			return errors.New("this account needs a two-factor code, log in interactively")
+		}
+		code, err := prompt("Two-factor code (or recovery code): ")
+		if err != nil {
+			return err
+		}
+		body := map[string]string{"mfaToken": reply.MFAToken, "code": code}
+		if len(code) != 6 {
+			body = map[string]string{"mfaToken": reply.MFAToken, "recoveryCode": code}
+		}
+		if _, err := c.call(http.MethodPost, "/login/mfa", body, &reply); err != nil {
+			return err
+		}
+	}
+
+	c.creds.Token, c.creds.RefreshToken = reply.Token, reply.RefreshToken
+	if err := saveCredentials(c.creds); err != nil {
+		return err
+	}
+	fmt.Printf("Logged in to %s as %s\n", *server, *email)
+	return nil
+}
+
+func runLogout(args []string) error {
+	fs := flag.NewFlagSet("logout", flag.ExitOnError)
+	parseFlags(fs, args)
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	// End the session on the server too, but forget it locally either way
+	_, logoutErr := c.call(http.MethodPost, "/logout", nil, nil)
+
+	path, err := credentialsPath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if logoutErr != nil {
+		fmt.Fprintln(os.Stderr, "warning: the server didn't end the session:", logoutErr)
+	}
+	fmt.Println("Logged out")
+	return nil
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,78 @@
+// Command safeenv is the SafeEnv command-line client, and runs maintenance
+// tasks against a SafeEnv deployment.
+//
+// The client commands talk to the API as the user who ran safeenv login.
+// The maintenance commands read the same SAFEENV_* environment (and
+// optional .env file) as the API server and talk to the configured store
+// directly.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/joho/godotenv"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"login", "log in to a SafeEnv server", runLogin},
+	{"logout", "end the session and forget the saved login", runLogout},
+	{"get", "print a variable's value", runGet},
+	{"set", "store variables, replacing existing values", runSet},
+	{"list", "list the keys in a scope", runList},
+	{"rename", "rename a variable", runRename},
+	{"delete", "delete a variable", runDelete},
+	{"share", "create a share link for a variable", runShare},
+	{"import", "store the variables in a dotenv, JSON or YAML file", runImport},
+	{"export", "write the variables as dotenv, JSON, YAML, shell or Docker env file", runExport},
+	{"run", "run a command with the variables in its environment", runRun},
+	{"k8s", "render variables as Kubernetes Secret or ConfigMap manifests", runK8s},
+
+	{"migrate", "re-encrypt legacy AES-CFB values with AES-GCM", runMigrate},
+	{"reencrypt", "re-encrypt every variable with the primary master key", runReencrypt},
+	{"audit", "verify the audit log, sign checkpoints or create a signing key", runAudit},
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: safeenv <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", c.name, c.usage)
+	}
+}
+
+func main() {
+	godotenv.Load() // .env is optional for the CLI
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	for _, c := range commands {
+		if c.name == os.Args[1] {
+			err := c.run(os.Args[2:])
+			var code exitCode
+			if errors.As(err, &code) {
+				os.Exit(int(code))
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "safeenv:", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "safeenv: unknown command %q\n\n", os.Args[1])
+	usage()
+	os.Exit(2)
+}
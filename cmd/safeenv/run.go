@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// exitCode is returned by a command that wants safeenv to exit with a
+// particular status, without printing anything.
+type exitCode int
+
+func (e exitCode) Error() string { return fmt.Sprintf("exit status %d", int(e)) }
+
+// forwardedSignals are passed on to the child of safeenv run.
+var forwardedSignals = []os.Signal{os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT}
+
+// runRun starts a command with the scope's variables in its environment.
+// The values only ever live in memory, in safeenv and in the child.
+func runRun(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	sc := addScopeFlags(fs)
+	preferEnv := fs.Bool("prefer-env", false, "keep variables already set in the environment instead of overriding them")
+	clean := fs.Bool("clean", false, "start the command with only the stored variables and PATH")
+	// Flags stop at the command, so its own flags are left alone
+	fs.Parse(args)
+	command := fs.Args()
+	if len(command) == 0 {
+		return errors.New("usage: safeenv run [--project P --env E] [--prefer-env] [--clean] -- COMMAND [ARGS...]")
+	}
+
+	prefix, err := sc.prefix()
+	if err != nil {
+		return err
+	}
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	values, err := fetchVariables(c, prefix)
+	if err != nil {
+		return err
+	}
+
+	path, err := exec.LookPath(command[0])
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(path, command[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = mergeEnv(os.Environ(), values, *preferEnv, *clean)
+
+	// Catch signals before starting, so none arrive in between and kill
+	// safeenv instead of reaching the child
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forwardedSignals...)
+	defer signal.Stop(signals)
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go func() {
+		for sig := range signals {
+			cmd.Process.Signal(sig)
+		}
+	}()
+
+	err = cmd.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		// A child killed by a signal exits like a shell reports it
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return exitCode(128 + int(status.Signal()))
+		}
+		return exitCode(exitErr.ExitCode())
+	}
+	return err
+}
+
+// mergeEnv sets the stored values over environ. With preferEnv, variables
+// already in environ win; with clean, only PATH is kept from it. Keys that
+// can't be environment variable names are skipped with a warning.
+func mergeEnv(environ []string, values map[string]string, preferEnv, clean bool) []string {
+	env := map[string]string{}
+	var names []string
+	set := func(name, value string) {
+		if _, ok := env[name]; !ok {
+			names = append(names, name)
+		}
+		env[name] = value
+	}
+
+	for _, kv := range environ {
+		name, value, _ := strings.Cut(kv, "=")
+		if !clean || name == "PATH" {
+			set(name, value)
+		}
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if key == "" || strings.ContainsAny(key, "=\x00") || strings.ContainsRune(values[key], 0) {
+			fmt.Fprintf(os.Stderr, "safeenv: skipping %q, it can't be an environment variable\n", key)
+			continue
+		}
+		if _, ok := env[key]; ok && preferEnv {
+			continue
+		}
+		set(key, values[key])
+	}
+
+	out := make([]string, 0, len(names))
+	for _, name := range names {
+		out = append(out, name+"="+env[name])
+	}
+	return out
+}
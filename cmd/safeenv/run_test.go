@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeEnv(t *testing.T) {
+	environ := []string{"PATH=/usr/bin", "HOME=/home/alice", "DB_URL=from-env", "EMPTY="}
+	values := map[string]string{"DB_URL": "stored", "API_KEY": "k=v", "EMPTY": "stored"}
+
+	tests := []struct {
+		name             string
+		preferEnv, clean bool
+		want             []string
+	}{
+		// Environment order first, new keys after it sorted
+		{"stored values win", false, false, []string{"PATH=/usr/bin", "HOME=/home/alice", "DB_URL=stored", "EMPTY=stored", "API_KEY=k=v"}},
+		{"environment wins with --prefer-env", true, false, []string{"PATH=/usr/bin", "HOME=/home/alice", "DB_URL=from-env", "EMPTY=", "API_KEY=k=v"}},
+		{"only PATH kept with --clean", false, true, []string{"PATH=/usr/bin", "API_KEY=k=v", "DB_URL=stored", "EMPTY=stored"}},
+		{"nothing left to prefer with --clean", true, true, []string{"PATH=/usr/bin", "API_KEY=k=v", "DB_URL=stored", "EMPTY=stored"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeEnv(environ, values, tt.preferEnv, tt.clean); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("mergeEnv = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// A stored PATH replaces the one kept by --clean, unless the environment is preferred
+	stored := map[string]string{"PATH": "/opt/bin"}
+	if got := mergeEnv(environ, stored, false, true); !reflect.DeepEqual(got, []string{"PATH=/opt/bin"}) {
+		t.Fatalf("stored PATH: %q", got)
+	}
+	if got := mergeEnv(environ, stored, true, true); !reflect.DeepEqual(got, []string{"PATH=/usr/bin"}) {
+		t.Fatalf("stored PATH with --prefer-env: %q", got)
+	}
+}
+
+func TestMergeEnvSkipsInvalidNames(t *testing.T) {
+	values := map[string]string{"": "x", "A=B": "x", "NUL\x00": "x", "BAD_VALUE": "a\x00b", "OK": "x"}
+	if got := mergeEnv(nil, values, false, false); !reflect.DeepEqual(got, []string{"OK=x"}) {
+		t.Fatalf("mergeEnv = %q", got)
+	}
+}
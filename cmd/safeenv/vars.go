@@ -0,0 +1,363 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/David-mwas/SafeEnv/encryption"
+)
+
+// scope says which variables a command works on: the flat personal ones,
+// or an environment of a personal or organization project.
+type scope struct {
+	org, project, env string
+}
+
+// addScopeFlags registers --org, --project and --env, defaulting to
+// SAFEENV_ORG, SAFEENV_PROJECT and SAFEENV_ENV.
+func addScopeFlags(fs *flag.FlagSet) *scope {
+	sc := &scope{}
+	fs.StringVar(&sc.org, "org", os.Getenv("SAFEENV_ORG"), "organization owning the project")
+	fs.StringVar(&sc.project, "project", os.Getenv("SAFEENV_PROJECT"), "project name")
+	fs.StringVar(&sc.env, "env", os.Getenv("SAFEENV_ENV"), "environment name")
+	return sc
+}
+
+// prefix is the API path the scope's variable routes live under.
+func (sc *scope) prefix() (string, error) {
+	if sc.project == "" && sc.env == "" {
+		if sc.org != "" {
+			return "", errors.New("--org needs --project and --env")
+		}
+		return "", nil
+	}
+	if sc.project == "" || sc.env == "" {
+		return "", errors.New("--project and --env go together")
+	}
+
+	prefix := "/projects/" + url.PathEscape(sc.project) + "/envs/" + url.PathEscape(sc.env)
+	if sc.org != "" {
+		prefix = "/orgs/" + url.PathEscape(sc.org) + prefix
+	}
+	return prefix, nil
+}
+
+// variableCommand holds what every variable command starts with: flags, a
+// client and the scope's path prefix.
+type variableCommand struct {
+	fs     *flag.FlagSet
+	scope  *scope
+	asJSON *bool
+
+	client *client
+	prefix string
+}
+
+func newVariableCommand(name string) *variableCommand {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	return &variableCommand{
+		fs:     fs,
+		scope:  addScopeFlags(fs),
+		asJSON: fs.Bool("json", false, "print the API's JSON response"),
+	}
+}
+
+// parse parses args, checks the number of positional arguments and
+// connects to the API.
+func (vc *variableCommand) parse(args []string, usage string, min, max int) ([]string, error) {
+	positional := parseFlags(vc.fs, args)
+	if len(positional) < min || (max >= 0 && len(positional) > max) {
+		return nil, fmt.Errorf("usage: safeenv %s %s", vc.fs.Name(), usage)
+	}
+
+	var err error
+	if vc.prefix, err = vc.scope.prefix(); err != nil {
+		return nil, err
+	}
+	vc.client, err = newClient()
+	return positional, err
+}
+
+// variable is a stored variable as the keys listing returns it.
+type variable struct {
+	ID        string    `json:"_id"`
+	Key       string    `json:"key"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func (vc *variableCommand) listKeys() ([]variable, json.RawMessage, error) {
+	var reply struct {
+		Keys []variable `json:"keys"`
+	}
+	raw, err := vc.client.call(http.MethodGet, vc.prefix+"/keys", nil, &reply)
+	return reply.Keys, raw, err
+}
+
+// fetchVariables returns every value in the scope at prefix that the
+// caller may read.
+func fetchVariables(c *client, prefix string) (map[string]string, error) {
+	var reply struct {
+		Variables map[string]string `json:"variables"`
+	}
+	_, err := c.call(http.MethodGet, prefix+"/retrieve", nil, &reply)
+	return reply.Variables, err
+}
+
+func runGet(args []string) error {
+	vc := newVariableCommand("get")
+	positional, err := vc.parse(args, "KEY", 1, 1)
+	if err != nil {
+		return err
+	}
+
+	var reply struct {
+		Value string `json:"value"`
+	}
+	raw, err := vc.client.call(http.MethodGet, vc.prefix+"/retrieve/"+url.PathEscape(positional[0]), nil, &reply)
+	if err != nil {
+		return err
+	}
+	if *vc.asJSON {
+		return printJSON(raw)
+	}
+	fmt.Println(reply.Value)
+	return nil
+}
+
+// runSet stores variables, replacing the values of keys that exist. A key
+// given without "=VALUE" reads its value from stdin, keeping it out of
+// the shell history.
+func runSet(args []string) error {
+	vc := newVariableCommand("set")
+	note := vc.fs.String("note", "", "note recorded with the new versions")
+	positional, err := vc.parse(args, "KEY=VALUE [KEY=VALUE...] | KEY < file", 1, -1)
+	if err != nil {
+		return err
+	}
+
+	values := map[string]string{}
+	var keys []string
+	for _, arg := range positional {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			if len(positional) > 1 {
+				return errors.New("only a single KEY can read its value from stdin")
+			}
+			data, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				return err
+			}
+			value = strings.TrimRight(string(data), "\r\n")
+		}
+		if _, dup := values[key]; !dup {
+			keys = append(keys, key)
+		}
+		values[key] = value
+	}
+
+	// Flat variables may repeat keys, so the API won't refuse a duplicate;
+	// check which keys exist before storing anything
+	existing, _, err := vc.listKeys()
+	if err != nil {
+		return err
+	}
+	exists := map[string]bool{}
+	for _, v := range existing {
+		exists[v.Key] = true
+	}
+
+	result := map[string][]string{"stored": {}, "updated": {}}
+	created := map[string]string{}
+	for _, key := range keys {
+		if !exists[key] {
+			created[key] = values[key]
+			result["stored"] = append(result["stored"], key)
+			continue
+		}
+		if _, err := vc.client.call(http.MethodPut, vc.prefix+"/keys/"+url.PathEscape(key), map[string]string{"newValue": values[key], "note": *note}, nil); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		result["updated"] = append(result["updated"], key)
+	}
+
+	switch len(created) {
+	case 0:
+	case 1:
+		key := result["stored"][0]
+		_, err = vc.client.call(http.MethodPost, vc.prefix+"/store", map[string]string{"key": key, "value": created[key], "note": *note}, nil)
+	default:
+		_, err = vc.client.call(http.MethodPost, vc.prefix+"/store/bulk", map[string]any{"variables": created, "note": *note}, nil)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *vc.asJSON {
+		raw, _ := json.Marshal(result)
+		return printJSON(raw)
+	}
+	for _, key := range result["stored"] {
+		fmt.Printf("Stored %s\n", key)
+	}
+	for _, key := range result["updated"] {
+		fmt.Printf("Updated %s\n", key)
+	}
+	return nil
+}
+
+func runRename(args []string) error {
+	vc := newVariableCommand("rename")
+	positional, err := vc.parse(args, "KEY NEW_KEY", 2, 2)
+	if err != nil {
+		return err
+	}
+
+	// Keep the value, only the key name changes
+	var current struct {
+		Value string `json:"value"`
+	}
+	if _, err := vc.client.call(http.MethodGet, vc.prefix+"/retrieve/"+url.PathEscape(positional[0]), nil, &current); err != nil {
+		return err
+	}
+	raw, err := vc.client.call(http.MethodPut, vc.prefix+"/keys/"+url.PathEscape(positional[0]), map[string]string{
+		"newKey":   positional[1],
+		"newValue": current.Value,
+		"note":     "renamed from " + positional[0],
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if *vc.asJSON {
+		return printJSON(raw)
+	}
+	fmt.Printf("Renamed %s to %s\n", positional[0], positional[1])
+	return nil
+}
+
+func runList(args []string) error {
+	vc := newVariableCommand("list")
+	if _, err := vc.parse(args, "", 0, 0); err != nil {
+		return err
+	}
+
+	keys, raw, err := vc.listKeys()
+	if err != nil {
+		return err
+	}
+	if *vc.asJSON {
+		return printJSON(raw)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tVERSION\tCREATED")
+	for _, v := range keys {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", v.Key, v.Version, v.CreatedAt.Local().Format(time.DateTime))
+	}
+	return w.Flush()
+}
+
+func runDelete(args []string) error {
+	vc := newVariableCommand("delete")
+	positional, err := vc.parse(args, "KEY", 1, 1)
+	if err != nil {
+		return err
+	}
+
+	// Deleting goes by id, so look the key up first
+	keys, _, err := vc.listKeys()
+	if err != nil {
+		return err
+	}
+	id := ""
+	for _, v := range keys {
+		if v.Key == positional[0] {
+			id = v.ID
+		}
+	}
+	if id == "" {
+		return fmt.Errorf("key %s not found", positional[0])
+	}
+
+	raw, err := vc.client.call(http.MethodDelete, vc.prefix+"/keys/"+url.PathEscape(id), nil, nil)
+	if err != nil {
+		return err
+	}
+	if *vc.asJSON {
+		return printJSON(raw)
+	}
+	fmt.Printf("Deleted %s\n", positional[0])
+	return nil
+}
+
+func runShare(args []string) error {
+	vc := newVariableCommand("share")
+	expires := vc.fs.Duration("expires", 24*time.Hour, "how long the link works")
+	maxViews := vc.fs.Int("max-views", 0, "views before the link stops working, 0 for unlimited")
+	oneTime := vc.fs.Bool("one-time", false, "the link works once; the value is encrypted here and the server never gets the key")
+	recipients := vc.fs.String("recipients", "", "comma separated emails allowed to open the link")
+	passphrase := vc.fs.Bool("passphrase", false, "prompt for a passphrase the link needs")
+	positional, err := vc.parse(args, "KEY", 1, 1)
+	if err != nil {
+		return err
+	}
+
+	body := map[string]any{
+		"key":       positional[0],
+		"expiresIn": int64(expires.Seconds()),
+		"maxViews":  *maxViews,
+		"oneTime":   *oneTime,
+	}
+	if *recipients != "" {
+		body["recipients"] = strings.Split(*recipients, ",")
+	}
+	if *passphrase {
+		if body["passphrase"], err = promptSecret("Passphrase: "); err != nil {
+			return err
+		}
+	}
+
+	// One-time values are sealed here; only the ciphertext goes to the
+	// server and the key goes in the link's fragment
+	fragment := ""
+	if *oneTime {
+		var value struct {
+			Value string `json:"value"`
+		}
+		if _, err := vc.client.call(http.MethodGet, vc.prefix+"/retrieve/"+url.PathEscape(positional[0]), nil, &value); err != nil {
+			return err
+		}
+		key, payload, err := encryption.SealOneTime([]byte(value.Value))
+		if err != nil {
+			return err
+		}
+		body["ciphertext"] = payload
+		fragment = "#" + key
+	}
+
+	var reply map[string]any
+	if _, err := vc.client.call(http.MethodPost, vc.prefix+"/share", body, &reply); err != nil {
+		return err
+	}
+	link, _ := reply["link"].(string)
+	link += fragment
+	if *vc.asJSON {
+		reply["link"] = link
+		raw, err := json.Marshal(reply)
+		if err != nil {
+			return err
+		}
+		return printJSON(raw)
+	}
+	fmt.Println(link)
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestScopePrefix(t *testing.T) {
+	tests := []struct {
+		name  string
+		scope scope
+		want  string
+		err   bool
+	}{
+		{"flat", scope{}, "", false},
+		{"personal project", scope{project: "web", env: "prod"}, "/projects/web/envs/prod", false},
+		{"organization project", scope{org: "acme", project: "web", env: "prod"}, "/orgs/acme/projects/web/envs/prod", false},
+		{"escaped", scope{project: "my app", env: "a/b"}, "/projects/my%20app/envs/a%2Fb", false},
+		{"org alone", scope{org: "acme"}, "", true},
+		{"project without env", scope{project: "web"}, "", true},
+		{"env without project", scope{org: "acme", env: "prod"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.scope.prefix()
+			if (err != nil) != tt.err || got != tt.want {
+				t.Fatalf("prefix() = %q, %v", got, err)
+			}
+		})
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		args       []string
+		positional []string
+		asJSON     bool
+		project    string
+	}{
+		{[]string{"DB_URL"}, []string{"DB_URL"}, false, ""},
+		{[]string{"--json", "DB_URL"}, []string{"DB_URL"}, true, ""},
+		{[]string{"DB_URL", "--json", "--project", "web"}, []string{"DB_URL"}, true, "web"},
+		{[]string{"A", "--project=web", "B"}, []string{"A", "B"}, false, "web"},
+		{[]string{"A", "--", "--json", "-x"}, []string{"A", "--json", "-x"}, false, ""},
+		{nil, nil, false, ""},
+	}
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("get", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		asJSON := fs.Bool("json", false, "")
+		project := fs.String("project", "", "")
+
+		got := parseFlags(fs, tt.args)
+		if !reflect.DeepEqual(got, tt.positional) || *asJSON != tt.asJSON || *project != tt.project {
+			t.Errorf("parseFlags(%q) = %q, json %v, project %q", tt.args, got, *asJSON, *project)
+		}
+	}
+}
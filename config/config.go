@@ -0,0 +1,315 @@
+// Package config reads SafeEnv settings from the environment.
+package config
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/David-mwas/SafeEnv/encryption"
+	"github.com/David-mwas/SafeEnv/oidc"
+	"github.com/David-mwas/SafeEnv/store"
+)
+
+// Config is the full set of settings shared by the API entrypoints.
+type Config struct {
+	SecretKey    []byte
+	KeyringSpec  string
+	PrimaryKeyID string
+	KeyProvider  string
+	Keyfile      string
+	Transit      encryption.TransitConfig
+	JWTSecret    []byte
+	FrontendURL  string
+	AdminEmails  []string
+	// VersionRetention is how many versions of each variable to keep; 0 keeps all.
+	VersionRetention int
+	// ShareMaxAttempts is how many wrong passphrases lock a share; 0 uses the default.
+	ShareMaxAttempts int
+
+	AccessTokenTTL time.Duration // lifetime of a JWT access token
+	SessionTTL     time.Duration // lifetime of a login session and its refresh tokens
+	RequireMFA     bool          // every user must log in with a second factor
+
+	WebAuthnRPID    string   // passkey domain; empty uses FrontendURL's host
+	WebAuthnOrigins []string // allowed passkey origins; empty uses FrontendURL
+
+	OIDCIssuer         string // single sign-on is off unless set
+	OIDCClientID       string
+	OIDCClientSecret   string
+	OIDCRedirectURL    string
+	OIDCScopes         []string
+	OIDCAutoProvision  bool     // create accounts for new SSO users
+	OIDCAllowedDomains []string // email domains SSO may link or create accounts for; empty allows any
+
+	AuditSigningKey         string // base64 Ed25519 seed
+	AuditPublicKey          string // base64 Ed25519 public key, for verification only
+	AuditCheckpointInterval time.Duration
+
+	Store store.Config
+}
+
+// FromEnv builds a Config from SAFEENV_* environment variables.
+//
+//	SAFEENV_SECRET_KEY      32-byte master key, registered under key id "default"
+//	SAFEENV_KEYRING         extra master keys as "id:base64key,id:base64key"
+//	SAFEENV_PRIMARY_KEY_ID  key id used for new values (default "default")
+//	SAFEENV_KEY_PROVIDER    where data keys are wrapped: "env" (default), "file" or "transit"
+//	SAFEENV_KEYFILE         JSON keyring file for the "file" provider
+//	SAFEENV_TRANSIT_ADDR    Vault address for the "transit" provider
+//	SAFEENV_TRANSIT_TOKEN   Vault token (or SAFEENV_TRANSIT_TOKEN_FILE)
+//	SAFEENV_TRANSIT_MOUNT   transit mount path (default transit)
+//	SAFEENV_TRANSIT_KEY     transit key name
+//	SAFEENV_ADMIN_EMAILS    comma separated emails allowed to use /api/v1/admin
+//	SAFEENV_VERSION_RETENTION  versions kept per variable (default 0, keep all)
+//	SAFEENV_SHARE_MAX_ATTEMPTS wrong passphrases before a share locks (default 5)
+//	SAFEENV_ACCESS_TOKEN_TTL   access token lifetime (default 15m)
+//	SAFEENV_SESSION_TTL        session lifetime, after which users log in again (default 720h)
+//	SAFEENV_REQUIRE_MFA        "true" makes every user enroll in two-factor authentication
+//	SAFEENV_WEBAUTHN_RP_ID     domain passkeys are registered for (default: host of SAFEENV_FRONTEND_URL)
+//	SAFEENV_WEBAUTHN_ORIGINS   comma separated origins passkeys may be used from (default: SAFEENV_FRONTEND_URL)
+//	SAFEENV_OIDC_ISSUER        OpenID provider for single sign-on, e.g. https://accounts.google.com
+//	SAFEENV_OIDC_CLIENT_ID     client id registered with the provider
+//	SAFEENV_OIDC_CLIENT_SECRET client secret (or SAFEENV_OIDC_CLIENT_SECRET_FILE), empty for public clients
+//	SAFEENV_OIDC_REDIRECT_URL  where the provider sends users back (default SAFEENV_FRONTEND_URL + /login/sso)
+//	SAFEENV_OIDC_SCOPES        comma separated scopes (default openid,email,profile)
+//	SAFEENV_OIDC_AUTO_PROVISION  "true" creates accounts for SSO users who don't have one
+//	SAFEENV_OIDC_ALLOWED_DOMAINS comma separated email domains SSO may link or create accounts for
+//	SAFEENV_AUDIT_SIGNING_KEY  base64 Ed25519 seed for audit checkpoints (or SAFEENV_AUDIT_SIGNING_KEY_FILE)
+//	SAFEENV_AUDIT_PUBLIC_KEY   base64 Ed25519 public key used by "safeenv audit verify"
+//	SAFEENV_AUDIT_CHECKPOINT_INTERVAL  how often to sign checkpoints (default 1h)
+//	SAFEENV_STORE           "mongo" (default) or "bolt"
+//	SAFEENV_MONGO_URI       MongoDB connection string (default mongodb://localhost:27017)
+//	SAFEENV_MONGO_DB        MongoDB database name (default safeenv)
+//	SAFEENV_BOLT_PATH       BoltDB file (default safeenv.db)
+func FromEnv() Config {
+	return Config{
+		SecretKey:    []byte(os.Getenv("SAFEENV_SECRET_KEY")),
+		KeyringSpec:  os.Getenv("SAFEENV_KEYRING"),
+		PrimaryKeyID: os.Getenv("SAFEENV_PRIMARY_KEY_ID"),
+		KeyProvider:  os.Getenv("SAFEENV_KEY_PROVIDER"),
+		Keyfile:      os.Getenv("SAFEENV_KEYFILE"),
+		Transit: encryption.TransitConfig{
+			Address: os.Getenv("SAFEENV_TRANSIT_ADDR"),
+			Token:   envOrFile("SAFEENV_TRANSIT_TOKEN"),
+			Mount:   os.Getenv("SAFEENV_TRANSIT_MOUNT"),
+			KeyName: os.Getenv("SAFEENV_TRANSIT_KEY"),
+		},
+		JWTSecret:   []byte(os.Getenv("SAFEENV_JWT_SECRET")),
+		FrontendURL: os.Getenv("SAFEENV_FRONTEND_URL"),
+		AdminEmails: splitList(os.Getenv("SAFEENV_ADMIN_EMAILS")),
+
+		VersionRetention: intEnv("SAFEENV_VERSION_RETENTION"),
+		ShareMaxAttempts: intEnv("SAFEENV_SHARE_MAX_ATTEMPTS"),
+
+		AccessTokenTTL: durationEnv("SAFEENV_ACCESS_TOKEN_TTL", 15*time.Minute),
+		SessionTTL:     durationEnv("SAFEENV_SESSION_TTL", 30*24*time.Hour),
+		RequireMFA:     boolEnv("SAFEENV_REQUIRE_MFA"),
+
+		WebAuthnRPID:    os.Getenv("SAFEENV_WEBAUTHN_RP_ID"),
+		WebAuthnOrigins: splitList(os.Getenv("SAFEENV_WEBAUTHN_ORIGINS")),
+
+		OIDCIssuer:         os.Getenv("SAFEENV_OIDC_ISSUER"),
+		OIDCClientID:       os.Getenv("SAFEENV_OIDC_CLIENT_ID"),
+		OIDCClientSecret:   envOrFile("SAFEENV_OIDC_CLIENT_SECRET"),
+		OIDCRedirectURL:    os.Getenv("SAFEENV_OIDC_REDIRECT_URL"),
+		OIDCScopes:         splitList(os.Getenv("SAFEENV_OIDC_SCOPES")),
+		OIDCAutoProvision:  boolEnv("SAFEENV_OIDC_AUTO_PROVISION"),
+		OIDCAllowedDomains: splitList(os.Getenv("SAFEENV_OIDC_ALLOWED_DOMAINS")),
+
+		AuditSigningKey:         envOrFile("SAFEENV_AUDIT_SIGNING_KEY"),
+		AuditPublicKey:          os.Getenv("SAFEENV_AUDIT_PUBLIC_KEY"),
+		AuditCheckpointInterval: durationEnv("SAFEENV_AUDIT_CHECKPOINT_INTERVAL", time.Hour),
+		Store: store.Config{
+			Driver:        os.Getenv("SAFEENV_STORE"),
+			MongoURI:      os.Getenv("SAFEENV_MONGO_URI"),
+			MongoDatabase: os.Getenv("SAFEENV_MONGO_DB"),
+			BoltPath:      os.Getenv("SAFEENV_BOLT_PATH"),
+		},
+	}
+}
+
+// Keyring assembles the master keyring from SAFEENV_SECRET_KEY and
+// SAFEENV_KEYRING, or from SAFEENV_KEYFILE with the "file" provider. It
+// returns nil when no local master key is configured at all.
+func (c Config) Keyring() (*encryption.Keyring, error) {
+	if c.KeyProvider == "file" {
+		return encryption.LoadKeyfile(c.Keyfile)
+	}
+
+	keys, err := encryption.ParseKeys(c.KeyringSpec)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.SecretKey) > 0 {
+		keys[encryption.DefaultKeyID] = c.SecretKey
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	primary := c.PrimaryKeyID
+	if primary == "" {
+		primary = encryption.DefaultKeyID
+	}
+	return encryption.NewKeyring(primary, keys)
+}
+
+// Keys returns the provider that wraps data keys together with the local
+// keyring (nil if none), which is still needed to read values written before
+// data keys existed.
+func (c Config) Keys() (encryption.KeyProvider, *encryption.Keyring, error) {
+	keyring, err := c.Keyring()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch c.KeyProvider {
+	case "", "env", "file":
+		if keyring == nil {
+			return nil, nil, fmt.Errorf("no master key configured: set SAFEENV_SECRET_KEY or SAFEENV_KEYRING")
+		}
+		return keyring, keyring, nil
+	case "transit":
+		transit, err := encryption.NewTransit(c.Transit)
+		if err != nil {
+			return nil, nil, err
+		}
+		if keyring == nil {
+			return transit, nil, nil
+		}
+		// Keep the local keyring around to unwrap keys made before the switch
+		return encryption.NewProviders(transit, keyring), keyring, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown SAFEENV_KEY_PROVIDER %q", c.KeyProvider)
+	}
+}
+
+// OIDC returns the single sign-on client settings, or nil if SSO isn't
+// configured.
+func (c Config) OIDC() (*oidc.Config, error) {
+	if c.OIDCIssuer == "" {
+		return nil, nil
+	}
+	if c.OIDCClientID == "" {
+		return nil, fmt.Errorf("SAFEENV_OIDC_CLIENT_ID is required with SAFEENV_OIDC_ISSUER")
+	}
+
+	redirect := c.OIDCRedirectURL
+	if redirect == "" {
+		if c.FrontendURL == "" {
+			return nil, fmt.Errorf("set SAFEENV_OIDC_REDIRECT_URL or SAFEENV_FRONTEND_URL for single sign-on")
+		}
+		redirect = strings.TrimSuffix(c.FrontendURL, "/") + "/login/sso"
+	}
+	return &oidc.Config{
+		Issuer:       c.OIDCIssuer,
+		ClientID:     c.OIDCClientID,
+		ClientSecret: c.OIDCClientSecret,
+		RedirectURL:  redirect,
+		Scopes:       c.OIDCScopes,
+	}, nil
+}
+
+// AuditSigner returns the key that signs audit checkpoints, or nil if none
+// is configured.
+func (c Config) AuditSigner() (ed25519.PrivateKey, error) {
+	if c.AuditSigningKey == "" {
+		return nil, nil
+	}
+	seed, err := base64.StdEncoding.DecodeString(c.AuditSigningKey)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("SAFEENV_AUDIT_SIGNING_KEY must be a base64 %d-byte Ed25519 seed", ed25519.SeedSize)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// AuditVerifier returns the public key audit checkpoints are checked
+// against: SAFEENV_AUDIT_PUBLIC_KEY, or the signing key's public half.
+// It returns nil if neither is configured.
+func (c Config) AuditVerifier() (ed25519.PublicKey, error) {
+	if c.AuditPublicKey != "" {
+		pub, err := base64.StdEncoding.DecodeString(c.AuditPublicKey)
+		if err != nil || len(pub) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("SAFEENV_AUDIT_PUBLIC_KEY must be a base64 %d-byte Ed25519 public key", ed25519.PublicKeySize)
+		}
+		return pub, nil
+	}
+
+	signer, err := c.AuditSigner()
+	if signer == nil || err != nil {
+		return nil, err
+	}
+	return signer.Public().(ed25519.PublicKey), nil
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// intEnv reads a non-negative integer, treating unset or invalid values as 0.
+func intEnv(name string) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		log.Printf("Ignoring invalid %s=%q", name, v)
+		return 0
+	}
+	return n
+}
+
+// durationEnv reads a positive duration such as "15m", falling back to def.
+func durationEnv(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Printf("Ignoring invalid %s=%q", name, v)
+		return def
+	}
+	return d
+}
+
+// boolEnv reads a flag such as "true" or "1", treating unset or invalid values as false.
+func boolEnv(name string) bool {
+	v := os.Getenv(name)
+	if v == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("Ignoring invalid %s=%q", name, v)
+		return false
+	}
+	return b
+}
+
+// envOrFile reads name, falling back to the contents of the file named by name_FILE.
+func envOrFile(name string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	if path := os.Getenv(name + "_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			return strings.TrimSpace(string(data))
+		}
+	}
+	return ""
+}
@@ -0,0 +1,53 @@
+package encryption
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+)
+
+// VersionDataKey is the envelope prefix for values sealed with a per-user
+// data encryption key (DEK) rather than a master key:
+//
+//	v3:<base64(nonce || AES-256-GCM ciphertext)>
+//
+// The DEK itself is stored wrapped by a master key, so rotating the master
+// key only rewrites one small record per user.
+const VersionDataKey = "v3"
+
+// GenerateDataKey returns a fresh random 32-byte data key.
+func GenerateDataKey() ([]byte, error) {
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, err
+	}
+	return dek, nil
+}
+
+// dataKeyAD binds a wrapped DEK to the user it belongs to.
+func dataKeyAD(userID string) []byte {
+	return append([]byte("safeenv-dek:"), userID...)
+}
+
+// IsDataKeyEnvelope reports whether value was sealed with a data key.
+func IsDataKeyEnvelope(value string) bool {
+	return strings.HasPrefix(value, VersionDataKey+":")
+}
+
+// EncryptWithDataKey seals plaintext with dek and returns a v3 envelope.
+func EncryptWithDataKey(dek, plaintext, ad []byte) (string, error) {
+	payload, err := sealGCM(dek, plaintext, ad)
+	if err != nil {
+		return "", err
+	}
+	return VersionDataKey + ":" + payload, nil
+}
+
+// DecryptWithDataKey opens a v3 envelope.
+func DecryptWithDataKey(dek []byte, value string, ad []byte) ([]byte, error) {
+	payload, ok := strings.CutPrefix(value, VersionDataKey+":")
+	if !ok {
+		return nil, fmt.Errorf("encryption: not a %s envelope", VersionDataKey)
+	}
+	return openGCM(dek, payload, ad)
+}
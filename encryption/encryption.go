@@ -0,0 +1,146 @@
+// Package encryption seals variable values for storage.
+//
+// Values are stored as a versioned envelope:
+//
+//	v3:<base64(nonce || AES-256-GCM ciphertext)>   (sealed with the owner's data key)
+//	v2:<key id>:<base64(nonce || AES-256-GCM ciphertext)>
+//	v1:<base64(nonce || AES-256-GCM ciphertext)>   (implicit key id "default")
+//
+// The associated data binds every ciphertext to the owning user and key name,
+// so a value copied onto another record fails to decrypt instead of silently
+// producing garbage. Values without a version prefix are the original
+// unauthenticated AES-CFB format; they can still be read so existing data
+// keeps working until it has been migrated.
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// VersionGCM is the envelope prefix for AES-GCM values without a key id.
+	VersionGCM = "v1"
+	// VersionKeyed is the envelope prefix for AES-GCM values tagged with a key id.
+	VersionKeyed = "v2"
+)
+
+var (
+	// ErrDecrypt is returned when a ciphertext fails authentication.
+	ErrDecrypt = errors.New("encryption: message authentication failed")
+	// ErrUnknownVersion is returned for envelopes this build cannot read.
+	ErrUnknownVersion = errors.New("encryption: unknown envelope version")
+)
+
+// AssociatedData returns the additional authenticated data for a variable.
+// Each field is length-prefixed so ("ab", "c") and ("a", "bc") differ.
+// Scope fields (project and environment ids) are only appended when set, so
+// variables outside any project keep the bytes they were sealed with.
+func AssociatedData(userID, key string, scope ...string) []byte {
+	fields := []string{userID, key}
+	for _, f := range scope {
+		if f != "" {
+			fields = append(fields, f)
+		}
+	}
+
+	ad := []byte("safeenv:")
+	for _, f := range fields {
+		ad = binary.BigEndian.AppendUint32(ad, uint32(len(f)))
+		ad = append(ad, f...)
+	}
+	return ad
+}
+
+// IsLegacy reports whether value uses the unauthenticated CFB format.
+// Standard base64 never contains ':', so the prefix is unambiguous.
+func IsLegacy(value string) bool {
+	return !strings.Contains(value, ":")
+}
+
+// KeyID returns the id of the key that produced value. Values written before
+// key ids existed (v1 and legacy CFB) report DefaultKeyID.
+func KeyID(value string) string {
+	version, rest, ok := strings.Cut(value, ":")
+	if ok && version == VersionKeyed {
+		kid, _, _ := strings.Cut(rest, ":")
+		return kid
+	}
+	return DefaultKeyID
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func sealGCM(key, plaintext, ad []byte) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, plaintext, ad)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func openGCM(key []byte, payload string, ad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, ad)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+func openCFB(key []byte, value string) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedBytes, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(encryptedBytes) < aes.BlockSize {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+
+	iv := encryptedBytes[:aes.BlockSize]         // Extract IV
+	ciphertext := encryptedBytes[aes.BlockSize:] // Extract actual data
+
+	stream := cipher.NewCFBDecrypter(block, iv)
+	plaintext := make([]byte, len(ciphertext))
+	stream.XORKeyStream(plaintext, ciphertext)
+
+	return plaintext, nil
+}
@@ -0,0 +1,53 @@
+package encryption
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// keyfile is the on-disk format read by LoadKeyfile:
+//
+//	{"primary": "2025-06", "keys": {"2025-06": "<base64 32 bytes>", "default": "..."}}
+type keyfile struct {
+	Primary string            `json:"primary"`
+	Keys    map[string]string `json:"keys"`
+}
+
+// LoadKeyfile reads a keyring from a JSON file so master keys can be kept out
+// of the process environment. The file must not be readable by group or others.
+func LoadKeyfile(path string) (*Keyring, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("encryption: keyfile: %w", err)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("encryption: keyfile %s must not be accessible by group or others (mode %v)", path, info.Mode().Perm())
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("encryption: keyfile: %w", err)
+	}
+
+	var kf keyfile
+	if err := json.Unmarshal(data, &kf); err != nil {
+		return nil, fmt.Errorf("encryption: keyfile %s: %w", path, err)
+	}
+
+	keys := make(map[string][]byte, len(kf.Keys))
+	for id, encoded := range kf.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("encryption: keyfile %s: key %q: %w", path, id, err)
+		}
+		keys[id] = key
+	}
+
+	primary := kf.Primary
+	if primary == "" {
+		primary = DefaultKeyID
+	}
+	return NewKeyring(primary, keys)
+}
@@ -0,0 +1,136 @@
+package encryption
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DefaultKeyID names the key configured through SAFEENV_SECRET_KEY. Values
+// written before key ids existed were all produced by it.
+const DefaultKeyID = "default"
+
+// ErrUnknownKey is returned when a value references a key id not in the keyring.
+var ErrUnknownKey = errors.New("encryption: unknown key id")
+
+// Keyring holds every master key that may still be referenced by stored
+// values. New values are always sealed with the primary key; older keys are
+// kept only for decryption until everything has been re-encrypted.
+type Keyring struct {
+	keys    map[string][]byte
+	primary string
+}
+
+// NewKeyring builds a keyring from 32-byte keys indexed by id.
+func NewKeyring(primary string, keys map[string][]byte) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("encryption: keyring is empty")
+	}
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("encryption: invalid key id %q", id)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("encryption: key %q must be exactly 32 bytes long not: %d", id, len(key))
+		}
+	}
+	if _, ok := keys[primary]; !ok {
+		return nil, fmt.Errorf("%w %q (primary)", ErrUnknownKey, primary)
+	}
+	return &Keyring{keys: keys, primary: primary}, nil
+}
+
+// ParseKeys parses a comma separated list of "id:base64key" pairs.
+func ParseKeys(spec string) (map[string][]byte, error) {
+	keys := map[string][]byte{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("encryption: keyring entry %q is not id:base64key", entry)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("encryption: key %q: %w", id, err)
+		}
+		keys[id] = key
+	}
+	return keys, nil
+}
+
+// Primary returns the id of the key used for new values.
+func (k *Keyring) Primary() string {
+	return k.primary
+}
+
+// IDs returns every key id in the keyring, sorted.
+func (k *Keyring) IDs() []string {
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (k *Keyring) key(id string) ([]byte, error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+	return key, nil
+}
+
+// Encrypt seals plaintext with the primary key and returns a v2 envelope.
+func (k *Keyring) Encrypt(plaintext, ad []byte) (string, error) {
+	payload, err := sealGCM(k.keys[k.primary], plaintext, ad)
+	if err != nil {
+		return "", err
+	}
+	return VersionKeyed + ":" + k.primary + ":" + payload, nil
+}
+
+// Decrypt opens any envelope version this package has produced.
+// Legacy CFB values carry no associated data, so ad is ignored for them.
+func (k *Keyring) Decrypt(value string, ad []byte) ([]byte, error) {
+	version, rest, ok := strings.Cut(value, ":")
+	if !ok {
+		key, err := k.key(DefaultKeyID)
+		if err != nil {
+			return nil, err
+		}
+		return openCFB(key, value)
+	}
+
+	switch version {
+	case VersionGCM:
+		key, err := k.key(DefaultKeyID)
+		if err != nil {
+			return nil, err
+		}
+		return openGCM(key, rest, ad)
+	case VersionKeyed:
+		kid, payload, ok := strings.Cut(rest, ":")
+		if !ok {
+			return nil, fmt.Errorf("encryption: malformed %s envelope", VersionKeyed)
+		}
+		key, err := k.key(kid)
+		if err != nil {
+			return nil, err
+		}
+		return openGCM(key, payload, ad)
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnknownVersion, version)
+	}
+}
+
+// NeedsReencrypt reports whether value should be rewritten: it is not a
+// current-version envelope or was sealed with a key other than the primary.
+func (k *Keyring) NeedsReencrypt(value string) bool {
+	return !strings.HasPrefix(value, VersionKeyed+":") || KeyID(value) != k.primary
+}
@@ -0,0 +1,180 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+var (
+	oldKey = bytes.Repeat([]byte{1}, 32)
+	newKey = bytes.Repeat([]byte{2}, 32)
+)
+
+func newTestKeyring(t *testing.T, primary string, keys map[string][]byte) *Keyring {
+	t.Helper()
+	k, err := NewKeyring(primary, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+// sealCFB produces a value the way releases before envelopes stored them.
+func sealCFB(t *testing.T, key, plaintext []byte) string {
+	t.Helper()
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make([]byte, aes.BlockSize+len(plaintext))
+	if _, err := rand.Read(out[:aes.BlockSize]); err != nil {
+		t.Fatal(err)
+	}
+	cipher.NewCFBEncrypter(block, out[:aes.BlockSize]).XORKeyStream(out[aes.BlockSize:], plaintext)
+	return base64.StdEncoding.EncodeToString(out)
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	k := newTestKeyring(t, DefaultKeyID, map[string][]byte{DefaultKeyID: oldKey})
+	ad := AssociatedData("alice", "DB_URL")
+	plaintext := []byte("postgres://localhost")
+
+	v1Payload, err := sealGCM(oldKey, plaintext, ad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, err := k.Encrypt(plaintext, ad)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		value  string
+		legacy bool
+	}{
+		{"legacy CFB", sealCFB(t, oldKey, plaintext), true},
+		{"v1", VersionGCM + ":" + v1Payload, false},
+		{"v2", v2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if IsLegacy(tt.value) != tt.legacy {
+				t.Fatalf("IsLegacy(%q) = %v", tt.value, !tt.legacy)
+			}
+			if KeyID(tt.value) != DefaultKeyID {
+				t.Fatalf("KeyID = %q", KeyID(tt.value))
+			}
+			got, err := k.Decrypt(tt.value, ad)
+			if err != nil || !bytes.Equal(got, plaintext) {
+				t.Fatalf("decrypt: %q %v", got, err)
+			}
+			if tt.legacy {
+				return
+			}
+			// Authenticated envelopes are bound to their variable
+			if _, err := k.Decrypt(tt.value, AssociatedData("mallory", "DB_URL")); !errors.Is(err, ErrDecrypt) {
+				t.Fatalf("decrypt with another owner: %v", err)
+			}
+		})
+	}
+
+	t.Run("v3", func(t *testing.T) {
+		dek, err := GenerateDataKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		v3, err := EncryptWithDataKey(dek, plaintext, ad)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsDataKeyEnvelope(v3) || IsLegacy(v3) {
+			t.Fatalf("unexpected envelope %q", v3)
+		}
+		got, err := DecryptWithDataKey(dek, v3, ad)
+		if err != nil || !bytes.Equal(got, plaintext) {
+			t.Fatalf("decrypt: %q %v", got, err)
+		}
+		if _, err := DecryptWithDataKey(dek, v3, AssociatedData("alice", "OTHER")); !errors.Is(err, ErrDecrypt) {
+			t.Fatalf("decrypt with another key name: %v", err)
+		}
+		if _, err := DecryptWithDataKey(dek, v2, ad); err == nil {
+			t.Fatal("opened a v2 envelope as v3")
+		}
+	})
+
+	if _, err := k.Decrypt("v9:AAAA", ad); !errors.Is(err, ErrUnknownVersion) {
+		t.Fatalf("unknown version: %v", err)
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	ad := AssociatedData("alice", "DB_URL")
+	before := newTestKeyring(t, DefaultKeyID, map[string][]byte{DefaultKeyID: oldKey})
+	sealed, err := before.Encrypt([]byte("secret"), ad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(sealed, VersionKeyed+":"+DefaultKeyID+":") || before.NeedsReencrypt(sealed) {
+		t.Fatalf("sealed %q", sealed)
+	}
+
+	// After rotating, values sealed with the old key still open
+	after := newTestKeyring(t, "2025-06", map[string][]byte{DefaultKeyID: oldKey, "2025-06": newKey})
+	got, err := after.Decrypt(sealed, ad)
+	if err != nil || string(got) != "secret" {
+		t.Fatalf("decrypt with the old key id: %q %v", got, err)
+	}
+	if !after.NeedsReencrypt(sealed) {
+		t.Fatal("value under the old key doesn't need re-encryption")
+	}
+
+	resealed, err := after.Encrypt(got, ad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if KeyID(resealed) != "2025-06" || after.NeedsReencrypt(resealed) {
+		t.Fatalf("resealed %q", resealed)
+	}
+
+	// and once the old key is dropped, only re-encrypted values do
+	dropped := newTestKeyring(t, "2025-06", map[string][]byte{"2025-06": newKey})
+	if _, err := dropped.Decrypt(sealed, ad); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("decrypt after dropping the key: %v", err)
+	}
+	if got, err := dropped.Decrypt(resealed, ad); err != nil || string(got) != "secret" {
+		t.Fatalf("decrypt re-encrypted value: %q %v", got, err)
+	}
+}
+
+func TestNewKeyring(t *testing.T) {
+	tests := []struct {
+		name    string
+		primary string
+		keys    map[string][]byte
+	}{
+		{"empty", DefaultKeyID, nil},
+		{"short key", DefaultKeyID, map[string][]byte{DefaultKeyID: oldKey[:16]}},
+		{"colon in id", "a:b", map[string][]byte{"a:b": oldKey}},
+		{"missing primary", "2025-06", map[string][]byte{DefaultKeyID: oldKey}},
+	}
+	for _, tt := range tests {
+		if _, err := NewKeyring(tt.primary, tt.keys); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+
+	keys, err := ParseKeys(" old:" + base64.StdEncoding.EncodeToString(oldKey) + ", new:" + base64.StdEncoding.EncodeToString(newKey))
+	if err != nil || !bytes.Equal(keys["old"], oldKey) || !bytes.Equal(keys["new"], newKey) {
+		t.Fatalf("ParseKeys: %v %v", keys, err)
+	}
+	if _, err := ParseKeys("nocolon"); err == nil {
+		t.Fatal("ParseKeys accepted an entry without an id")
+	}
+}
@@ -0,0 +1,46 @@
+package encryption
+
+import (
+	"encoding/base64"
+	"errors"
+)
+
+// SealOneTime encrypts plaintext under a fresh AES-256-GCM key for a
+// one-time share. It runs on the client: only the payload is sent to the
+// server, and the key, base64url encoded, goes in the link's URL fragment.
+// The payload is base64(nonce || ciphertext), the layout Web Crypto
+// expects, so a browser can open it without the server.
+func SealOneTime(plaintext []byte) (key, payload string, err error) {
+	k, err := GenerateDataKey()
+	if err != nil {
+		return "", "", err
+	}
+	payload, err = sealGCM(k, plaintext, nil)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(k), payload, nil
+}
+
+// OpenOneTime decrypts a payload produced by SealOneTime.
+func OpenOneTime(key, payload string) ([]byte, error) {
+	k, err := base64.RawURLEncoding.DecodeString(key)
+	if err != nil {
+		return nil, err
+	}
+	return openGCM(k, payload, nil)
+}
+
+// CheckOneTime checks that a payload has the layout SealOneTime produces,
+// without being able to decrypt it.
+func CheckOneTime(payload string) error {
+	sealed, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return errors.New("ciphertext must be base64")
+	}
+	// 12-byte nonce and 16-byte tag
+	if len(sealed) < 12+16 {
+		return errors.New("ciphertext is too short")
+	}
+	return nil
+}
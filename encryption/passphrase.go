@@ -0,0 +1,121 @@
+package encryption
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2id parameters for passphrases, OWASP's 19 MiB / 2 pass baseline.
+const (
+	argonMemory  = 19 * 1024
+	argonTime    = 2
+	argonThreads = 1
+	argonKeyLen  = 32
+	argonSaltLen = 16
+)
+
+var errBadPassphraseHash = errors.New("encryption: malformed passphrase hash")
+
+// HashPassphrase derives a key from passphrase with Argon2id and returns it
+// with its salt and parameters in the usual PHC string form:
+//
+//	$argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>
+func HashPassphrase(passphrase string) (string, error) {
+	salt := make([]byte, argonSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(passphrase), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, argonMemory, argonTime, argonThreads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// VerifyPassphrase reports whether passphrase matches a HashPassphrase
+// result, using the parameters stored with it.
+func VerifyPassphrase(encoded, passphrase string) (bool, error) {
+	p, err := parsePHC(encoded)
+	if err != nil {
+		return false, err
+	}
+	want, err := base64.RawStdEncoding.DecodeString(p.rest)
+	if err != nil {
+		return false, errBadPassphraseHash
+	}
+
+	got := argon2.IDKey([]byte(passphrase), p.salt, p.time, p.memory, p.threads, uint32(len(want)))
+	return subtle.ConstantTimeCompare(got, want) == 1, nil
+}
+
+// SealWithPassphrase encrypts plaintext with AES-256-GCM under a key
+// derived from passphrase with Argon2id, so it can't be read without the
+// passphrase. The result has the same form as HashPassphrase, with the
+// ciphertext in place of the key:
+//
+//	$argon2id$v=19$m=19456,t=2,p=1$<salt>$<base64(nonce || ciphertext)>
+func SealWithPassphrase(passphrase string, plaintext []byte) (string, error) {
+	salt := make([]byte, argonSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(passphrase), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+
+	payload, err := sealGCM(key, plaintext, nil)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, argonMemory, argonTime, argonThreads,
+		base64.RawStdEncoding.EncodeToString(salt), payload,
+	), nil
+}
+
+// OpenWithPassphrase decrypts a SealWithPassphrase result. A wrong
+// passphrase gives ErrDecrypt.
+func OpenWithPassphrase(sealed, passphrase string) ([]byte, error) {
+	p, err := parsePHC(sealed)
+	if err != nil {
+		return nil, err
+	}
+	key := argon2.IDKey([]byte(passphrase), p.salt, p.time, p.memory, p.threads, argonKeyLen)
+	return openGCM(key, p.rest, nil)
+}
+
+// phc is a parsed $argon2id$ string; rest is its last field.
+type phc struct {
+	memory, time uint32
+	threads      uint8
+	salt         []byte
+	rest         string
+}
+
+func parsePHC(encoded string) (*phc, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, errBadPassphraseHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, errBadPassphraseHash
+	}
+	var p phc
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return nil, errBadPassphraseHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, errBadPassphraseHash
+	}
+	p.salt, p.rest = salt, parts[5]
+	return &p, nil
+}
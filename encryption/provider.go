@@ -0,0 +1,99 @@
+package encryption
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// KeyProvider wraps and unwraps data keys with a master key. The master key
+// may live in SafeEnv's own process (Keyring) or in an external KMS (Transit)
+// that never hands it out.
+type KeyProvider interface {
+	// Primary identifies the master key new data keys are wrapped with.
+	Primary() string
+	Wrap(ctx context.Context, plaintext, ad []byte) (string, error)
+	Unwrap(ctx context.Context, wrapped string, ad []byte) ([]byte, error)
+	// Handles reports whether wrapped was produced by this provider.
+	Handles(wrapped string) bool
+	// NeedsRewrap reports whether wrapped should be re-wrapped with the primary key.
+	NeedsRewrap(wrapped string) bool
+}
+
+// WrapDataKey seals a user's data key with p.
+func WrapDataKey(ctx context.Context, p KeyProvider, dek []byte, userID string) (string, error) {
+	return p.Wrap(ctx, dek, dataKeyAD(userID))
+}
+
+// UnwrapDataKey opens a data key produced by WrapDataKey.
+func UnwrapDataKey(ctx context.Context, p KeyProvider, wrapped, userID string) ([]byte, error) {
+	return p.Unwrap(ctx, wrapped, dataKeyAD(userID))
+}
+
+// Providers wraps new keys with Primary but can unwrap keys produced by any
+// of its members, which is what a migration between providers needs.
+type Providers struct {
+	primary KeyProvider
+	others  []KeyProvider
+}
+
+// NewProviders combines a primary provider with fallbacks used only to unwrap.
+func NewProviders(primary KeyProvider, others ...KeyProvider) *Providers {
+	return &Providers{primary: primary, others: others}
+}
+
+func (p *Providers) Primary() string { return p.primary.Primary() }
+
+func (p *Providers) Wrap(ctx context.Context, plaintext, ad []byte) (string, error) {
+	return p.primary.Wrap(ctx, plaintext, ad)
+}
+
+func (p *Providers) Unwrap(ctx context.Context, wrapped string, ad []byte) ([]byte, error) {
+	for _, kp := range append([]KeyProvider{p.primary}, p.others...) {
+		if kp.Handles(wrapped) {
+			return kp.Unwrap(ctx, wrapped, ad)
+		}
+	}
+	version, _, _ := strings.Cut(wrapped, ":")
+	return nil, fmt.Errorf("%w %q: no key provider configured for it", ErrUnknownVersion, version)
+}
+
+func (p *Providers) Handles(wrapped string) bool {
+	for _, kp := range append([]KeyProvider{p.primary}, p.others...) {
+		if kp.Handles(wrapped) {
+			return true
+		}
+	}
+	return false
+}
+
+// NeedsRewrap is true for anything not wrapped by the current primary key,
+// including keys still held by a fallback provider.
+func (p *Providers) NeedsRewrap(wrapped string) bool {
+	return !p.primary.Handles(wrapped) || p.primary.NeedsRewrap(wrapped)
+}
+
+// Keyring implements KeyProvider with master keys held in process memory.
+
+func (k *Keyring) Wrap(ctx context.Context, plaintext, ad []byte) (string, error) {
+	return k.Encrypt(plaintext, ad)
+}
+
+func (k *Keyring) Unwrap(ctx context.Context, wrapped string, ad []byte) ([]byte, error) {
+	return k.Decrypt(wrapped, ad)
+}
+
+// Handles reports whether wrapped is a keyring envelope (v1 or v2).
+func (k *Keyring) Handles(wrapped string) bool {
+	return strings.HasPrefix(wrapped, VersionKeyed+":") || strings.HasPrefix(wrapped, VersionGCM+":")
+}
+
+// NeedsRewrap reports whether wrapped was sealed with a non-primary key.
+func (k *Keyring) NeedsRewrap(wrapped string) bool {
+	return k.NeedsReencrypt(wrapped)
+}
+
+var (
+	_ KeyProvider = (*Keyring)(nil)
+	_ KeyProvider = (*Providers)(nil)
+)
@@ -0,0 +1,171 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// transitPrefix is how the Vault transit engine tags its ciphertext.
+const transitPrefix = "vault:v"
+
+// TransitConfig points at a HashiCorp Vault (or API compatible) transit engine.
+type TransitConfig struct {
+	Address string // e.g. https://vault.internal:8200
+	Token   string // sent as X-Vault-Token
+	Mount   string // secrets engine mount, default "transit"
+	KeyName string
+	Client  *http.Client
+}
+
+// Transit is a KeyProvider backed by the Vault transit secrets engine. The
+// master key never leaves Vault; SafeEnv only sends data keys to be wrapped
+// or unwrapped.
+type Transit struct {
+	cfg TransitConfig
+
+	mu            sync.Mutex
+	latestVersion int
+	checkedAt     time.Time
+}
+
+// NewTransit returns a Transit provider for cfg.
+func NewTransit(cfg TransitConfig) (*Transit, error) {
+	if cfg.Address == "" || cfg.KeyName == "" {
+		return nil, fmt.Errorf("encryption: transit address and key name are required")
+	}
+	if cfg.Mount == "" {
+		cfg.Mount = "transit"
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	cfg.Address = strings.TrimRight(cfg.Address, "/")
+	return &Transit{cfg: cfg}, nil
+}
+
+// Primary identifies the transit key; Vault tracks key versions itself.
+func (t *Transit) Primary() string {
+	return "transit:" + t.cfg.KeyName
+}
+
+// Handles reports whether wrapped is Vault transit ciphertext.
+func (t *Transit) Handles(wrapped string) bool {
+	return strings.HasPrefix(wrapped, transitPrefix)
+}
+
+func (t *Transit) Wrap(ctx context.Context, plaintext, ad []byte) (string, error) {
+	var resp struct {
+		Ciphertext string `json:"ciphertext"`
+	}
+	err := t.call(ctx, http.MethodPost, "encrypt", map[string]string{
+		"plaintext":       base64.StdEncoding.EncodeToString(plaintext),
+		"associated_data": base64.StdEncoding.EncodeToString(ad),
+	}, &resp)
+	if err != nil {
+		return "", err
+	}
+	return resp.Ciphertext, nil
+}
+
+func (t *Transit) Unwrap(ctx context.Context, wrapped string, ad []byte) ([]byte, error) {
+	var resp struct {
+		Plaintext string `json:"plaintext"`
+	}
+	err := t.call(ctx, http.MethodPost, "decrypt", map[string]string{
+		"ciphertext":      wrapped,
+		"associated_data": base64.StdEncoding.EncodeToString(ad),
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(resp.Plaintext)
+}
+
+// NeedsRewrap reports whether wrapped was produced by an older version of
+// the transit key. If Vault cannot be reached it errs on the side of true;
+// re-wrapping a current key is harmless.
+func (t *Transit) NeedsRewrap(wrapped string) bool {
+	rest, ok := strings.CutPrefix(wrapped, transitPrefix)
+	if !ok {
+		return true
+	}
+	versionStr, _, _ := strings.Cut(rest, ":")
+	version, err := strconv.Atoi(versionStr)
+	if err != nil {
+		return true
+	}
+
+	latest, err := t.latestKeyVersion()
+	if err != nil {
+		return true
+	}
+	return version < latest
+}
+
+// latestKeyVersion reads the key's latest version, cached for a minute.
+func (t *Transit) latestKeyVersion() (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if time.Since(t.checkedAt) < time.Minute {
+		return t.latestVersion, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var resp struct {
+		LatestVersion int `json:"latest_version"`
+	}
+	if err := t.call(ctx, http.MethodGet, "keys", nil, &resp); err != nil {
+		return 0, err
+	}
+	t.latestVersion, t.checkedAt = resp.LatestVersion, time.Now()
+	return t.latestVersion, nil
+}
+
+// call performs a transit API request and decodes its "data" object into out.
+func (t *Transit) call(ctx context.Context, method, op string, body interface{}, out interface{}) error {
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			return err
+		}
+	}
+
+	url := fmt.Sprintf("%s/v1/%s/%s/%s", t.cfg.Address, t.cfg.Mount, op, t.cfg.KeyName)
+	req, err := http.NewRequestWithContext(ctx, method, url, &reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", t.cfg.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.cfg.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("encryption: transit %s: %w", op, err)
+	}
+	defer resp.Body.Close()
+
+	var envelope struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []string        `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return fmt.Errorf("encryption: transit %s: status %d: %w", op, resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("encryption: transit %s: status %d: %s", op, resp.StatusCode, strings.Join(envelope.Errors, "; "))
+	}
+	return json.Unmarshal(envelope.Data, out)
+}
+
+var _ KeyProvider = (*Transit)(nil)
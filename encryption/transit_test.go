@@ -0,0 +1,285 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubTransit is a Vault transit engine with one key, serving
+// /v1/transit/{encrypt,decrypt,keys}/:name. Each version of the key is a
+// real AES key so associated data is checked the way Vault checks it.
+type stubTransit struct {
+	*httptest.Server
+	t     *testing.T
+	token string
+	name  string
+
+	mu       sync.Mutex
+	versions [][]byte // versions[i] is version i+1
+	keyReads int
+}
+
+func newStubTransit(t *testing.T) *stubTransit {
+	s := &stubTransit{t: t, token: "s.test", name: "safeenv"}
+	s.rotate()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/transit/encrypt/{name}", s.encrypt)
+	mux.HandleFunc("POST /v1/transit/decrypt/{name}", s.decrypt)
+	mux.HandleFunc("GET /v1/transit/keys/{name}", s.keys)
+	s.Server = httptest.NewServer(s.auth(mux))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// rotate adds a key version, like POST /v1/transit/keys/:name/rotate.
+func (s *stubTransit) rotate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		s.t.Fatal(err)
+	}
+	s.versions = append(s.versions, key)
+}
+
+func (s *stubTransit) auth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != s.token {
+			vaultError(w, http.StatusForbidden, "permission denied")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func vaultError(w http.ResponseWriter, status int, errs ...string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"errors": errs})
+}
+
+func vaultReply(w http.ResponseWriter, data any) {
+	json.NewEncoder(w).Encode(map[string]any{"data": data})
+}
+
+func (s *stubTransit) known(w http.ResponseWriter, r *http.Request) bool {
+	if r.PathValue("name") != s.name {
+		vaultError(w, http.StatusBadRequest, "encryption key not found")
+		return false
+	}
+	return true
+}
+
+func (s *stubTransit) encrypt(w http.ResponseWriter, r *http.Request) {
+	if !s.known(w, r) {
+		return
+	}
+	var req struct {
+		Plaintext      string `json:"plaintext"`
+		AssociatedData string `json:"associated_data"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	plaintext, err1 := base64.StdEncoding.DecodeString(req.Plaintext)
+	ad, err2 := base64.StdEncoding.DecodeString(req.AssociatedData)
+	if err1 != nil || err2 != nil {
+		vaultError(w, http.StatusBadRequest, "failed to base64-decode plaintext")
+		return
+	}
+
+	s.mu.Lock()
+	version := len(s.versions)
+	key := s.versions[version-1]
+	s.mu.Unlock()
+
+	sealed, err := sealGCM(key, plaintext, ad)
+	if err != nil {
+		vaultError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	vaultReply(w, map[string]any{"ciphertext": fmt.Sprintf("vault:v%d:%s", version, sealed), "key_version": version})
+}
+
+func (s *stubTransit) decrypt(w http.ResponseWriter, r *http.Request) {
+	if !s.known(w, r) {
+		return
+	}
+	var req struct {
+		Ciphertext     string `json:"ciphertext"`
+		AssociatedData string `json:"associated_data"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	ad, _ := base64.StdEncoding.DecodeString(req.AssociatedData)
+
+	rest, ok := strings.CutPrefix(req.Ciphertext, transitPrefix)
+	versionStr, payload, _ := strings.Cut(rest, ":")
+	version, err := strconv.Atoi(versionStr)
+	s.mu.Lock()
+	valid := ok && err == nil && version >= 1 && version <= len(s.versions)
+	var key []byte
+	if valid {
+		key = s.versions[version-1]
+	}
+	s.mu.Unlock()
+	if !valid {
+		vaultError(w, http.StatusBadRequest, "invalid ciphertext: no prefix")
+		return
+	}
+
+	plaintext, err := openGCM(key, payload, ad)
+	if err != nil {
+		vaultError(w, http.StatusBadRequest, "cipher: message authentication failed")
+		return
+	}
+	vaultReply(w, map[string]any{"plaintext": base64.StdEncoding.EncodeToString(plaintext)})
+}
+
+func (s *stubTransit) keys(w http.ResponseWriter, r *http.Request) {
+	if !s.known(w, r) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keyReads++
+	vaultReply(w, map[string]any{"name": s.name, "type": "aes256-gcm96", "latest_version": len(s.versions)})
+}
+
+func newTestTransit(t *testing.T, stub *stubTransit) *Transit {
+	t.Helper()
+	tr, err := NewTransit(TransitConfig{Address: stub.URL + "/", Token: stub.token, KeyName: stub.name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tr
+}
+
+func TestTransitRoundTrip(t *testing.T) {
+	stub := newStubTransit(t)
+	tr := newTestTransit(t, stub)
+	ctx := context.Background()
+	dataKey := bytes.Repeat([]byte{7}, 32)
+
+	wrapped, err := tr.Wrap(ctx, dataKey, []byte("users/alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tr.Handles(wrapped) || !strings.HasPrefix(wrapped, "vault:v1:") {
+		t.Fatalf("unexpected ciphertext %q", wrapped)
+	}
+	got, err := tr.Unwrap(ctx, wrapped, []byte("users/alice"))
+	if err != nil || !bytes.Equal(got, dataKey) {
+		t.Fatalf("unwrap: %x %v", got, err)
+	}
+
+	// Associated data binds the key to its owner
+	if _, err := tr.Unwrap(ctx, wrapped, []byte("users/mallory")); err == nil {
+		t.Fatal("unwrapped with the wrong associated data")
+	}
+}
+
+func TestTransitErrors(t *testing.T) {
+	stub := newStubTransit(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		cfg  TransitConfig
+		want string
+	}{
+		{"bad token", TransitConfig{Address: stub.URL, Token: "wrong", KeyName: stub.name}, "status 403: permission denied"},
+		{"unknown key", TransitConfig{Address: stub.URL, Token: stub.token, KeyName: "missing"}, "status 400: encryption key not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr, err := NewTransit(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tr.Wrap(ctx, []byte("key"), nil); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("wrap: %v, want %q", err, tt.want)
+			}
+			if _, err := tr.Unwrap(ctx, "vault:v1:AAAA", nil); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("unwrap: %v, want %q", err, tt.want)
+			}
+		})
+	}
+
+	tr := newTestTransit(t, stub)
+	if _, err := tr.Unwrap(ctx, "vault:v9:AAAA", nil); err == nil || !strings.Contains(err.Error(), "invalid ciphertext") {
+		t.Fatalf("unknown version: %v", err)
+	}
+
+	// A proxy answering with something other than a Vault envelope
+	html := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("<html>bad gateway</html>"))
+	}))
+	defer html.Close()
+	tr, err := NewTransit(TransitConfig{Address: html.URL, KeyName: stub.name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tr.Wrap(ctx, []byte("key"), nil); err == nil || !strings.Contains(err.Error(), "status 502") {
+		t.Fatalf("non-JSON response: %v", err)
+	}
+}
+
+func TestTransitNeedsRewrap(t *testing.T) {
+	stub := newStubTransit(t)
+	tr := newTestTransit(t, stub)
+	ctx := context.Background()
+
+	v1, err := tr.Wrap(ctx, []byte("key"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.NeedsRewrap(v1) {
+		t.Fatal("current ciphertext needs rewrap")
+	}
+
+	stub.rotate()
+	// The latest version is cached for a minute
+	if tr.NeedsRewrap(v1) {
+		t.Fatal("latest version was not cached")
+	}
+	stub.mu.Lock()
+	keyReads := stub.keyReads
+	stub.mu.Unlock()
+	if keyReads != 1 {
+		t.Fatalf("key read %d times, want 1", keyReads)
+	}
+	tr.mu.Lock()
+	tr.checkedAt = time.Time{}
+	tr.mu.Unlock()
+
+	if !tr.NeedsRewrap(v1) {
+		t.Fatal("ciphertext from an old key version doesn't need rewrap")
+	}
+	v2, err := tr.Wrap(ctx, []byte("key"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(v2, "vault:v2:") || tr.NeedsRewrap(v2) {
+		t.Fatalf("rewrapped ciphertext %q still needs rewrap", v2)
+	}
+	// Old versions still decrypt until Vault's min_decryption_version moves
+	if got, err := tr.Unwrap(ctx, v1, nil); err != nil || string(got) != "key" {
+		t.Fatalf("unwrap v1: %q %v", got, err)
+	}
+
+	for _, wrapped := range []string{"vault:vX:AAAA", "local:AAAA"} {
+		if !tr.NeedsRewrap(wrapped) {
+			t.Errorf("NeedsRewrap(%q) = false", wrapped)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+// Package envfile reads and writes sets of environment variables in the
+// file formats people keep them in: dotenv, JSON and YAML both ways, plus
+// shell scripts and Docker --env-file files for export, and Kubernetes
+// Secret and ConfigMap manifests.
+//
+// Dotenv files are read with godotenv, so quoting, multiline values,
+// "export" prefixes and comments mean what they do for the API server's
+// own .env, and written so godotenv reads back exactly the same values.
+package envfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Format is a file format variables can be imported from or exported to.
+type Format string
+
+const (
+	Dotenv Format = "dotenv" // KEY="value", as read by godotenv
+	JSON   Format = "json"   // {"KEY": "value"}
+	YAML   Format = "yaml"   // KEY: value
+	Shell  Format = "shell"  // export KEY='value', for eval or source
+	Docker Format = "docker" // KEY=value, for docker run --env-file
+)
+
+// Formats lists every format, the importable ones first.
+var Formats = []Format{Dotenv, JSON, YAML, Shell, Docker}
+
+// ParseFormat checks name is a known format. An empty name is dotenv.
+func ParseFormat(name string) (Format, error) {
+	if name == "" {
+		return Dotenv, nil
+	}
+	for _, f := range Formats {
+		if string(f) == name {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown format %q, use dotenv, json, yaml, shell or docker", name)
+}
+
+// FormatOf guesses a file's format from its extension, falling back to
+// dotenv.
+func FormatOf(filename string) Format {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		return JSON
+	case ".yaml", ".yml":
+		return YAML
+	case ".sh":
+		return Shell
+	}
+	return Dotenv
+}
+
+// Importable reports whether Parse reads f. Shell and Docker files are
+// export only.
+func (f Format) Importable() bool {
+	return f == Dotenv || f == JSON || f == YAML
+}
+
+// ContentType is the MIME type files in f are served as.
+func (f Format) ContentType() string {
+	switch f {
+	case JSON:
+		return "application/json"
+	case YAML:
+		return "application/yaml"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// Extension is the usual file extension for f.
+func (f Format) Extension() string {
+	switch f {
+	case JSON:
+		return ".json"
+	case YAML:
+		return ".yaml"
+	case Shell:
+		return ".sh"
+	}
+	return ".env"
+}
+
+// Parse reads the variables in data. JSON and YAML files hold a single
+// object whose values are scalars; numbers and booleans keep the text
+// they were written as.
+func Parse(f Format, data []byte) (map[string]string, error) {
+	switch f {
+	case Dotenv:
+		return godotenv.UnmarshalBytes(data)
+	case JSON:
+		return parseJSON(data)
+	case YAML:
+		return parseYAML(data)
+	}
+	return nil, fmt.Errorf("%s files can't be imported", f)
+}
+
+func parseJSON(data []byte) (map[string]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc map[string]any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(doc))
+	for key, v := range doc {
+		switch v := v.(type) {
+		case string:
+			values[key] = v
+		case json.Number:
+			values[key] = v.String()
+		case bool:
+			values[key] = strconv.FormatBool(v)
+		case nil:
+			values[key] = ""
+		default:
+			return nil, fmt.Errorf("%s: value must be a string, number or boolean", key)
+		}
+	}
+	return values, nil
+}
+
+func parseYAML(data []byte) (map[string]string, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	if len(doc.Content) == 0 {
+		return values, nil // empty file
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: expected a mapping of keys to values", root.Line)
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		if value.Kind == yaml.AliasNode {
+			value = value.Alias
+		}
+		if key.Kind != yaml.ScalarNode || value.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("line %d: value must be a string, number or boolean", key.Line)
+		}
+		if value.Tag == "!!null" {
+			values[key.Value] = ""
+		} else {
+			values[key.Value] = value.Value
+		}
+	}
+	return values, nil
+}
+
+var (
+	dotenvKey   = regexp.MustCompile(`^[A-Za-z0-9_.]+$`)
+	shellKey    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	plainDotenv = regexp.MustCompile(`^[A-Za-z0-9_./:@%+,=\\-]+$`)
+	yaml11Bool  = regexp.MustCompile(`^(?i:y|n|yes|no|on|off)$`)
+)
+
+// Marshal writes values in f, sorted by key. It fails rather than write a
+// key or value the format can't hold exactly.
+func Marshal(f Format, values map[string]string) ([]byte, error) {
+	switch f {
+	case JSON:
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		err := enc.Encode(values)
+		return buf.Bytes(), err
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if f == YAML {
+		return marshalYAML(keys, values)
+	}
+
+	var buf bytes.Buffer
+	for _, key := range keys {
+		line, err := marshalLine(f, key, values[key])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// marshalYAML writes every value as a string.
+func marshalYAML(keys []string, values map[string]string) ([]byte, error) {
+	doc := &yaml.Node{Kind: yaml.MappingNode}
+	for _, key := range keys {
+		doc.Content = append(doc.Content, yamlString(key), yamlString(values[key]))
+	}
+	return yaml.Marshal(doc)
+}
+
+// yamlString is a string scalar that YAML 1.1 and 1.2 readers both read
+// back unchanged, with multiline values as literal blocks where yaml.v3
+// gets those right.
+func yamlString(value string) *yaml.Node {
+	n := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+	if yaml11Bool.MatchString(value) {
+		// YAML 1.1 readers take these for booleans
+		n.Style = yaml.DoubleQuotedStyle
+	}
+	if strings.Contains(value, "\n") {
+		n.Style = yaml.DoubleQuotedStyle
+		if !strings.ContainsAny(value, "\r\t") && !strings.HasPrefix(value, "\n") && !strings.HasPrefix(value, " ") {
+			n.Style = yaml.LiteralStyle
+		}
+	}
+	return n
+}
+
+func marshalLine(f Format, key, value string) (string, error) {
+	switch f {
+	case Dotenv:
+		if !dotenvKey.MatchString(key) {
+			return "", errors.New("not a valid dotenv key")
+		}
+		quoted, err := quoteDotenv(value)
+		return key + "=" + quoted, err
+
+	case Shell:
+		if !shellKey.MatchString(key) {
+			return "", errors.New("not a valid shell variable name")
+		}
+		return "export " + key + "='" + strings.ReplaceAll(value, "'", `'\''`) + "'", nil
+
+	case Docker:
+		// Docker reads each line as is, with no quoting or escapes
+		if key == "" || strings.HasPrefix(key, "#") || strings.ContainsAny(key, "= \t") {
+			return "", errors.New("not a valid --env-file key")
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return "", errors.New("--env-file values can't span lines")
+		}
+		return key + "=" + value, nil
+	}
+	return "", fmt.Errorf("unknown format %q", f)
+}
+
+// quoteDotenv quotes value so godotenv reads it back unchanged. Single
+// quotes are literal; double quotes take backslash escapes and are needed
+// for newlines and single quotes.
+func quoteDotenv(value string) (string, error) {
+	switch {
+	case plainDotenv.MatchString(value):
+		return value, nil
+	case strings.HasSuffix(value, `\`):
+		// godotenv reads it as escaping the closing quote
+		return "", errors.New("dotenv can't hold a quoted value ending in a backslash")
+	case !strings.ContainsAny(value, "'\r\n"):
+		return "'" + value + "'", nil
+	case strings.HasSuffix(value, `"`):
+		// godotenv trims every quote off the end of the value
+		return "", errors.New("dotenv can't hold a value ending in a double quote with newlines or single quotes")
+	}
+
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range value {
+		switch r {
+		case '\\', '"', '$':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String(), nil
+}
@@ -0,0 +1,166 @@
+package envfile
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// tricky holds values each format has to quote or escape.
+var tricky = map[string]string{
+	"PLAIN":        "postgres://user@host:5432/db?sslmode=require",
+	"EMPTY":        "",
+	"SPACES":       "  leading and trailing  ",
+	"HASH":         "value # not a comment",
+	"SINGLE_QUOTE": "it's",
+	"DOUBLE_QUOTE": `say "hi" there`,
+	"DOLLAR":       "$HOME and ${USER}",
+	"BACKSLASH":    `C:\path\to`,
+	"BACKTICK":     "`whoami`",
+	"MULTILINE":    "-----BEGIN KEY-----\nabc\ndef\n-----END KEY-----",
+	"CRLF":         "a\r\nb",
+	"TRAILING_NL":  "line\n",
+	"YAML_BOOL":    "yes",
+	"NUMBER":       "0123",
+	"NULL":         "null",
+	"UNICODE":      "héllo ✓",
+	"TAB":          "a\tb",
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, f := range []Format{Dotenv, JSON, YAML} {
+		t.Run(string(f), func(t *testing.T) {
+			data, err := Marshal(f, tricky)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Parse(f, data)
+			if err != nil {
+				t.Fatalf("parse:\n%s\n%v", data, err)
+			}
+			for key, want := range tricky {
+				if got[key] != want {
+					t.Errorf("%s = %q, want %q", key, got[key], want)
+				}
+			}
+			if len(got) != len(tricky) {
+				t.Errorf("read back %d keys, want %d", len(got), len(tricky))
+			}
+		})
+	}
+}
+
+func TestShellRoundTrip(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no sh")
+	}
+	data, err := Marshal(Shell, tricky)
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := filepath.Join(t.TempDir(), "env.sh")
+	if err := os.WriteFile(script, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range tricky {
+		out, err := exec.Command(sh, "-c", `. "$1" && printf %s "$`+key+`"`, "sh", script).Output()
+		if err != nil {
+			t.Fatalf("%s: %v", key, err)
+		}
+		if string(out) != want {
+			t.Errorf("%s = %q, want %q", key, out, want)
+		}
+	}
+}
+
+func TestDotenvQuoting(t *testing.T) {
+	tests := []struct {
+		value, want string
+	}{
+		{"plain-value_1.0", "plain-value_1.0"},
+		{"has space", "'has space'"},
+		{"$HOME", "'$HOME'"},
+		{"it's", `"it's"`},
+		{"a\nb", `"a\nb"`},
+		{"it's $HOME \"x\" \\ \n", `"it's \$HOME \"x\" \\ \n"`},
+	}
+	for _, tt := range tests {
+		got, err := quoteDotenv(tt.value)
+		if err != nil || got != tt.want {
+			t.Errorf("quoteDotenv(%q) = %s, %v, want %s", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestMarshalRefusesWhatItCantWrite(t *testing.T) {
+	tests := []struct {
+		name   string
+		format Format
+		values map[string]string
+		want   string
+	}{
+		{"dotenv key", Dotenv, map[string]string{"MY-KEY": "x"}, "not a valid dotenv key"},
+		{"dotenv trailing backslash", Dotenv, map[string]string{"K": `a b\`}, "ending in a backslash"},
+		{"dotenv trailing quote with newline", Dotenv, map[string]string{"K": "a\nb\""}, "ending in a double quote"},
+		{"shell name", Shell, map[string]string{"1KEY": "x"}, "not a valid shell variable name"},
+		{"docker key", Docker, map[string]string{"A B": "x"}, "not a valid --env-file key"},
+		{"docker newline", Docker, map[string]string{"K": "a\nb"}, "can't span lines"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Marshal(tt.format, tt.values)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Marshal: %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarshalDocker(t *testing.T) {
+	data, err := Marshal(Docker, map[string]string{"B": `"quoted" $x`, "A": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Docker takes values verbatim, so nothing is quoted
+	if want := "A=1\nB=\"quoted\" $x\n"; string(data) != want {
+		t.Fatalf("got %q, want %q", data, want)
+	}
+}
+
+func TestParseScalars(t *testing.T) {
+	want := map[string]string{"PORT": "8080", "DEBUG": "true", "RATIO": "1.50", "NONE": ""}
+	for _, tt := range []struct {
+		format Format
+		src    string
+	}{
+		{JSON, `{"PORT": 8080, "DEBUG": true, "RATIO": 1.50, "NONE": null}`},
+		{YAML, "PORT: 8080\nDEBUG: true\nRATIO: 1.50\nNONE:\n"},
+	} {
+		got, err := Parse(tt.format, []byte(tt.src))
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: %v %v", tt.format, got, err)
+		}
+	}
+
+	for _, tt := range []struct {
+		format Format
+		src    string
+	}{
+		{JSON, `{"NESTED": {"A": 1}}`},
+		{JSON, `["A"]`},
+		{YAML, "LIST: [1, 2]\n"},
+		{YAML, "- A\n"},
+	} {
+		if _, err := Parse(tt.format, []byte(tt.src)); err == nil {
+			t.Errorf("%s %q: no error", tt.format, tt.src)
+		}
+	}
+	if _, err := Parse(Shell, nil); err == nil {
+		t.Error("parsed a shell file")
+	}
+}
@@ -0,0 +1,218 @@
+package envfile
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Kind is the kind of Kubernetes object variables are rendered as.
+type Kind string
+
+const (
+	Secret    Kind = "Secret"    // values base64 encoded under data
+	ConfigMap Kind = "ConfigMap" // values as plain strings under data
+)
+
+// ParseKind reads a kind as "secret" or "configmap", in any case.
+func ParseKind(name string) (Kind, error) {
+	switch strings.ToLower(name) {
+	case "secret":
+		return Secret, nil
+	case "configmap":
+		return ConfigMap, nil
+	}
+	return "", fmt.Errorf("unknown kind %q, use secret or configmap", name)
+}
+
+// Manifest is a v1 Secret or ConfigMap holding variables.
+type Manifest struct {
+	Kind      Kind
+	Name      string
+	Namespace string // optional
+	Labels    map[string]string
+	Data      map[string]string // decoded values, even for a Secret
+}
+
+var (
+	dnsSubdomain = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+	dnsLabel     = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+	labelName    = regexp.MustCompile(`^[A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?$`)
+	dataKey      = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+)
+
+// Validate checks m is an object the Kubernetes API would accept.
+func (m *Manifest) Validate() error {
+	if m.Kind != Secret && m.Kind != ConfigMap {
+		return fmt.Errorf("unknown kind %q", m.Kind)
+	}
+	if len(m.Name) > 253 || !dnsSubdomain.MatchString(m.Name) {
+		return fmt.Errorf("name %q must be lowercase letters, digits, '-' and '.'", m.Name)
+	}
+	if m.Namespace != "" && (len(m.Namespace) > 63 || !dnsLabel.MatchString(m.Namespace)) {
+		return fmt.Errorf("namespace %q must be lowercase letters, digits and '-'", m.Namespace)
+	}
+	for key, value := range m.Labels {
+		if !validLabelKey(key) {
+			return fmt.Errorf("invalid label key %q", key)
+		}
+		if value != "" && (len(value) > 63 || !labelName.MatchString(value)) {
+			return fmt.Errorf("invalid value for label %s", key)
+		}
+	}
+	for key := range m.Data {
+		if len(key) > 253 || !dataKey.MatchString(key) {
+			return fmt.Errorf("%s: keys may only hold letters, digits, '-', '_' and '.'", key)
+		}
+	}
+	return nil
+}
+
+// validLabelKey checks a label key: a name, optionally behind a DNS
+// subdomain prefix and a slash.
+func validLabelKey(key string) bool {
+	if prefix, name, ok := strings.Cut(key, "/"); ok {
+		if len(prefix) > 253 || !dnsSubdomain.MatchString(prefix) {
+			return false
+		}
+		key = name
+	}
+	return len(key) <= 63 && labelName.MatchString(key)
+}
+
+// ParseLabels reads labels written as key=value pairs.
+func ParseLabels(pairs []string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("label %q must be key=value", pair)
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+// MarshalManifest renders m as YAML, with keys sorted.
+func MarshalManifest(m *Manifest) ([]byte, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+
+	metadata := mapping("name", yamlString(m.Name))
+	if m.Namespace != "" {
+		metadata.Content = append(metadata.Content, yamlString("namespace"), yamlString(m.Namespace))
+	}
+	if len(m.Labels) > 0 {
+		metadata.Content = append(metadata.Content, yamlString("labels"), stringMapping(m.Labels, nil))
+	}
+
+	doc := mapping(
+		"apiVersion", yamlString("v1"),
+		"kind", yamlString(string(m.Kind)),
+		"metadata", metadata,
+	)
+	if m.Kind == Secret {
+		doc.Content = append(doc.Content, yamlString("type"), yamlString("Opaque"))
+		doc.Content = append(doc.Content, yamlString("data"), stringMapping(m.Data, func(v string) string {
+			return base64.StdEncoding.EncodeToString([]byte(v))
+		}))
+	} else {
+		doc.Content = append(doc.Content, yamlString("data"), stringMapping(m.Data, nil))
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), enc.Close()
+}
+
+// mapping builds a mapping node from alternating keys and values.
+func mapping(pairs ...any) *yaml.Node {
+	n := &yaml.Node{Kind: yaml.MappingNode}
+	for i := 0; i < len(pairs); i += 2 {
+		n.Content = append(n.Content, yamlString(pairs[i].(string)), pairs[i+1].(*yaml.Node))
+	}
+	return n
+}
+
+// stringMapping builds a mapping node of m sorted by key, passing values
+// through encode if set.
+func stringMapping(m map[string]string, encode func(string) string) *yaml.Node {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	n := &yaml.Node{Kind: yaml.MappingNode}
+	for _, key := range keys {
+		value := m[key]
+		if encode != nil {
+			value = encode(value)
+		}
+		n.Content = append(n.Content, yamlString(key), yamlString(value))
+	}
+	return n
+}
+
+// ParseManifest reads a single Secret or ConfigMap. A Secret's data is
+// base64 decoded, and its stringData taken as is, as the API server does.
+func ParseManifest(data []byte) (*Manifest, error) {
+	var doc struct {
+		APIVersion string `yaml:"apiVersion"`
+		Kind       Kind   `yaml:"kind"`
+		Metadata   struct {
+			Name      string            `yaml:"name"`
+			Namespace string            `yaml:"namespace"`
+			Labels    map[string]string `yaml:"labels"`
+		} `yaml:"metadata"`
+		Data       map[string]string `yaml:"data"`
+		StringData map[string]string `yaml:"stringData"`
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	var extra yaml.Node
+	if err := dec.Decode(&extra); !errors.Is(err, io.EOF) {
+		return nil, errors.New("expected a single document")
+	}
+	if doc.APIVersion != "v1" || (doc.Kind != Secret && doc.Kind != ConfigMap) {
+		return nil, fmt.Errorf("expected a v1 Secret or ConfigMap, found %s %s", doc.APIVersion, doc.Kind)
+	}
+
+	m := &Manifest{
+		Kind:      doc.Kind,
+		Name:      doc.Metadata.Name,
+		Namespace: doc.Metadata.Namespace,
+		Labels:    doc.Metadata.Labels,
+		Data:      map[string]string{},
+	}
+	for key, value := range doc.Data {
+		if m.Kind == Secret {
+			decoded, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			value = string(decoded)
+		}
+		m.Data[key] = value
+	}
+	if m.Kind == Secret {
+		for key, value := range doc.StringData {
+			m.Data[key] = value
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,116 @@
+package envfile
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMarshalManifest(t *testing.T) {
+	m := &Manifest{
+		Kind:      Secret,
+		Name:      "web-prod",
+		Namespace: "prod",
+		Labels:    map[string]string{"app": "web", "example.com/team": "payments"},
+		Data:      map[string]string{"DB_URL": "postgres://", "TLS_KEY": "-----BEGIN KEY-----\nabc\n", "FLAG": "yes"},
+	}
+	data, err := MarshalManifest(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `apiVersion: v1
+kind: Secret
+metadata:
+  name: web-prod
+  namespace: prod
+  labels:
+    app: web
+    example.com/team: payments
+type: Opaque
+data:
+  DB_URL: cG9zdGdyZXM6Ly8=
+  FLAG: eWVz
+  TLS_KEY: LS0tLS1CRUdJTiBLRVktLS0tLQphYmMK
+`
+	if string(data) != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", data, want)
+	}
+
+	for _, kind := range []Kind{Secret, ConfigMap} {
+		m.Kind = kind
+		data, err := MarshalManifest(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ParseManifest(data)
+		if err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+		if !reflect.DeepEqual(got, m) {
+			t.Fatalf("%s read back as %+v", kind, got)
+		}
+	}
+}
+
+func TestManifestValidate(t *testing.T) {
+	valid := func() *Manifest {
+		return &Manifest{Kind: ConfigMap, Name: "web", Data: map[string]string{"A": "1"}}
+	}
+	tests := []struct {
+		name   string
+		change func(m *Manifest)
+	}{
+		{"kind", func(m *Manifest) { m.Kind = "Pod" }},
+		{"uppercase name", func(m *Manifest) { m.Name = "Web" }},
+		{"empty name", func(m *Manifest) { m.Name = "" }},
+		{"namespace with dot", func(m *Manifest) { m.Namespace = "a.b" }},
+		{"label key", func(m *Manifest) { m.Labels = map[string]string{"-bad": "x"} }},
+		{"label prefix", func(m *Manifest) { m.Labels = map[string]string{"Example.com/team": "x"} }},
+		{"label value", func(m *Manifest) { m.Labels = map[string]string{"app": "has space"} }},
+		{"data key", func(m *Manifest) { m.Data = map[string]string{"MY KEY": "x"} }},
+	}
+	if err := valid().Validate(); err != nil {
+		t.Fatalf("valid manifest: %v", err)
+	}
+	for _, tt := range tests {
+		m := valid()
+		tt.change(m)
+		if err := m.Validate(); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
+
+func TestParseManifest(t *testing.T) {
+	m, err := ParseManifest([]byte(`apiVersion: v1
+kind: Secret
+metadata:
+  name: web
+data:
+  A: MQ==
+  B: Mg==
+stringData:
+  B: plain
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// stringData wins over data, like the API server merges them
+	if want := map[string]string{"A": "1", "B": "plain"}; !reflect.DeepEqual(m.Data, want) {
+		t.Fatalf("data %v", m.Data)
+	}
+
+	tests := []struct {
+		name, src, want string
+	}{
+		{"not base64", "apiVersion: v1\nkind: Secret\nmetadata: {name: web}\ndata: {A: \"!!\"}\n", "A:"},
+		{"other kind", "apiVersion: v1\nkind: Pod\nmetadata: {name: web}\n", "expected a v1 Secret or ConfigMap"},
+		{"other version", "apiVersion: v2\nkind: Secret\nmetadata: {name: web}\n", "expected a v1 Secret or ConfigMap"},
+		{"two documents", "apiVersion: v1\nkind: Secret\n---\napiVersion: v1\nkind: Secret\n", "single document"},
+	}
+	for _, tt := range tests {
+		if _, err := ParseManifest([]byte(tt.src)); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,322 @@
+// Package oidc is a small OpenID Connect relying party: discovery, the
+// authorization code flow with PKCE, and ID token validation against the
+// provider's JWKS.
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// maxResponseSize bounds what is read from the provider
+	maxResponseSize = 1 << 20
+
+	// jwksMinRefresh stops tokens with unknown key ids from hammering the provider
+	jwksMinRefresh = time.Minute
+
+	clockSkew = time.Minute
+)
+
+// Config describes the client registered with the provider.
+type Config struct {
+	Issuer       string // e.g. "https://accounts.google.com"
+	ClientID     string
+	ClientSecret string // empty for public clients, which rely on PKCE alone
+	RedirectURL  string
+	Scopes       []string // defaults to openid, email and profile
+
+	HTTPClient *http.Client // defaults to one with a 10s timeout
+}
+
+// Claims are the ID token claims used to find or create a user.
+type Claims struct {
+	Issuer            string
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+	AMR               []string // authentication methods, "mfa" if the provider did 2FA
+}
+
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider talks to one OpenID provider. Discovery and key fetching happen
+// on first use and are cached.
+type Provider struct {
+	cfg Config
+
+	mu          sync.Mutex
+	meta        *metadata
+	keys        map[string]any // kid -> public key
+	keysFetched time.Time
+}
+
+// New returns a Provider for cfg. It doesn't contact the provider.
+func New(cfg Config) *Provider {
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Provider{cfg: cfg}
+}
+
+// Issuer returns the configured issuer URL.
+func (p *Provider) Issuer() string {
+	return p.cfg.Issuer
+}
+
+// CodeChallenge derives the PKCE S256 challenge for a verifier.
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns where to send the browser to log in.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.cfg.ClientID)
+	q.Set("redirect_uri", p.cfg.RedirectURL)
+	q.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", CodeChallenge(verifier))
+	q.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return meta.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Exchange trades an authorization code for tokens and returns the
+// validated ID token claims.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Claims, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("code_verifier", verifier)
+	form.Set("client_id", p.cfg.ClientID)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		// client_secret_basic, the default every provider supports
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	status, err := p.do(req, &tokens)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK || tokens.Error != "" {
+		return nil, fmt.Errorf("oidc: token request failed: %s %s", tokens.Error, tokens.ErrorDescription)
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("oidc: token response has no id_token")
+	}
+	return p.VerifyIDToken(ctx, tokens.IDToken, nonce)
+}
+
+// VerifyIDToken checks an ID token's signature, issuer, audience, expiry
+// and nonce.
+func (p *Provider) VerifyIDToken(ctx context.Context, raw, nonce string) (*Claims, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := jwt.Parse(raw, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384"}),
+		jwt.WithIssuer(meta.Issuer),
+		jwt.WithAudience(p.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(clockSkew),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: invalid id token: %w", err)
+	}
+	claims := token.Claims.(jwt.MapClaims)
+
+	// With several audiences the token must say it was issued to us
+	if aud, _ := claims.GetAudience(); len(aud) > 1 && claims["azp"] != p.cfg.ClientID {
+		return nil, errors.New("oidc: id token was issued to another client")
+	}
+	if got, _ := claims["nonce"].(string); nonce == "" || got != nonce {
+		return nil, errors.New("oidc: nonce mismatch")
+	}
+
+	out := &Claims{Issuer: meta.Issuer}
+	out.Subject, _ = claims["sub"].(string)
+	out.Email, _ = claims["email"].(string)
+	out.Name, _ = claims["name"].(string)
+	out.PreferredUsername, _ = claims["preferred_username"].(string)
+	// some providers send "true" as a string
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		out.EmailVerified = v
+	case string:
+		out.EmailVerified = v == "true"
+	}
+	if amr, ok := claims["amr"].([]any); ok {
+		for _, m := range amr {
+			if s, ok := m.(string); ok {
+				out.AMR = append(out.AMR, s)
+			}
+		}
+	}
+	if out.Subject == "" {
+		return nil, errors.New("oidc: id token has no subject")
+	}
+	return out, nil
+}
+
+// discover fetches the provider metadata once.
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.meta != nil {
+		return p.meta, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.cfg.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var meta metadata
+	status, err := p.do(req, &meta)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("oidc: discovery returned %d", status)
+	}
+
+	// A mismatch would let another issuer's tokens through, OIDC Discovery 4.3
+	if strings.TrimSuffix(meta.Issuer, "/") != p.cfg.Issuer {
+		return nil, fmt.Errorf("oidc: discovery issuer %q doesn't match %q", meta.Issuer, p.cfg.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, errors.New("oidc: discovery document is missing endpoints")
+	}
+	p.meta = &meta
+	return p.meta, nil
+}
+
+// key returns the signing key with the given id, refetching the JWKS
+// when the provider may have rotated keys.
+func (p *Provider) key(ctx context.Context, kid string) (any, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if k, ok := p.lookup(kid); ok {
+		return k, nil
+	}
+	if time.Since(p.keysFetched) < jwksMinRefresh {
+		return nil, fmt.Errorf("oidc: unknown signing key %q", kid)
+	}
+
+	keys, err := p.fetchKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.keys, p.keysFetched = keys, time.Now()
+
+	if k, ok := p.lookup(kid); ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("oidc: unknown signing key %q", kid)
+}
+
+// lookup finds a key by id; tokens without a kid are accepted only when
+// the provider has a single key.
+func (p *Provider) lookup(kid string) (any, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, k := range p.keys {
+			return k, true
+		}
+	}
+	k, ok := p.keys[kid]
+	return k, ok
+}
+
+func (p *Provider) fetchKeys(ctx context.Context) (map[string]any, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.meta.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set jwks
+	status, err := p.do(req, &set)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("oidc: jwks returned %d", status)
+	}
+	return set.signingKeys(), nil
+}
+
+// do sends req and decodes a JSON response into v, returning the status.
+func (p *Provider) do(req *http.Request, v any) (int, error) {
+	resp, err := p.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("oidc: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return 0, fmt.Errorf("oidc: %w", err)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return resp.StatusCode, fmt.Errorf("oidc: %s returned %d with invalid JSON", req.URL.Path, resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
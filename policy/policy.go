@@ -0,0 +1,188 @@
+// Package policy parses and evaluates secret access policies: YAML
+// documents that bind rules to teams and users, where each rule gives
+// capabilities on the variables whose project/env/key path matches a glob.
+//
+//	teams: [payments]
+//	users: [ops@example.com]
+//	rules:
+//	  - path: "*/prod/PAYMENT_*"
+//	    capabilities: [read, list]
+//	  - path: "*/staging/**"
+//	    capabilities: [read, list, write, delete]
+//	  - path: "*/prod/ROOT_*"
+//	    capabilities: [deny]
+package policy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Capability is something a rule allows on the variables it matches.
+type Capability string
+
+const (
+	Read   Capability = "read"   // retrieve a value and its history
+	Write  Capability = "write"  // create and change variables
+	Delete Capability = "delete" // delete variables
+	Share  Capability = "share"  // create share links
+	List   Capability = "list"   // see the key in listings
+
+	// Deny refuses every capability on the paths it matches, whatever
+	// other rules allow.
+	Deny Capability = "deny"
+)
+
+// Capabilities lists the capabilities a rule can allow, in display order.
+var Capabilities = []Capability{Read, Write, Delete, Share, List}
+
+func validCapability(c Capability) bool {
+	for _, known := range Capabilities {
+		if c == known {
+			return true
+		}
+	}
+	return c == Deny
+}
+
+// Rule gives capabilities on the paths matching Path. Each path segment is
+// matched with path.Match; a "**" segment matches any number of segments.
+type Rule struct {
+	Path         string       `yaml:"path" json:"path"`
+	Capabilities []Capability `yaml:"capabilities" json:"capabilities"`
+}
+
+// Policy is a parsed policy document.
+type Policy struct {
+	Teams []string `yaml:"teams" json:"teams"` // team names
+	Users []string `yaml:"users" json:"users"` // user emails
+	Rules []Rule   `yaml:"rules" json:"rules"`
+}
+
+// Parse reads and validates a policy document.
+func Parse(src []byte) (*Policy, error) {
+	var p Policy
+	dec := yaml.NewDecoder(bytes.NewReader(src))
+	dec.KnownFields(true)
+	err := dec.Decode(&p)
+	if errors.Is(err, io.EOF) {
+		return nil, errors.New("policy: empty document")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("policy: %w", err)
+	}
+
+	if len(p.Rules) == 0 {
+		return nil, errors.New("policy: no rules")
+	}
+	for i, r := range p.Rules {
+		if r.Path == "" {
+			return nil, fmt.Errorf("policy: rule %d: missing path", i+1)
+		}
+		for _, seg := range strings.Split(r.Path, "/") {
+			if _, err := path.Match(seg, ""); err != nil {
+				return nil, fmt.Errorf("policy: rule %d: bad path %q", i+1, r.Path)
+			}
+		}
+		if len(r.Capabilities) == 0 {
+			return nil, fmt.Errorf("policy: rule %d: no capabilities", i+1)
+		}
+		for _, c := range r.Capabilities {
+			if !validCapability(c) {
+				return nil, fmt.Errorf("policy: rule %d: unknown capability %q", i+1, c)
+			}
+		}
+	}
+	return &p, nil
+}
+
+// Applies reports whether the policy binds the user with email who is in
+// teams.
+func (p *Policy) Applies(email string, teams []string) bool {
+	for _, u := range p.Users {
+		if strings.EqualFold(u, email) {
+			return true
+		}
+	}
+	for _, t := range p.Teams {
+		for _, name := range teams {
+			if t == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Match reports whether a slash separated path matches pattern.
+func Match(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := len(name); i >= 0; i-- {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// Decision is the outcome of evaluating a capability on a path, with the
+// rule that decided it.
+type Decision struct {
+	Allowed bool   `json:"allowed"`
+	Policy  string `json:"policy,omitempty"`
+	Rule    string `json:"rule,omitempty"` // the deciding rule's path
+}
+
+// Evaluate decides whether the named policies allow c on name. A matching
+// deny rule wins over everything; otherwise any matching rule with c
+// allows it, and nothing else does.
+func Evaluate(policies map[string]*Policy, c Capability, name string) Decision {
+	names := make([]string, 0, len(policies))
+	for n := range policies {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	var allowed *Decision
+	for _, n := range names {
+		for _, r := range policies[n].Rules {
+			if !Match(r.Path, name) {
+				continue
+			}
+			for _, rc := range r.Capabilities {
+				if rc == Deny {
+					return Decision{Allowed: false, Policy: n, Rule: r.Path}
+				}
+				if rc == c && allowed == nil {
+					allowed = &Decision{Allowed: true, Policy: n, Rule: r.Path}
+				}
+			}
+		}
+	}
+	if allowed != nil {
+		return *allowed
+	}
+	return Decision{}
+}
@@ -0,0 +1,124 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"web/prod/DB_URL", "web/prod/DB_URL", true},
+		{"web/prod/DB_URL", "web/prod/DB_USER", false},
+		{"*/prod/PAYMENT_*", "api/prod/PAYMENT_KEY", true},
+		{"*/prod/PAYMENT_*", "api/staging/PAYMENT_KEY", false},
+
+		// * stays within one segment
+		{"*", "web", true},
+		{"*", "web/prod", false},
+		{"web/*", "web/prod/DB_URL", false},
+		{"*/*/*", "web/prod/DB_URL", true},
+
+		// ** spans any number of segments, none included
+		{"**", "web/prod/DB_URL", true},
+		{"web/**", "web/prod/DB_URL", true},
+		{"web/**", "web", true},
+		{"web/**", "api/prod/DB_URL", false},
+		{"**/DB_URL", "web/prod/DB_URL", true},
+		{"**/DB_URL", "DB_URL", true},
+		{"**/DB_URL", "web/prod/DB_USER", false},
+		{"web/**/DB_*", "web/prod/DB_URL", true},
+		{"web/**/DB_*", "web/DB_URL", true},
+		{"*/staging/**", "web/staging/API_KEY", true},
+		{"*/staging/**", "web/prod/API_KEY", false},
+
+		// ** only means "any segments" on its own
+		{"web**", "web/prod", false},
+		{"**DB_URL", "web/prod/DB_URL", false},
+	}
+	for _, tt := range tests {
+		if got := Match(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func mustParse(t *testing.T, src string) *Policy {
+	t.Helper()
+	p, err := Parse([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestEvaluate(t *testing.T) {
+	policies := map[string]*Policy{
+		"payments": mustParse(t, `
+rules:
+  - path: "*/prod/PAYMENT_*"
+    capabilities: [read, list]
+  - path: "*/staging/**"
+    capabilities: [read, list, write, delete]
+  - path: "*/prod/ROOT_*"
+    capabilities: [deny]
+`),
+		"ops": mustParse(t, `
+rules:
+  - path: "**"
+    capabilities: [read, list]
+  - path: "web/prod/ROOT_TOKEN"
+    capabilities: [read]
+`),
+	}
+
+	tests := []struct {
+		name       string
+		capability Capability
+		path       string
+		want       Decision
+	}{
+		{"allowed by a rule", Write, "api/staging/DB_URL", Decision{Allowed: true, Policy: "payments", Rule: "*/staging/**"}},
+		{"first allowing policy by name", Read, "api/prod/PAYMENT_KEY", Decision{Allowed: true, Policy: "ops", Rule: "**"}},
+		{"deny wins over allow in another policy", Read, "web/prod/ROOT_TOKEN", Decision{Policy: "payments", Rule: "*/prod/ROOT_*"}},
+		{"deny covers every capability", List, "api/prod/ROOT_KEY", Decision{Policy: "payments", Rule: "*/prod/ROOT_*"}},
+		{"matching rule without the capability", Write, "api/prod/PAYMENT_KEY", Decision{}},
+		{"no rule matches", Share, "api/staging/DB_URL", Decision{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Evaluate(policies, tt.capability, tt.path); got != tt.want {
+				t.Fatalf("Evaluate(%s, %s) = %+v, want %+v", tt.capability, tt.path, got, tt.want)
+			}
+		})
+	}
+
+	// Without policies nothing is allowed
+	if got := Evaluate(nil, Read, "web/prod/DB_URL"); got.Allowed {
+		t.Fatalf("no policies: %+v", got)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{"empty", "", "empty document"},
+		{"no rules", "teams: [payments]", "no rules"},
+		{"missing path", "rules: [{capabilities: [read]}]", "missing path"},
+		{"bad glob", `rules: [{path: "web/[", capabilities: [read]}]`, "bad path"},
+		{"no capabilities", `rules: [{path: "**"}]`, "no capabilities"},
+		{"unknown capability", `rules: [{path: "**", capabilities: [admin]}]`, "unknown capability"},
+		{"unknown field", `rules: [{path: "**", capabilities: [read], effect: allow}]`, "effect"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.src))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Parse: %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,328 @@
+// Package client is a Go client for the SafeEnv API, for services that
+// read their secrets at startup.
+//
+//	c := client.New("https://safeenv.example.com", client.WithToken(os.Getenv("SAFEENV_TOKEN")),
+//		client.WithCache(5*time.Minute))
+//	dbURL, err := c.Env("api", "prod").Retrieve(ctx, "DB_URL")
+//
+// Requests that are safe to repeat are retried with exponential backoff
+// when the server is unavailable, and an expired access token is
+// refreshed once when the client logged in with a password.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultRetries = 3
+	defaultBackoff = 200 * time.Millisecond
+	maxBackoff     = 5 * time.Second
+)
+
+// Client talks to a SafeEnv server. It is safe for concurrent use.
+type Client struct {
+	*Scope // the flat variables outside any project
+
+	baseURL string
+	http    *http.Client
+	retries int
+	backoff time.Duration
+	cache   *cache
+
+	mu           sync.Mutex
+	token        string // API token or access JWT
+	refreshToken string
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithToken authenticates with an API token or an access JWT.
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithRefreshToken lets the client renew its access JWT when it expires.
+func WithRefreshToken(refreshToken string) Option {
+	return func(c *Client) { c.refreshToken = refreshToken }
+}
+
+// WithHTTPClient sends requests through hc instead of a client with a 30
+// second timeout.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.http = hc }
+}
+
+// WithRetries sets how many times a failed request is retried, and the
+// delay before the first retry, which doubles for each one after. Zero
+// retries turns retrying off.
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(c *Client) { c.retries, c.backoff = retries, backoff }
+}
+
+// WithCache keeps retrieved values in memory for ttl. Writes through the
+// same client clear the cached values of the scope they change.
+func WithCache(ttl time.Duration) Option {
+	return func(c *Client) { c.cache = newCache(ttl) }
+}
+
+// New returns a client for the server at baseURL, e.g.
+// "https://safeenv.example.com".
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/") + "/api/v1",
+		http:    &http.Client{Timeout: 30 * time.Second},
+		retries: defaultRetries,
+		backoff: defaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.Scope = &Scope{client: c}
+	return c
+}
+
+// Env returns the scope of an environment in one of the caller's own
+// projects.
+func (c *Client) Env(project, env string) *Scope {
+	return &Scope{client: c, prefix: "/projects/" + url.PathEscape(project) + "/envs/" + url.PathEscape(env)}
+}
+
+// OrgEnv returns the scope of an environment in an organization's project.
+func (c *Client) OrgEnv(org, project, env string) *Scope {
+	return &Scope{client: c, prefix: "/orgs/" + url.PathEscape(org) + "/projects/" + url.PathEscape(project) + "/envs/" + url.PathEscape(env)}
+}
+
+// Error is an error response from the API.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("safeenv: %s (%d)", e.Message, e.StatusCode)
+}
+
+// IsNotFound reports whether err is a 404 from the API, e.g. a missing key.
+func IsNotFound(err error) bool {
+	return statusOf(err) == http.StatusNotFound
+}
+
+// IsConflict reports whether err is a 409 from the API, e.g. storing a
+// key that exists.
+func IsConflict(err error) bool {
+	return statusOf(err) == http.StatusConflict
+}
+
+func statusOf(err error) int {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
+
+// ErrMFARequired is returned by Login for accounts that need a second
+// factor; use an API token for those instead.
+var ErrMFARequired = errors.New("safeenv: this account needs two-factor authentication, use an API token")
+
+// Tokens are an access JWT and the refresh token that renews it.
+type Tokens struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+}
+
+// Login starts a session with an email and password, and uses its tokens
+// from then on.
+func (c *Client) Login(ctx context.Context, email, password string) (*Tokens, error) {
+	var reply struct {
+		Tokens
+		MFARequired bool `json:"mfaRequired"`
+	}
+	body := map[string]string{"email": email, "password": password}
+	if err := c.send(ctx, http.MethodPost, "/login", body, &reply, false); err != nil {
+		return nil, err
+	}
+	if reply.MFARequired {
+		return nil, ErrMFARequired
+	}
+
+	c.mu.Lock()
+	c.token, c.refreshToken = reply.Token, reply.RefreshToken
+	c.mu.Unlock()
+	return &reply.Tokens, nil
+}
+
+// Logout ends the session the client logged in with.
+func (c *Client) Logout(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/logout", nil, nil)
+}
+
+// refresh trades the refresh token for new tokens. seen is the access
+// token that was rejected, so concurrent callers only refresh once.
+func (c *Client) refresh(ctx context.Context, seen string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != seen {
+		return nil // another request already refreshed
+	}
+
+	var tokens Tokens
+	body := map[string]string{"refreshToken": c.refreshToken}
+	if err := c.send(ctx, http.MethodPost, "/token/refresh", body, &tokens, false); err != nil {
+		return err
+	}
+	c.token, c.refreshToken = tokens.Token, tokens.RefreshToken
+	return nil
+}
+
+// do sends an authenticated request, refreshing the access token once if
+// it has expired.
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	c.mu.Lock()
+	token, canRefresh := c.token, c.refreshToken != ""
+	c.mu.Unlock()
+
+	err := c.send(ctx, method, path, body, out, true)
+	if statusOf(err) == http.StatusUnauthorized && canRefresh {
+		if err := c.refresh(ctx, token); err != nil {
+			return err
+		}
+		err = c.send(ctx, method, path, body, out, true)
+	}
+	return err
+}
+
+// send makes a request, retrying it while the server is unavailable if
+// that is safe: always for requests that never reached it or were turned
+// away with 429, and for other failures if the method is idempotent.
+func (c *Client) send(ctx context.Context, method, path string, body, out any, auth bool) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	idempotent := method == http.MethodGet || method == http.MethodPut || method == http.MethodDelete
+
+	for attempt := 0; ; attempt++ {
+		retryAfter, err := c.attempt(ctx, method, path, data, out, auth)
+		if err == nil || attempt >= c.retries || ctx.Err() != nil {
+			return err
+		}
+
+		status := statusOf(err)
+		var netErr *requestError
+		switch {
+		case status == http.StatusTooManyRequests:
+		case errors.As(err, &netErr) && (idempotent || !netErr.sent):
+		case status >= 500 && idempotent:
+		default:
+			return err
+		}
+
+		delay := c.backoff << attempt
+		if delay > maxBackoff || delay < 0 {
+			delay = maxBackoff
+		}
+		// Jitter so many clients restarting together don't retry in step
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		if retryAfter > delay {
+			delay = retryAfter
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// requestError is a request that got no response. sent is false if it
+// never reached the server, e.g. the connection was refused.
+type requestError struct {
+	err  error
+	sent bool
+}
+
+func (e *requestError) Error() string { return "safeenv: " + e.err.Error() }
+func (e *requestError) Unwrap() error { return e.err }
+
+// attempt makes one request, returning the Retry-After delay if the
+// server sent one.
+func (c *Client) attempt(ctx context.Context, method, path string, data []byte, out any, auth bool) (time.Duration, error) {
+	var body io.Reader
+	if data != nil {
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return 0, err
+	}
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if auth {
+		c.mu.Lock()
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		c.mu.Unlock()
+	}
+
+	// Note whether the request went out, so a POST that never reached the
+	// server can be retried without storing anything twice
+	var sent atomic.Bool
+	req = req.WithContext(httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		WroteRequest: func(httptrace.WroteRequestInfo) { sent.Store(true) },
+	}))
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, &requestError{err: err, sent: sent.Load()}
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, &requestError{err: err, sent: true}
+	}
+	if resp.StatusCode >= 400 {
+		var reply struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(raw, &reply) != nil || reply.Error == "" {
+			reply.Error = http.StatusText(resp.StatusCode)
+		}
+		var retryAfter time.Duration
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			retryAfter = time.Duration(secs) * time.Second
+		}
+		return retryAfter, &Error{StatusCode: resp.StatusCode, Message: reply.Error}
+	}
+
+	if out != nil {
+		if err := json.Unmarshal(raw, out); err != nil {
+			return 0, fmt.Errorf("safeenv: unexpected response: %w", err)
+		}
+	}
+	return 0, nil
+}
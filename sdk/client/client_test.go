@@ -0,0 +1,379 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/David-mwas/SafeEnv/encryption"
+	"github.com/David-mwas/SafeEnv/sdk/client"
+	"github.com/David-mwas/SafeEnv/server"
+	"github.com/David-mwas/SafeEnv/store"
+	"github.com/gin-gonic/gin"
+)
+
+// newServer starts the API on a fresh Bolt store.
+func newServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	st, err := store.OpenBolt(filepath.Join(t.TempDir(), "safeenv.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close(context.Background()) })
+
+	keyring, err := encryption.NewKeyring("default", map[string][]byte{"default": bytes.Repeat([]byte{1}, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := server.New(st, server.Config{
+		Keys:        keyring,
+		Keyring:     keyring,
+		JWTSecret:   []byte("test secret"),
+		FrontendURL: "http://localhost:5173",
+	})
+	r := gin.New()
+	srv.Register(r)
+
+	ts := httptest.NewServer(r)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// post calls the API directly, for what the client doesn't cover.
+func post(t *testing.T, baseURL, path, token string, body, out any) {
+	t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, baseURL+"/api/v1"+path, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		t.Fatalf("POST %s: %s", path, resp.Status)
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// login registers an account and returns a client logged in to it, with
+// its tokens.
+func login(t *testing.T, baseURL, email string, opts ...client.Option) (*client.Client, *client.Tokens) {
+	t.Helper()
+	post(t, baseURL, "/register", "", map[string]string{"username": email, "email": email, "password": "hunter22"}, nil)
+
+	c := client.New(baseURL, opts...)
+	tokens, err := c.Login(context.Background(), email, "hunter22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, tokens
+}
+
+// flaky sits in front of a server and answers the first fail requests
+// with status, counting every request it sees.
+type flaky struct {
+	*httptest.Server
+	status int
+
+	mu       sync.Mutex
+	fail     int
+	requests atomic.Int32
+}
+
+func newFlaky(t *testing.T, target string) *flaky {
+	u, err := url.Parse(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httputil.NewSingleHostReverseProxy(u)
+
+	f := &flaky{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.requests.Add(1)
+		f.mu.Lock()
+		fail, status := f.fail > 0, f.status
+		if fail {
+			f.fail--
+		}
+		f.mu.Unlock()
+		if fail {
+			w.WriteHeader(status)
+			w.Write([]byte(`{"error":"try again"}`))
+			return
+		}
+		proxy.ServeHTTP(w, r)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *flaky) failNext(n, status int) {
+	f.mu.Lock()
+	f.fail, f.status = n, status
+	f.mu.Unlock()
+	f.requests.Store(0)
+}
+
+func TestVariables(t *testing.T) {
+	ts := newServer(t)
+	c, _ := login(t, ts.URL, "alice@example.com")
+	ctx := context.Background()
+
+	if err := c.Store(ctx, client.StoreRequest{Key: "DB_URL", Value: "postgres://"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.StoreBulk(ctx, client.BulkStoreRequest{Variables: map[string]string{"A": "1", "B": "2"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if value, err := c.Retrieve(ctx, "DB_URL"); err != nil || value != "postgres://" {
+		t.Fatalf("retrieve: %q %v", value, err)
+	}
+	all, err := c.RetrieveAll(ctx)
+	if err != nil || len(all) != 3 || all["A"] != "1" || all["B"] != "2" {
+		t.Fatalf("retrieve all: %v %v", all, err)
+	}
+	vars, err := c.List(ctx)
+	if err != nil || len(vars) != 3 {
+		t.Fatalf("list: %v %v", vars, err)
+	}
+
+	if err := c.Update(ctx, "DB_URL", client.UpdateRequest{NewValue: "mysql://"}); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := c.Retrieve(ctx, "DB_URL"); err != nil || value != "mysql://" {
+		t.Fatalf("retrieve after update: %q %v", value, err)
+	}
+
+	if err := c.Delete(ctx, "A"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Retrieve(ctx, "A"); !client.IsNotFound(err) {
+		t.Fatalf("retrieve deleted: %v", err)
+	}
+	if err := c.Delete(ctx, "A"); !client.IsNotFound(err) {
+		t.Fatalf("delete missing: %v", err)
+	}
+}
+
+func TestShare(t *testing.T) {
+	ts := newServer(t)
+	c, _ := login(t, ts.URL, "alice@example.com")
+	ctx := context.Background()
+	if err := c.Store(ctx, client.StoreRequest{Key: "API_KEY", Value: "s3cret"}); err != nil {
+		t.Fatal(err)
+	}
+
+	share, err := c.Share(ctx, client.ShareRequest{Key: "API_KEY", MaxViews: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if share.Token == "" || share.Share.Key != "API_KEY" || share.Share.MaxViews != 2 {
+		t.Fatalf("share: %+v", share)
+	}
+
+	// One-time links carry the key in the fragment; the server only has ciphertext
+	share, err = c.Share(ctx, client.ShareRequest{Key: "API_KEY", OneTime: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, key, ok := strings.Cut(share.Link, "#")
+	if !ok {
+		t.Fatalf("one-time link has no key: %s", share.Link)
+	}
+
+	_, bob := login(t, ts.URL, "bob@example.com")
+	var opened struct {
+		Ciphertext string `json:"ciphertext"`
+	}
+	post(t, ts.URL, "/share/retrieve/"+share.Token, bob.Token, map[string]string{}, &opened)
+	if plain, err := encryption.OpenOneTime(key, opened.Ciphertext); err != nil || string(plain) != "s3cret" {
+		t.Fatalf("open one-time share: %q %v", plain, err)
+	}
+}
+
+func TestJWTRefresh(t *testing.T) {
+	ts := newServer(t)
+	session, tokens := login(t, ts.URL, "alice@example.com")
+	ctx := context.Background()
+	if err := session.Store(ctx, client.StoreRequest{Key: "DB_URL", Value: "postgres://"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// An access token the server rejects is renewed with the refresh token
+	c := client.New(ts.URL, client.WithToken("expired"), client.WithRefreshToken(tokens.RefreshToken))
+	if value, err := c.Retrieve(ctx, "DB_URL"); err != nil || value != "postgres://" {
+		t.Fatalf("retrieve: %q %v", value, err)
+	}
+
+	if err := session.Logout(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := session.List(ctx); statusOf(err) != http.StatusUnauthorized {
+		t.Fatalf("list after logout: %v", err)
+	}
+}
+
+func TestAPIToken(t *testing.T) {
+	ts := newServer(t)
+	session, tokens := login(t, ts.URL, "alice@example.com")
+	ctx := context.Background()
+
+	var created struct {
+		Token string `json:"token"`
+	}
+	post(t, ts.URL, "/tokens", tokens.Token, map[string]string{"name": "ci", "access": "read"}, &created)
+	c := client.New(ts.URL, client.WithToken(created.Token))
+
+	if err := session.Store(ctx, client.StoreRequest{Key: "DB_URL", Value: "postgres://"}); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := c.Retrieve(ctx, "DB_URL"); err != nil || value != "postgres://" {
+		t.Fatalf("retrieve: %q %v", value, err)
+	}
+	if all, err := c.RetrieveAll(ctx); err != nil || all["DB_URL"] != "postgres://" {
+		t.Fatalf("retrieve all: %v %v", all, err)
+	}
+
+	// The token is read-only
+	if err := c.Store(ctx, client.StoreRequest{Key: "OTHER", Value: "x"}); statusOf(err) != http.StatusForbidden {
+		t.Fatalf("store with read-only token: %v", err)
+	}
+
+	if _, err := client.New(ts.URL, client.WithToken("senv_bogus")).Retrieve(ctx, "DB_URL"); statusOf(err) != http.StatusUnauthorized {
+		t.Fatalf("bogus token: %v", err)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	ts := newServer(t)
+	f := newFlaky(t, ts.URL)
+	c, _ := login(t, f.URL, "alice@example.com", client.WithRetries(3, time.Millisecond))
+	ctx := context.Background()
+	if err := c.Store(ctx, client.StoreRequest{Key: "DB_URL", Value: "postgres://"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, status := range []int{http.StatusServiceUnavailable, http.StatusTooManyRequests} {
+		f.failNext(2, status)
+		if value, err := c.Retrieve(ctx, "DB_URL"); err != nil || value != "postgres://" {
+			t.Fatalf("retrieve through %d: %q %v", status, value, err)
+		}
+		if n := f.requests.Load(); n != 3 {
+			t.Fatalf("%d: %d requests, want 3", status, n)
+		}
+	}
+
+	// Giving up after the last retry
+	f.failNext(10, http.StatusBadGateway)
+	if _, err := c.Retrieve(ctx, "DB_URL"); statusOf(err) != http.StatusBadGateway {
+		t.Fatalf("retrieve through outage: %v", err)
+	}
+	if n := f.requests.Load(); n != 4 {
+		t.Fatalf("%d requests, want 4", n)
+	}
+
+	// A POST that reached the server may have been applied, so it isn't
+	// retried on a 5xx, but a 429 means it wasn't
+	f.failNext(1, http.StatusInternalServerError)
+	if err := c.Store(ctx, client.StoreRequest{Key: "A", Value: "1"}); statusOf(err) != http.StatusInternalServerError {
+		t.Fatalf("store through 500: %v", err)
+	}
+	if n := f.requests.Load(); n != 1 {
+		t.Fatalf("store through 500: %d requests, want 1", n)
+	}
+	f.failNext(1, http.StatusTooManyRequests)
+	if err := c.Store(ctx, client.StoreRequest{Key: "A", Value: "1"}); err != nil {
+		t.Fatalf("store through 429: %v", err)
+	}
+}
+
+func TestCache(t *testing.T) {
+	ts := newServer(t)
+	f := newFlaky(t, ts.URL)
+	const ttl = 200 * time.Millisecond
+	c, tokens := login(t, f.URL, "alice@example.com", client.WithCache(ttl))
+	ctx := context.Background()
+	if err := c.Store(ctx, client.StoreRequest{Key: "DB_URL", Value: "v1"}); err != nil {
+		t.Fatal(err)
+	}
+	// Another writer, whose changes the cache doesn't see until it expires
+	other := client.New(ts.URL, client.WithToken(tokens.Token))
+
+	f.failNext(0, 0)
+	for range 3 {
+		if value, err := c.Retrieve(ctx, "DB_URL"); err != nil || value != "v1" {
+			t.Fatalf("retrieve: %q %v", value, err)
+		}
+	}
+	if _, err := c.RetrieveAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := f.requests.Load(); n != 2 {
+		t.Fatalf("%d requests, want 2", n)
+	}
+
+	if err := other.Update(ctx, "DB_URL", client.UpdateRequest{NewValue: "v2"}); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := c.Retrieve(ctx, "DB_URL"); value != "v1" {
+		t.Fatalf("cached value: %q, want v1", value)
+	}
+	time.Sleep(ttl + 50*time.Millisecond)
+	if value, _ := c.Retrieve(ctx, "DB_URL"); value != "v2" {
+		t.Fatalf("value after ttl: %q, want v2", value)
+	}
+
+	// Writes through the same client clear the scope's cached values
+	if err := c.Update(ctx, "DB_URL", client.UpdateRequest{NewValue: "v3"}); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := c.Retrieve(ctx, "DB_URL"); value != "v3" {
+		t.Fatalf("value after update: %q, want v3", value)
+	}
+	if all, _ := c.RetrieveAll(ctx); all["DB_URL"] != "v3" {
+		t.Fatalf("all values after update: %v", all)
+	}
+
+	// Values handed out can't change what's cached
+	all, _ := c.RetrieveAll(ctx)
+	all["DB_URL"] = "tampered"
+	if again, _ := c.RetrieveAll(ctx); again["DB_URL"] != "v3" {
+		t.Fatalf("cache was modified through a result: %v", again)
+	}
+}
+
+func statusOf(err error) int {
+	var apiErr *client.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
@@ -0,0 +1,204 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/David-mwas/SafeEnv/encryption"
+)
+
+// Scope is a set of variables: the flat ones outside any project, or an
+// environment's. Get one from Client.Env or Client.OrgEnv; the Client
+// itself is the flat scope.
+type Scope struct {
+	client *Client
+	prefix string // API path of the scope's variable routes
+}
+
+// Variable is a stored variable as listings return it, without its value.
+type Variable struct {
+	ID        string    `json:"_id"`
+	Key       string    `json:"key"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// StoreRequest creates a variable.
+type StoreRequest struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Note  string `json:"note,omitempty"` // recorded with the first version
+}
+
+// BulkStoreRequest creates several variables at once.
+type BulkStoreRequest struct {
+	Variables map[string]string `json:"variables"`
+	Note      string            `json:"note,omitempty"`
+}
+
+// UpdateRequest gives a variable a new value, and optionally a new key.
+type UpdateRequest struct {
+	NewValue string `json:"newValue"`
+	NewKey   string `json:"newKey,omitempty"`
+	Note     string `json:"note,omitempty"`
+}
+
+// ShareRequest creates a share link for a variable.
+type ShareRequest struct {
+	Key        string   `json:"key"`
+	ExpiresIn  int64    `json:"expiresIn,omitempty"` // seconds, default one day
+	MaxViews   int      `json:"maxViews,omitempty"`  // 0 means unlimited
+	OneTime    bool     `json:"oneTime,omitempty"`
+	Ciphertext string   `json:"ciphertext,omitempty"` // set by Share for one-time links
+	Recipients []string `json:"recipients,omitempty"` // emails or user ids allowed to open it
+	Passphrase string   `json:"passphrase,omitempty"`
+}
+
+// Share describes a share link.
+type Share struct {
+	ID             string    `json:"id"`
+	Key            string    `json:"key"`
+	MaxViews       int       `json:"maxViews"`
+	Views          int       `json:"views"`
+	Revoked        bool      `json:"revoked"`
+	OneTime        bool      `json:"oneTime"`
+	Active         bool      `json:"active"`
+	ExpiresAt      time.Time `json:"expiresAt"`
+	CreatedAt      time.Time `json:"createdAt"`
+	Recipients     []string  `json:"recipients"`
+	Passphrase     bool      `json:"passphrase"` // whether opening it needs one
+	FailedAttempts int       `json:"failedAttempts"`
+	Locked         bool      `json:"locked"`
+}
+
+// ShareResponse is a new share link. The token is only shown once.
+type ShareResponse struct {
+	Link  string `json:"link"`
+	Token string `json:"token"`
+	Share Share  `json:"share"`
+}
+
+// Store creates a variable. Storing a key that exists in an environment
+// fails with a conflict, see IsConflict.
+func (s *Scope) Store(ctx context.Context, req StoreRequest) error {
+	defer s.invalidate()
+	return s.client.do(ctx, http.MethodPost, s.prefix+"/store", req, nil)
+}
+
+// StoreBulk creates several variables in one request.
+func (s *Scope) StoreBulk(ctx context.Context, req BulkStoreRequest) error {
+	defer s.invalidate()
+	return s.client.do(ctx, http.MethodPost, s.prefix+"/store/bulk", req, nil)
+}
+
+// Retrieve returns a variable's value, from the cache if the client has
+// one and the value is fresh.
+func (s *Scope) Retrieve(ctx context.Context, key string) (string, error) {
+	cacheKey := s.prefix + "/retrieve/" + key
+	if value, ok := s.client.cache.get(cacheKey); ok {
+		return value.(string), nil
+	}
+
+	var reply struct {
+		Value string `json:"value"`
+	}
+	if err := s.client.do(ctx, http.MethodGet, s.prefix+"/retrieve/"+url.PathEscape(key), nil, &reply); err != nil {
+		return "", err
+	}
+	s.client.cache.put(cacheKey, reply.Value)
+	return reply.Value, nil
+}
+
+// RetrieveAll returns every value in the scope the caller may read, keyed
+// by variable key. It is cached like Retrieve.
+func (s *Scope) RetrieveAll(ctx context.Context) (map[string]string, error) {
+	cacheKey := s.prefix + "/retrieve"
+	if values, ok := s.client.cache.get(cacheKey); ok {
+		return copyValues(values.(map[string]string)), nil
+	}
+
+	var reply struct {
+		Variables map[string]string `json:"variables"`
+	}
+	if err := s.client.do(ctx, http.MethodGet, s.prefix+"/retrieve", nil, &reply); err != nil {
+		return nil, err
+	}
+	s.client.cache.put(cacheKey, copyValues(reply.Variables))
+	return reply.Variables, nil
+}
+
+// List returns the scope's variables without their values.
+func (s *Scope) List(ctx context.Context) ([]Variable, error) {
+	var reply struct {
+		Keys []Variable `json:"keys"`
+	}
+	err := s.client.do(ctx, http.MethodGet, s.prefix+"/keys", nil, &reply)
+	return reply.Keys, err
+}
+
+// Update writes a new version of the variable key.
+func (s *Scope) Update(ctx context.Context, key string, req UpdateRequest) error {
+	defer s.invalidate()
+	return s.client.do(ctx, http.MethodPut, s.prefix+"/keys/"+url.PathEscape(key), req, nil)
+}
+
+// Delete deletes the variable key.
+func (s *Scope) Delete(ctx context.Context, key string) error {
+	// The API deletes by id
+	vars, err := s.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, v := range vars {
+		if v.Key == key {
+			return s.DeleteByID(ctx, v.ID)
+		}
+	}
+	return &Error{StatusCode: http.StatusNotFound, Message: "Key not found"}
+}
+
+// DeleteByID deletes the variable with the id List reported.
+func (s *Scope) DeleteByID(ctx context.Context, id string) error {
+	defer s.invalidate()
+	return s.client.do(ctx, http.MethodDelete, s.prefix+"/keys/"+url.PathEscape(id), nil, nil)
+}
+
+// Share creates a share link for a variable. For one-time links the value
+// is encrypted here and only the ciphertext is sent; the key is added to
+// the returned link's fragment.
+func (s *Scope) Share(ctx context.Context, req ShareRequest) (*ShareResponse, error) {
+	fragment := ""
+	if req.OneTime {
+		value, err := s.Retrieve(ctx, req.Key)
+		if err != nil {
+			return nil, err
+		}
+		key, payload, err := encryption.SealOneTime([]byte(value))
+		if err != nil {
+			return nil, err
+		}
+		req.Ciphertext, fragment = payload, "#"+key
+	}
+
+	var reply ShareResponse
+	if err := s.client.do(ctx, http.MethodPost, s.prefix+"/share", req, &reply); err != nil {
+		return nil, err
+	}
+	reply.Link += fragment
+	return &reply, nil
+}
+
+// invalidate drops the scope's cached values after a write.
+func (s *Scope) invalidate() {
+	s.client.cache.clear(s.prefix + "/retrieve")
+}
+
+func copyValues(values map[string]string) map[string]string {
+	out := make(map[string]string, len(values))
+	for k, v := range values {
+		out[k] = v
+	}
+	return out
+}
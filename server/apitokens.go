@@ -0,0 +1,381 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/David-mwas/SafeEnv/store"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// apiTokenPrefix marks API tokens so they can't be mistaken for JWTs
+	// and secret scanners can spot leaked ones.
+	apiTokenPrefix = "senv_"
+
+	defaultAPITokenTTL = 90 * 24 * time.Hour
+	maxAPITokenTTL     = 365 * 24 * time.Hour
+)
+
+// tokenRoute is what an API token needs to call a route.
+type tokenRoute struct {
+	access   string
+	unscoped bool // works on the unscoped variables, outside any project
+}
+
+// tokenRoutes lists the routes API tokens may call. Everything else,
+// including managing tokens, needs a login.
+var tokenRoutes = func() map[string]tokenRoute {
+	routes := map[string]tokenRoute{
+		"GET /api/v1/user": {access: store.TokenRead},
+	}
+
+	// Projects are either the user's own or an organization's
+	projects := []string{"/api/v1/projects/:project", "/api/v1/orgs/:org/projects/:project"}
+	for _, project := range projects {
+		routes["GET "+project] = tokenRoute{access: store.TokenRead}
+		routes["GET "+project+"/envs"] = tokenRoute{access: store.TokenRead}
+		routes["GET "+project+"/envs/:env"] = tokenRoute{access: store.TokenRead}
+	}
+
+	// Variable routes exist flat and under an environment
+	variableRoutes := map[string]string{
+		"GET /keys":                        store.TokenRead,
+		"GET /retrieve":                    store.TokenRead,
+		"GET /retrieve/:key":               store.TokenRead,
+		"GET /export":                      store.TokenRead,
+		"GET /keys/:key/versions":          store.TokenRead,
+		"GET /keys/:key/versions/:version": store.TokenRead,
+		"POST /store":                      store.TokenWrite,
+		"POST /store/bulk":                 store.TokenWrite,
+		"POST /import":                     store.TokenWrite,
+		"PUT /keys/:key":                   store.TokenWrite,
+		"DELETE /keys/:id":                 store.TokenWrite,
+		"POST /keys/:key/versions/:version/rollback": store.TokenWrite,
+	}
+	for route, access := range variableRoutes {
+		method, path, _ := strings.Cut(route, " ")
+		routes[method+" /api/v1"+path] = tokenRoute{access: access, unscoped: true}
+		for _, project := range projects {
+			routes[method+" "+project+"/envs/:env"+path] = tokenRoute{access: access}
+		}
+	}
+	return routes
+}()
+
+// authenticateAPIToken is authMiddleware's path for API tokens. It checks
+// the route and any :key against the token's scopes; projects are checked
+// once projectMiddleware has loaded them, and keys from request bodies by
+// the handlers.
+func (s *Server) authenticateAPIToken(c *gin.Context, raw string) bool {
+	ctx := c.Request.Context()
+	now := time.Now()
+
+	token, err := s.store.GetAPITokenByHash(ctx, hashToken(raw))
+	if err != nil || !token.Active(now) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired API token"})
+		return false
+	}
+
+	// Set early so denials below are audited against the owner
+	c.Set("userID", token.UserID)
+	c.Set("apiToken", token)
+	c.Set(mfaKey, token.MFA)
+
+	route, ok := tokenRoutes[c.Request.Method+" "+c.FullPath()]
+	switch {
+	case !ok:
+		c.JSON(http.StatusForbidden, gin.H{"error": "API tokens can't be used for this endpoint"})
+		return false
+	case route.access == store.TokenWrite && token.Access != store.TokenWrite:
+		c.JSON(http.StatusForbidden, gin.H{"error": "This API token is read-only"})
+		return false
+	case route.unscoped && !token.AllowsProject(""):
+		c.JSON(http.StatusForbidden, gin.H{"error": "This API token is limited to specific projects"})
+		return false
+	case c.Param("key") != "" && !token.AllowsKey(c.Param("key")):
+		c.JSON(http.StatusForbidden, gin.H{"error": "This API token can't access this key"})
+		return false
+	}
+
+	if now.Sub(token.LastUsedAt) > sessionTouchInterval {
+		// Only bookkeeping, a failed write shouldn't fail the request
+		_ = s.store.TouchAPIToken(ctx, token.ID, c.ClientIP(), now)
+	}
+	return true
+}
+
+// apiTokenOf returns the API token the request was made with, if any.
+func apiTokenOf(c *gin.Context) *store.APIToken {
+	if t, ok := c.Get("apiToken"); ok {
+		return t.(*store.APIToken)
+	}
+	return nil
+}
+
+// keyAllowed checks a key taken from the request body against the API
+// token's key prefixes, writing a 403 if it's out of scope.
+func keyAllowed(c *gin.Context, keys ...string) bool {
+	token := apiTokenOf(c)
+	if token == nil {
+		return true
+	}
+	for _, key := range keys {
+		if !token.AllowsKey(key) {
+			c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("This API token can't access key %s", key)})
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Server) createAPIToken(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var data struct {
+		Name        string   `json:"name"`
+		Access      string   `json:"access"`   // "read" (default) or "write"
+		Projects    []string `json:"projects"` // project or org/project names, empty for all
+		KeyPrefixes []string `json:"keyPrefixes"`
+		ExpiresIn   int64    `json:"expiresIn"` // seconds, default 90 days
+	}
+	if err := c.ShouldBindJSON(&data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	data.Name = strings.TrimSpace(data.Name)
+	if data.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Token name is required"})
+		return
+	}
+	if data.Access == "" {
+		data.Access = store.TokenRead
+	}
+	if data.Access != store.TokenRead && data.Access != store.TokenWrite {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "access must be read or write"})
+		return
+	}
+	ttl := defaultAPITokenTTL
+	if data.ExpiresIn != 0 {
+		ttl = time.Duration(data.ExpiresIn) * time.Second
+	}
+	if ttl <= 0 || ttl > maxAPITokenTTL {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("expiresIn must be between 1 and %d seconds", int64(maxAPITokenTTL/time.Second))})
+		return
+	}
+
+	// Pin projects by id so renaming one can't widen the token
+	perm := permRead
+	if data.Access == store.TokenWrite {
+		perm = permWrite
+	}
+	var projectIDs []string
+	for _, name := range data.Projects {
+		project, ok := s.tokenProject(c, name, perm)
+		if !ok {
+			return
+		}
+		projectIDs = append(projectIDs, project.ID)
+	}
+	// Checking organization projects pointed the audit owner at them
+	c.Set(auditOwnerKey, userID)
+
+	secret, err := newToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		return
+	}
+	raw := apiTokenPrefix + secret
+
+	now := time.Now()
+	token := &store.APIToken{
+		UserID:      userID,
+		Name:        data.Name,
+		TokenHash:   hashToken(raw),
+		Prefix:      raw[:len(apiTokenPrefix)+6],
+		Access:      data.Access,
+		ProjectIDs:  projectIDs,
+		KeyPrefixes: data.KeyPrefixes,
+		MFA:         c.GetBool(mfaKey),
+		ExpiresAt:   now.Add(ttl),
+		CreatedAt:   now,
+	}
+	if err := s.store.CreateAPIToken(c.Request.Context(), token); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		return
+	}
+	c.Set(auditResourceKey, "tokens/"+token.ID)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":  "Token created, copy it now, it won't be shown again",
+		"token":    raw,
+		"apiToken": apiTokenJSON(token, data.Projects, now),
+	})
+}
+
+// tokenProject loads a project named for an API token, either the user's
+// own or "org/project", checking that the caller's role there allows what
+// the token will do. It writes a response and returns false if not.
+func (s *Server) tokenProject(c *gin.Context, name string, perm permission) (*store.Project, bool) {
+	ctx := c.Request.Context()
+	owner := c.GetString("userID")
+
+	orgName, projectName, inOrg := strings.Cut(name, "/")
+	if inOrg {
+		if !s.loadOrg(c, orgName) {
+			return nil, false
+		}
+		owner = memberOf(c).OrgID
+	} else {
+		projectName = name
+	}
+
+	project, err := s.store.GetProject(ctx, owner, projectName)
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found: " + name})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load project"})
+		return nil, false
+	}
+
+	if inOrg {
+		role, err := s.projectRole(ctx, memberOf(c), project.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load project"})
+			return nil, false
+		}
+		c.Set(roleKey, role)
+		if _, ok := s.authorize(c, perm); !ok {
+			return nil, false
+		}
+	}
+	return project, true
+}
+
+// apiTokenJSON is what owners see of a token; projects are shown by name.
+func apiTokenJSON(t *store.APIToken, projects []string, now time.Time) gin.H {
+	out := gin.H{
+		"id":          t.ID,
+		"name":        t.Name,
+		"prefix":      t.Prefix,
+		"access":      t.Access,
+		"projects":    projects,
+		"keyPrefixes": t.KeyPrefixes,
+		"revoked":     t.Revoked,
+		"active":      t.Active(now),
+		"expiresAt":   t.ExpiresAt,
+		"createdAt":   t.CreatedAt,
+		"lastUsedAt":  nil,
+		"lastUsedIP":  t.LastUsedIP,
+	}
+	if !t.LastUsedAt.IsZero() {
+		out["lastUsedAt"] = t.LastUsedAt
+	}
+	return out
+}
+
+// listAPITokens shows the caller's active tokens, or all with ?all=true.
+func (s *Server) listAPITokens(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+
+	tokens, err := s.store.ListAPITokens(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tokens"})
+		return
+	}
+	names, err := s.projectNames(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch projects"})
+		return
+	}
+
+	now := time.Now()
+	all := c.Query("all") == "true"
+	out := []gin.H{}
+	for _, t := range tokens {
+		if !all && !t.Active(now) {
+			continue
+		}
+		var tokenProjects []string
+		for _, id := range t.ProjectIDs {
+			// A deleted project leaves its id behind, which still matches nothing
+			if name, ok := names[id]; ok {
+				tokenProjects = append(tokenProjects, name)
+			}
+		}
+		out = append(out, apiTokenJSON(t, tokenProjects, now))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tokens": out})
+}
+
+// projectNames maps the ids of the user's projects, and of the projects of
+// organizations they belong to, to the names tokens are created with.
+func (s *Server) projectNames(ctx context.Context, userID string) (map[string]string, error) {
+	names := map[string]string{}
+	projects, err := s.store.ListProjects(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range projects {
+		names[p.ID] = p.Name
+	}
+
+	memberships, err := s.store.ListMemberships(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range memberships {
+		org, err := s.store.GetOrganizationByID(ctx, m.OrgID)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		projects, err := s.store.ListProjects(ctx, org.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range projects {
+			names[p.ID] = org.Name + "/" + p.Name
+		}
+	}
+	return names, nil
+}
+
+func (s *Server) revokeAPIToken(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	id := c.Param("id")
+	c.Set(auditResourceKey, "tokens/"+id)
+
+	err := s.store.RevokeAPIToken(c.Request.Context(), userID, id)
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Token revoked successfully"})
+}
@@ -0,0 +1,267 @@
+package server
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newAPIToken creates an API token for the account logged in with token.
+func newAPIToken(t *testing.T, ts *testServer, token string, body gin.H) string {
+	t.Helper()
+	status, out := ts.call(t, "POST", "/tokens", token, body)
+	if status != http.StatusCreated {
+		t.Fatalf("create token: %d %v", status, out)
+	}
+	return out["token"].(string)
+}
+
+// storeVars stores key=value pairs at path, e.g. "/store" or an environment's.
+func storeVars(t *testing.T, ts *testServer, token, path string, kv ...string) {
+	t.Helper()
+	for i := 0; i < len(kv); i += 2 {
+		if status, out := ts.call(t, "POST", path, token, gin.H{"key": kv[i], "value": kv[i+1]}); status != http.StatusOK {
+			t.Fatalf("store %s: %d %v", kv[i], status, out)
+		}
+	}
+}
+
+func TestAPITokenReadOnly(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	login := ts.register(t, "alice@example.com", "hunter22")
+	storeVars(t, ts, login, "/store", "DB_URL", "postgres://")
+	_, keys := ts.call(t, "GET", "/keys", login, nil)
+	id := keys["keys"].([]any)[0].(map[string]any)["_id"].(string)
+
+	token := newAPIToken(t, ts, login, gin.H{"name": "ci"})
+	if status, out := ts.call(t, "GET", "/retrieve/DB_URL", token, nil); status != http.StatusOK || out["value"] != "postgres://" {
+		t.Fatalf("read: %d %v", status, out)
+	}
+
+	writes := []struct {
+		method, path string
+		body         any
+	}{
+		{"POST", "/store", gin.H{"key": "NEW", "value": "x"}},
+		{"POST", "/store/bulk", gin.H{"variables": gin.H{"NEW": "x"}}},
+		{"PUT", "/keys/DB_URL", gin.H{"newValue": "x"}},
+		{"DELETE", "/keys/" + id, nil},
+		{"POST", "/keys/DB_URL/versions/1/rollback", nil},
+		{"POST", "/import?format=dotenv", gin.H{}},
+	}
+	for _, w := range writes {
+		status, out := ts.call(t, w.method, w.path, token, w.body)
+		if status != http.StatusForbidden || !strings.Contains(out["error"].(string), "read-only") {
+			t.Errorf("%s %s: %d %v", w.method, w.path, status, out)
+		}
+	}
+	if status, out := ts.call(t, "GET", "/retrieve/DB_URL", login, nil); status != http.StatusOK || out["value"] != "postgres://" {
+		t.Fatalf("value changed by a read-only token: %d %v", status, out)
+	}
+}
+
+func TestAPITokenKeyPrefixes(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	login := ts.register(t, "alice@example.com", "hunter22")
+	storeVars(t, ts, login, "/store", "DB_URL", "postgres://", "CI_TOKEN", "abc")
+	token := newAPIToken(t, ts, login, gin.H{"name": "ci", "access": "write", "keyPrefixes": []string{"CI_"}})
+
+	// Keys in the path
+	if status, out := ts.call(t, "GET", "/retrieve/CI_TOKEN", token, nil); status != http.StatusOK {
+		t.Fatalf("key in scope: %d %v", status, out)
+	}
+	if status, out := ts.call(t, "GET", "/retrieve/DB_URL", token, nil); status != http.StatusForbidden {
+		t.Fatalf("key out of scope: %d %v", status, out)
+	}
+	if status, out := ts.call(t, "GET", "/keys/DB_URL/versions", token, nil); status != http.StatusForbidden {
+		t.Fatalf("history of a key out of scope: %d %v", status, out)
+	}
+
+	// Keys in the body
+	denied := []struct {
+		method, path string
+		body         gin.H
+	}{
+		{"POST", "/store", gin.H{"key": "DB_PASSWORD", "value": "x"}},
+		{"POST", "/store/bulk", gin.H{"variables": gin.H{"CI_OK": "x", "DB_PASSWORD": "x"}}},
+		{"PUT", "/keys/CI_TOKEN", gin.H{"newKey": "DB_TOKEN"}},
+	}
+	for _, d := range denied {
+		if status, out := ts.call(t, d.method, d.path, token, d.body); status != http.StatusForbidden {
+			t.Errorf("%s %s %v: %d %v", d.method, d.path, d.body, status, out)
+		}
+	}
+	req, err := http.NewRequest("POST", ts.URL+"/api/v1/import?format=dotenv", strings.NewReader("CI_OK=1\nDB_PASSWORD=2\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("import with a key out of scope: %d", resp.StatusCode)
+	}
+	storeVars(t, ts, token, "/store", "CI_NEW", "x")
+
+	// Listings leave out what the token can't see
+	status, out := ts.call(t, "GET", "/retrieve", token, nil)
+	if status != http.StatusOK {
+		t.Fatalf("retrieve all: %d %v", status, out)
+	}
+	vars := out["variables"].(map[string]any)
+	if len(vars) != 2 || vars["CI_TOKEN"] != "abc" || vars["CI_NEW"] != "x" {
+		t.Fatalf("retrieved %v", vars)
+	}
+	_, out = ts.call(t, "GET", "/retrieve", login, nil)
+	if _, ok := out["variables"].(map[string]any)["DB_PASSWORD"]; ok {
+		t.Fatal("a denied write was stored")
+	}
+}
+
+func TestAPITokenProjectScope(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	login := ts.register(t, "alice@example.com", "hunter22")
+	for _, project := range []string{"web", "api"} {
+		if status, out := ts.call(t, "POST", "/projects", login, gin.H{"name": project}); status >= 300 {
+			t.Fatalf("create project: %d %v", status, out)
+		}
+		if status, out := ts.call(t, "POST", "/projects/"+project+"/envs", login, gin.H{"name": "prod"}); status >= 300 {
+			t.Fatalf("create env: %d %v", status, out)
+		}
+		storeVars(t, ts, login, "/projects/"+project+"/envs/prod/store", "DB_URL", project)
+	}
+	storeVars(t, ts, login, "/store", "PERSONAL", "x")
+
+	token := newAPIToken(t, ts, login, gin.H{"name": "ci", "projects": []string{"web"}})
+	if status, out := ts.call(t, "GET", "/projects/web/envs/prod/retrieve/DB_URL", token, nil); status != http.StatusOK || out["value"] != "web" {
+		t.Fatalf("project in scope: %d %v", status, out)
+	}
+	for _, path := range []string{"/projects/api/envs/prod/retrieve/DB_URL", "/projects/api", "/retrieve", "/retrieve/PERSONAL", "/keys"} {
+		if status, out := ts.call(t, "GET", path, token, nil); status != http.StatusForbidden {
+			t.Errorf("GET %s: %d %v", path, status, out)
+		}
+	}
+
+	if status, out := ts.call(t, "POST", "/tokens", login, gin.H{"name": "ci", "projects": []string{"missing"}}); status != http.StatusNotFound {
+		t.Fatalf("token for a missing project: %d %v", status, out)
+	}
+}
+
+// TestAPITokenRoutes checks every authenticated route outside tokenRoutes
+// refuses API tokens, and that tokenRoutes only names real routes.
+func TestAPITokenRoutes(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	login := ts.register(t, "alice@example.com", "hunter22")
+	token := newAPIToken(t, ts, login, gin.H{"name": "ci", "access": "write"})
+
+	r := gin.New()
+	ts.srv.Register(r)
+	registered := map[string]bool{}
+	checked := 0
+	param := regexp.MustCompile(`:[a-z]+`)
+	for _, route := range r.Routes() {
+		name := route.Method + " " + route.Path
+		registered[name] = true
+		if _, ok := tokenRoutes[name]; ok || !strings.HasPrefix(route.Path, "/api/v1/") {
+			continue
+		}
+
+		path := strings.TrimPrefix(param.ReplaceAllString(route.Path, "x"), "/api/v1")
+		// Only routes that need a login are of interest
+		if status, _ := ts.call(t, route.Method, path, "", nil); status != http.StatusUnauthorized {
+			continue
+		}
+		checked++
+		status, out := ts.call(t, route.Method, path, token, nil)
+		if status != http.StatusForbidden || !strings.Contains(out["error"].(string), "can't be used for this endpoint") {
+			t.Errorf("%s with an API token: %d %v", name, status, out)
+		}
+	}
+	if checked < 20 {
+		t.Fatalf("only %d routes needed a login", checked)
+	}
+
+	for name := range tokenRoutes {
+		if !registered[name] {
+			t.Errorf("tokenRoutes names %s, which isn't a route", name)
+		}
+	}
+}
+
+// newOrgProject sets up organization acme with project web and its prod
+// environment, with dev@example.com as a viewer, and returns the owner's
+// and the viewer's tokens.
+func newOrgProject(t *testing.T, ts *testServer) (string, string) {
+	t.Helper()
+	ownerToken := ts.register(t, "owner@example.com", "hunter22")
+	viewerToken := ts.register(t, "dev@example.com", "hunter22")
+
+	steps := []struct {
+		path string
+		body gin.H
+	}{
+		{"/orgs", gin.H{"name": "acme"}},
+		{"/orgs/acme/members", gin.H{"email": "dev@example.com", "role": "viewer"}},
+		{"/orgs/acme/projects", gin.H{"name": "web"}},
+		{"/orgs/acme/projects/web/envs", gin.H{"name": "prod"}},
+		{"/orgs/acme/projects", gin.H{"name": "api"}},
+		{"/orgs/acme/projects/api/envs", gin.H{"name": "prod"}},
+	}
+	for _, step := range steps {
+		if status, out := ts.call(t, "POST", step.path, ownerToken, step.body); status >= 300 {
+			t.Fatalf("POST %s: %d %v", step.path, status, out)
+		}
+	}
+	return ownerToken, viewerToken
+}
+
+func TestAPITokenForOrgProject(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	ownerToken, viewerToken := newOrgProject(t, ts)
+
+	status, out := ts.call(t, "POST", "/tokens", ownerToken, gin.H{"name": "ci", "access": "write", "projects": []string{"acme/web"}})
+	if status != http.StatusCreated {
+		t.Fatalf("create token: %d %v", status, out)
+	}
+	token := out["token"].(string)
+
+	status, out = ts.call(t, "POST", "/orgs/acme/projects/web/envs/prod/store", token, gin.H{"key": "DB_URL", "value": "postgres://"})
+	if status >= 300 {
+		t.Fatalf("store in scoped project: %d %v", status, out)
+	}
+	if status, out := ts.call(t, "GET", "/orgs/acme/projects/api/envs/prod/retrieve", token, nil); status != http.StatusForbidden {
+		t.Fatalf("other org project: %d %v", status, out)
+	}
+	if status, out := ts.call(t, "GET", "/retrieve", token, nil); status != http.StatusForbidden {
+		t.Fatalf("unscoped variables: %d %v", status, out)
+	}
+
+	status, out = ts.call(t, "GET", "/tokens", ownerToken, nil)
+	if status != http.StatusOK {
+		t.Fatalf("list tokens: %d %v", status, out)
+	}
+	projects := out["tokens"].([]any)[0].(map[string]any)["projects"].([]any)
+	if len(projects) != 1 || projects[0] != "acme/web" {
+		t.Fatalf("listed projects %v", projects)
+	}
+
+	// The role on the project has to allow what the token does
+	if status, out := ts.call(t, "POST", "/tokens", viewerToken, gin.H{"name": "ci", "access": "write", "projects": []string{"acme/web"}}); status != http.StatusForbidden {
+		t.Fatalf("write token as viewer: %d %v", status, out)
+	}
+	if status, out := ts.call(t, "POST", "/tokens", viewerToken, gin.H{"name": "ci", "projects": []string{"acme/web"}}); status != http.StatusCreated {
+		t.Fatalf("read token as viewer: %d %v", status, out)
+	}
+
+	// and only members can name the organization's projects
+	outsider := ts.register(t, "outsider@example.com", "hunter22")
+	if status, out := ts.call(t, "POST", "/tokens", outsider, gin.H{"name": "ci", "projects": []string{"acme/web"}}); status != http.StatusNotFound {
+		t.Fatalf("token for another organization: %d %v", status, out)
+	}
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/David-mwas/SafeEnv/store"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultAuditLimit = 50
+	maxAuditLimit     = 200
+)
+
+// Context keys handlers can set to fill in what audited can't infer from
+// the route parameters.
+const (
+	auditActorKey    = "auditActor"    // actor for routes without authMiddleware
+	auditOwnerKey    = "auditOwner"    // resource owner when it isn't the actor
+	auditKeyKey      = "auditKey"      // variable key taken from the request body
+	auditResourceKey = "auditResource" // full resource, overrides everything else
+	auditDetailKey   = "auditDetail"
+)
+
+// audited records an audit event for the request once the handler has run.
+func (s *Server) audited(action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		actor := c.GetString("userID")
+		if actor == "" {
+			actor = c.GetString(auditActorKey)
+		}
+		owner := c.GetString(auditOwnerKey)
+		if owner == "" {
+			owner = actor
+		}
+
+		status := c.Writer.Status()
+		detail := c.GetString(auditDetailKey)
+		if token := apiTokenOf(c); token != nil {
+			detail = strings.TrimSpace(detail + " (api token " + token.ID + ")")
+		}
+		event := &store.AuditEvent{
+			ActorID:   actor,
+			OwnerID:   owner,
+			Action:    action,
+			Resource:  auditResource(c),
+			IP:        c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+			Outcome:   auditOutcome(status),
+			Status:    status,
+			Detail:    detail,
+			CreatedAt: time.Now(),
+		}
+
+		// The response is already written, a failed write can only be logged
+		ctx := context.WithoutCancel(c.Request.Context())
+		if err := s.audit.Append(ctx, event); err != nil {
+			log.Printf("Failed to write audit event %s %s: %v", action, event.Resource, err)
+		}
+	}
+}
+
+// auditResource names what the request touched, e.g.
+// "projects/web/envs/prod/keys/DB_URL/versions/3", prefixed with
+// "orgs/acme/" in an organization.
+func auditResource(c *gin.Context) string {
+	if r := c.GetString(auditResourceKey); r != "" {
+		return r
+	}
+
+	var parts []string
+	if org := c.Param("org"); org != "" {
+		parts = append(parts, "orgs", org)
+	}
+	if project := c.Param("project"); project != "" {
+		parts = append(parts, "projects", project)
+	}
+	if env := c.Param("env"); env != "" {
+		parts = append(parts, "envs", env)
+	}
+
+	key := c.Param("key")
+	if k := c.GetString(auditKeyKey); k != "" {
+		key = k
+	}
+	if key != "" {
+		parts = append(parts, "keys", key)
+	}
+	if id := c.Param("id"); id != "" {
+		parts = append(parts, "variables", id)
+	}
+	if version := c.Param("version"); version != "" {
+		parts = append(parts, "versions", version)
+	}
+	return strings.Join(parts, "/")
+}
+
+// orgPrefix is what resources set by handlers start with inside an
+// organization.
+func orgPrefix(c *gin.Context) string {
+	if org := c.Param("org"); org != "" {
+		return "orgs/" + org + "/"
+	}
+	return ""
+}
+
+func auditOutcome(status int) string {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return store.AuditDenied
+	case status >= 400:
+		return store.AuditFailure
+	default:
+		return store.AuditSuccess
+	}
+}
+
+// listAudit pages through the audit log, newest first. Users see events
+// they caused or that touched their secrets, plus those of organizations
+// they own or administer; admins see everything.
+func (s *Server) listAudit(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	filter := store.AuditFilter{
+		Action:   c.Query("action"),
+		Outcome:  c.Query("outcome"),
+		Resource: c.Query("resource"),
+		ActorID:  c.Query("actor"),
+		Before:   c.Query("before"),
+		Limit:    defaultAuditLimit,
+	}
+	if !s.isAdmin(c.Request.Context(), userID) {
+		filter.Subject = userID
+
+		memberships, err := s.store.ListMemberships(c.Request.Context(), userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch audit log"})
+			return
+		}
+		for _, m := range memberships {
+			if roleRank[m.Role] >= roleRank[store.RoleAdmin] {
+				filter.Owners = append(filter.Owners, m.OrgID)
+			}
+		}
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxAuditLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(maxAuditLimit)})
+			return
+		}
+		filter.Limit = n
+	}
+
+	for param, dst := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if v := c.Query(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": param + " must be an RFC 3339 timestamp"})
+				return
+			}
+			*dst = t
+		}
+	}
+
+	events, err := s.store.ListAudit(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch audit log"})
+		return
+	}
+
+	// A full page means there may be more
+	nextCursor := ""
+	if len(events) == filter.Limit {
+		nextCursor = events[len(events)-1].ID
+	}
+
+	c.JSON(http.StatusOK, gin.H{"events": events, "nextCursor": nextCursor})
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/David-mwas/SafeEnv/store"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// auth
+func (s *Server) registerUser(c *gin.Context) {
+	var user struct {
+		Username string `json:"username"`
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	if err := c.ShouldBindJSON(&user); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user.Email = store.NormalizeEmail(user.Email)
+
+	// Hash password
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	// Store user in DB
+	err = s.store.CreateUser(c.Request.Context(), &store.User{
+		Username:     user.Username,
+		Email:        user.Email,
+		PasswordHash: string(hashedPassword),
+		CreatedAt:    time.Now(),
+	})
+	if errors.Is(err, store.ErrDuplicate) {
+		c.JSON(http.StatusConflict, gin.H{"error": "An account with this email already exists"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User registered successfully"})
+}
+
+func (s *Server) loginUser(c *gin.Context) {
+	var credentials struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	if err := c.ShouldBindJSON(&credentials); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	credentials.Email = store.NormalizeEmail(credentials.Email)
+	c.Set(auditResourceKey, "users/"+credentials.Email)
+
+	// Fetch user from DB
+	user, err := s.store.GetUserByEmail(c.Request.Context(), credentials.Email)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		return
+	}
+
+	c.Set(auditActorKey, user.ID)
+
+	// Compare password
+	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(credentials.Password))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		return
+	}
+
+	s.finishLogin(c, user, false)
+}
+
+// finishLogin hands out session tokens once the first factor checks out,
+// or asks for a second one. verified means the user already proved more
+// than one factor, e.g. with a passkey that checked their PIN.
+func (s *Server) finishLogin(c *gin.Context, user *store.User, verified bool) {
+	if !verified && s.needsMFA(user) {
+		mfaToken, err := s.signMFAToken(user.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+		// enrolled false means the user has to set up 2FA before continuing
+		c.JSON(http.StatusOK, gin.H{
+			"mfaRequired": true,
+			"mfaToken":    mfaToken,
+			"enrolled":    user.MFA.Enabled,
+		})
+		return
+	}
+
+	tokens, err := s.startSession(c, user, verified)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (s *Server) authMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenString := c.GetHeader("Authorization")
+		if tokenString == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token required"})
+			c.Abort()
+			return
+		}
+
+		// Remove "Bearer " prefix if present
+		if len(tokenString) > 7 && tokenString[:7] == "Bearer " {
+			tokenString = tokenString[7:]
+		} else {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Malformed token"})
+			c.Abort()
+			return
+		}
+
+		if strings.HasPrefix(tokenString, apiTokenPrefix) {
+			if !s.authenticateAPIToken(c, tokenString) {
+				c.Abort()
+				return
+			}
+			c.Next()
+			return
+		}
+
+		// Parse JWT token
+		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+			return s.jwtSecret, nil
+		}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+
+		if err != nil || !token.Valid {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			c.Abort()
+			return
+		}
+
+		// Extract user ID from token claims
+		claims, ok := token.Claims.(jwt.MapClaims)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+			c.Abort()
+			return
+		}
+
+		userID, ok := claims["sub"].(string)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID in token"})
+			c.Abort()
+			return
+		}
+
+		// Tokens from before sessions existed can't be revoked, so refuse them
+		sessionID, ok := claims["sid"].(string)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired, please log in again"})
+			c.Abort()
+			return
+		}
+		if !s.checkSession(c, userID, sessionID) {
+			c.Abort()
+			return
+		}
+
+		// Store userID in the request context
+		c.Set("userID", userID)
+		c.Set("sessionID", sessionID)
+
+		c.Next()
+	}
+}
+
+// adminMiddleware only lets through users listed in SAFEENV_ADMIN_EMAILS.
+func (s *Server) adminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			c.Abort()
+			return
+		}
+
+		if !s.isAdmin(c.Request.Context(), userID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// isAdmin reports whether the user's email is listed in SAFEENV_ADMIN_EMAILS.
+func (s *Server) isAdmin(ctx context.Context, userID string) bool {
+	user, err := s.store.GetUserByID(ctx, userID)
+	if err != nil {
+		return false
+	}
+	for _, email := range s.adminEmails {
+		if store.NormalizeEmail(email) == user.Email {
+			return true
+		}
+	}
+	return false
+}
+
+// Get Current User Details
+func (s *Server) getCurrentUser(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	user, err := s.store.GetUserByID(c.Request.Context(), userID)
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":         user.ID,
+		"username":   user.Username,
+		"email":      user.Email,
+		"mfaEnabled": user.MFA.Enabled,
+	})
+}
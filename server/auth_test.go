@@ -0,0 +1,26 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestEmailsAreNormalized(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	ts.register(t, " Alice@Example.com ", "hunter22")
+
+	status, out := ts.call(t, "POST", "/register", "", gin.H{"username": "alice", "email": "alice@example.com", "password": "hunter22"})
+	if status != http.StatusConflict {
+		t.Fatalf("register with another case: %d %v", status, out)
+	}
+
+	status, out = ts.call(t, "POST", "/login", "", gin.H{"email": "ALICE@example.com", "password": "hunter22"})
+	if status != http.StatusOK || out["token"] == nil {
+		t.Fatalf("login with another case: %d %v", status, out)
+	}
+	if _, me := ts.call(t, "GET", "/user", out["token"].(string), nil); me["email"] != "alice@example.com" {
+		t.Fatalf("stored email %v", me["email"])
+	}
+}
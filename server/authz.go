@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/David-mwas/SafeEnv/store"
+	"github.com/gin-gonic/gin"
+)
+
+// permission is something a role may do where it applies.
+type permission int
+
+const (
+	permRead    permission = iota // list and read variables, their history and the project
+	permWrite                     // create, change and delete variables
+	permShare                     // create share links
+	permManage                    // create and change projects and environments
+	permMembers                   // manage an organization's members and teams
+)
+
+var rolePermissions = map[string][]permission{
+	store.RoleViewer:    {permRead},
+	store.RoleDeveloper: {permRead, permWrite, permShare},
+	store.RoleAdmin:     {permRead, permWrite, permShare, permManage, permMembers},
+	store.RoleOwner:     {permRead, permWrite, permShare, permManage, permMembers},
+}
+
+// roleRank orders roles so the strongest of several grants wins.
+var roleRank = map[string]int{
+	store.RoleViewer:    1,
+	store.RoleDeveloper: 2,
+	store.RoleAdmin:     3,
+	store.RoleOwner:     4,
+}
+
+func roleAllows(role string, perm permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// Set by orgMiddleware and projectMiddleware for authorize.
+const (
+	ownerKey = "owner" // user or organization owning the request's variables
+	roleKey  = "role"  // the caller's role there
+)
+
+// authorize decides whether the caller may do perm where the request
+// points: their own variables, or the organization and project loaded by
+// the middlewares. Organizations that require 2FA also need the caller to
+// have used it. It returns the owner to look variables up by, or writes a
+// 403 and returns false.
+func (s *Server) authorize(c *gin.Context, perm permission) (string, bool) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return "", false
+	}
+
+	// Users own everything outside organizations
+	owner, role := userID, store.RoleOwner
+	if o, ok := c.Get(ownerKey); ok {
+		owner, role = o.(string), c.GetString(roleKey)
+	}
+	if owner != userID {
+		c.Set(auditOwnerKey, owner)
+	}
+
+	if org, ok := c.Get("organization"); ok && org.(*store.Organization).RequireMFA && !c.GetBool(mfaKey) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This organization requires two-factor authentication; set it up and log in again"})
+		return "", false
+	}
+	if !roleAllows(role, perm) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Your role (" + role + ") doesn't allow this"})
+		return "", false
+	}
+	return owner, true
+}
+
+// projectRole is a member's role on one of the organization's projects:
+// their organization role, raised by any of their teams' grants.
+func (s *Server) projectRole(ctx context.Context, member *store.Member, projectID string) (string, error) {
+	teams, err := s.store.ListUserTeams(ctx, member.OrgID, member.UserID)
+	if err != nil {
+		return "", err
+	}
+
+	role := member.Role
+	for _, t := range teams {
+		if grant := t.Grants[projectID]; roleRank[grant] > roleRank[role] {
+			role = grant
+		}
+	}
+	return role, nil
+}
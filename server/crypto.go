@@ -0,0 +1,230 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/David-mwas/SafeEnv/encryption"
+	"github.com/David-mwas/SafeEnv/store"
+)
+
+// userDataKey returns the user's unwrapped data encryption key, generating
+// and storing one the first time the user needs it.
+func (s *Server) userDataKey(ctx context.Context, userID string) ([]byte, error) {
+	return s.dataKey(ctx, userID,
+		func() (string, error) {
+			user, err := s.store.GetUserByID(ctx, userID)
+			if err != nil {
+				return "", fmt.Errorf("load user %s: %w", userID, err)
+			}
+			return user.DataKey, nil
+		},
+		func(wrapped string) error {
+			return s.store.SetUserDataKey(ctx, userID, wrapped, "")
+		})
+}
+
+// orgDataKey is userDataKey for an organization.
+func (s *Server) orgDataKey(ctx context.Context, orgID string) ([]byte, error) {
+	return s.dataKey(ctx, orgID,
+		func() (string, error) {
+			org, err := s.store.GetOrganizationByID(ctx, orgID)
+			if err != nil {
+				return "", fmt.Errorf("load organization %s: %w", orgID, err)
+			}
+			return org.DataKey, nil
+		},
+		func(wrapped string) error {
+			return s.store.SetOrganizationDataKey(ctx, orgID, wrapped, "")
+		})
+}
+
+// ownerDataKey returns the data key of a variable's owner, a user or an
+// organization.
+func (s *Server) ownerDataKey(ctx context.Context, ownerID string) ([]byte, error) {
+	dek, err := s.userDataKey(ctx, ownerID)
+	if errors.Is(err, store.ErrNotFound) {
+		return s.orgDataKey(ctx, ownerID)
+	}
+	return dek, err
+}
+
+// dataKey unwraps the key returned by get, or generates one and saves it
+// with set, which must fail with ErrNotFound if a key was saved meanwhile.
+func (s *Server) dataKey(ctx context.Context, ownerID string, get func() (string, error), set func(wrapped string) error) ([]byte, error) {
+	for {
+		current, err := get()
+		if err != nil {
+			return nil, err
+		}
+		if current != "" {
+			return s.unwrapDataKey(ctx, current, ownerID)
+		}
+
+		dek, err := encryption.GenerateDataKey()
+		if err != nil {
+			return nil, err
+		}
+		wrapped, err := encryption.WrapDataKey(ctx, s.keys, dek, ownerID)
+		if err != nil {
+			return nil, err
+		}
+
+		// Another request may have created the key first; if so, use theirs.
+		err = set(wrapped)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return dek, nil
+	}
+}
+
+// maxCachedDataKeys bounds the in-memory data key cache.
+const maxCachedDataKeys = 1024
+
+// unwrapDataKey unwraps an owner's data key, caching the result so a remote
+// key provider is not called on every request. Entries are keyed by the
+// wrapped value, so a re-wrapped key simply misses the cache.
+func (s *Server) unwrapDataKey(ctx context.Context, wrapped, ownerID string) ([]byte, error) {
+	s.dekMu.Lock()
+	dek, ok := s.dekCache[wrapped]
+	s.dekMu.Unlock()
+	if ok {
+		return dek, nil
+	}
+
+	dek, err := encryption.UnwrapDataKey(ctx, s.keys, wrapped, ownerID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.dekMu.Lock()
+	if len(s.dekCache) >= maxCachedDataKeys {
+		s.dekCache = map[string][]byte{}
+	}
+	s.dekCache[wrapped] = dek
+	s.dekMu.Unlock()
+	return dek, nil
+}
+
+// variableAD binds a value to its owner, key name and environment, so a
+// ciphertext copied to another variable fails to decrypt.
+func variableAD(v *store.Variable) []byte {
+	return encryption.AssociatedData(v.UserID, v.Key, v.ProjectID, v.EnvID)
+}
+
+// seal encrypts value for v with an already unwrapped data key.
+func seal(dek []byte, v *store.Variable, value string) (string, error) {
+	return encryption.EncryptWithDataKey(dek, []byte(value), variableAD(v))
+}
+
+// encrypt seals value for v, which needs its owner, key and scope set.
+func (s *Server) encrypt(ctx context.Context, v *store.Variable, value string) (string, error) {
+	dek, err := s.ownerDataKey(ctx, v.UserID)
+	if err != nil {
+		return "", err
+	}
+	return seal(dek, v, value)
+}
+
+// decrypt opens a stored variable's value. Values written before data keys
+// existed are still sealed directly with a master key.
+func (s *Server) decrypt(ctx context.Context, v *store.Variable) (string, error) {
+	ad := variableAD(v)
+
+	if !encryption.IsDataKeyEnvelope(v.Value) {
+		if s.keyring == nil {
+			return "", errors.New("value is sealed with a master key but no local keyring is configured")
+		}
+		plaintext, err := s.keyring.Decrypt(v.Value, ad)
+		if err != nil {
+			return "", err
+		}
+		return string(plaintext), nil
+	}
+
+	dek, err := s.ownerDataKey(ctx, v.UserID)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := encryption.DecryptWithDataKey(dek, v.Value, ad)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// reencryptVariable rewrites v with its owner's data key. It reports false
+// when the record changed underneath us, in which case the newer write wins.
+func (s *Server) reencryptVariable(ctx context.Context, v *store.Variable) (bool, error) {
+	plaintext, err := s.decrypt(ctx, v)
+	if err != nil {
+		return false, fmt.Errorf("decrypt variable %s: %w", v.ID, err)
+	}
+	sealed, err := s.encrypt(ctx, v, plaintext)
+	if err != nil {
+		return false, fmt.Errorf("encrypt variable %s: %w", v.ID, err)
+	}
+
+	// Matching on the old value skips records updated since we read them.
+	err = s.store.UpdateVariable(ctx, store.VariableFilter{ID: v.ID, Value: v.Value}, store.VariableUpdate{Value: sealed})
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("update variable %s: %w", v.ID, err)
+	}
+	return true, nil
+}
+
+// rewrapDataKey re-wraps a user's or organization's data key with the
+// primary master key, saving it with set.
+func (s *Server) rewrapDataKey(ctx context.Context, ownerID, current string, set func(ctx context.Context, id, wrapped, expected string) error) (bool, error) {
+	dek, err := encryption.UnwrapDataKey(ctx, s.keys, current, ownerID)
+	if err != nil {
+		return false, fmt.Errorf("unwrap data key for %s: %w", ownerID, err)
+	}
+	wrapped, err := encryption.WrapDataKey(ctx, s.keys, dek, ownerID)
+	if err != nil {
+		return false, fmt.Errorf("wrap data key for %s: %w", ownerID, err)
+	}
+
+	err = set(ctx, ownerID, wrapped, current)
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("update data key for %s: %w", ownerID, err)
+	}
+	return true, nil
+}
+
+// MigrateEncryption re-encrypts every variable still stored in the legacy
+// AES-CFB format into the authenticated envelope. It is safe to run
+// repeatedly and alongside live traffic: already migrated values are skipped.
+func (s *Server) MigrateEncryption(ctx context.Context) (int, error) {
+	vars, err := s.store.ListVariables(ctx, store.VariableFilter{})
+	if err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+	for _, v := range vars {
+		if !encryption.IsLegacy(v.Value) {
+			continue
+		}
+
+		changed, err := s.reencryptVariable(ctx, v)
+		if err != nil {
+			return migrated, err
+		}
+		if changed {
+			migrated++
+		}
+	}
+	return migrated, nil
+}
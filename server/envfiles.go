@@ -0,0 +1,274 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/David-mwas/SafeEnv/envfile"
+	"github.com/David-mwas/SafeEnv/policy"
+	"github.com/David-mwas/SafeEnv/store"
+	"github.com/gin-gonic/gin"
+)
+
+// maxImportSize caps an uploaded file; nobody's .env is a megabyte.
+const maxImportSize = 1 << 20
+
+// formatParam reads ?format=, writing a 400 if it is unknown.
+func formatParam(c *gin.Context) (envfile.Format, bool) {
+	format, err := envfile.ParseFormat(c.Query("format"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return "", false
+	}
+	return format, true
+}
+
+// exportVariables downloads the scope's variables as a dotenv, JSON, YAML,
+// shell or Docker --env-file file, or as a Kubernetes Secret or ConfigMap
+// named by ?name=, in ?namespace= and with ?label=key=value labels.
+func (s *Server) exportVariables(c *gin.Context) {
+	owner, ok := s.authorize(c, permRead)
+	if !ok {
+		return
+	}
+	kind, kindErr := envfile.ParseKind(c.Query("format"))
+	format, formatErr := envfile.ParseFormat(c.Query("format"))
+	if kindErr != nil && formatErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Format must be dotenv, json, yaml, shell, docker, secret or configmap"})
+		return
+	}
+
+	name := "safeenv"
+	if project, ok := c.Get("project"); ok {
+		name = project.(*store.Project).Name + "-" + c.MustGet("environment").(*store.Environment).Name
+	}
+
+	var manifest *envfile.Manifest
+	if kindErr == nil {
+		labels, err := envfile.ParseLabels(c.QueryArray("label"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		manifest = &envfile.Manifest{
+			Kind:      kind,
+			Name:      c.DefaultQuery("name", manifestName(name)),
+			Namespace: c.Query("namespace"),
+			Labels:    labels,
+		}
+		// Check the options before decrypting anything
+		if err := manifest.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	values, ok := s.readableValues(c, owner)
+	if !ok {
+		return
+	}
+
+	var data []byte
+	var err error
+	if manifest != nil {
+		manifest.Data = values
+		data, err = envfile.MarshalManifest(manifest)
+		format, name = envfile.YAML, manifest.Name
+	} else {
+		data, err = envfile.Marshal(format, values)
+	}
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s%s"`, name, format.Extension()))
+	c.Data(http.StatusOK, format.ContentType(), data)
+}
+
+// manifestName turns a project-env name into a Kubernetes object name.
+func manifestName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '.':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return '-'
+	}, name)
+	return strings.Trim(name, "-.")
+}
+
+// importVariables stores the variables in an uploaded dotenv, JSON or YAML
+// file, sent as the request body. New keys are created and changed ones
+// get a new version; keys missing from the file are left alone. With
+// ?dryRun=true it only reports what would change.
+func (s *Server) importVariables(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	owner, ok := s.authorize(c, permWrite)
+	if !ok {
+		return
+	}
+	format, ok := formatParam(c)
+	if !ok {
+		return
+	}
+	if !format.Importable() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Import from dotenv, json or yaml"})
+		return
+	}
+	dryRun := c.Query("dryRun") == "true"
+	ctx := c.Request.Context()
+
+	data, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File is too large"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	values, err := envfile.Parse(format, data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid %s file: %v", format, err)})
+		return
+	}
+	if _, ok := values[""]; ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Keys can't be empty"})
+		return
+	}
+	if len(values) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The file has no variables"})
+		return
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	detail := "keys: " + strings.Join(keys, ", ")
+	if dryRun {
+		detail = "dry run, " + detail
+	}
+	c.Set(auditDetailKey, detail)
+	if !keyAllowed(c, keys...) || !s.policyAllows(c, policy.Write, keys...) {
+		return
+	}
+
+	// Compare against what is stored to see what the file changes
+	scope := scopeOf(c)
+	vars, err := s.store.ListVariables(ctx, store.VariableFilter{UserID: owner, Scope: &scope})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch variables"})
+		return
+	}
+	existing := map[string]*store.Variable{}
+	for _, v := range vars {
+		if _, seen := existing[v.Key]; !seen {
+			existing[v.Key] = v
+		}
+	}
+
+	added, changed, unchanged := []string{}, []string{}, []string{}
+	for _, key := range keys {
+		current, ok := existing[key]
+		if !ok {
+			added = append(added, key)
+			continue
+		}
+		value, err := s.decrypt(ctx, current)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Decryption failed"})
+			return
+		}
+		if value == values[key] {
+			unchanged = append(unchanged, key)
+		} else {
+			changed = append(changed, key)
+		}
+	}
+
+	if !dryRun {
+		if !s.applyImport(c, owner, userID, values, added, changed, existing) {
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"added":     added,
+		"changed":   changed,
+		"unchanged": unchanged,
+		"dryRun":    dryRun,
+	})
+}
+
+// applyImport creates the added keys and writes new versions of the
+// changed ones, writing a response and returning false if that fails.
+func (s *Server) applyImport(c *gin.Context, owner, userID string, values map[string]string, added, changed []string, existing map[string]*store.Variable) bool {
+	ctx := c.Request.Context()
+	note := c.Query("note")
+
+	if len(added) > 0 {
+		dek, err := s.ownerDataKey(ctx, owner)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Encryption failed"})
+			return false
+		}
+
+		scope := scopeOf(c)
+		variables := make([]*store.Variable, 0, len(added))
+		for _, key := range added {
+			v := &store.Variable{
+				UserID:    owner,
+				ProjectID: scope.ProjectID,
+				EnvID:     scope.EnvID,
+				Key:       key,
+				Version:   1,
+				CreatedAt: time.Now(),
+			}
+			if v.Value, err = seal(dek, v, values[key]); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Encryption failed"})
+				return false
+			}
+			variables = append(variables, v)
+		}
+
+		err = s.store.CreateVariables(ctx, variables...)
+		if errors.Is(err, store.ErrDuplicate) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Keys were added by another request, try again"})
+			return false
+		}
+		if err == nil {
+			err = s.recordVersions(ctx, userID, note, variables...)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import variables"})
+			return false
+		}
+	}
+
+	for _, key := range changed {
+		_, err := s.writeVariable(ctx, existing[key], "", values[key], userID, note)
+		if errors.Is(err, errConcurrentUpdate) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Key " + key + " was modified by another request, try again"})
+			return false
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import variables"})
+			return false
+		}
+	}
+	return true
+}
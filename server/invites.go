@@ -0,0 +1,391 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/David-mwas/SafeEnv/store"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// inviteTTL is how long an invite link works before it has to be resent.
+const inviteTTL = 7 * 24 * time.Hour
+
+// signInviteToken issues the token in an invite link. It has no sub or
+// email, so it can't stand in for a session or a password reset.
+func (s *Server) signInviteToken(expiresAt time.Time) (string, error) {
+	nonce, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"typ": "invite",
+		"jti": nonce,
+		"exp": expiresAt.Unix(),
+	})
+	return token.SignedString(s.jwtSecret)
+}
+
+// sendInviteEmail mails the link to accept an invite.
+func (s *Server) sendInviteEmail(inv *store.Invite, org *store.Organization, inviter, token string) error {
+	link := fmt.Sprintf("%s/invite?token=%s", s.frontendURL, url.QueryEscape(token))
+
+	subject := fmt.Sprintf("You're invited to join %s on SafeEnv", org.Name)
+	body := fmt.Sprintf(
+		"Hello,\n\n%s invited you to join %s on SafeEnv as %s. Click the link below to accept:\n%s\n\nThe link will expire in %d days.\n\nThanks,\nSafeEnv",
+		inviter, org.Name, inv.Role, link, int(inviteTTL.Hours()/24),
+	)
+
+	return s.sendEmail(inv.Email, subject, body)
+}
+
+// issueInvite gives an invite a fresh token and emails it.
+func (s *Server) issueInvite(c *gin.Context, inv *store.Invite) error {
+	ctx := c.Request.Context()
+	org := c.MustGet("organization").(*store.Organization)
+
+	inviter, err := s.store.GetUserByID(ctx, memberOf(c).UserID)
+	if err != nil {
+		return err
+	}
+
+	inv.ExpiresAt = time.Now().Add(inviteTTL)
+	token, err := s.signInviteToken(inv.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	inv.TokenHash = hashToken(token)
+
+	if inv.ID == "" {
+		err = s.store.CreateInvite(ctx, inv)
+	} else {
+		err = s.store.RenewInvite(ctx, inv.ID, inv.TokenHash, inv.ExpiresAt)
+	}
+	if err != nil {
+		return err
+	}
+
+	name := inviter.Username
+	if name == "" {
+		name = inviter.Email
+	}
+	return s.sendInviteEmail(inv, org, name, token)
+}
+
+func inviteJSON(inv *store.Invite) gin.H {
+	return gin.H{
+		"id":        inv.ID,
+		"email":     inv.Email,
+		"role":      inv.Role,
+		"invitedBy": inv.InvitedBy,
+		"expired":   time.Now().After(inv.ExpiresAt),
+		"expiresAt": inv.ExpiresAt,
+		"createdAt": inv.CreatedAt,
+	}
+}
+
+func (s *Server) listInvites(c *gin.Context) {
+	if _, ok := s.authorize(c, permMembers); !ok {
+		return
+	}
+	org := c.MustGet("organization").(*store.Organization)
+
+	invites, err := s.store.ListInvites(c.Request.Context(), org.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invites"})
+		return
+	}
+
+	out := make([]gin.H, 0, len(invites))
+	for _, inv := range invites {
+		out = append(out, inviteJSON(inv))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invites": out})
+}
+
+// createInvite emails someone a link to join the organization, whether or
+// not they have an account yet.
+func (s *Server) createInvite(c *gin.Context) {
+	if _, ok := s.authorize(c, permMembers); !ok {
+		return
+	}
+	org := c.MustGet("organization").(*store.Organization)
+	ctx := c.Request.Context()
+
+	var data struct {
+		Email string `json:"email" binding:"required"`
+		Role  string `json:"role"` // defaults to developer
+	}
+	if err := c.ShouldBindJSON(&data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	data.Email = store.NormalizeEmail(data.Email)
+	c.Set(auditResourceKey, "orgs/"+org.Name+"/invites/"+data.Email)
+	if data.Role == "" {
+		data.Role = store.RoleDeveloper
+	}
+	if !store.ValidRole(data.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role must be owner, admin, developer or viewer"})
+		return
+	}
+	if !canAssign(memberOf(c).Role, data.Role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can't give out a role above your own"})
+		return
+	}
+
+	user, err := s.store.GetUserByEmail(ctx, data.Email)
+	if err == nil {
+		_, err = s.store.GetMember(ctx, org.ID, user.ID)
+		if err == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "User is already a member"})
+			return
+		}
+	}
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invite"})
+		return
+	}
+
+	inv := &store.Invite{
+		OrgID:     org.ID,
+		Email:     data.Email,
+		Role:      data.Role,
+		InvitedBy: memberOf(c).UserID,
+		CreatedAt: time.Now(),
+	}
+	err = s.issueInvite(c, inv)
+	if errors.Is(err, store.ErrDuplicate) {
+		c.JSON(http.StatusConflict, gin.H{"error": "That email already has an invite, resend it instead"})
+		return
+	}
+	if err != nil {
+		// Don't leave an invite behind that nobody received
+		if inv.ID != "" {
+			s.store.DeleteInvite(ctx, inv.ID)
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send invite"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"invite": inviteJSON(inv)})
+}
+
+// findInvite loads the organization's invite named by :invite, writing a
+// response and returning false if there is none or it is for a role above
+// the caller's.
+func (s *Server) findInvite(c *gin.Context) (*store.Invite, bool) {
+	org := c.MustGet("organization").(*store.Organization)
+
+	inv, err := s.store.GetInvite(c.Request.Context(), c.Param("invite"))
+	if err == nil && inv.OrgID != org.ID {
+		err = store.ErrNotFound
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invite not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invite"})
+		return nil, false
+	}
+	c.Set(auditResourceKey, "orgs/"+org.Name+"/invites/"+inv.Email)
+
+	if !canAssign(memberOf(c).Role, inv.Role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can't manage an invite above your own role"})
+		return nil, false
+	}
+	return inv, true
+}
+
+// resendInvite mails a new link and restarts the invite's expiry. Links
+// sent before stop working.
+func (s *Server) resendInvite(c *gin.Context) {
+	if _, ok := s.authorize(c, permMembers); !ok {
+		return
+	}
+	inv, ok := s.findInvite(c)
+	if !ok {
+		return
+	}
+
+	if err := s.issueInvite(c, inv); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send invite"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invite": inviteJSON(inv)})
+}
+
+func (s *Server) revokeInvite(c *gin.Context) {
+	if _, ok := s.authorize(c, permMembers); !ok {
+		return
+	}
+	inv, ok := s.findInvite(c)
+	if !ok {
+		return
+	}
+
+	if err := s.store.DeleteInvite(c.Request.Context(), inv.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke invite"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invite revoked successfully"})
+}
+
+// openInvite checks an invite link's token and loads the invite and its
+// organization, writing a 400 if the link is no good.
+func (s *Server) openInvite(c *gin.Context, raw string) (*store.Invite, *store.Organization, bool) {
+	ctx := c.Request.Context()
+
+	token, err := jwt.Parse(raw, func(token *jwt.Token) (interface{}, error) {
+		return s.jwtSecret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	var inv *store.Invite
+	if err == nil && token.Valid {
+		if claims, _ := token.Claims.(jwt.MapClaims); claims["typ"] == "invite" {
+			inv, err = s.store.GetInviteByToken(ctx, hashToken(raw))
+		}
+	}
+	if inv == nil || err != nil || time.Now().After(inv.ExpiresAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired invite"})
+		return nil, nil, false
+	}
+
+	org, err := s.store.GetOrganizationByID(ctx, inv.OrgID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept invite"})
+		return nil, nil, false
+	}
+	c.Set(auditOwnerKey, org.ID)
+	c.Set(auditResourceKey, "orgs/"+org.Name+"/members/"+inv.Email)
+	return inv, org, true
+}
+
+// joinOrg makes the user a member with the invite's role.
+func (s *Server) joinOrg(c *gin.Context, inv *store.Invite, org *store.Organization, user *store.User) {
+	ctx := c.Request.Context()
+
+	err := s.store.AddMember(ctx, &store.Member{OrgID: org.ID, UserID: user.ID, Role: inv.Role, CreatedAt: time.Now()})
+	if err != nil && !errors.Is(err, store.ErrDuplicate) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept invite"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "You joined " + org.Name,
+		"organization": orgJSON(org, inv.Role),
+	})
+}
+
+// acceptInvite creates an account for someone invited by email and adds
+// it to the organization. Emails aren't verified, so an existing account
+// with the address doesn't prove anything; its owner has to log in and
+// use joinInvite instead.
+func (s *Server) acceptInvite(c *gin.Context) {
+	var data struct {
+		Token    string `json:"token" binding:"required"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx := c.Request.Context()
+
+	inv, org, ok := s.openInvite(c, data.Token)
+	if !ok {
+		return
+	}
+
+	_, err := s.store.GetUserByEmail(ctx, inv.Email)
+	if err == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Log in as " + inv.Email + " to accept this invite", "login": true})
+		return
+	}
+	if !errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept invite"})
+		return
+	}
+	if data.Username == "" || data.Password == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Choose a username and password to create your account", "register": true})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(data.Password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register"})
+		return
+	}
+
+	// Use up the invite first so the same link can't be accepted twice
+	consumed, err := s.store.ConsumeInvite(ctx, inv.ID, inv.TokenHash)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired invite"})
+		return
+	}
+
+	user := &store.User{Username: data.Username, Email: store.NormalizeEmail(inv.Email), PasswordHash: string(hashedPassword), CreatedAt: time.Now()}
+	if err := s.store.CreateUser(ctx, user); err != nil {
+		// Put the invite back so the link still works, e.g. after logging in
+		// to the account that was created meanwhile
+		restoreErr := s.store.CreateInvite(ctx, consumed)
+		switch {
+		case restoreErr == nil && errors.Is(err, store.ErrDuplicate):
+			c.JSON(http.StatusConflict, gin.H{"error": "An account with this email was just created, log in to accept the invite"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register"})
+		}
+		return
+	}
+	c.Set(auditActorKey, user.ID)
+
+	s.joinOrg(c, inv, org, user)
+}
+
+// joinInvite adds the logged-in user to the organization, if the invite
+// was sent to their email.
+func (s *Server) joinInvite(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var data struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx := c.Request.Context()
+
+	inv, org, ok := s.openInvite(c, data.Token)
+	if !ok {
+		return
+	}
+
+	user, err := s.store.GetUserByEmail(ctx, inv.Email)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept invite"})
+		return
+	}
+	if user == nil || user.ID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This invite is for " + inv.Email + ", log in as that account to accept it"})
+		return
+	}
+
+	if _, err := s.store.ConsumeInvite(ctx, inv.ID, inv.TokenHash); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired invite"})
+		return
+	}
+
+	s.joinOrg(c, inv, org, user)
+}
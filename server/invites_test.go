@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/David-mwas/SafeEnv/store"
+	"github.com/gin-gonic/gin"
+)
+
+// newInvite stores an invite to the organization without mailing it and
+// returns the link's token.
+func newInvite(t *testing.T, ts *testServer, org, email, role string) string {
+	t.Helper()
+	ctx := context.Background()
+	o, err := ts.store.GetOrganization(ctx, org)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiresAt := time.Now().Add(inviteTTL)
+	token, err := ts.srv.signInviteToken(expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inv := &store.Invite{OrgID: o.ID, Email: email, Role: role, TokenHash: hashToken(token), ExpiresAt: expiresAt, CreatedAt: time.Now()}
+	if err := ts.store.CreateInvite(ctx, inv); err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// failingUsers fails every CreateUser with err.
+type failingUsers struct {
+	store.Store
+	err error
+}
+
+func (s *failingUsers) CreateUser(ctx context.Context, u *store.User) error {
+	return s.err
+}
+
+func TestAcceptInvite(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	ownerToken := ts.register(t, "owner@example.com", "hunter22")
+	if status, out := ts.call(t, "POST", "/orgs", ownerToken, gin.H{"name": "acme"}); status >= 300 {
+		t.Fatalf("create org: %d %v", status, out)
+	}
+	token := newInvite(t, ts, "acme", "new@example.com", "developer")
+	accept := gin.H{"token": token, "username": "new", "password": "hunter22"}
+
+	if status, out := ts.call(t, "POST", "/invites/accept", "", gin.H{"token": token}); status != http.StatusBadRequest || out["register"] != true {
+		t.Fatalf("accept without an account: %d %v", status, out)
+	}
+
+	// A failed signup leaves the link working
+	for _, tt := range []struct {
+		err    error
+		status int
+	}{
+		{errors.New("connection reset"), http.StatusInternalServerError},
+		{store.ErrDuplicate, http.StatusConflict},
+	} {
+		failing := serve(t, &failingUsers{Store: ts.store, err: tt.err}, Config{})
+		if status, out := failing.call(t, "POST", "/invites/accept", "", accept); status != tt.status {
+			t.Fatalf("accept with CreateUser failing with %v: %d %v", tt.err, status, out)
+		}
+		if _, err := ts.store.GetInviteByToken(context.Background(), hashToken(token)); err != nil {
+			t.Fatalf("invite gone after CreateUser failed with %v: %v", tt.err, err)
+		}
+	}
+
+	status, out := ts.call(t, "POST", "/invites/accept", "", accept)
+	if status != http.StatusOK || out["organization"].(map[string]any)["role"] != "developer" {
+		t.Fatalf("accept: %d %v", status, out)
+	}
+	memberToken, _ := login(t, ts, "new@example.com", "hunter22")
+	if status, out := ts.call(t, "GET", "/orgs/acme/members", memberToken, nil); status != http.StatusOK {
+		t.Fatalf("member listing members: %d %v", status, out)
+	}
+
+	// The link only works once
+	if status, out := ts.call(t, "POST", "/invites/accept", "", gin.H{"token": token, "username": "other", "password": "hunter22"}); status != http.StatusBadRequest {
+		t.Fatalf("accept twice: %d %v", status, out)
+	}
+}
@@ -0,0 +1,415 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/David-mwas/SafeEnv/encryption"
+	"github.com/David-mwas/SafeEnv/store"
+	"github.com/David-mwas/SafeEnv/totp"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	mfaIssuer = "SafeEnv"
+
+	// mfaTokenTTL is how long a user has to enter their code after the password
+	mfaTokenTTL = 5 * time.Minute
+
+	// wrong codes before the user is locked out of 2FA for mfaLockout
+	maxMFAFailures = 5
+	mfaLockout     = 15 * time.Minute
+
+	recoveryCodeCount = 10
+)
+
+// mfaKey is set by authMiddleware to whether the caller's session, or the
+// session their API token was created in, was opened with a second factor.
+const mfaKey = "mfa"
+
+var recoveryEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// signMFAToken issues the token that stands in for a session between the
+// password and the code. It has no sid, so authMiddleware won't take it.
+func (s *Server) signMFAToken(userID string) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": userID,
+		"mfa": "pending",
+		"exp": time.Now().Add(mfaTokenTTL).Unix(),
+	})
+	return token.SignedString(s.jwtSecret)
+}
+
+// pendingMFAUser loads the user an mfa token was issued to, writing a 401
+// if the token isn't valid.
+func (s *Server) pendingMFAUser(c *gin.Context, raw string) (*store.User, bool) {
+	token, err := jwt.Parse(raw, func(token *jwt.Token) (interface{}, error) {
+		return s.jwtSecret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || !token.Valid {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired login, please log in again"})
+		return nil, false
+	}
+
+	claims, _ := token.Claims.(jwt.MapClaims)
+	userID, _ := claims["sub"].(string)
+	if claims["mfa"] != "pending" || userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired login, please log in again"})
+		return nil, false
+	}
+
+	user, err := s.store.GetUserByID(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired login, please log in again"})
+		return nil, false
+	}
+	c.Set(auditActorKey, user.ID)
+	return user, true
+}
+
+// needsMFA reports whether a login must be finished with a code.
+func (s *Server) needsMFA(user *store.User) bool {
+	return user.MFA.Enabled || s.requireMFA
+}
+
+func totpAD(userID string) []byte {
+	return []byte("safeenv-totp:" + userID)
+}
+
+// sealTOTPSecret encrypts a TOTP secret with the user's data key.
+func (s *Server) sealTOTPSecret(c *gin.Context, userID, secret string) (string, error) {
+	dek, err := s.userDataKey(c.Request.Context(), userID)
+	if err != nil {
+		return "", err
+	}
+	return encryption.EncryptWithDataKey(dek, []byte(secret), totpAD(userID))
+}
+
+func (s *Server) openTOTPSecret(c *gin.Context, user *store.User) (string, error) {
+	dek, err := s.userDataKey(c.Request.Context(), user.ID)
+	if err != nil {
+		return "", err
+	}
+	secret, err := encryption.DecryptWithDataKey(dek, user.MFA.TOTPSecret, totpAD(user.ID))
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+// newRecoveryCodes returns fresh recovery codes and the hashes to store.
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	for range recoveryCodeCount {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := recoveryEncoding.EncodeToString(b)[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode ignores case, dashes and spaces so codes can be typed loosely.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	return hashToken(code)
+}
+
+// verifyMFA checks a TOTP code, or a recovery code once 2FA is enabled,
+// writing the error response if it fails. Attempts are counted before the
+// check so parallel guesses can't get past the lockout. It returns the
+// TOTP step used, or 0 for a recovery code.
+func (s *Server) verifyMFA(c *gin.Context, user *store.User, code, recoveryCode string) (int64, bool) {
+	ctx := c.Request.Context()
+	now := time.Now()
+
+	if user.MFA.TOTPSecret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not set up"})
+		return 0, false
+	}
+	if code == "" && recoveryCode == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A code or recovery code is required"})
+		return 0, false
+	}
+
+	err := s.store.ClaimMFAAttempt(ctx, user.ID, now, maxMFAFailures, mfaLockout)
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many wrong codes, try again later"})
+		return 0, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+		return 0, false
+	}
+
+	var step int64
+	if recoveryCode != "" && user.MFA.Enabled {
+		err = s.store.ConsumeMFA(ctx, user.ID, 0, hashRecoveryCode(recoveryCode))
+	} else {
+		secret, openErr := s.openTOTPSecret(c, user)
+		if openErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+			return 0, false
+		}
+		var ok bool
+		if step, ok = totp.Validate(secret, strings.TrimSpace(code), now, 1); !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+			return 0, false
+		}
+		err = s.store.ConsumeMFA(ctx, user.ID, step, "")
+	}
+
+	// ErrNotFound here is a replayed step or an unknown recovery code
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		return 0, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+		return 0, false
+	}
+	return step, true
+}
+
+// startTOTPEnrollment stores a new, not yet enabled secret and returns it
+// with the otpauth:// URI for a QR code.
+func (s *Server) startTOTPEnrollment(c *gin.Context, user *store.User) {
+	if user.MFA.Enabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+		return
+	}
+	sealed, err := s.sealTOTPSecret(c, user.ID, secret)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store secret"})
+		return
+	}
+	if err := s.store.SetUserMFA(c.Request.Context(), user.ID, &store.UserMFA{TOTPSecret: sealed}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store secret"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret": secret,
+		"uri":    totp.URI(mfaIssuer, user.Email, secret),
+	})
+}
+
+// enableTOTP turns 2FA on after the first good code and returns the
+// recovery codes, the only time they're shown.
+func (s *Server) enableTOTP(c *gin.Context, user *store.User, step int64) ([]string, bool) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+		return nil, false
+	}
+
+	err = s.store.SetUserMFA(c.Request.Context(), user.ID, &store.UserMFA{
+		TOTPSecret:    user.MFA.TOTPSecret,
+		Enabled:       true,
+		LastStep:      step,
+		RecoveryCodes: hashes,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable two-factor authentication"})
+		return nil, false
+	}
+	return codes, true
+}
+
+// loginMFA finishes a login with a TOTP or recovery code. When 2FA is
+// required and the user just enrolled, the first code also enables it.
+func (s *Server) loginMFA(c *gin.Context) {
+	var req struct {
+		MFAToken     string `json:"mfaToken" binding:"required"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recoveryCode"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := s.pendingMFAUser(c, req.MFAToken)
+	if !ok {
+		return
+	}
+	c.Set(auditResourceKey, "users/"+user.Email)
+
+	step, ok := s.verifyMFA(c, user, req.Code, req.RecoveryCode)
+	if !ok {
+		return
+	}
+
+	var recoveryCodes []string
+	if !user.MFA.Enabled {
+		if recoveryCodes, ok = s.enableTOTP(c, user, step); !ok {
+			return
+		}
+	}
+
+	tokens, err := s.startSession(c, user, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+		return
+	}
+	if recoveryCodes != nil {
+		tokens["recoveryCodes"] = recoveryCodes
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// loginEnrollMFA lets a user who has to use 2FA but hasn't set it up yet
+// enroll halfway through logging in.
+func (s *Server) loginEnrollMFA(c *gin.Context) {
+	var req struct {
+		MFAToken string `json:"mfaToken" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := s.pendingMFAUser(c, req.MFAToken)
+	if !ok {
+		return
+	}
+	c.Set(auditResourceKey, "users/"+user.Email)
+
+	s.startTOTPEnrollment(c, user)
+}
+
+// sessionUser loads the logged in user, writing an error if that fails.
+func (s *Server) sessionUser(c *gin.Context) (*store.User, bool) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return nil, false
+	}
+	user, err := s.store.GetUserByID(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+		return nil, false
+	}
+	c.Set(auditResourceKey, "users/"+user.Email)
+	return user, true
+}
+
+func (s *Server) enrollTOTP(c *gin.Context) {
+	user, ok := s.sessionUser(c)
+	if !ok {
+		return
+	}
+	s.startTOTPEnrollment(c, user)
+}
+
+func (s *Server) verifyTOTP(c *gin.Context) {
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := s.sessionUser(c)
+	if !ok {
+		return
+	}
+	if user.MFA.Enabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	step, ok := s.verifyMFA(c, user, req.Code, "")
+	if !ok {
+		return
+	}
+	codes, ok := s.enableTOTP(c, user, step)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Two-factor authentication enabled",
+		"recoveryCodes": codes,
+	})
+}
+
+// regenerateRecoveryCodes replaces every recovery code; it takes a TOTP
+// code so a stolen session alone can't mint new ones.
+func (s *Server) regenerateRecoveryCodes(c *gin.Context) {
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := s.sessionUser(c)
+	if !ok {
+		return
+	}
+	if !user.MFA.Enabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+
+	step, ok := s.verifyMFA(c, user, req.Code, "")
+	if !ok {
+		return
+	}
+	codes, ok := s.enableTOTP(c, user, step)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+}
+
+func (s *Server) disableTOTP(c *gin.Context) {
+	var req struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recoveryCode"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if s.requireMFA {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Two-factor authentication is required on this server"})
+		return
+	}
+
+	user, ok := s.sessionUser(c)
+	if !ok {
+		return
+	}
+	if !user.MFA.Enabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+	if _, ok := s.verifyMFA(c, user, req.Code, req.RecoveryCode); !ok {
+		return
+	}
+
+	if err := s.store.SetUserMFA(c.Request.Context(), user.ID, &store.UserMFA{}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
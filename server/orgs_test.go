@@ -0,0 +1,150 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/David-mwas/SafeEnv/totp"
+	"github.com/gin-gonic/gin"
+)
+
+// loginWithMFA turns on TOTP for an account logged in with token and
+// logs in again with a second factor, returning the new access token.
+func loginWithMFA(t *testing.T, ts *testServer, token, email, password string) string {
+	t.Helper()
+	status, out := ts.call(t, "POST", "/mfa/totp/enroll", token, nil)
+	if status != http.StatusOK {
+		t.Fatalf("enroll: %d %v", status, out)
+	}
+	code, err := totp.Code(out["secret"].(string), totp.Step(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, out = ts.call(t, "POST", "/mfa/totp/verify", token, gin.H{"code": code})
+	if status != http.StatusOK {
+		t.Fatalf("verify: %d %v", status, out)
+	}
+	// The TOTP code was just used, so finish the login with a recovery code
+	recoveryCode := out["recoveryCodes"].([]any)[0].(string)
+
+	status, out = ts.call(t, "POST", "/login", "", gin.H{"email": email, "password": password})
+	if status != http.StatusOK || out["mfaRequired"] != true {
+		t.Fatalf("login: %d %v", status, out)
+	}
+	status, out = ts.call(t, "POST", "/login/mfa", "", gin.H{"mfaToken": out["mfaToken"], "recoveryCode": recoveryCode})
+	if status != http.StatusOK || out["token"] == nil {
+		t.Fatalf("login mfa: %d %v", status, out)
+	}
+	return out["token"].(string)
+}
+
+func TestOrgRequireMFA(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	ownerToken := ts.register(t, "owner@example.com", "hunter22")
+	devToken := ts.register(t, "dev@example.com", "hunter22")
+
+	steps := []struct {
+		path string
+		body gin.H
+	}{
+		{"/orgs", gin.H{"name": "acme"}},
+		{"/orgs/acme/members", gin.H{"email": "dev@example.com", "role": "developer"}},
+		{"/orgs/acme/projects", gin.H{"name": "web"}},
+		{"/orgs/acme/projects/web/envs", gin.H{"name": "prod"}},
+	}
+	for _, step := range steps {
+		if status, out := ts.call(t, "POST", step.path, ownerToken, step.body); status >= 300 {
+			t.Fatalf("POST %s: %d %v", step.path, status, out)
+		}
+	}
+	const retrieve = "/orgs/acme/projects/web/envs/prod/retrieve"
+
+	// Turning it on needs a second factor, so the owner can't lock themselves out
+	if status, out := ts.call(t, "PUT", "/orgs/acme", ownerToken, gin.H{"requireMFA": true}); status != http.StatusBadRequest {
+		t.Fatalf("require without mfa: %d %v", status, out)
+	}
+	ownerToken = loginWithMFA(t, ts, ownerToken, "owner@example.com", "hunter22")
+	if status, out := ts.call(t, "PUT", "/orgs/acme", devToken, gin.H{"requireMFA": true}); status != http.StatusForbidden {
+		t.Fatalf("developer changing settings: %d %v", status, out)
+	}
+	status, out := ts.call(t, "PUT", "/orgs/acme", ownerToken, gin.H{"requireMFA": true})
+	if status != http.StatusOK || out["organization"].(map[string]any)["requireMFA"] != true {
+		t.Fatalf("require mfa: %d %v", status, out)
+	}
+
+	if status, out := ts.call(t, "GET", retrieve, ownerToken, nil); status != http.StatusOK {
+		t.Fatalf("owner with mfa: %d %v", status, out)
+	}
+	if status, out := ts.call(t, "GET", retrieve, devToken, nil); status != http.StatusForbidden {
+		t.Fatalf("member without mfa: %d %v", status, out)
+	}
+	if status, out := ts.call(t, "GET", "/orgs/acme/members", devToken, nil); status != http.StatusForbidden {
+		t.Fatalf("member without mfa listing members: %d %v", status, out)
+	}
+
+	// API tokens carry over whether the session that made them used 2FA
+	status, out = ts.call(t, "POST", "/tokens", devToken, gin.H{"name": "ci"})
+	if status != http.StatusCreated {
+		t.Fatalf("create token: %d %v", status, out)
+	}
+	if status, out := ts.call(t, "GET", retrieve, out["token"].(string), nil); status != http.StatusForbidden {
+		t.Fatalf("token without mfa: %d %v", status, out)
+	}
+
+	devToken = loginWithMFA(t, ts, devToken, "dev@example.com", "hunter22")
+	if status, out := ts.call(t, "GET", retrieve, devToken, nil); status != http.StatusOK {
+		t.Fatalf("member with mfa: %d %v", status, out)
+	}
+	status, out = ts.call(t, "POST", "/tokens", devToken, gin.H{"name": "ci"})
+	if status != http.StatusCreated {
+		t.Fatalf("create token: %d %v", status, out)
+	}
+	if status, out := ts.call(t, "GET", retrieve, out["token"].(string), nil); status != http.StatusOK {
+		t.Fatalf("token with mfa: %d %v", status, out)
+	}
+
+	// Personal variables aren't affected
+	if status, out := ts.call(t, "GET", "/retrieve", ts.register(t, "solo@example.com", "hunter22"), nil); status != http.StatusOK {
+		t.Fatalf("personal variables: %d %v", status, out)
+	}
+}
+
+func TestRemoveTeamMemberAboveOwnRole(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	ownerToken := ts.register(t, "owner@example.com", "hunter22")
+	adminToken := ts.register(t, "admin@example.com", "hunter22")
+	ids := map[string]string{}
+	for _, email := range []string{"owner2@example.com", "dev@example.com"} {
+		_, me := ts.call(t, "GET", "/user", ts.register(t, email, "hunter22"), nil)
+		ids[email] = me["id"].(string)
+	}
+
+	steps := []struct {
+		path string
+		body gin.H
+	}{
+		{"/orgs", gin.H{"name": "acme"}},
+		{"/orgs/acme/members", gin.H{"email": "admin@example.com", "role": "admin"}},
+		{"/orgs/acme/members", gin.H{"email": "owner2@example.com", "role": "owner"}},
+		{"/orgs/acme/members", gin.H{"email": "dev@example.com", "role": "developer"}},
+		{"/orgs/acme/teams", gin.H{"name": "ops"}},
+		{"/orgs/acme/teams/ops/members", gin.H{"email": "owner2@example.com"}},
+		{"/orgs/acme/teams/ops/members", gin.H{"email": "dev@example.com"}},
+	}
+	for _, step := range steps {
+		if status, out := ts.call(t, "POST", step.path, ownerToken, step.body); status >= 300 {
+			t.Fatalf("POST %s: %d %v", step.path, status, out)
+		}
+	}
+
+	if status, out := ts.call(t, "DELETE", "/orgs/acme/teams/ops/members/"+ids["owner2@example.com"], adminToken, nil); status != http.StatusForbidden {
+		t.Fatalf("admin removing an owner from a team: %d %v", status, out)
+	}
+	if status, out := ts.call(t, "DELETE", "/orgs/acme/teams/ops/members/"+ids["dev@example.com"], adminToken, nil); status != http.StatusOK {
+		t.Fatalf("admin removing a developer from a team: %d %v", status, out)
+	}
+	if status, out := ts.call(t, "DELETE", "/orgs/acme/teams/ops/members/"+ids["owner2@example.com"], ownerToken, nil); status != http.StatusOK {
+		t.Fatalf("owner removing an owner from a team: %d %v", status, out)
+	}
+}
@@ -0,0 +1,369 @@
+package server
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/David-mwas/SafeEnv/store"
+	"github.com/David-mwas/SafeEnv/webauthn"
+	"github.com/gin-gonic/gin"
+)
+
+// webauthnTimeout is how long a browser has to finish a ceremony
+const webauthnTimeout = 5 * time.Minute
+
+// relyingParty works out which site passkeys are bound to, defaulting to
+// the frontend's host.
+func relyingParty(cfg Config) webauthn.RelyingParty {
+	rp := webauthn.RelyingParty{ID: cfg.WebAuthnRPID, Name: "SafeEnv", Origins: cfg.WebAuthnOrigins}
+	if u, err := url.Parse(cfg.FrontendURL); err == nil && u.Host != "" {
+		if rp.ID == "" {
+			rp.ID = u.Hostname()
+		}
+		if len(rp.Origins) == 0 {
+			rp.Origins = []string{u.Scheme + "://" + u.Host}
+		}
+	}
+	return rp
+}
+
+// passkeyCredential is a PublicKeyCredential as serialized by toJSON().
+type passkeyCredential struct {
+	ID       string         `json:"id"`
+	RawID    webauthn.Bytes `json:"rawId"`
+	Response struct {
+		ClientDataJSON    webauthn.Bytes `json:"clientDataJSON"`
+		AttestationObject webauthn.Bytes `json:"attestationObject"`
+		AuthenticatorData webauthn.Bytes `json:"authenticatorData"`
+		Signature         webauthn.Bytes `json:"signature"`
+		UserHandle        webauthn.Bytes `json:"userHandle"`
+		Transports        []string       `json:"transports"`
+	} `json:"response"`
+}
+
+// credentialID is the id passkeys are stored under.
+func (p *passkeyCredential) credentialID() string {
+	if len(p.RawID) > 0 {
+		return base64.RawURLEncoding.EncodeToString(p.RawID)
+	}
+	return p.ID
+}
+
+func passkeyView(p *store.Passkey) gin.H {
+	return gin.H{
+		"id":         p.ID,
+		"name":       p.Name,
+		"transports": p.Transports,
+		"createdAt":  p.CreatedAt,
+		"lastUsedAt": p.LastUsedAt,
+	}
+}
+
+// credentialDescriptors lists a user's passkeys for allowCredentials and
+// excludeCredentials.
+func credentialDescriptors(user *store.User) []gin.H {
+	out := []gin.H{}
+	for _, p := range user.Passkeys {
+		id, err := base64.RawURLEncoding.DecodeString(p.ID)
+		if err != nil {
+			continue
+		}
+		out = append(out, gin.H{"type": "public-key", "id": webauthn.Bytes(id), "transports": p.Transports})
+	}
+	return out
+}
+
+func (s *Server) passkeysConfigured(c *gin.Context) bool {
+	if s.rp.ID == "" || len(s.rp.Origins) == 0 {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Passkeys are not configured on this server"})
+		return false
+	}
+	return true
+}
+
+// newChallenge starts a ceremony for userID ("" if not yet known).
+func (s *Server) newChallenge(c *gin.Context, userID, purpose string) (*store.WebAuthnChallenge, bool) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate challenge"})
+		return nil, false
+	}
+	ch := &store.WebAuthnChallenge{
+		UserID:    userID,
+		Purpose:   purpose,
+		Challenge: challenge,
+		ExpiresAt: time.Now().Add(webauthnTimeout),
+	}
+	if err := s.store.CreateWebAuthnChallenge(c.Request.Context(), ch); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store challenge"})
+		return nil, false
+	}
+	return ch, true
+}
+
+// takeChallenge uses up a ceremony's challenge, writing a 400 if it's
+// unknown, expired or was issued for something else.
+func (s *Server) takeChallenge(c *gin.Context, id, purpose string) (*store.WebAuthnChallenge, bool) {
+	ch, err := s.store.ConsumeWebAuthnChallenge(c.Request.Context(), id)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch challenge"})
+		return nil, false
+	}
+	if err != nil || ch.Purpose != purpose || !time.Now().Before(ch.ExpiresAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Challenge expired or already used, please try again"})
+		return nil, false
+	}
+	return ch, true
+}
+
+// beginPasskeyRegistration returns the options for navigator.credentials.create().
+func (s *Server) beginPasskeyRegistration(c *gin.Context) {
+	if !s.passkeysConfigured(c) {
+		return
+	}
+	user, ok := s.sessionUser(c)
+	if !ok {
+		return
+	}
+	ch, ok := s.newChallenge(c, user.ID, store.ChallengeRegister)
+	if !ok {
+		return
+	}
+
+	params := []gin.H{}
+	for _, alg := range webauthn.Algorithms {
+		params = append(params, gin.H{"type": "public-key", "alg": alg})
+	}
+	displayName := user.Username
+	if displayName == "" {
+		displayName = user.Email
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"challengeId": ch.ID,
+		"publicKey": gin.H{
+			"challenge": webauthn.Bytes(ch.Challenge),
+			"rp":        gin.H{"id": s.rp.ID, "name": s.rp.Name},
+			"user": gin.H{
+				"id":          webauthn.Bytes(user.ID),
+				"name":        user.Email,
+				"displayName": displayName,
+			},
+			"pubKeyCredParams":   params,
+			"excludeCredentials": credentialDescriptors(user),
+			"authenticatorSelection": gin.H{
+				"residentKey":      "preferred",
+				"userVerification": "preferred",
+			},
+			"attestation": "none",
+			"timeout":     webauthnTimeout.Milliseconds(),
+		},
+	})
+}
+
+func (s *Server) finishPasskeyRegistration(c *gin.Context) {
+	var req struct {
+		ChallengeID string            `json:"challengeId" binding:"required"`
+		Name        string            `json:"name"`
+		Credential  passkeyCredential `json:"credential"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Name) > 64 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name must be at most 64 characters"})
+		return
+	}
+	if !s.passkeysConfigured(c) {
+		return
+	}
+
+	user, ok := s.sessionUser(c)
+	if !ok {
+		return
+	}
+	ch, ok := s.takeChallenge(c, req.ChallengeID, store.ChallengeRegister)
+	if !ok {
+		return
+	}
+	if ch.UserID != user.ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Challenge expired or already used, please try again"})
+		return
+	}
+
+	resp := req.Credential.Response
+	cred, err := s.rp.VerifyRegistration(ch.Challenge, resp.ClientDataJSON, resp.AttestationObject)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid passkey registration: " + err.Error()})
+		return
+	}
+
+	name := req.Name
+	if name == "" {
+		name = "Passkey"
+	}
+	passkey := &store.Passkey{
+		ID:         base64.RawURLEncoding.EncodeToString(cred.ID),
+		Name:       name,
+		PublicKey:  cred.PublicKey,
+		SignCount:  cred.SignCount,
+		AAGUID:     cred.AAGUID,
+		Transports: resp.Transports,
+		CreatedAt:  time.Now(),
+	}
+	c.Set(auditDetailKey, "passkey: "+passkey.Name)
+
+	err = s.store.AddPasskey(c.Request.Context(), user.ID, passkey)
+	if errors.Is(err, store.ErrDuplicate) {
+		c.JSON(http.StatusConflict, gin.H{"error": "This passkey is already registered"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save passkey"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, passkeyView(passkey))
+}
+
+func (s *Server) listPasskeys(c *gin.Context) {
+	user, ok := s.sessionUser(c)
+	if !ok {
+		return
+	}
+	out := []gin.H{}
+	for i := range user.Passkeys {
+		out = append(out, passkeyView(&user.Passkeys[i]))
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+func (s *Server) deletePasskey(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	err := s.store.DeletePasskey(c.Request.Context(), userID, c.Param("id"))
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Passkey not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete passkey"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Passkey deleted"})
+}
+
+// beginPasskeyLogin returns the options for navigator.credentials.get().
+// Without an email the browser offers any passkey it has for the site.
+func (s *Server) beginPasskeyLogin(c *gin.Context) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	// the body is optional
+	_ = c.ShouldBindJSON(&req)
+
+	if !s.passkeysConfigured(c) {
+		return
+	}
+
+	// Unknown emails get an empty list too, so this doesn't reveal who has an account
+	var userID string
+	allow := []gin.H{}
+	if req.Email != "" {
+		if user, err := s.store.GetUserByEmail(c.Request.Context(), store.NormalizeEmail(req.Email)); err == nil {
+			userID = user.ID
+			allow = credentialDescriptors(user)
+		}
+	}
+
+	ch, ok := s.newChallenge(c, userID, store.ChallengeLogin)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"challengeId": ch.ID,
+		"publicKey": gin.H{
+			"challenge":        webauthn.Bytes(ch.Challenge),
+			"rpId":             s.rp.ID,
+			"allowCredentials": allow,
+			"userVerification": "preferred",
+			"timeout":          webauthnTimeout.Milliseconds(),
+		},
+	})
+}
+
+// finishPasskeyLogin logs in with a passkey. A passkey that verified the
+// user (PIN, biometrics) counts as two factors; otherwise users with 2FA
+// still have to enter a code.
+func (s *Server) finishPasskeyLogin(c *gin.Context) {
+	var req struct {
+		ChallengeID string            `json:"challengeId" binding:"required"`
+		Credential  passkeyCredential `json:"credential"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !s.passkeysConfigured(c) {
+		return
+	}
+	ctx := c.Request.Context()
+
+	ch, ok := s.takeChallenge(c, req.ChallengeID, store.ChallengeLogin)
+	if !ok {
+		return
+	}
+
+	credID := req.Credential.credentialID()
+	user, err := s.store.GetUserByPasskey(ctx, credID)
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unknown passkey"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+		return
+	}
+	c.Set(auditActorKey, user.ID)
+	c.Set(auditResourceKey, "users/"+user.Email)
+
+	resp := req.Credential.Response
+	if (ch.UserID != "" && ch.UserID != user.ID) || (len(resp.UserHandle) > 0 && string(resp.UserHandle) != user.ID) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid passkey"})
+		return
+	}
+
+	passkey := user.Passkey(credID)
+	c.Set(auditDetailKey, "passkey: "+passkey.Name)
+	assertion, err := s.rp.VerifyAssertion(passkey.PublicKey, passkey.SignCount,
+		ch.Challenge, resp.ClientDataJSON, resp.AuthenticatorData, resp.Signature)
+	if errors.Is(err, webauthn.ErrSignCount) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Passkey sign count went backwards, it may have been cloned"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid passkey"})
+		return
+	}
+
+	// Fails if another login with this passkey got in first
+	err = s.store.UpdatePasskeySignCount(ctx, user.ID, credID, passkey.SignCount, assertion.SignCount, time.Now())
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Passkey sign count went backwards, it may have been cloned"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update passkey"})
+		return
+	}
+
+	s.finishLogin(c, user, assertion.UserVerified)
+}
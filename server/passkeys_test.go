@@ -0,0 +1,250 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// softAuthenticator is a software passkey holding one ES256 credential.
+type softAuthenticator struct {
+	rpID      string
+	origin    string
+	id        []byte
+	key       *ecdsa.PrivateKey
+	signCount uint32
+}
+
+func newSoftAuthenticator(t *testing.T, ts *testServer) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		t.Fatal(err)
+	}
+	return &softAuthenticator{rpID: ts.srv.rp.ID, origin: ts.srv.rp.Origins[0], id: id, key: key}
+}
+
+func (a *softAuthenticator) clientData(t *testing.T, typ string, options map[string]any) []byte {
+	t.Helper()
+	challenge, _ := options["challenge"].(string)
+	data, err := json.Marshal(map[string]string{"type": typ, "challenge": challenge, "origin": a.origin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// authData builds authenticator data, with the credential when attested.
+func (a *softAuthenticator) authData(attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	flags := byte(0x01 | 0x04) // user present, user verified
+	if attested {
+		flags |= 0x40
+	}
+	out := append(rpIDHash[:], flags)
+	out = binary.BigEndian.AppendUint32(out, a.signCount)
+	if attested {
+		out = append(out, make([]byte, 16)...) // AAGUID
+		out = binary.BigEndian.AppendUint16(out, uint16(len(a.id)))
+		out = append(out, a.id...)
+		out = append(out, cborMap(
+			cborInt(1), cborInt(2), // kty: EC2
+			cborInt(3), cborInt(-7), // alg: ES256
+			cborInt(-1), cborInt(1), // crv: P-256
+			cborInt(-2), cborBytes(a.key.X.FillBytes(make([]byte, 32))),
+			cborInt(-3), cborBytes(a.key.Y.FillBytes(make([]byte, 32))),
+		)...)
+	}
+	return out
+}
+
+// create answers navigator.credentials.create() options.
+func (a *softAuthenticator) create(t *testing.T, options map[string]any) gin.H {
+	attestation := cborMap(
+		cborText("fmt"), cborText("none"),
+		cborText("attStmt"), cborMap(),
+		cborText("authData"), cborBytes(a.authData(true)),
+	)
+	return gin.H{
+		"id":    b64url(a.id),
+		"rawId": b64url(a.id),
+		"type":  "public-key",
+		"response": gin.H{
+			"clientDataJSON":    b64url(a.clientData(t, "webauthn.create", options)),
+			"attestationObject": b64url(attestation),
+		},
+	}
+}
+
+// get answers navigator.credentials.get() options, counting the signature.
+func (a *softAuthenticator) get(t *testing.T, options map[string]any) gin.H {
+	a.signCount++
+	clientData := a.clientData(t, "webauthn.get", options)
+	authData := a.authData(false)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gin.H{
+		"id":    b64url(a.id),
+		"rawId": b64url(a.id),
+		"type":  "public-key",
+		"response": gin.H{
+			"clientDataJSON":    b64url(clientData),
+			"authenticatorData": b64url(authData),
+			"signature":         b64url(sig),
+		},
+	}
+}
+
+func b64url(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Just enough CBOR encoding for attestation objects and COSE keys
+
+func cborHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n <= 0xff:
+		return []byte{major<<5 | 24, byte(n)}
+	default:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+	}
+}
+
+func cborInt(n int) []byte {
+	if n < 0 {
+		return cborHead(1, uint64(-1-n))
+	}
+	return cborHead(0, uint64(n))
+}
+
+func cborBytes(b []byte) []byte { return append(cborHead(2, uint64(len(b))), b...) }
+func cborText(s string) []byte  { return append(cborHead(3, uint64(len(s))), s...) }
+
+func cborMap(kv ...[]byte) []byte {
+	return append(cborHead(5, uint64(len(kv)/2)), bytes.Join(kv, nil)...)
+}
+
+// registerPasskey adds a's credential to the account logged in with token.
+func registerPasskey(t *testing.T, ts *testServer, token string, a *softAuthenticator) {
+	t.Helper()
+	status, begin := ts.call(t, "POST", "/passkeys/register/begin", token, nil)
+	if status != http.StatusOK {
+		t.Fatalf("register begin: %d %v", status, begin)
+	}
+	options := begin["publicKey"].(map[string]any)
+	if rp := options["rp"].(map[string]any); rp["id"] != a.rpID {
+		t.Fatalf("rp id %v, want %s", rp["id"], a.rpID)
+	}
+
+	status, out := ts.call(t, "POST", "/passkeys/register/finish", token, gin.H{
+		"challengeId": begin["challengeId"],
+		"name":        "soft key",
+		"credential":  a.create(t, options),
+	})
+	if status != http.StatusCreated {
+		t.Fatalf("register finish: %d %v", status, out)
+	}
+}
+
+// passkeyLogin runs a login ceremony with a and returns the response.
+func passkeyLogin(t *testing.T, ts *testServer, email string, a *softAuthenticator) (int, map[string]any) {
+	t.Helper()
+	status, begin := ts.call(t, "POST", "/login/passkey/begin", "", gin.H{"email": email})
+	if status != http.StatusOK {
+		t.Fatalf("login begin: %d %v", status, begin)
+	}
+	options := begin["publicKey"].(map[string]any)
+	return ts.call(t, "POST", "/login/passkey/finish", "", gin.H{
+		"challengeId": begin["challengeId"],
+		"credential":  a.get(t, options),
+	})
+}
+
+func TestPasskeyRegistrationAndLogin(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	token := ts.register(t, "alice@example.com", "hunter22")
+	a := newSoftAuthenticator(t, ts)
+	registerPasskey(t, ts, token, a)
+
+	var status int
+	var out map[string]any
+	for range 2 {
+		status, out = passkeyLogin(t, ts, "alice@example.com", a)
+		if status != http.StatusOK || out["token"] == nil {
+			t.Fatalf("login: %d %v", status, out)
+		}
+	}
+	if _, me := ts.call(t, "GET", "/user", out["token"].(string), nil); me["email"] != "alice@example.com" {
+		t.Fatalf("logged in as %v", me)
+	}
+
+	// The same credential can't be registered twice
+	status, begin := ts.call(t, "POST", "/passkeys/register/begin", token, nil)
+	if status != http.StatusOK {
+		t.Fatalf("register begin: %d %v", status, begin)
+	}
+	status, out = ts.call(t, "POST", "/passkeys/register/finish", token, gin.H{
+		"challengeId": begin["challengeId"],
+		"credential":  a.create(t, begin["publicKey"].(map[string]any)),
+	})
+	if status != http.StatusConflict {
+		t.Fatalf("second registration: %d %v", status, out)
+	}
+}
+
+func TestPasskeyLoginChecksSignature(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	token := ts.register(t, "alice@example.com", "hunter22")
+	a := newSoftAuthenticator(t, ts)
+	registerPasskey(t, ts, token, a)
+
+	// Another key claiming the same credential id
+	impostor := newSoftAuthenticator(t, ts)
+	impostor.id = a.id
+	if status, out := passkeyLogin(t, ts, "alice@example.com", impostor); status != http.StatusUnauthorized {
+		t.Fatalf("wrong key: %d %v", status, out)
+	}
+}
+
+func TestPasskeyRejectsClonedAuthenticator(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	token := ts.register(t, "alice@example.com", "hunter22")
+	a := newSoftAuthenticator(t, ts)
+	registerPasskey(t, ts, token, a)
+
+	clone := *a
+	for range 3 {
+		if status, out := passkeyLogin(t, ts, "alice@example.com", a); status != http.StatusOK {
+			t.Fatalf("login: %d %v", status, out)
+		}
+	}
+
+	// The clone's counter is behind the original's
+	if status, out := passkeyLogin(t, ts, "alice@example.com", &clone); status != http.StatusUnauthorized || !strings.Contains(out["error"].(string), "cloned") {
+		t.Fatalf("cloned authenticator: %d %v", status, out)
+	}
+	// and one that stops counting is refused as well
+	a.signCount--
+	if status, out := passkeyLogin(t, ts, "alice@example.com", a); status != http.StatusUnauthorized || !strings.Contains(out["error"].(string), "cloned") {
+		t.Fatalf("repeated sign count: %d %v", status, out)
+	}
+}
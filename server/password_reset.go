@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"os"
+	"time"
+
+	"github.com/David-mwas/SafeEnv/store"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// sendEmail sends a plain text email through the SMTP server set in the
+// environment.
+func (s *Server) sendEmail(to, subject, body string) error {
+	smtpHost := os.Getenv("SMTP_HOST")
+	smtpPort := os.Getenv("SMTP_PORT")
+	smtpEmail := os.Getenv("SMTP_EMAIL")
+	smtpPassword := os.Getenv("SMTP_PASSWORD")
+
+	if smtpHost == "" || smtpPort == "" || smtpEmail == "" || smtpPassword == "" || s.frontendURL == "" {
+		return fmt.Errorf("SMTP credentials are not set properly")
+	}
+
+	addr := fmt.Sprintf("%s:%s", smtpHost, smtpPort)
+	auth := smtp.PlainAuth("", smtpEmail, smtpPassword, smtpHost)
+
+	msg := []byte("Subject: " + subject + "\r\n\r\n" + body)
+
+	err := smtp.SendMail(addr, auth, smtpEmail, []string{to}, msg)
+	if err != nil {
+		return fmt.Errorf("failed to send email: %v", err)
+	}
+	return nil
+}
+
+// send-reset-email
+func (s *Server) sendResetEmail(to, resetToken string) error {
+	// Generate the reset link with the token
+	resetLink := fmt.Sprintf("%s/reset-password?token=%s", s.frontendURL, resetToken)
+
+	subject := "Password Reset Request"
+	body := fmt.Sprintf(
+		"Hello,\n\nClick the link below to reset your password:\n%s\n\nIf you didn't request this, please ignore it.The link will expire in 1 hour.\n\nThanks,\nSafeEnv",
+		resetLink,
+	)
+
+	return s.sendEmail(to, subject, body)
+}
+
+// newResetToken issues the token in a reset link. Only a hash of its nonce
+// is stored, and resetPassword consumes it, so each link works once.
+func (s *Server) newResetToken(ctx context.Context, email string) (string, error) {
+	nonce, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"typ":   "reset",
+		"jti":   nonce,
+		"email": email,
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	signed, err := token.SignedString(s.jwtSecret)
+	if err != nil {
+		return "", err
+	}
+
+	err = s.store.CreatePasswordReset(ctx, &store.PasswordReset{
+		Email:     email,
+		Token:     hashToken(nonce),
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Hour),
+	})
+	if err != nil {
+		return "", err
+	}
+	return signed, nil
+}
+
+func (s *Server) requestPasswordReset(c *gin.Context) {
+	var request struct {
+		Email string `json:"email"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	// Check if user exists
+	user, err := s.store.GetUserByEmail(c.Request.Context(), store.NormalizeEmail(request.Email))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	tokenString, err := s.newResetToken(c.Request.Context(), user.Email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	// Send Reset Email
+	err = s.sendResetEmail(user.Email, tokenString)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send email" + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset email sent!"})
+}
+
+func (s *Server) resetPassword(c *gin.Context) {
+	var request struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"newPassword"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	// Parse the token
+	token, err := jwt.Parse(request.Token, func(token *jwt.Token) (interface{}, error) {
+		return s.jwtSecret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+
+	if err != nil || !token.Valid {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+		return
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["typ"] != "reset" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+		return
+	}
+
+	email, ok := claims["email"].(string)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email in token"})
+		return
+	}
+
+	// Each link works once, and not after a newer reset finished
+	nonce, _ := claims["jti"].(string)
+	reset, err := s.store.ConsumePasswordReset(c.Request.Context(), hashToken(nonce))
+	if errors.Is(err, store.ErrNotFound) || (err == nil && reset.Email != email) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check token"})
+		return
+	}
+
+	// Hash the new password
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(request.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	// Update the user's password
+	err = s.store.UpdateUserPassword(c.Request.Context(), email, string(hashedPassword))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
+		return
+	}
+
+	// Invalidate any other reset links
+	s.store.DeletePasswordResets(c.Request.Context(), email)
+
+	// Log out everywhere, whoever knew the old password may hold a session
+	if user, err := s.store.GetUserByEmail(c.Request.Context(), email); err == nil {
+		if err := s.store.RevokeSessions(c.Request.Context(), user.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to end existing sessions"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password updated successfully!"})
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/David-mwas/SafeEnv/store"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestPasswordResetTokenWorksOnce(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	ts.register(t, "alice@example.com", "hunter22")
+
+	token, err := ts.srv.newResetToken(context.Background(), "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, out := ts.call(t, "POST", "/reset-password", "", gin.H{"token": token, "newPassword": "correct horse"}); status != http.StatusOK {
+		t.Fatalf("reset: %d %v", status, out)
+	}
+	if status, out := ts.call(t, "POST", "/reset-password", "", gin.H{"token": token, "newPassword": "hunter22"}); status != http.StatusUnauthorized {
+		t.Fatalf("second reset with the same link: %d %v", status, out)
+	}
+	if status, out := ts.call(t, "POST", "/login", "", gin.H{"email": "alice@example.com", "password": "correct horse"}); status != http.StatusOK {
+		t.Fatalf("login with the new password: %d %v", status, out)
+	}
+}
+
+func TestPasswordResetTokenIsChecked(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	ts.register(t, "alice@example.com", "hunter22")
+
+	// A link from before a reset finished stops working with it
+	older, err := ts.srv.newResetToken(context.Background(), "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newer, err := ts.srv.newResetToken(context.Background(), "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, out := ts.call(t, "POST", "/reset-password", "", gin.H{"token": newer, "newPassword": "correct horse"}); status != http.StatusOK {
+		t.Fatalf("reset: %d %v", status, out)
+	}
+
+	// A pending reset with a known nonce, so each case below only fails its own check
+	err = ts.store.CreatePasswordReset(context.Background(), &store.PasswordReset{
+		Email:     "alice@example.com",
+		Token:     hashToken("nonce"),
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+	sign := func(method jwt.SigningMethod, key any, claims jwt.MapClaims) string {
+		s, err := jwt.NewWithClaims(method, claims).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"used by an earlier reset", older},
+		{"without typ", sign(jwt.SigningMethodHS256, ts.srv.jwtSecret, jwt.MapClaims{"jti": "nonce", "email": "alice@example.com", "exp": exp})},
+		{"other typ", sign(jwt.SigningMethodHS256, ts.srv.jwtSecret, jwt.MapClaims{"typ": "invite", "jti": "nonce", "email": "alice@example.com", "exp": exp})},
+		{"unknown nonce", sign(jwt.SigningMethodHS256, ts.srv.jwtSecret, jwt.MapClaims{"typ": "reset", "jti": "forged", "email": "alice@example.com", "exp": exp})},
+		{"HS512", sign(jwt.SigningMethodHS512, ts.srv.jwtSecret, jwt.MapClaims{"typ": "reset", "jti": "nonce", "email": "alice@example.com", "exp": exp})},
+		// last, as it burns the pending reset
+		{"other email", sign(jwt.SigningMethodHS256, ts.srv.jwtSecret, jwt.MapClaims{"typ": "reset", "jti": "nonce", "email": "bob@example.com", "exp": exp})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, out := ts.call(t, "POST", "/reset-password", "", gin.H{"token": tt.token, "newPassword": "hunter22"}); status != http.StatusUnauthorized {
+				t.Fatalf("reset: %d %v", status, out)
+			}
+		})
+	}
+}
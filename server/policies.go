@@ -0,0 +1,413 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/David-mwas/SafeEnv/policy"
+	"github.com/David-mwas/SafeEnv/store"
+	"github.com/gin-gonic/gin"
+)
+
+// Policies narrow what roles allow on an organization's variables. A member
+// bound by no policy keeps everything their role allows; once any policy
+// binds them, they may only do what their role allows and a policy rule
+// grants on the variable's project/env/key path.
+
+// capabilityPermission is the role permission each capability needs.
+var capabilityPermission = map[policy.Capability]permission{
+	policy.Read:   permRead,
+	policy.List:   permRead,
+	policy.Write:  permWrite,
+	policy.Delete: permWrite,
+	policy.Share:  permShare,
+}
+
+// policyPath is the path policies match a key in the request's environment by.
+func policyPath(c *gin.Context, key string) string {
+	project := c.MustGet("project").(*store.Project)
+	env := c.MustGet("environment").(*store.Environment)
+	return project.Name + "/" + env.Name + "/" + key
+}
+
+// variablePath is the path policies match an organization variable by,
+// for when there is no request naming its environment.
+func (s *Server) variablePath(ctx context.Context, v *store.Variable) (string, error) {
+	projects, err := s.store.ListProjects(ctx, v.UserID)
+	if err != nil {
+		return "", err
+	}
+	envs, err := s.store.ListEnvironments(ctx, v.ProjectID)
+	if err != nil {
+		return "", err
+	}
+	var project, env string
+	for _, p := range projects {
+		if p.ID == v.ProjectID {
+			project = p.Name
+		}
+	}
+	for _, e := range envs {
+		if e.ID == v.EnvID {
+			env = e.Name
+		}
+	}
+	if project == "" || env == "" {
+		return "", store.ErrNotFound
+	}
+	return project + "/" + env + "/" + v.Key, nil
+}
+
+// bindPolicies parses the policies among docs that bind the user.
+func (s *Server) bindPolicies(ctx context.Context, orgID, userID string, docs []*store.Policy) (map[string]*policy.Policy, error) {
+	bound := map[string]*policy.Policy{}
+	if len(docs) == 0 {
+		return bound, nil
+	}
+
+	user, err := s.store.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	teams, err := s.store.ListUserTeams(ctx, orgID, userID)
+	if err != nil {
+		return nil, err
+	}
+	teamNames := make([]string, 0, len(teams))
+	for _, t := range teams {
+		teamNames = append(teamNames, t.Name)
+	}
+
+	for _, doc := range docs {
+		p, err := policy.Parse([]byte(doc.Document))
+		if err != nil {
+			return nil, err
+		}
+		if p.Applies(user.Email, teamNames) {
+			bound[doc.Name] = p
+		}
+	}
+	return bound, nil
+}
+
+// boundPolicies returns the policies binding the caller in the request's
+// organization, none outside organizations. They are loaded once per
+// request.
+func (s *Server) boundPolicies(c *gin.Context) (map[string]*policy.Policy, error) {
+	if p, ok := c.Get("policies"); ok {
+		return p.(map[string]*policy.Policy), nil
+	}
+	member := memberOf(c)
+	if member == nil {
+		return nil, nil
+	}
+
+	docs, err := s.store.ListPolicies(c.Request.Context(), member.OrgID)
+	if err != nil {
+		return nil, err
+	}
+	bound, err := s.bindPolicies(c.Request.Context(), member.OrgID, member.UserID, docs)
+	if err != nil {
+		return nil, err
+	}
+	c.Set("policies", bound)
+	return bound, nil
+}
+
+// policyAllows checks the caller's policies allow capability on keys in
+// the request's environment, writing a response and returning false if
+// they don't.
+func (s *Server) policyAllows(c *gin.Context, capability policy.Capability, keys ...string) bool {
+	bound, err := s.boundPolicies(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to evaluate policies"})
+		return false
+	}
+	if len(bound) == 0 {
+		return true
+	}
+
+	for _, key := range keys {
+		name := policyPath(c, key)
+		if d := policy.Evaluate(bound, capability, name); !d.Allowed {
+			c.JSON(http.StatusForbidden, gin.H{"error": policyDenial(d, capability, name)})
+			return false
+		}
+	}
+	return true
+}
+
+func policyDenial(d policy.Decision, capability policy.Capability, name string) string {
+	if d.Policy != "" {
+		return "Policy " + d.Policy + " denies " + name
+	}
+	return "No policy allows " + string(capability) + " on " + name
+}
+
+// visibleKeys drops the variables the caller's policies don't let them list.
+func (s *Server) visibleKeys(c *gin.Context, vars []*store.Variable) ([]*store.Variable, error) {
+	bound, err := s.boundPolicies(c)
+	if err != nil || len(bound) == 0 {
+		return vars, err
+	}
+
+	visible := vars[:0]
+	for _, v := range vars {
+		if policy.Evaluate(bound, policy.List, policyPath(c, v.Key)).Allowed {
+			visible = append(visible, v)
+		}
+	}
+	return visible, nil
+}
+
+// policies
+
+func (s *Server) listPolicies(c *gin.Context) {
+	if _, ok := s.authorize(c, permRead); !ok {
+		return
+	}
+	org := c.MustGet("organization").(*store.Organization)
+
+	policies, err := s.store.ListPolicies(c.Request.Context(), org.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch policies"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"policies": policies})
+}
+
+// findPolicy loads the policy named by :policy, writing a response and
+// returning false if there is none.
+func (s *Server) findPolicy(c *gin.Context) (*store.Policy, bool) {
+	org := c.MustGet("organization").(*store.Organization)
+	c.Set(auditResourceKey, "orgs/"+org.Name+"/policies/"+c.Param("policy"))
+
+	p, err := s.store.GetPolicy(c.Request.Context(), org.ID, c.Param("policy"))
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Policy not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch policy"})
+		return nil, false
+	}
+	return p, true
+}
+
+func (s *Server) getPolicy(c *gin.Context) {
+	if _, ok := s.authorize(c, permRead); !ok {
+		return
+	}
+	p, ok := s.findPolicy(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"policy": p})
+}
+
+// putPolicy creates the policy named by :policy or replaces its document.
+func (s *Server) putPolicy(c *gin.Context) {
+	if _, ok := s.authorize(c, permMembers); !ok {
+		return
+	}
+	org := c.MustGet("organization").(*store.Organization)
+	name := c.Param("policy")
+	c.Set(auditResourceKey, "orgs/"+org.Name+"/policies/"+name)
+
+	var data struct {
+		Document string `json:"document" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validName(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid policy name"})
+		return
+	}
+	if _, err := policy.Parse([]byte(data.Document)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx := c.Request.Context()
+	now := time.Now()
+
+	p, err := s.store.GetPolicy(ctx, org.ID, name)
+	if errors.Is(err, store.ErrNotFound) {
+		p = &store.Policy{OrgID: org.ID, Name: name, Document: data.Document, CreatedAt: now, UpdatedAt: now}
+		err = s.store.CreatePolicy(ctx, p)
+		if errors.Is(err, store.ErrDuplicate) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Policy was created by another request, try again"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save policy"})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"policy": p})
+		return
+	}
+	if err == nil {
+		err = s.store.UpdatePolicy(ctx, p.ID, data.Document, now)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save policy"})
+		return
+	}
+	p.Document, p.UpdatedAt = data.Document, now
+
+	c.JSON(http.StatusOK, gin.H{"policy": p})
+}
+
+func (s *Server) deletePolicy(c *gin.Context) {
+	if _, ok := s.authorize(c, permMembers); !ok {
+		return
+	}
+	p, ok := s.findPolicy(c)
+	if !ok {
+		return
+	}
+
+	if err := s.store.DeletePolicy(c.Request.Context(), p.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete policy"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Policy deleted successfully"})
+}
+
+// simulatedDecision explains one capability in a simulation.
+type simulatedDecision struct {
+	policy.Decision
+	Reason string `json:"reason"`
+}
+
+// simulatePolicies reports what a member could do on a project/env/key
+// path. Draft documents can be passed in to try them out before saving;
+// they replace stored policies of the same name.
+func (s *Server) simulatePolicies(c *gin.Context) {
+	var data struct {
+		Org      string            `json:"org" binding:"required"`
+		Email    string            `json:"email"` // defaults to the caller
+		Path     string            `json:"path" binding:"required"`
+		Policies map[string]string `json:"policies"`
+	}
+	if err := c.ShouldBindJSON(&data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !s.loadOrg(c, data.Org) {
+		return
+	}
+	org := c.MustGet("organization").(*store.Organization)
+	member := memberOf(c)
+	ctx := c.Request.Context()
+
+	parts := strings.Split(data.Path, "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "path must be project/env/key"})
+		return
+	}
+
+	// Looking at someone else's access needs the right to manage it
+	email := data.Email
+	if email != "" {
+		if _, ok := s.authorize(c, permMembers); !ok {
+			return
+		}
+		user, ok := s.orgUser(c, email)
+		if !ok {
+			return
+		}
+		m, err := s.store.GetMember(ctx, org.ID, user.ID)
+		if errors.Is(err, store.ErrNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "User is not a member of the organization"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch member"})
+			return
+		}
+		member = m
+	}
+
+	// Team grants only raise the role on projects that exist
+	role := member.Role
+	project, err := s.store.GetProject(ctx, org.ID, parts[0])
+	if err == nil {
+		role, err = s.projectRole(ctx, member, project.ID)
+	}
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch project"})
+		return
+	}
+
+	docs, err := s.store.ListPolicies(ctx, org.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch policies"})
+		return
+	}
+	for name, document := range data.Policies {
+		if _, err := policy.Parse([]byte(document)); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": name + ": " + err.Error()})
+			return
+		}
+		draft := &store.Policy{Name: name, Document: document}
+		replaced := false
+		for i, doc := range docs {
+			if doc.Name == name {
+				docs[i], replaced = draft, true
+			}
+		}
+		if !replaced {
+			docs = append(docs, draft)
+		}
+	}
+	bound, err := s.bindPolicies(ctx, org.ID, member.UserID, docs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to evaluate policies"})
+		return
+	}
+
+	boundNames := []string{}
+	for name := range bound {
+		boundNames = append(boundNames, name)
+	}
+	sort.Strings(boundNames)
+	decisions := map[policy.Capability]simulatedDecision{}
+	for _, capability := range policy.Capabilities {
+		var d simulatedDecision
+		switch {
+		case !roleAllows(role, capabilityPermission[capability]):
+			d.Reason = "Role " + role + " doesn't allow " + string(capability)
+		case len(bound) == 0:
+			d.Allowed = true
+			d.Reason = "Role " + role + " allows " + string(capability) + " and no policy applies"
+		default:
+			d.Decision = policy.Evaluate(bound, capability, data.Path)
+			switch {
+			case d.Allowed:
+				d.Reason = "Rule " + d.Rule + " of policy " + d.Policy + " allows it"
+			case d.Policy != "":
+				d.Reason = "Rule " + d.Rule + " of policy " + d.Policy + " denies it"
+			default:
+				d.Reason = "No policy allows " + string(capability) + " on " + data.Path
+			}
+		}
+		decisions[capability] = d
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"userID":       member.UserID,
+		"role":         role,
+		"path":         data.Path,
+		"policies":     boundNames,
+		"capabilities": decisions,
+	})
+}
@@ -0,0 +1,323 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/David-mwas/SafeEnv/store"
+	"github.com/gin-gonic/gin"
+)
+
+// Project and environment names appear in URLs, so keep them path safe.
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+func validName(name string) bool {
+	return namePattern.MatchString(name)
+}
+
+// scopeOf returns the environment resolved by envMiddleware. The original
+// flat routes have none and work on unscoped variables.
+func scopeOf(c *gin.Context) store.Scope {
+	if sc, ok := c.Get("scope"); ok {
+		return sc.(store.Scope)
+	}
+	return store.Scope{}
+}
+
+// projectMiddleware loads the project named by :project, from the
+// organization loaded by orgMiddleware or else the current user's own, and
+// works out the caller's role on it.
+func (s *Server) projectMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			c.Abort()
+			return
+		}
+
+		owner, role := userID, store.RoleOwner
+		member := memberOf(c)
+		if member != nil {
+			owner = member.OrgID
+		}
+
+		project, err := s.store.GetProject(c.Request.Context(), owner, c.Param("project"))
+		if errors.Is(err, store.ErrNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to load project"})
+			return
+		}
+		if token := apiTokenOf(c); token != nil && !token.AllowsProject(project.ID) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "This API token can't access this project"})
+			return
+		}
+
+		if member != nil {
+			role, err = s.projectRole(c.Request.Context(), member, project.ID)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to load project"})
+				return
+			}
+		}
+
+		c.Set("project", project)
+		c.Set(ownerKey, owner)
+		c.Set(roleKey, role)
+		c.Next()
+	}
+}
+
+// envMiddleware loads the environment named by :env and scopes the
+// variable handlers to it.
+func (s *Server) envMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		project := c.MustGet("project").(*store.Project)
+
+		env, err := s.store.GetEnvironment(c.Request.Context(), project.ID, c.Param("env"))
+		if errors.Is(err, store.ErrNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Environment not found"})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to load environment"})
+			return
+		}
+
+		c.Set("environment", env)
+		c.Set("scope", store.Scope{ProjectID: project.ID, EnvID: env.ID})
+		c.Next()
+	}
+}
+
+// projects
+
+func (s *Server) listProjects(c *gin.Context) {
+	owner, ok := s.authorize(c, permRead)
+	if !ok {
+		return
+	}
+
+	projects, err := s.store.ListProjects(c.Request.Context(), owner)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch projects"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"projects": projects})
+}
+
+func (s *Server) createProject(c *gin.Context) {
+	owner, ok := s.authorize(c, permManage)
+	if !ok {
+		return
+	}
+
+	var data struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+	if err := c.ShouldBindJSON(&data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Set(auditResourceKey, orgPrefix(c)+"projects/"+data.Name)
+	if !validName(data.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project name"})
+		return
+	}
+
+	project := &store.Project{
+		OwnerID:     owner,
+		Name:        data.Name,
+		Description: data.Description,
+		CreatedAt:   time.Now(),
+	}
+	err := s.store.CreateProject(c.Request.Context(), project)
+	if errors.Is(err, store.ErrDuplicate) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Project already exists"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create project"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"project": project})
+}
+
+func (s *Server) getProject(c *gin.Context) {
+	if _, ok := s.authorize(c, permRead); !ok {
+		return
+	}
+	project := c.MustGet("project").(*store.Project)
+
+	envs, err := s.store.ListEnvironments(c.Request.Context(), project.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch environments"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"project": project, "environments": envs})
+}
+
+func (s *Server) updateProject(c *gin.Context) {
+	if _, ok := s.authorize(c, permManage); !ok {
+		return
+	}
+	project := c.MustGet("project").(*store.Project)
+
+	var data struct {
+		Name        string  `json:"name"`
+		Description *string `json:"description"`
+	}
+	if err := c.ShouldBindJSON(&data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if data.Name != "" && !validName(data.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project name"})
+		return
+	}
+
+	err := s.store.UpdateProject(c.Request.Context(), project.ID, store.ProjectUpdate{
+		Name:        data.Name,
+		Description: data.Description,
+	})
+	if errors.Is(err, store.ErrDuplicate) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Project already exists"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update project"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Project updated successfully"})
+}
+
+func (s *Server) deleteProject(c *gin.Context) {
+	if _, ok := s.authorize(c, permManage); !ok {
+		return
+	}
+	project := c.MustGet("project").(*store.Project)
+
+	if err := s.store.DeleteProject(c.Request.Context(), project.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete project"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Project deleted successfully"})
+}
+
+// environments
+
+func (s *Server) listEnvironments(c *gin.Context) {
+	if _, ok := s.authorize(c, permRead); !ok {
+		return
+	}
+	project := c.MustGet("project").(*store.Project)
+
+	envs, err := s.store.ListEnvironments(c.Request.Context(), project.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch environments"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"environments": envs})
+}
+
+func (s *Server) createEnvironment(c *gin.Context) {
+	if _, ok := s.authorize(c, permManage); !ok {
+		return
+	}
+	project := c.MustGet("project").(*store.Project)
+
+	var data struct {
+		Name string `json:"name"`
+	}
+	if err := c.ShouldBindJSON(&data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Set(auditResourceKey, orgPrefix(c)+"projects/"+project.Name+"/envs/"+data.Name)
+	if !validName(data.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid environment name"})
+		return
+	}
+
+	env := &store.Environment{
+		ProjectID: project.ID,
+		Name:      data.Name,
+		CreatedAt: time.Now(),
+	}
+	err := s.store.CreateEnvironment(c.Request.Context(), env)
+	if errors.Is(err, store.ErrDuplicate) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Environment already exists"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create environment"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"environment": env})
+}
+
+func (s *Server) getEnvironment(c *gin.Context) {
+	if _, ok := s.authorize(c, permRead); !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"environment": c.MustGet("environment")})
+}
+
+// renameEnvironment only changes the name; variables reference the
+// environment by id, so their ciphertexts stay valid.
+func (s *Server) renameEnvironment(c *gin.Context) {
+	if _, ok := s.authorize(c, permManage); !ok {
+		return
+	}
+	env := c.MustGet("environment").(*store.Environment)
+
+	var data struct {
+		Name string `json:"name"`
+	}
+	if err := c.ShouldBindJSON(&data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validName(data.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid environment name"})
+		return
+	}
+
+	err := s.store.RenameEnvironment(c.Request.Context(), env.ID, data.Name)
+	if errors.Is(err, store.ErrDuplicate) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Environment already exists"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rename environment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Environment renamed successfully"})
+}
+
+func (s *Server) deleteEnvironment(c *gin.Context) {
+	if _, ok := s.authorize(c, permManage); !ok {
+		return
+	}
+	env := c.MustGet("environment").(*store.Environment)
+
+	if err := s.store.DeleteEnvironment(c.Request.Context(), env.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete environment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Environment deleted successfully"})
+}
@@ -0,0 +1,261 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/David-mwas/SafeEnv/encryption"
+	"github.com/David-mwas/SafeEnv/store"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	reencryptJobID     = "reencrypt"
+	reencryptBatchSize = 100
+
+	phaseUsers     = "users"
+	phaseOrgs      = "organizations"
+	phaseVariables = "variables"
+)
+
+// ErrJobRunning is returned when a re-encryption job is already in progress.
+var ErrJobRunning = errors.New("re-encryption job already running")
+
+// Reencrypt brings all stored ciphertext up to date with the primary master
+// key: user and organization data keys are re-wrapped with it and variables
+// still sealed directly with a master key are moved onto their owner's data
+// key.
+//
+// Progress is checkpointed in the store after each batch. An unfinished job
+// targeting the same primary key is resumed from its cursor unless restart
+// is set. progress, if non-nil, is called after every batch.
+func (s *Server) Reencrypt(ctx context.Context, restart bool, progress func(*store.Job)) (*store.Job, error) {
+	job, err := s.beginReencrypt(ctx, restart)
+	if err != nil {
+		return nil, err
+	}
+	defer s.jobMu.Unlock()
+
+	return job, s.runReencrypt(ctx, job, progress)
+}
+
+// beginReencrypt takes the job lock and loads the job to run. On success the
+// caller owns the lock and must release it once runReencrypt returns.
+func (s *Server) beginReencrypt(ctx context.Context, restart bool) (*store.Job, error) {
+	if !s.jobMu.TryLock() {
+		return nil, ErrJobRunning
+	}
+
+	job, err := s.loadReencryptJob(ctx, restart)
+	if err == nil {
+		err = s.store.SaveJob(ctx, job)
+	}
+	if err != nil {
+		s.jobMu.Unlock()
+		return nil, err
+	}
+	return job, nil
+}
+
+func (s *Server) runReencrypt(ctx context.Context, job *store.Job, progress func(*store.Job)) error {
+	for {
+		done, err := s.reencryptBatch(ctx, job)
+		if err != nil {
+			job.Status = store.JobFailed
+			job.Error = err.Error()
+		} else if done {
+			job.Status = store.JobCompleted
+		}
+
+		job.UpdatedAt = time.Now()
+		if saveErr := s.store.SaveJob(ctx, job); saveErr != nil && err == nil {
+			err = saveErr
+		}
+		if progress != nil {
+			progress(job)
+		}
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// reencryptBatch handles one batch of the job's current phase: first every
+// user's and organization's wrapped data key, then every variable not yet
+// sealed with a data key. It reports true once all phases are exhausted.
+func (s *Server) reencryptBatch(ctx context.Context, job *store.Job) (bool, error) {
+	switch job.Phase {
+	case phaseUsers:
+		users, err := s.store.ScanUsers(ctx, job.Cursor, reencryptBatchSize)
+		if err != nil {
+			return false, err
+		}
+		if len(users) == 0 {
+			job.Phase, job.Cursor = phaseOrgs, ""
+			return false, nil
+		}
+
+		for _, u := range users {
+			if u.DataKey != "" && s.keys.NeedsRewrap(u.DataKey) {
+				changed, err := s.rewrapDataKey(ctx, u.ID, u.DataKey, s.store.SetUserDataKey)
+				if err != nil {
+					return false, err
+				}
+				if changed {
+					job.Changed++
+				}
+			}
+			job.Cursor = u.ID
+			job.Processed++
+		}
+		return false, nil
+
+	case phaseOrgs:
+		orgs, err := s.store.ScanOrganizations(ctx, job.Cursor, reencryptBatchSize)
+		if err != nil {
+			return false, err
+		}
+		if len(orgs) == 0 {
+			job.Phase, job.Cursor = phaseVariables, ""
+			return false, nil
+		}
+
+		for _, o := range orgs {
+			if o.DataKey != "" && s.keys.NeedsRewrap(o.DataKey) {
+				changed, err := s.rewrapDataKey(ctx, o.ID, o.DataKey, s.store.SetOrganizationDataKey)
+				if err != nil {
+					return false, err
+				}
+				if changed {
+					job.Changed++
+				}
+			}
+			job.Cursor = o.ID
+			job.Processed++
+		}
+		return false, nil
+
+	case phaseVariables:
+		vars, err := s.store.ScanVariables(ctx, job.Cursor, reencryptBatchSize)
+		if err != nil {
+			return false, err
+		}
+		if len(vars) == 0 {
+			return true, nil
+		}
+
+		for _, v := range vars {
+			if !encryption.IsDataKeyEnvelope(v.Value) {
+				changed, err := s.reencryptVariable(ctx, v)
+				if err != nil {
+					return false, err
+				}
+				if changed {
+					job.Changed++
+				}
+			}
+			job.Cursor = v.ID
+			job.Processed++
+		}
+		return false, nil
+
+	default:
+		return false, fmt.Errorf("unknown re-encryption phase %q", job.Phase)
+	}
+}
+
+// loadReencryptJob returns the job to continue, or a fresh one.
+func (s *Server) loadReencryptJob(ctx context.Context, restart bool) (*store.Job, error) {
+	job, err := s.store.GetJob(ctx, reencryptJobID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return nil, err
+	}
+
+	resumable := job != nil && !restart &&
+		job.Status != store.JobCompleted &&
+		job.Target == s.keys.Primary()
+	if resumable {
+		job.Status = store.JobRunning
+		job.Error = ""
+		if job.Phase == "" {
+			// Jobs saved before data keys existed only walked variables
+			job.Phase = phaseVariables
+		}
+		return job, nil
+	}
+
+	users, err := s.store.CountUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	orgs, err := s.store.CountOrganizations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	vars, err := s.store.CountVariables(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &store.Job{
+		ID:        reencryptJobID,
+		Status:    store.JobRunning,
+		Target:    s.keys.Primary(),
+		Phase:     phaseUsers,
+		Total:     users + orgs + vars,
+		StartedAt: now,
+		UpdatedAt: now,
+	}, nil
+}
+
+func (s *Server) getReencryptJob(c *gin.Context) {
+	job, err := s.store.GetJob(c.Request.Context(), reencryptJobID)
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No re-encryption job has run"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch job"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"job": job, "primaryKeyID": s.keys.Primary()})
+}
+
+func (s *Server) startReencryptJob(c *gin.Context) {
+	var request struct {
+		Restart bool `json:"restart"`
+	}
+	// An empty body is fine, it just means "resume"
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	job, err := s.beginReencrypt(c.Request.Context(), request.Restart)
+	if errors.Is(err, ErrJobRunning) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Re-encryption job already running"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start re-encryption"})
+		return
+	}
+	snapshot := *job
+
+	// The job outlives the request, so it must not use the request context
+	go func() {
+		defer s.jobMu.Unlock()
+		if err := s.runReencrypt(context.Background(), job, nil); err != nil {
+			log.Println("Re-encryption job failed:", err)
+		}
+	}()
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Re-encryption started", "job": snapshot})
+}
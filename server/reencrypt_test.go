@@ -0,0 +1,188 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/David-mwas/SafeEnv/encryption"
+	"github.com/David-mwas/SafeEnv/store"
+)
+
+// interruptedStore fails the nth ScanVariables call, like a job killed
+// halfway through, and records where each scan started.
+type interruptedStore struct {
+	store.Store
+	failAt int
+
+	mu     sync.Mutex
+	scans  int
+	starts []string
+}
+
+func (s *interruptedStore) ScanVariables(ctx context.Context, afterID string, limit int) ([]*store.Variable, error) {
+	s.mu.Lock()
+	s.scans++
+	s.starts = append(s.starts, afterID)
+	fail := s.scans == s.failAt
+	s.mu.Unlock()
+	if fail {
+		return nil, errors.New("connection reset")
+	}
+	return s.Store.ScanVariables(ctx, afterID, limit)
+}
+
+// legacyValue seals plaintext the way older releases did: unprefixed
+// AES-CFB, or a v1 AES-GCM envelope under the default key.
+func legacyValue(t *testing.T, version string, key, plaintext, ad []byte) string {
+	t.Helper()
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	switch version {
+	case "cfb":
+		out := make([]byte, aes.BlockSize+len(plaintext))
+		if _, err := rand.Read(out[:aes.BlockSize]); err != nil {
+			t.Fatal(err)
+		}
+		cipher.NewCFBEncrypter(block, out[:aes.BlockSize]).XORKeyStream(out[aes.BlockSize:], plaintext)
+		return base64.StdEncoding.EncodeToString(out)
+	case encryption.VersionGCM:
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nonce := make([]byte, gcm.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			t.Fatal(err)
+		}
+		return encryption.VersionGCM + ":" + base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, plaintext, ad))
+	}
+	t.Fatalf("unknown version %s", version)
+	return ""
+}
+
+func TestReencryptResumesAfterInterruption(t *testing.T) {
+	ctx := context.Background()
+	oldKey := bytes.Repeat([]byte{1}, 32)
+	before, err := encryption.NewKeyring(encryption.DefaultKeyID, map[string][]byte{encryption.DefaultKeyID: oldKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The master key has been rotated; the old one is kept to read with
+	rotated, err := encryption.NewKeyring("2025-06", map[string][]byte{
+		encryption.DefaultKeyID: oldKey,
+		"2025-06":               bytes.Repeat([]byte{2}, 32),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config{Keys: rotated, Keyring: rotated, JWTSecret: []byte("test secret"), FrontendURL: "http://localhost:5173"}
+	ts := newTestServer(t, cfg)
+
+	ts.register(t, "alice@example.com", "hunter22")
+	alice, err := ts.store.GetUserByEmail(ctx, "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dek, err := encryption.GenerateDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped, err := encryption.WrapDataKey(ctx, before, dek, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.store.SetUserDataKey(ctx, alice.ID, wrapped, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// More than two batches of values from every envelope version
+	const n = 2*reencryptBatchSize + 50
+	var vars []*store.Variable
+	for i := range n {
+		v := &store.Variable{UserID: alice.ID, Key: fmt.Sprintf("KEY_%03d", i)}
+		plaintext := []byte("value " + v.Key)
+		ad := variableAD(v)
+		switch i % 4 {
+		case 0:
+			v.Value = legacyValue(t, "cfb", oldKey, plaintext, ad)
+		case 1:
+			v.Value = legacyValue(t, encryption.VersionGCM, oldKey, plaintext, ad)
+		case 2:
+			v.Value, err = before.Encrypt(plaintext, ad)
+		case 3:
+			v.Value, err = encryption.EncryptWithDataKey(dek, plaintext, ad)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		vars = append(vars, v)
+	}
+	if err := ts.store.CreateVariables(ctx, vars...); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first run dies on its second batch of variables
+	interrupted := &interruptedStore{Store: ts.store, failAt: 2}
+	job, err := New(interrupted, cfg).Reencrypt(ctx, false, nil)
+	if err == nil || job.Status != store.JobFailed {
+		t.Fatalf("interrupted run: %+v %v", job, err)
+	}
+	if job.Phase != phaseVariables || job.Cursor == "" {
+		t.Fatalf("interrupted job saved at phase %q cursor %q", job.Phase, job.Cursor)
+	}
+	cursor, changed := job.Cursor, job.Changed
+
+	// The next run picks up at the saved cursor instead of starting over
+	resumed := &interruptedStore{Store: ts.store}
+	job, err = New(resumed, cfg).Reencrypt(ctx, false, nil)
+	if err != nil || job.Status != store.JobCompleted {
+		t.Fatalf("resumed run: %+v %v", job, err)
+	}
+	if len(resumed.starts) == 0 || resumed.starts[0] != cursor {
+		t.Fatalf("resumed scanning from %v, want %q", resumed.starts, cursor)
+	}
+	// One data key, and every variable not already under it
+	if want := int64(1 + n - n/4); job.Changed != want || job.Changed <= changed {
+		t.Fatalf("changed %d (%d before resuming), want %d", job.Changed, changed, want)
+	}
+
+	alice, err = ts.store.GetUserByID(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encryption.KeyID(alice.DataKey) != "2025-06" {
+		t.Fatalf("data key still wrapped with %s", encryption.KeyID(alice.DataKey))
+	}
+	stored, err := ts.store.ListVariables(ctx, store.VariableFilter{UserID: alice.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != n {
+		t.Fatalf("%d variables, want %d", len(stored), n)
+	}
+	for _, v := range stored {
+		if !encryption.IsDataKeyEnvelope(v.Value) {
+			t.Fatalf("%s still stored as %.3q", v.Key, v.Value)
+		}
+		got, err := ts.srv.decrypt(ctx, v)
+		if err != nil || got != "value "+v.Key {
+			t.Fatalf("%s: %q %v", v.Key, got, err)
+		}
+	}
+
+	// Running again finds nothing left to do
+	job, err = ts.srv.Reencrypt(ctx, true, nil)
+	if err != nil || job.Status != store.JobCompleted || job.Changed != 0 {
+		t.Fatalf("second run: %+v %v", job, err)
+	}
+}
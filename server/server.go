@@ -0,0 +1,273 @@
+// Package server contains the Gin handlers for the SafeEnv API.
+package server
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/David-mwas/SafeEnv/audit"
+	"github.com/David-mwas/SafeEnv/encryption"
+	"github.com/David-mwas/SafeEnv/oidc"
+	"github.com/David-mwas/SafeEnv/store"
+	"github.com/David-mwas/SafeEnv/webauthn"
+	"github.com/gin-gonic/gin"
+)
+
+// Config holds the secrets and settings the handlers need.
+type Config struct {
+	Keys        encryption.KeyProvider // wraps per-user data keys
+	Keyring     *encryption.Keyring    // optional, reads values sealed directly with a master key
+	JWTSecret   []byte
+	FrontendURL string
+	AdminEmails []string
+
+	VersionRetention int           // versions kept per variable, 0 keeps all
+	ShareMaxAttempts int           // wrong passphrases before a share locks, defaults to 5
+	AccessTokenTTL   time.Duration // defaults to 15 minutes
+	SessionTTL       time.Duration // defaults to 30 days
+	RequireMFA       bool          // every user must log in with a second factor
+	WebAuthnRPID     string        // passkey domain, defaults to FrontendURL's host
+	WebAuthnOrigins  []string      // origins passkey ceremonies may come from, defaults to FrontendURL
+	Audit            *audit.Log    // defaults to an unsigned log on the same store
+
+	OIDC               *oidc.Config // single sign-on provider, nil disables SSO
+	OIDCAutoProvision  bool         // create accounts for SSO users who don't have one
+	OIDCAllowedDomains []string     // email domains SSO may link or create accounts for; empty allows any
+}
+
+// Server wires the API handlers to a Store.
+type Server struct {
+	store       store.Store
+	keys        encryption.KeyProvider
+	keyring     *encryption.Keyring
+	jwtSecret   []byte
+	frontendURL string
+	adminEmails []string
+	retention   int
+	maxAttempts int
+	accessTTL   time.Duration
+	sessionTTL  time.Duration
+	requireMFA  bool
+	rp          webauthn.RelyingParty
+	sso         *oidc.Provider
+	ssoDomains  []string
+	ssoAutoJoin bool
+	audit       *audit.Log
+
+	jobMu sync.Mutex // held while a re-encryption job runs
+
+	dekMu    sync.Mutex
+	dekCache map[string][]byte // wrapped data key -> unwrapped data key
+}
+
+// New returns a Server backed by st.
+func New(st store.Store, cfg Config) *Server {
+	if cfg.Audit == nil {
+		cfg.Audit = audit.New(st, nil)
+	}
+	if cfg.ShareMaxAttempts == 0 {
+		cfg.ShareMaxAttempts = defaultShareMaxAttempts
+	}
+	if cfg.AccessTokenTTL == 0 {
+		cfg.AccessTokenTTL = defaultAccessTokenTTL
+	}
+	if cfg.SessionTTL == 0 {
+		cfg.SessionTTL = defaultSessionTTL
+	}
+	var sso *oidc.Provider
+	if cfg.OIDC != nil {
+		sso = oidc.New(*cfg.OIDC)
+	}
+	return &Server{
+		store:       st,
+		keys:        cfg.Keys,
+		keyring:     cfg.Keyring,
+		jwtSecret:   cfg.JWTSecret,
+		frontendURL: cfg.FrontendURL,
+		adminEmails: cfg.AdminEmails,
+		retention:   cfg.VersionRetention,
+		maxAttempts: cfg.ShareMaxAttempts,
+		accessTTL:   cfg.AccessTokenTTL,
+		sessionTTL:  cfg.SessionTTL,
+		requireMFA:  cfg.RequireMFA,
+		rp:          relyingParty(cfg),
+		sso:         sso,
+		ssoDomains:  cfg.OIDCAllowedDomains,
+		ssoAutoJoin: cfg.OIDCAutoProvision,
+		audit:       cfg.Audit,
+		dekCache:    map[string][]byte{},
+	}
+}
+
+// Register mounts every API route on r.
+func (s *Server) Register(r *gin.Engine) {
+	// public routes
+	r.GET("/", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"message": "Welcome to SafeEnv API"})
+	})
+
+	r.POST("/api/v1/register", s.registerUser)
+	r.POST("/api/v1/login", s.audited("auth.login"), s.loginUser)
+	r.POST("/api/v1/login/mfa", s.audited("auth.mfa"), s.loginMFA)
+	r.POST("/api/v1/login/mfa/enroll", s.audited("mfa.enroll"), s.loginEnrollMFA)
+	r.POST("/api/v1/login/passkey/begin", s.beginPasskeyLogin)
+	r.POST("/api/v1/login/passkey/finish", s.audited("auth.passkey"), s.finishPasskeyLogin)
+	r.POST("/api/v1/sso/begin", s.beginSSOLogin)
+	r.POST("/api/v1/sso/callback", s.audited("auth.sso"), s.finishSSOLogin)
+	r.POST("/api/v1/token/refresh", s.audited("auth.refresh"), s.refreshToken)
+	r.POST("/api/v1/invites/accept", s.audited("org.invite.accept"), s.acceptInvite)
+
+	// Password reset routes
+	r.POST("/api/v1/forgot-password", s.requestPasswordReset)
+	r.POST("/api/v1/reset-password", s.resetPassword)
+
+	// protected routes
+	auth := r.Group("/api/v1")
+	auth.Use(s.authMiddleware())
+
+	{
+		auth.POST("/store", s.audited("variable.store"), s.storeVariable)
+		auth.GET("/keys", s.getUserKeys)
+		auth.GET("/user", s.getCurrentUser)
+		auth.POST("/logout", s.audited("auth.logout"), s.logout)
+		auth.GET("/sessions", s.listSessions)
+		auth.DELETE("/sessions/:id", s.audited("session.revoke"), s.revokeSession)
+		auth.POST("/mfa/totp/enroll", s.audited("mfa.enroll"), s.enrollTOTP)
+		auth.POST("/mfa/totp/verify", s.audited("mfa.enable"), s.verifyTOTP)
+		auth.DELETE("/mfa/totp", s.audited("mfa.disable"), s.disableTOTP)
+		auth.POST("/mfa/recovery-codes", s.audited("mfa.recovery_codes"), s.regenerateRecoveryCodes)
+		auth.GET("/passkeys", s.listPasskeys)
+		auth.POST("/passkeys/register/begin", s.beginPasskeyRegistration)
+		auth.POST("/passkeys/register/finish", s.audited("passkey.register"), s.finishPasskeyRegistration)
+		auth.DELETE("/passkeys/:id", s.audited("passkey.delete"), s.deletePasskey)
+		auth.GET("/tokens", s.listAPITokens)
+		auth.POST("/tokens", s.audited("token.create"), s.createAPIToken)
+		auth.DELETE("/tokens/:id", s.audited("token.revoke"), s.revokeAPIToken)
+		auth.DELETE("/keys/:id", s.audited("variable.delete"), s.deleteKey)
+		auth.PUT("/keys/:key", s.audited("variable.update"), s.updateKey)
+
+		auth.GET("/retrieve", s.audited("variable.retrieve"), s.retrieveVariables)
+		auth.GET("/retrieve/:key", s.audited("variable.retrieve"), s.retrieveVariable)
+		auth.GET("/share/retrieve/:token", s.audited("share.retrieve"), s.retrieveSharedVariable)
+		auth.POST("/share/retrieve/:token", s.audited("share.retrieve"), s.retrieveSharedVariable)
+		auth.POST("/share", s.audited("share.create"), s.shareVariable)
+		auth.GET("/shares", s.listShares)
+		auth.DELETE("/shares/:id", s.audited("share.revoke"), s.revokeShare)
+		auth.POST("/store/bulk", s.audited("variable.store"), s.storeVariablesBulk)
+		auth.POST("/import", s.audited("variable.import"), s.importVariables)
+		auth.GET("/export", s.audited("variable.export"), s.exportVariables)
+
+		auth.GET("/keys/:key/versions", s.listVersions)
+		auth.GET("/keys/:key/versions/:version", s.audited("variable.retrieve"), s.getVersion)
+		auth.POST("/keys/:key/versions/:version/rollback", s.audited("variable.rollback"), s.rollbackVersion)
+
+		auth.GET("/projects", s.listProjects)
+		auth.POST("/projects", s.audited("project.create"), s.createProject)
+		auth.GET("/orgs", s.listOrgs)
+		auth.POST("/orgs", s.audited("org.create"), s.createOrg)
+		auth.POST("/sso/link", s.beginSSOLink)
+		auth.POST("/sso/link/callback", s.audited("auth.sso.link"), s.finishSSOLink)
+		auth.POST("/invites/join", s.audited("org.invite.accept"), s.joinInvite)
+		auth.POST("/policies/simulate", s.simulatePolicies)
+
+		auth.GET("/audit", s.listAudit)
+	}
+
+	// project routes, for the user's own projects and an organization's
+	project := auth.Group("/projects/:project")
+	project.Use(s.projectMiddleware())
+	s.registerProject(project)
+
+	org := auth.Group("/orgs/:org")
+	org.Use(s.orgMiddleware())
+
+	{
+		org.GET("", s.getOrg)
+		org.PUT("", s.audited("org.update"), s.updateOrg)
+		org.GET("/members", s.listMembers)
+		org.POST("/members", s.audited("org.member.add"), s.addMember)
+		org.PUT("/members/:user", s.audited("org.member.update"), s.updateMember)
+		org.DELETE("/members/:user", s.audited("org.member.remove"), s.removeMember)
+		org.GET("/invites", s.listInvites)
+		org.POST("/invites", s.audited("org.invite.create"), s.createInvite)
+		org.POST("/invites/:invite/resend", s.audited("org.invite.resend"), s.resendInvite)
+		org.DELETE("/invites/:invite", s.audited("org.invite.revoke"), s.revokeInvite)
+		org.GET("/teams", s.listTeams)
+		org.POST("/teams", s.audited("team.create"), s.createTeam)
+		org.DELETE("/teams/:team", s.audited("team.delete"), s.deleteTeam)
+		org.POST("/teams/:team/members", s.audited("team.member.add"), s.addTeamMember)
+		org.DELETE("/teams/:team/members/:user", s.audited("team.member.remove"), s.removeTeamMember)
+		org.GET("/policies", s.listPolicies)
+		org.GET("/policies/:policy", s.getPolicy)
+		org.PUT("/policies/:policy", s.audited("policy.update"), s.putPolicy)
+		org.DELETE("/policies/:policy", s.audited("policy.delete"), s.deletePolicy)
+		org.GET("/projects", s.listProjects)
+		org.POST("/projects", s.audited("project.create"), s.createProject)
+	}
+
+	orgProject := org.Group("/projects/:project")
+	orgProject.Use(s.projectMiddleware())
+	s.registerProject(orgProject)
+
+	{
+		orgProject.PUT("/teams/:team", s.audited("team.grant"), s.setTeamGrant)
+		orgProject.DELETE("/teams/:team", s.audited("team.revoke"), s.setTeamGrant)
+	}
+
+	// admin routes
+	admin := auth.Group("/admin")
+	admin.Use(s.adminMiddleware())
+
+	{
+		admin.GET("/reencrypt", s.getReencryptJob)
+		admin.POST("/reencrypt", s.audited("admin.reencrypt"), s.startReencryptJob)
+	}
+}
+
+// registerProject mounts the routes under a project; variables under an
+// environment reuse the flat variable handlers.
+func (s *Server) registerProject(project *gin.RouterGroup) {
+	{
+		project.GET("", s.getProject)
+		project.PUT("", s.audited("project.update"), s.updateProject)
+		project.DELETE("", s.audited("project.delete"), s.deleteProject)
+
+		project.GET("/envs", s.listEnvironments)
+		project.POST("/envs", s.audited("environment.create"), s.createEnvironment)
+	}
+
+	env := project.Group("/envs/:env")
+	env.Use(s.envMiddleware())
+
+	{
+		env.GET("", s.getEnvironment)
+		env.PUT("", s.audited("environment.rename"), s.renameEnvironment)
+		env.DELETE("", s.audited("environment.delete"), s.deleteEnvironment)
+
+		env.POST("/store", s.audited("variable.store"), s.storeVariable)
+		env.POST("/store/bulk", s.audited("variable.store"), s.storeVariablesBulk)
+		env.POST("/import", s.audited("variable.import"), s.importVariables)
+		env.GET("/export", s.audited("variable.export"), s.exportVariables)
+		env.GET("/keys", s.getUserKeys)
+		env.DELETE("/keys/:id", s.audited("variable.delete"), s.deleteKey)
+		env.PUT("/keys/:key", s.audited("variable.update"), s.updateKey)
+		env.GET("/retrieve", s.audited("variable.retrieve"), s.retrieveVariables)
+		env.GET("/retrieve/:key", s.audited("variable.retrieve"), s.retrieveVariable)
+		env.POST("/share", s.audited("share.create"), s.shareVariable)
+
+		env.GET("/keys/:key/versions", s.listVersions)
+		env.GET("/keys/:key/versions/:version", s.audited("variable.retrieve"), s.getVersion)
+		env.POST("/keys/:key/versions/:version/rollback", s.audited("variable.rollback"), s.rollbackVersion)
+	}
+}
+
+// currentUserID returns the user id set by authMiddleware, writing a 401 if it is missing.
+func currentUserID(c *gin.Context) (string, bool) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return "", false
+	}
+	return userID.(string), true
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/David-mwas/SafeEnv/encryption"
+	"github.com/David-mwas/SafeEnv/store"
+	"github.com/gin-gonic/gin"
+)
+
+// testServer is the API on a fresh Bolt store, served over HTTP.
+type testServer struct {
+	*httptest.Server
+	srv   *Server
+	store store.Store
+}
+
+func newTestServer(t *testing.T, cfg Config) *testServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	st, err := store.OpenBolt(filepath.Join(t.TempDir(), "safeenv.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close(context.Background()) })
+	return serve(t, st, cfg)
+}
+
+// serve runs the API on st, with the same defaults as newTestServer so a
+// second server can share its store.
+func serve(t *testing.T, st store.Store, cfg Config) *testServer {
+	t.Helper()
+	if cfg.Keys == nil {
+		keyring, err := encryption.NewKeyring("default", map[string][]byte{"default": bytes.Repeat([]byte{1}, 32)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.Keys, cfg.Keyring = keyring, keyring
+	}
+	if cfg.JWTSecret == nil {
+		cfg.JWTSecret = []byte("test secret")
+	}
+	if cfg.FrontendURL == "" {
+		cfg.FrontendURL = "http://localhost:5173"
+	}
+
+	srv := New(st, cfg)
+	r := gin.New()
+	srv.Register(r)
+	ts := httptest.NewServer(r)
+	t.Cleanup(ts.Close)
+	return &testServer{Server: ts, srv: srv, store: st}
+}
+
+// call sends a JSON request, with token as the bearer if set, and decodes
+// the JSON response.
+func (ts *testServer) call(t *testing.T, method, path, token string, body any) (int, map[string]any) {
+	t.Helper()
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, ts.URL+"/api/v1"+path, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var out map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil && err != io.EOF {
+		t.Fatalf("%s %s: decoding response: %v", method, path, err)
+	}
+	return resp.StatusCode, out
+}
+
+// register creates an account and logs it in, returning its access token.
+func (ts *testServer) register(t *testing.T, email, password string) string {
+	t.Helper()
+	if status, out := ts.call(t, "POST", "/register", "", gin.H{"username": email, "email": email, "password": password}); status != http.StatusOK {
+		t.Fatalf("register %s: %d %v", email, status, out)
+	}
+	status, out := ts.call(t, "POST", "/login", "", gin.H{"email": email, "password": password})
+	if status != http.StatusOK || out["token"] == nil {
+		t.Fatalf("login %s: %d %v", email, status, out)
+	}
+	return out["token"].(string)
+}
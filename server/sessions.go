@@ -0,0 +1,248 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/David-mwas/SafeEnv/store"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	defaultAccessTokenTTL = 15 * time.Minute
+	defaultSessionTTL     = 30 * 24 * time.Hour
+
+	// sessionTouchInterval limits how often authMiddleware writes lastUsedAt
+	sessionTouchInterval = time.Minute
+)
+
+// signAccessToken issues a short-lived JWT tied to a session, so revoking
+// the session cuts it off before it expires.
+func (s *Server) signAccessToken(userID, sessionID string) (string, error) {
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": userID,
+		"sid": sessionID,
+		"iat": now.Unix(),
+		"exp": now.Add(s.accessTTL).Unix(),
+	})
+	return token.SignedString(s.jwtSecret)
+}
+
+// refreshTokenFor builds the token handed to the client; only the hash of
+// secret is stored.
+func refreshTokenFor(sessionID, secret string) string {
+	return sessionID + "." + secret
+}
+
+// startSession opens a session for a user who just authenticated, mfa
+// telling whether they used a second factor, and returns the tokens.
+func (s *Server) startSession(c *gin.Context, user *store.User, mfa bool) (gin.H, error) {
+	secret, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &store.Session{
+		UserID:      user.ID,
+		RefreshHash: hashToken(secret),
+		UserAgent:   c.Request.UserAgent(),
+		IP:          c.ClientIP(),
+		MFA:         mfa,
+		CreatedAt:   now,
+		LastUsedAt:  now,
+		ExpiresAt:   now.Add(s.sessionTTL),
+	}
+	if err := s.store.CreateSession(c.Request.Context(), session); err != nil {
+		return nil, err
+	}
+	return s.sessionTokens(session, secret)
+}
+
+func (s *Server) sessionTokens(session *store.Session, secret string) (gin.H, error) {
+	accessToken, err := s.signAccessToken(session.UserID, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return gin.H{
+		"token":        accessToken,
+		"expiresIn":    int64(s.accessTTL / time.Second),
+		"refreshToken": refreshTokenFor(session.ID, secret),
+	}, nil
+}
+
+// sessionAllowed reports whether a session may still be used, which it
+// can't if 2FA became mandatory after it was opened without it.
+func (s *Server) sessionAllowed(session *store.Session, now time.Time) bool {
+	return session.Active(now) && (session.MFA || !s.requireMFA)
+}
+
+// refreshToken trades a refresh token for new access and refresh tokens.
+// Each refresh token works once; presenting one that was already used
+// means it leaked, so the whole session is revoked.
+func (s *Server) refreshToken(c *gin.Context) {
+	var data struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	if err := c.ShouldBindJSON(&data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	sessionID, secret, ok := strings.Cut(data.RefreshToken, ".")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+	session, err := s.store.GetSession(ctx, sessionID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+	c.Set(auditActorKey, session.UserID)
+	c.Set(auditResourceKey, "sessions/"+session.ID)
+
+	now := time.Now()
+	hash := hashToken(secret)
+	switch {
+	case !s.sessionAllowed(session, now):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired, please log in again"})
+		return
+	case session.PrevRefreshHash != "" && hash == session.PrevRefreshHash:
+		s.refreshTokenReused(c, session)
+		return
+	case hash != session.RefreshHash:
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+
+	next, err := newToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	rotated, err := s.store.RotateSession(ctx, session.ID, hash, hashToken(next), c.ClientIP(), c.Request.UserAgent(), now)
+	if errors.Is(err, store.ErrNotFound) {
+		// Another refresh used the same token meanwhile, which is reuse too
+		s.refreshTokenReused(c, session)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh session"})
+		return
+	}
+
+	tokens, err := s.sessionTokens(rotated, next)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// refreshTokenReused revokes a session whose refresh token was presented
+// twice: one of the two holders copied it.
+func (s *Server) refreshTokenReused(c *gin.Context, session *store.Session) {
+	if err := s.store.RevokeSession(c.Request.Context(), session.UserID, session.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+	c.Set(auditDetailKey, "refresh token reused, session revoked")
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token was already used, session revoked"})
+}
+
+// checkSession rejects access tokens whose session was revoked or expired.
+func (s *Server) checkSession(c *gin.Context, userID, sessionID string) bool {
+	session, err := s.store.GetSession(c.Request.Context(), sessionID)
+	if err != nil || session.UserID != userID || !s.sessionAllowed(session, time.Now()) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired, please log in again"})
+		return false
+	}
+
+	if now := time.Now(); now.Sub(session.LastUsedAt) > sessionTouchInterval {
+		// Only bookkeeping, a failed write shouldn't fail the request
+		_ = s.store.TouchSession(c.Request.Context(), session.ID, c.ClientIP(), now)
+	}
+	c.Set(mfaKey, session.MFA)
+	return true
+}
+
+func (s *Server) logout(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	sessionID := c.GetString("sessionID")
+	c.Set(auditResourceKey, "sessions/"+sessionID)
+	if err := s.store.RevokeSession(c.Request.Context(), userID, sessionID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
+
+// listSessions shows the caller's active sessions, or all with ?all=true.
+func (s *Server) listSessions(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	sessions, err := s.store.ListSessions(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sessions"})
+		return
+	}
+
+	now := time.Now()
+	all := c.Query("all") == "true"
+	current := c.GetString("sessionID")
+	out := []gin.H{}
+	for _, session := range sessions {
+		if !all && !session.Active(now) {
+			continue
+		}
+		out = append(out, gin.H{
+			"id":         session.ID,
+			"userAgent":  session.UserAgent,
+			"ip":         session.IP,
+			"current":    session.ID == current,
+			"active":     session.Active(now),
+			"createdAt":  session.CreatedAt,
+			"lastUsedAt": session.LastUsedAt,
+			"expiresAt":  session.ExpiresAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": out})
+}
+
+func (s *Server) revokeSession(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	id := c.Param("id")
+	c.Set(auditResourceKey, "sessions/"+id)
+
+	err := s.store.RevokeSession(c.Request.Context(), userID, id)
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked successfully"})
+}
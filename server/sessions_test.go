@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/David-mwas/SafeEnv/store"
+	"github.com/gin-gonic/gin"
+)
+
+// login logs in and returns the access and refresh tokens.
+func login(t *testing.T, ts *testServer, email, password string) (string, string) {
+	t.Helper()
+	status, out := ts.call(t, "POST", "/login", "", gin.H{"email": email, "password": password})
+	if status != http.StatusOK || out["refreshToken"] == nil {
+		t.Fatalf("login: %d %v", status, out)
+	}
+	return out["token"].(string), out["refreshToken"].(string)
+}
+
+func TestRefreshTokenReuseRevokesSession(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	ts.register(t, "alice@example.com", "hunter22")
+	access, refresh := login(t, ts, "alice@example.com", "hunter22")
+
+	status, out := ts.call(t, "POST", "/token/refresh", "", gin.H{"refreshToken": refresh})
+	if status != http.StatusOK {
+		t.Fatalf("refresh: %d %v", status, out)
+	}
+	next := out["refreshToken"].(string)
+
+	// The old token again, e.g. from whoever copied it
+	if status, out := ts.call(t, "POST", "/token/refresh", "", gin.H{"refreshToken": refresh}); status != http.StatusUnauthorized || !strings.Contains(out["error"].(string), "already used") {
+		t.Fatalf("reused refresh token: %d %v", status, out)
+	}
+	if status, out := ts.call(t, "POST", "/token/refresh", "", gin.H{"refreshToken": next}); status != http.StatusUnauthorized {
+		t.Fatalf("refresh after reuse: %d %v", status, out)
+	}
+	if status, out := ts.call(t, "GET", "/user", access, nil); status != http.StatusUnauthorized {
+		t.Fatalf("access token after reuse: %d %v", status, out)
+	}
+	if status, out := ts.call(t, "GET", "/user", out["token"].(string), nil); status != http.StatusUnauthorized {
+		t.Fatalf("rotated access token after reuse: %d %v", status, out)
+	}
+}
+
+// racingStore lets another refresh with the same token rotate the session
+// between refreshToken reading it and rotating it.
+type racingStore struct {
+	store.Store
+	raced bool
+}
+
+func (s *racingStore) RotateSession(ctx context.Context, id, oldHash, newHash, ip, userAgent string, now time.Time) (*store.Session, error) {
+	if !s.raced {
+		s.raced = true
+		if _, err := s.Store.RotateSession(ctx, id, oldHash, hashToken("winner"), ip, userAgent, now); err != nil {
+			return nil, err
+		}
+	}
+	return s.Store.RotateSession(ctx, id, oldHash, newHash, ip, userAgent, now)
+}
+
+func TestRefreshTokenRaceRevokesSession(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	ts.register(t, "alice@example.com", "hunter22")
+	access, refresh := login(t, ts, "alice@example.com", "hunter22")
+
+	// A second server on the same store, where the winning refresh
+	// happens inside RotateSession
+	loser := serve(t, &racingStore{Store: ts.store}, Config{})
+	status, out := loser.call(t, "POST", "/token/refresh", "", gin.H{"refreshToken": refresh})
+	if status != http.StatusUnauthorized || !strings.Contains(out["error"].(string), "already used") {
+		t.Fatalf("refresh that lost the race: %d %v", status, out)
+	}
+	// The winner's token is no good either once the session is revoked
+	sessionID, _, _ := strings.Cut(refresh, ".")
+	if status, out := ts.call(t, "POST", "/token/refresh", "", gin.H{"refreshToken": sessionID + ".winner"}); status != http.StatusUnauthorized {
+		t.Fatalf("winner's refresh: %d %v", status, out)
+	}
+	if status, out := ts.call(t, "GET", "/user", access, nil); status != http.StatusUnauthorized {
+		t.Fatalf("access token after the race: %d %v", status, out)
+	}
+}
@@ -0,0 +1,488 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/David-mwas/SafeEnv/encryption"
+	"github.com/David-mwas/SafeEnv/policy"
+	"github.com/David-mwas/SafeEnv/store"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultShareTTL = 24 * time.Hour
+	maxShareTTL     = 30 * 24 * time.Hour
+
+	defaultShareMaxAttempts = 5
+	minSharePassphrase      = 8
+	maxShareRecipients      = 50
+
+	maxOneTimeCiphertext = 1 << 16
+)
+
+// shareJSON is the owner's view of a share; the token itself is only
+// shown once, when the share is created.
+func shareJSON(sh *store.Share, now time.Time) gin.H {
+	return gin.H{
+		"id":        sh.ID,
+		"key":       sh.Key,
+		"maxViews":  sh.MaxViews,
+		"views":     sh.Views,
+		"revoked":   sh.Revoked,
+		"oneTime":   sh.OneTime,
+		"active":    sh.Active(now),
+		"expiresAt": sh.ExpiresAt,
+		"createdAt": sh.CreatedAt,
+
+		"recipients":     sh.Recipients,
+		"passphrase":     sh.PassphraseHash != "",
+		"failedAttempts": sh.FailedAttempts,
+		"locked":         sh.Locked(),
+	}
+}
+
+// normalizeRecipients trims, lowercases emails and drops duplicates.
+func normalizeRecipients(in []string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, r := range in {
+		r = strings.TrimSpace(r)
+		if strings.Contains(r, "@") {
+			r = strings.ToLower(r)
+		}
+		if r != "" && !seen[r] {
+			seen[r] = true
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// checkShareAccess enforces a share's recipient list and passphrase,
+// returning the passphrase the caller proved. It writes the response and
+// returns false when the caller may not open the share.
+func (s *Server) checkShareAccess(c *gin.Context, sh *store.Share) (string, bool) {
+	ctx := c.Request.Context()
+
+	if len(sh.Recipients) > 0 {
+		userID := c.GetString("userID")
+		user, err := s.store.GetUserByID(ctx, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load user"})
+			return "", false
+		}
+		allowed := false
+		for _, r := range sh.Recipients {
+			if r == userID || strings.EqualFold(r, user.Email) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This share is not addressed to you"})
+			return "", false
+		}
+	}
+
+	if sh.PassphraseHash == "" {
+		return "", true
+	}
+	if sh.Locked() {
+		c.JSON(http.StatusLocked, gin.H{"error": "This share is locked after too many wrong passphrases"})
+		return "", false
+	}
+	if !sh.Active(time.Now()) {
+		// Let the caller report it like any other dead link
+		return "", true
+	}
+
+	var data struct {
+		Passphrase string `json:"passphrase"`
+	}
+	if err := c.ShouldBindJSON(&data); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return "", false
+	}
+	if data.Passphrase == "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This share requires a passphrase", "passphraseRequired": true})
+		return "", false
+	}
+
+	// Count the attempt first so parallel guesses can't slip past the limit
+	claimed, err := s.store.ClaimShareAttempt(ctx, sh.ID, time.Now())
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusLocked, gin.H{"error": "This share is locked after too many wrong passphrases"})
+		return "", false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open share"})
+		return "", false
+	}
+
+	ok, err := encryption.VerifyPassphrase(sh.PassphraseHash, data.Passphrase)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open share"})
+		return "", false
+	}
+	if !ok {
+		left := claimed.MaxAttempts - claimed.FailedAttempts
+		if left <= 0 {
+			c.JSON(http.StatusLocked, gin.H{"error": "Wrong passphrase, this share is now locked"})
+			return "", false
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": "Wrong passphrase", "passphraseRequired": true, "attemptsLeft": left})
+		return "", false
+	}
+
+	if err := s.store.RefundShareAttempt(ctx, sh.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open share"})
+		return "", false
+	}
+	return data.Passphrase, true
+}
+
+// canStillShare reports whether the user who made a share may still share
+// the variable. Members removed from its organization, whose role no
+// longer allows sharing on its project, whose policies no longer allow
+// sharing the key, or who made the share without a second factor in an
+// organization that now requires one, lose their links too.
+func (s *Server) canStillShare(ctx context.Context, sh *store.Share, v *store.Variable) (bool, error) {
+	if v.UserID == sh.OwnerID {
+		return true, nil
+	}
+	member, err := s.store.GetMember(ctx, v.UserID, sh.OwnerID)
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	org, err := s.store.GetOrganizationByID(ctx, member.OrgID)
+	if err != nil {
+		return false, err
+	}
+	if org.RequireMFA && !sh.MFA {
+		return false, nil
+	}
+	role, err := s.projectRole(ctx, member, v.ProjectID)
+	if err != nil {
+		return false, err
+	}
+	if !roleAllows(role, permShare) {
+		return false, nil
+	}
+
+	docs, err := s.store.ListPolicies(ctx, org.ID)
+	if err != nil {
+		return false, err
+	}
+	bound, err := s.bindPolicies(ctx, org.ID, member.UserID, docs)
+	if err != nil {
+		return false, err
+	}
+	if len(bound) == 0 {
+		return true, nil
+	}
+	name, err := s.variablePath(ctx, v)
+	if err != nil {
+		return false, err
+	}
+	return policy.Evaluate(bound, policy.Share, name).Allowed, nil
+}
+
+func (s *Server) retrieveSharedVariable(c *gin.Context) {
+	ctx := c.Request.Context()
+	sh, err := s.store.GetShareByToken(ctx, hashToken(c.Param("token")))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Share link is invalid or has expired"})
+		return
+	}
+	c.Set(auditOwnerKey, sh.OwnerID)
+	c.Set(auditResourceKey, "shares/"+sh.ID)
+
+	passphrase, ok := s.checkShareAccess(c, sh)
+	if !ok {
+		return
+	}
+
+	// The variable may belong to an organization rather than the sharer
+	result, err := s.store.FindVariable(ctx, store.VariableFilter{ID: sh.VariableID})
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Key not found"})
+		return
+	}
+	allowed, err := s.canStillShare(ctx, sh, result)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open share"})
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Share link is invalid or has expired"})
+		return
+	}
+
+	if sh.OneTime {
+		s.burnSharedVariable(c, sh, passphrase)
+		return
+	}
+
+	// Counting the view is what checks expiry, revocation and the view limit
+	sh, err = s.store.UseShare(ctx, sh.ID, time.Now())
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Share link is invalid or has expired"})
+		return
+	}
+
+	// Passphrase shares carry their own copy sealed under the passphrase;
+	// older ones without it fall back to the variable
+	var decryptedValue string
+	if passphrase != "" && sh.Ciphertext != "" {
+		var plain []byte
+		plain, err = encryption.OpenWithPassphrase(sh.Ciphertext, passphrase)
+		decryptedValue = string(plain)
+	} else {
+		decryptedValue, err = s.decrypt(ctx, result)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Decryption failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"key":   result.Key,
+		"value": decryptedValue,
+	})
+}
+
+func (s *Server) shareVariable(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	owner, ok := s.authorize(c, permShare)
+	if !ok {
+		return
+	}
+
+	var data struct {
+		Key       string `json:"key"`
+		ExpiresIn int64  `json:"expiresIn"` // seconds, default one day
+		MaxViews  int    `json:"maxViews"`  // 0 means unlimited
+		OneTime   bool   `json:"oneTime"`
+		// One-time shares: the value sealed by the client, which keeps the key
+		Ciphertext string `json:"ciphertext"`
+
+		Recipients []string `json:"recipients"` // emails or user ids allowed to open it
+		Passphrase string   `json:"passphrase"`
+	}
+	if err := c.ShouldBindJSON(&data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Set(auditKeyKey, data.Key)
+	if !s.policyAllows(c, policy.Share, data.Key) {
+		return
+	}
+
+	ttl := defaultShareTTL
+	if data.ExpiresIn != 0 {
+		ttl = time.Duration(data.ExpiresIn) * time.Second
+	}
+	if ttl <= 0 || ttl > maxShareTTL {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("expiresIn must be between 1 and %d seconds", int64(maxShareTTL/time.Second))})
+		return
+	}
+	if data.MaxViews < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "maxViews must not be negative"})
+		return
+	}
+	if data.OneTime {
+		if data.MaxViews > 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "One-time shares allow a single view"})
+			return
+		}
+		if data.Ciphertext == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "One-time shares need the value encrypted by the client in ciphertext"})
+			return
+		}
+		if len(data.Ciphertext) > maxOneTimeCiphertext {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ciphertext is too large"})
+			return
+		}
+		if err := encryption.CheckOneTime(data.Ciphertext); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		data.MaxViews = 1
+	} else if data.Ciphertext != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ciphertext is only for one-time shares"})
+		return
+	}
+	data.Recipients = normalizeRecipients(data.Recipients)
+	if len(data.Recipients) > maxShareRecipients {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("A share can have at most %d recipients", maxShareRecipients)})
+		return
+	}
+	if data.Passphrase != "" && len(data.Passphrase) < minSharePassphrase {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Passphrase must be at least %d characters", minSharePassphrase)})
+		return
+	}
+
+	scope := scopeOf(c)
+	result, err := s.store.FindVariable(c.Request.Context(), store.VariableFilter{UserID: owner, Key: data.Key, Scope: &scope})
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Key not found"})
+		return
+	}
+
+	token, err := newToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create share"})
+		return
+	}
+
+	now := time.Now()
+	sh := &store.Share{
+		TokenHash:  hashToken(token),
+		OwnerID:    userID,
+		VariableID: result.ID,
+		Key:        result.Key,
+		MaxViews:   data.MaxViews,
+		OneTime:    data.OneTime,
+		ExpiresAt:  now.Add(ttl),
+		CreatedAt:  now,
+		Recipients: data.Recipients,
+		Ciphertext: data.Ciphertext,
+		MFA:        c.GetBool(mfaKey),
+	}
+	if data.Passphrase != "" {
+		// Keep the value only under a key derived from the passphrase, so
+		// the share can't be opened from the database without it. One-time
+		// shares wrap the client's ciphertext the same way.
+		inner := data.Ciphertext
+		if !data.OneTime {
+			if inner, err = s.decrypt(c.Request.Context(), result); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Decryption failed"})
+				return
+			}
+		}
+		hash, err := encryption.HashPassphrase(data.Passphrase)
+		if err == nil {
+			sh.Ciphertext, err = encryption.SealWithPassphrase(data.Passphrase, []byte(inner))
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create share"})
+			return
+		}
+		sh.PassphraseHash = hash
+		sh.MaxAttempts = s.maxAttempts
+	}
+
+	if err := s.store.CreateShare(c.Request.Context(), sh); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create share"})
+		return
+	}
+
+	// Generate a shareable link; one-time links get the client's key
+	// appended as a fragment
+	shareLink := fmt.Sprintf("%s/share/retrieve/%s", s.frontendURL, token)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Shareable link generated",
+		"link":    shareLink,
+		"token":   token,
+		"share":   shareJSON(sh, now),
+	})
+}
+
+// burnSharedVariable hands out a one-time share's ciphertext and wipes it.
+// Decrypting is left to the client, which holds the key from the link;
+// the server only removes the passphrase layer, if there is one.
+func (s *Server) burnSharedVariable(c *gin.Context, sh *store.Share, passphrase string) {
+	ctx := c.Request.Context()
+
+	burnt, err := s.store.BurnShare(ctx, sh.ID, time.Now())
+	if errors.Is(err, store.ErrNotFound) {
+		// Reload, someone may have opened it since we looked
+		if sh, err = s.store.GetShareByToken(ctx, sh.TokenHash); err == nil && sh.Views > 0 {
+			c.JSON(http.StatusGone, gin.H{"error": "This secret has already been viewed"})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "Share link is invalid or has expired"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open share"})
+		return
+	}
+
+	ciphertext := burnt.Ciphertext
+	if passphrase != "" {
+		plain, err := encryption.OpenWithPassphrase(ciphertext, passphrase)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Decryption failed"})
+			return
+		}
+		ciphertext = string(plain)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"key":        burnt.Key,
+		"oneTime":    true,
+		"algorithm":  "AES-256-GCM",
+		"ciphertext": ciphertext,
+	})
+}
+
+// listShares returns the caller's active shares, or all of them with ?all=true.
+func (s *Server) listShares(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	shares, err := s.store.ListShares(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch shares"})
+		return
+	}
+
+	now := time.Now()
+	all := c.Query("all") == "true"
+	out := []gin.H{}
+	for _, sh := range shares {
+		if all || sh.Active(now) {
+			out = append(out, shareJSON(sh, now))
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"shares": out})
+}
+
+func (s *Server) revokeShare(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	id := c.Param("id")
+	c.Set(auditResourceKey, "shares/"+id)
+
+	err := s.store.RevokeShare(c.Request.Context(), userID, id)
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Share not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke share"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Share revoked successfully"})
+}
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/David-mwas/SafeEnv/encryption"
+	"github.com/gin-gonic/gin"
+)
+
+// newOrgShare sets up an organization project whose developer shares a
+// variable, returning the owner's token, the member's id and the share
+// token.
+func newOrgShare(t *testing.T, ts *testServer) (ownerToken, memberID, shareToken string) {
+	t.Helper()
+	ownerToken = ts.register(t, "owner@example.com", "hunter22")
+	memberToken := ts.register(t, "dev@example.com", "hunter22")
+
+	steps := []struct {
+		token, path string
+		body        gin.H
+	}{
+		{ownerToken, "/orgs", gin.H{"name": "acme"}},
+		{ownerToken, "/orgs/acme/members", gin.H{"email": "dev@example.com", "role": "developer"}},
+		{ownerToken, "/orgs/acme/projects", gin.H{"name": "web"}},
+		{ownerToken, "/orgs/acme/projects/web/envs", gin.H{"name": "prod"}},
+		{memberToken, "/orgs/acme/projects/web/envs/prod/store", gin.H{"key": "DB_URL", "value": "postgres://"}},
+	}
+	for _, step := range steps {
+		if status, out := ts.call(t, "POST", step.path, step.token, step.body); status >= 300 {
+			t.Fatalf("POST %s: %d %v", step.path, status, out)
+		}
+	}
+
+	status, out := ts.call(t, "POST", "/orgs/acme/projects/web/envs/prod/share", memberToken, gin.H{"key": "DB_URL"})
+	if status != http.StatusOK {
+		t.Fatalf("share: %d %v", status, out)
+	}
+	_, me := ts.call(t, "GET", "/user", memberToken, nil)
+	return ownerToken, me["id"].(string), out["token"].(string)
+}
+
+func TestShareStopsWorkingWhenSharerIsRemoved(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	ownerToken, memberID, shareToken := newOrgShare(t, ts)
+	reader := ts.register(t, "reader@example.com", "hunter22")
+
+	if status, out := ts.call(t, "GET", "/share/retrieve/"+shareToken, reader, nil); status != http.StatusOK || out["value"] != "postgres://" {
+		t.Fatalf("retrieve: %d %v", status, out)
+	}
+
+	if status, out := ts.call(t, "DELETE", "/orgs/acme/members/"+memberID, ownerToken, nil); status != http.StatusOK {
+		t.Fatalf("remove member: %d %v", status, out)
+	}
+	if status, out := ts.call(t, "GET", "/share/retrieve/"+shareToken, reader, nil); status != http.StatusNotFound {
+		t.Fatalf("retrieve after removal: %d %v", status, out)
+	}
+}
+
+func TestShareStopsWorkingWhenSharerIsDemoted(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	ownerToken, memberID, shareToken := newOrgShare(t, ts)
+	reader := ts.register(t, "reader@example.com", "hunter22")
+
+	if status, out := ts.call(t, "PUT", "/orgs/acme/members/"+memberID, ownerToken, gin.H{"role": "viewer"}); status != http.StatusOK {
+		t.Fatalf("demote member: %d %v", status, out)
+	}
+	if status, out := ts.call(t, "GET", "/share/retrieve/"+shareToken, reader, nil); status != http.StatusNotFound {
+		t.Fatalf("retrieve after demotion: %d %v", status, out)
+	}
+}
+
+func TestOneTimeShareStoresClientCiphertext(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	token := ts.register(t, "alice@example.com", "hunter22")
+	if status, out := ts.call(t, "POST", "/store", token, gin.H{"key": "API_KEY", "value": "s3cret"}); status != http.StatusOK {
+		t.Fatalf("store: %d %v", status, out)
+	}
+
+	if status, out := ts.call(t, "POST", "/share", token, gin.H{"key": "API_KEY", "oneTime": true}); status != http.StatusBadRequest {
+		t.Fatalf("one-time share without ciphertext: %d %v", status, out)
+	}
+
+	key, payload, err := encryption.SealOneTime([]byte("s3cret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, out := ts.call(t, "POST", "/share", token, gin.H{"key": "API_KEY", "oneTime": true, "ciphertext": payload})
+	if status != http.StatusOK {
+		t.Fatalf("one-time share: %d %v", status, out)
+	}
+	if link := out["link"].(string); strings.Contains(link, "#") {
+		t.Fatalf("server made up a key for the link: %s", link)
+	}
+
+	shareToken := out["token"].(string)
+	status, out = ts.call(t, "GET", "/share/retrieve/"+shareToken, token, nil)
+	if status != http.StatusOK || out["ciphertext"] != payload || out["value"] != nil {
+		t.Fatalf("retrieve: %d %v", status, out)
+	}
+	if plain, err := encryption.OpenOneTime(key, out["ciphertext"].(string)); err != nil || string(plain) != "s3cret" {
+		t.Fatalf("open: %q %v", plain, err)
+	}
+	if status, out := ts.call(t, "GET", "/share/retrieve/"+shareToken, token, nil); status != http.StatusGone {
+		t.Fatalf("second retrieve: %d %v", status, out)
+	}
+}
+
+func TestPassphraseShareIsEncryptedWithThePassphrase(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	token := ts.register(t, "alice@example.com", "hunter22")
+	if status, out := ts.call(t, "POST", "/store", token, gin.H{"key": "API_KEY", "value": "s3cret"}); status != http.StatusOK {
+		t.Fatalf("store: %d %v", status, out)
+	}
+	status, out := ts.call(t, "POST", "/share", token, gin.H{"key": "API_KEY", "passphrase": "correct horse"})
+	if status != http.StatusOK {
+		t.Fatalf("share: %d %v", status, out)
+	}
+	shareToken := out["token"].(string)
+
+	_, me := ts.call(t, "GET", "/user", token, nil)
+	shares, err := ts.store.ListShares(context.Background(), me["id"].(string))
+	if err != nil || len(shares) != 1 {
+		t.Fatalf("list shares: %v %v", shares, err)
+	}
+	sealed := shares[0].Ciphertext
+	if sealed == "" || strings.Contains(sealed, "s3cret") {
+		t.Fatalf("share is not sealed: %q", sealed)
+	}
+	if _, err := encryption.OpenWithPassphrase(sealed, "wrong horse"); err == nil {
+		t.Fatal("opened with the wrong passphrase")
+	}
+
+	if status, out := ts.call(t, "POST", "/share/retrieve/"+shareToken, token, gin.H{"passphrase": "wrong horse"}); status != http.StatusForbidden {
+		t.Fatalf("wrong passphrase: %d %v", status, out)
+	}
+	status, out = ts.call(t, "POST", "/share/retrieve/"+shareToken, token, gin.H{"passphrase": "correct horse"})
+	if status != http.StatusOK || out["value"] != "s3cret" {
+		t.Fatalf("retrieve: %d %v", status, out)
+	}
+}
+
+func TestShareStopsWorkingWhenPolicyDeniesSharer(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	ownerToken, _, shareToken := newOrgShare(t, ts)
+	reader := ts.register(t, "reader@example.com", "hunter22")
+
+	// A policy binding someone else doesn't matter
+	doc := "users: [%s]\nrules:\n  - path: \"web/prod/DB_*\"\n    capabilities: [read, list]\n"
+	if status, out := ts.call(t, "PUT", "/orgs/acme/policies/others", ownerToken, gin.H{"document": fmt.Sprintf(doc, "other@example.com")}); status >= 300 {
+		t.Fatalf("put policy: %d %v", status, out)
+	}
+	if status, out := ts.call(t, "GET", "/share/retrieve/"+shareToken, reader, nil); status != http.StatusOK {
+		t.Fatalf("retrieve: %d %v", status, out)
+	}
+
+	if status, out := ts.call(t, "PUT", "/orgs/acme/policies/readonly", ownerToken, gin.H{"document": fmt.Sprintf(doc, "dev@example.com")}); status >= 300 {
+		t.Fatalf("put policy: %d %v", status, out)
+	}
+	if status, out := ts.call(t, "GET", "/share/retrieve/"+shareToken, reader, nil); status != http.StatusNotFound {
+		t.Fatalf("retrieve after a policy took away sharing: %d %v", status, out)
+	}
+}
+
+func TestShareStopsWorkingWhenOrgRequiresMFA(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	ownerToken, _, shareToken := newOrgShare(t, ts)
+	reader := ts.register(t, "reader@example.com", "hunter22")
+
+	ownerToken = loginWithMFA(t, ts, ownerToken, "owner@example.com", "hunter22")
+	if status, out := ts.call(t, "PUT", "/orgs/acme", ownerToken, gin.H{"requireMFA": true}); status != http.StatusOK {
+		t.Fatalf("require mfa: %d %v", status, out)
+	}
+	if status, out := ts.call(t, "GET", "/share/retrieve/"+shareToken, reader, nil); status != http.StatusNotFound {
+		t.Fatalf("retrieve a share made without mfa: %d %v", status, out)
+	}
+
+	// Shares made with a second factor keep working
+	status, out := ts.call(t, "POST", "/orgs/acme/projects/web/envs/prod/share", ownerToken, gin.H{"key": "DB_URL"})
+	if status != http.StatusOK {
+		t.Fatalf("share: %d %v", status, out)
+	}
+	if status, out := ts.call(t, "GET", "/share/retrieve/"+out["token"].(string), reader, nil); status != http.StatusOK || out["value"] != "postgres://" {
+		t.Fatalf("retrieve a share made with mfa: %d %v", status, out)
+	}
+}
+
+func TestPassphraseShareRevokedWhenValueChanges(t *testing.T) {
+	ts := newTestServer(t, Config{})
+	token := ts.register(t, "alice@example.com", "hunter22")
+	storeVars(t, ts, token, "/store", "API_KEY", "s3cret")
+
+	share := func(body gin.H) string {
+		t.Helper()
+		status, out := ts.call(t, "POST", "/share", token, body)
+		if status != http.StatusOK {
+			t.Fatalf("share: %d %v", status, out)
+		}
+		return out["token"].(string)
+	}
+	copied := share(gin.H{"key": "API_KEY", "passphrase": "correct horse"})
+	plain := share(gin.H{"key": "API_KEY"})
+
+	if status, out := ts.call(t, "PUT", "/keys/API_KEY", token, gin.H{"newValue": "rotated"}); status != http.StatusOK {
+		t.Fatalf("update: %d %v", status, out)
+	}
+
+	// The passphrase share's copy is of the old value, so it goes
+	if status, out := ts.call(t, "POST", "/share/retrieve/"+copied, token, gin.H{"passphrase": "correct horse"}); status != http.StatusNotFound {
+		t.Fatalf("retrieve a copy of the old value: %d %v", status, out)
+	}
+	// while other shares read the variable and see the new one
+	if status, out := ts.call(t, "GET", "/share/retrieve/"+plain, token, nil); status != http.StatusOK || out["value"] != "rotated" {
+		t.Fatalf("retrieve: %d %v", status, out)
+	}
+	_, me := ts.call(t, "GET", "/user", token, nil)
+	shares, err := ts.store.ListShares(context.Background(), me["id"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sh := range shares {
+		if sh.PassphraseHash != "" && (!sh.Revoked || sh.Ciphertext != "") {
+			t.Fatalf("passphrase share left with %+v", sh)
+		}
+	}
+}
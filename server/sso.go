@@ -0,0 +1,253 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/David-mwas/SafeEnv/oidc"
+	"github.com/David-mwas/SafeEnv/store"
+	"github.com/gin-gonic/gin"
+)
+
+// ssoLoginTTL is how long a user has to finish logging in at the provider
+const ssoLoginTTL = 10 * time.Minute
+
+var (
+	errNoSSOAccount    = errors.New("no account for this identity")
+	errSSOLinkRequired = errors.New("an account with this email exists")
+)
+
+// beginSSOLogin starts the authorization code flow. The frontend sends the
+// browser to authorizationUrl and keeps state to check when it comes back.
+func (s *Server) beginSSOLogin(c *gin.Context) {
+	s.beginSSO(c, "")
+}
+
+// beginSSOLink starts the same flow for the logged-in user, to link the
+// provider account to theirs instead of logging in.
+func (s *Server) beginSSOLink(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	s.beginSSO(c, userID)
+}
+
+func (s *Server) beginSSO(c *gin.Context, userID string) {
+	if s.sso == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not configured"})
+		return
+	}
+	ctx := c.Request.Context()
+
+	var secrets [3]string // state, nonce, PKCE verifier
+	for i := range secrets {
+		t, err := newToken()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+		secrets[i] = t
+	}
+	state, nonce, verifier := secrets[0], secrets[1], secrets[2]
+
+	authURL, err := s.sso.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider is unavailable"})
+		return
+	}
+
+	err = s.store.CreateOIDCLogin(ctx, &store.OIDCLogin{
+		StateHash: hashToken(state),
+		Nonce:     nonce,
+		Verifier:  verifier,
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(ssoLoginTTL),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"authorizationUrl": authURL, "state": state})
+}
+
+// redeemSSOLogin takes the code and state the provider redirected back
+// with and exchanges them for the provider's claims. userID is who must
+// have started the flow: "" for a login, the session's user for a link.
+func (s *Server) redeemSSOLogin(c *gin.Context, userID string) (*oidc.Claims, bool) {
+	var req struct {
+		Code  string `json:"code" binding:"required"`
+		State string `json:"state" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if s.sso == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not configured"})
+		return nil, false
+	}
+	ctx := c.Request.Context()
+
+	login, err := s.store.ConsumeOIDCLogin(ctx, hashToken(req.State))
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch login"})
+		return nil, false
+	}
+	if err != nil || !time.Now().Before(login.ExpiresAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Login expired, please try again"})
+		return nil, false
+	}
+	// A link must finish in the session that started it, or someone could
+	// bind their provider account to another user's, or the reverse
+	if login.UserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This single sign-on was started by someone else, please try again"})
+		return nil, false
+	}
+
+	claims, err := s.sso.Exchange(ctx, req.Code, login.Verifier, login.Nonce)
+	if err != nil {
+		c.Set(auditDetailKey, err.Error())
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Single sign-on failed"})
+		return nil, false
+	}
+	c.Set(auditResourceKey, "users/"+claims.Email)
+	return claims, true
+}
+
+// finishSSOLogin logs the user in like loginUser does once the provider
+// redirects back.
+func (s *Server) finishSSOLogin(c *gin.Context) {
+	claims, ok := s.redeemSSOLogin(c, "")
+	if !ok {
+		return
+	}
+
+	user, err := s.ssoUser(c.Request.Context(), claims)
+	if errors.Is(err, errNoSSOAccount) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "There is no SafeEnv account for this login"})
+		return
+	}
+	if errors.Is(err, errSSOLinkRequired) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "An account with this email already exists, log in to it and link single sign-on first", "link": true})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+		return
+	}
+	c.Set(auditActorKey, user.ID)
+
+	// Trust the provider's own 2FA when it says it did one
+	s.finishLogin(c, user, slices.Contains(claims.AMR, "mfa"))
+}
+
+// finishSSOLink adds the provider account to the logged-in user, who must
+// be the one that called beginSSOLink.
+func (s *Server) finishSSOLink(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	claims, ok := s.redeemSSOLogin(c, userID)
+	if !ok {
+		return
+	}
+	s.linkSSOIdentity(c, userID, claims)
+}
+
+// linkSSOIdentity adds the provider account to a user.
+func (s *Server) linkSSOIdentity(c *gin.Context, userID string, claims *oidc.Claims) {
+	c.Set(auditActorKey, userID)
+
+	err := s.store.LinkIdentity(c.Request.Context(), userID, store.Identity{Issuer: claims.Issuer, Subject: claims.Subject})
+	if errors.Is(err, store.ErrDuplicate) {
+		c.JSON(http.StatusConflict, gin.H{"error": "This login is already linked to an account"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to link single sign-on"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Single sign-on linked"})
+}
+
+// ssoUser finds the user for a provider account. Accounts are matched by
+// subject, or created if allowed. Emails aren't verified at registration,
+// so a local account with the same email is never linked automatically;
+// its owner links it with beginSSOLink.
+func (s *Server) ssoUser(ctx context.Context, claims *oidc.Claims) (*store.User, error) {
+	user, err := s.store.GetUserByIdentity(ctx, claims.Issuer, claims.Subject)
+	if !errors.Is(err, store.ErrNotFound) {
+		return user, err
+	}
+
+	// Only an address the provider has checked can be matched to an account
+	if claims.Email == "" || !claims.EmailVerified || !s.ssoDomainAllowed(claims.Email) {
+		return nil, errNoSSOAccount
+	}
+	identity := store.Identity{Issuer: claims.Issuer, Subject: claims.Subject}
+	email := store.NormalizeEmail(claims.Email)
+
+	_, err = s.store.GetUserByEmail(ctx, email)
+	if err == nil {
+		return nil, errSSOLinkRequired
+	}
+	if !errors.Is(err, store.ErrNotFound) {
+		return nil, err
+	}
+
+	if !s.ssoAutoJoin {
+		return nil, errNoSSOAccount
+	}
+	user = &store.User{
+		Username:   ssoUsername(claims),
+		Email:      email,
+		CreatedAt:  time.Now(),
+		Identities: []store.Identity{identity},
+	}
+	err = s.store.CreateUser(ctx, user)
+	if errors.Is(err, store.ErrDuplicate) {
+		// a simultaneous first login created the account, or someone
+		// registered the email meanwhile
+		user, err = s.store.GetUserByIdentity(ctx, claims.Issuer, claims.Subject)
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, errSSOLinkRequired
+		}
+		return user, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *Server) ssoDomainAllowed(email string) bool {
+	if len(s.ssoDomains) == 0 {
+		return true
+	}
+	_, domain, _ := strings.Cut(email, "@")
+	for _, d := range s.ssoDomains {
+		if strings.EqualFold(d, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func ssoUsername(claims *oidc.Claims) string {
+	if claims.PreferredUsername != "" {
+		return claims.PreferredUsername
+	}
+	if claims.Name != "" {
+		return claims.Name
+	}
+	name, _, _ := strings.Cut(claims.Email, "@")
+	return name
+}
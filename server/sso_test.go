@@ -0,0 +1,331 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/David-mwas/SafeEnv/oidc"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockProvider is an OpenID provider serving discovery, JWKS and a token
+// endpoint. Codes are issued by authorize instead of a login page.
+type mockProvider struct {
+	*httptest.Server
+	t   *testing.T
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]mockGrant
+}
+
+// mockGrant is what the provider remembers about an issued code.
+type mockGrant struct {
+	challenge string // PKCE challenge from the authorization request
+	nonce     string
+	claims    jwt.MapClaims
+}
+
+const mockClientID = "safeenv"
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockProvider{t: t, key: key, codes: map[string]mockGrant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+			"jwks_uri":               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		b64 := base64.RawURLEncoding
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kid": "k1",
+			"kty": "RSA",
+			"use": "sig",
+			"n":   b64.EncodeToString(key.N.Bytes()),
+			"e":   b64.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", p.token)
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// token redeems a code once, checking the PKCE verifier against the
+// challenge it was issued for.
+func (p *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	grant, ok := p.codes[r.FormValue("code")]
+	delete(p.codes, r.FormValue("code"))
+	p.mu.Unlock()
+
+	fail := func(code, description string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": code, "error_description": description})
+	}
+	switch {
+	case !ok:
+		fail("invalid_grant", "unknown code")
+		return
+	case r.FormValue("client_id") != mockClientID:
+		fail("invalid_client", "wrong client")
+		return
+	case oidc.CodeChallenge(r.FormValue("code_verifier")) != grant.challenge:
+		fail("invalid_grant", "PKCE verification failed")
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":   p.URL,
+		"aud":   mockClientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+		"nonce": grant.nonce,
+	}
+	for k, v := range grant.claims {
+		claims[k] = v
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "k1"
+	idToken, err := token.SignedString(p.key)
+	if err != nil {
+		p.t.Error(err)
+	}
+	json.NewEncoder(w).Encode(map[string]string{"access_token": "at", "token_type": "Bearer", "id_token": idToken})
+}
+
+// authorize plays the provider's login page for an authorization URL,
+// returning the code and state it would redirect back with.
+func (p *mockProvider) authorize(t *testing.T, authURL string, claims jwt.MapClaims) (code, state string) {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" || q.Get("nonce") == "" {
+		t.Fatalf("authorization URL is missing PKCE or nonce: %s", authURL)
+	}
+
+	code = randomString(t)
+	p.mu.Lock()
+	p.codes[code] = mockGrant{challenge: q.Get("code_challenge"), nonce: q.Get("nonce"), claims: claims}
+	p.mu.Unlock()
+	return code, q.Get("state")
+}
+
+func randomString(t *testing.T) string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func newSSOTestServer(t *testing.T) (*testServer, *mockProvider) {
+	p := newMockProvider(t)
+	ts := newTestServer(t, Config{
+		OIDC: &oidc.Config{
+			Issuer:      p.URL,
+			ClientID:    mockClientID,
+			RedirectURL: "http://localhost:5173/login/sso",
+		},
+		OIDCAutoProvision: true,
+	})
+	return ts, p
+}
+
+// beginSSO starts a login, or a link when token is set, and returns the
+// authorization URL.
+func beginSSO(t *testing.T, ts *testServer, token string) string {
+	t.Helper()
+	path := "/sso/begin"
+	if token != "" {
+		path = "/sso/link"
+	}
+	status, out := ts.call(t, "POST", path, token, nil)
+	if status != http.StatusOK {
+		t.Fatalf("%s: %d %v", path, status, out)
+	}
+	return out["authorizationUrl"].(string)
+}
+
+func TestSSOLoginCreatesAccount(t *testing.T) {
+	ts, p := newSSOTestServer(t)
+	claims := jwt.MapClaims{"sub": "alice", "email": "alice@example.com", "email_verified": true}
+
+	code, state := p.authorize(t, beginSSO(t, ts, ""), claims)
+	status, out := ts.call(t, "POST", "/sso/callback", "", gin.H{"code": code, "state": state})
+	if status != http.StatusOK || out["token"] == nil {
+		t.Fatalf("callback: %d %v", status, out)
+	}
+	status, user := ts.call(t, "GET", "/user", out["token"].(string), nil)
+	if status != http.StatusOK || user["email"] != "alice@example.com" {
+		t.Fatalf("user: %d %v", status, user)
+	}
+
+	// The same provider account logs into the same user
+	code, state = p.authorize(t, beginSSO(t, ts, ""), claims)
+	status, out = ts.call(t, "POST", "/sso/callback", "", gin.H{"code": code, "state": state})
+	if status != http.StatusOK {
+		t.Fatalf("second login: %d %v", status, out)
+	}
+	if _, again := ts.call(t, "GET", "/user", out["token"].(string), nil); again["id"] != user["id"] {
+		t.Fatalf("second login got user %v, want %v", again["id"], user["id"])
+	}
+}
+
+func TestSSOStateIsChecked(t *testing.T) {
+	ts, p := newSSOTestServer(t)
+	claims := jwt.MapClaims{"sub": "alice", "email": "alice@example.com", "email_verified": true}
+
+	code, _ := p.authorize(t, beginSSO(t, ts, ""), claims)
+	if status, out := ts.call(t, "POST", "/sso/callback", "", gin.H{"code": code, "state": "forged"}); status != http.StatusBadRequest {
+		t.Fatalf("unknown state: %d %v", status, out)
+	}
+
+	code, state := p.authorize(t, beginSSO(t, ts, ""), claims)
+	if status, out := ts.call(t, "POST", "/sso/callback", "", gin.H{"code": code, "state": state}); status != http.StatusOK {
+		t.Fatalf("callback: %d %v", status, out)
+	}
+	// A state works once
+	code, _ = p.authorize(t, beginSSO(t, ts, ""), claims)
+	if status, out := ts.call(t, "POST", "/sso/callback", "", gin.H{"code": code, "state": state}); status != http.StatusBadRequest {
+		t.Fatalf("reused state: %d %v", status, out)
+	}
+}
+
+func TestSSOPKCEIsChecked(t *testing.T) {
+	ts, p := newSSOTestServer(t)
+	claims := jwt.MapClaims{"sub": "alice", "email": "alice@example.com", "email_verified": true}
+
+	// A code issued to another login has another PKCE challenge, so the
+	// provider refuses this login's verifier
+	code, _ := p.authorize(t, beginSSO(t, ts, ""), claims)
+	_, state := p.authorize(t, beginSSO(t, ts, ""), claims)
+	if status, out := ts.call(t, "POST", "/sso/callback", "", gin.H{"code": code, "state": state}); status != http.StatusUnauthorized {
+		t.Fatalf("mismatched verifier: %d %v", status, out)
+	}
+}
+
+func TestSSONonceIsChecked(t *testing.T) {
+	ts, p := newSSOTestServer(t)
+	claims := jwt.MapClaims{"sub": "alice", "email": "alice@example.com", "email_verified": true}
+
+	code, state := p.authorize(t, beginSSO(t, ts, ""), claims)
+	p.mu.Lock()
+	grant := p.codes[code]
+	grant.nonce = "replayed"
+	p.codes[code] = grant
+	p.mu.Unlock()
+
+	if status, out := ts.call(t, "POST", "/sso/callback", "", gin.H{"code": code, "state": state}); status != http.StatusUnauthorized {
+		t.Fatalf("wrong nonce: %d %v", status, out)
+	}
+}
+
+func TestSSOUnverifiedEmailIsRefused(t *testing.T) {
+	ts, p := newSSOTestServer(t)
+
+	code, state := p.authorize(t, beginSSO(t, ts, ""), jwt.MapClaims{"sub": "alice", "email": "alice@example.com"})
+	if status, out := ts.call(t, "POST", "/sso/callback", "", gin.H{"code": code, "state": state}); status != http.StatusForbidden {
+		t.Fatalf("unverified email: %d %v", status, out)
+	}
+}
+
+func TestSSODoesNotLinkByEmail(t *testing.T) {
+	ts, p := newSSOTestServer(t)
+	// Someone registers the address before its owner first uses SSO
+	squatter := ts.register(t, "victim@example.com", "hunter22")
+	claims := jwt.MapClaims{"sub": "victim", "email": "victim@example.com", "email_verified": true}
+
+	code, state := p.authorize(t, beginSSO(t, ts, ""), claims)
+	status, out := ts.call(t, "POST", "/sso/callback", "", gin.H{"code": code, "state": state})
+	if status != http.StatusForbidden || out["link"] != true {
+		t.Fatalf("login matching a local account: %d %v", status, out)
+	}
+	_, me := ts.call(t, "GET", "/user", squatter, nil)
+	user, err := ts.store.GetUserByID(context.Background(), me["id"].(string))
+	if err != nil || len(user.Identities) != 0 {
+		t.Fatalf("identity was linked: %v %v", user, err)
+	}
+}
+
+func TestSSOLinkFromSession(t *testing.T) {
+	ts, p := newSSOTestServer(t)
+	token := ts.register(t, "bob@example.com", "hunter22")
+	_, user := ts.call(t, "GET", "/user", token, nil)
+	claims := jwt.MapClaims{"sub": "bob", "email": "bob@corp.example", "email_verified": true}
+
+	code, state := p.authorize(t, beginSSO(t, ts, token), claims)
+	if status, out := ts.call(t, "POST", "/sso/link/callback", token, gin.H{"code": code, "state": state}); status != http.StatusOK {
+		t.Fatalf("link: %d %v", status, out)
+	}
+
+	code, state = p.authorize(t, beginSSO(t, ts, ""), claims)
+	status, out := ts.call(t, "POST", "/sso/callback", "", gin.H{"code": code, "state": state})
+	if status != http.StatusOK {
+		t.Fatalf("login after linking: %d %v", status, out)
+	}
+	if _, got := ts.call(t, "GET", "/user", out["token"].(string), nil); got["id"] != user["id"] {
+		t.Fatalf("logged in as %v, want %v", got["id"], user["id"])
+	}
+
+	// The provider account can't be linked to a second user
+	other := ts.register(t, "carol@example.com", "hunter22")
+	code, state = p.authorize(t, beginSSO(t, ts, other), claims)
+	if status, out := ts.call(t, "POST", "/sso/link/callback", other, gin.H{"code": code, "state": state}); status != http.StatusConflict {
+		t.Fatalf("linking twice: %d %v", status, out)
+	}
+}
+
+func TestSSOLinkMustFinishInTheSameSession(t *testing.T) {
+	ts, p := newSSOTestServer(t)
+	attacker := ts.register(t, "mallory@example.com", "hunter22")
+	victim := ts.register(t, "victim@example.com", "hunter22")
+	claims := jwt.MapClaims{"sub": "mallory", "email": "mallory@corp.example", "email_verified": true}
+
+	// A link started by one user and finished by another is refused
+	code, state := p.authorize(t, beginSSO(t, ts, attacker), claims)
+	if status, out := ts.call(t, "POST", "/sso/link/callback", victim, gin.H{"code": code, "state": state}); status != http.StatusForbidden {
+		t.Fatalf("link finished by another user: %d %v", status, out)
+	}
+
+	// and the public callback doesn't finish links at all
+	code, state = p.authorize(t, beginSSO(t, ts, attacker), claims)
+	if status, out := ts.call(t, "POST", "/sso/callback", "", gin.H{"code": code, "state": state}); status != http.StatusForbidden {
+		t.Fatalf("link through the login callback: %d %v", status, out)
+	}
+
+	// nor does the link callback finish logins
+	code, state = p.authorize(t, beginSSO(t, ts, ""), claims)
+	if status, out := ts.call(t, "POST", "/sso/link/callback", victim, gin.H{"code": code, "state": state}); status != http.StatusForbidden {
+		t.Fatalf("login through the link callback: %d %v", status, out)
+	}
+
+	_, me := ts.call(t, "GET", "/user", victim, nil)
+	if user, err := ts.store.GetUserByID(context.Background(), me["id"].(string)); err != nil || len(user.Identities) != 0 {
+		t.Fatalf("identity was linked: %v %v", user, err)
+	}
+}
@@ -0,0 +1,346 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/David-mwas/SafeEnv/policy"
+	"github.com/David-mwas/SafeEnv/store"
+	"github.com/gin-gonic/gin"
+)
+
+// Delete a Key by its _id
+func (s *Server) deleteKey(c *gin.Context) {
+	owner, ok := s.authorize(c, permWrite)
+	if !ok {
+		return
+	}
+
+	keyID := c.Param("id") // Fetch _id from URL parameters
+	scope := scopeOf(c)
+
+	v, err := s.store.FindVariable(c.Request.Context(), store.VariableFilter{ID: keyID, UserID: owner, Scope: &scope})
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Key not found"})
+		return
+	}
+	if !keyAllowed(c, v.Key) || !s.policyAllows(c, policy.Delete, v.Key) {
+		return
+	}
+
+	err = s.store.DeleteVariable(c.Request.Context(), store.VariableFilter{ID: keyID, UserID: owner, Scope: &scope})
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Key not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Key deleted successfully"})
+}
+
+// Update a Key’s Value. The previous value stays available as a version.
+func (s *Server) updateKey(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var data struct {
+		NewValue string `json:"newValue"`
+		NewKey   string `json:"newKey"`
+		Note     string `json:"note"`
+	}
+
+	if err := c.ShouldBindJSON(&data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if data.NewKey != "" && !keyAllowed(c, data.NewKey) {
+		return
+	}
+
+	current, ok := s.findScopedVariable(c, permWrite, policy.Write)
+	if !ok {
+		return
+	}
+	if data.NewKey != "" && !s.policyAllows(c, policy.Write, data.NewKey) {
+		return
+	}
+
+	_, err := s.writeVariable(c.Request.Context(), current, data.NewKey, data.NewValue, userID, data.Note)
+	if errors.Is(err, store.ErrDuplicate) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Key already exists"})
+		return
+	}
+	if errors.Is(err, errConcurrentUpdate) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Key was modified by another request, try again"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Key updated successfully"})
+}
+
+func (s *Server) getUserKeys(c *gin.Context) {
+	owner, ok := s.authorize(c, permRead)
+	if !ok {
+		return
+	}
+
+	// Fetch all variables in this environment
+	scope := scopeOf(c)
+	keys, err := s.store.ListVariables(c.Request.Context(), store.VariableFilter{UserID: owner, Scope: &scope})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch keys"})
+		return
+	}
+
+	// API tokens only see the keys they may access
+	if token := apiTokenOf(c); token != nil {
+		visible := keys[:0]
+		for _, v := range keys {
+			if token.AllowsKey(v.Key) {
+				visible = append(visible, v)
+			}
+		}
+		keys = visible
+	}
+	keys, err = s.visibleKeys(c, keys)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to evaluate policies"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+func (s *Server) storeVariable(c *gin.Context) {
+	// Extract user ID from the JWT
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	owner, ok := s.authorize(c, permWrite)
+	if !ok {
+		return
+	}
+
+	var data struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+		Note  string `json:"note"`
+	}
+
+	if err := c.ShouldBindJSON(&data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Set(auditKeyKey, data.Key)
+	if !keyAllowed(c, data.Key) || !s.policyAllows(c, policy.Write, data.Key) {
+		return
+	}
+
+	scope := scopeOf(c)
+	variable := &store.Variable{
+		Key:       data.Key,
+		UserID:    owner,
+		ProjectID: scope.ProjectID,
+		EnvID:     scope.EnvID,
+		Version:   1,
+		CreatedAt: time.Now(),
+	}
+
+	encryptedValue, err := s.encrypt(c.Request.Context(), variable, data.Value)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Encryption failed"})
+		return
+	}
+	variable.Value = encryptedValue
+
+	// Store the variable in the database
+	err = s.store.CreateVariables(c.Request.Context(), variable)
+	if errors.Is(err, store.ErrDuplicate) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Key already exists"})
+		return
+	}
+	if err == nil {
+		err = s.recordVersions(c.Request.Context(), userID, data.Note, variable)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store variable"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Stored successfully"})
+}
+
+func (s *Server) retrieveVariable(c *gin.Context) {
+	owner, ok := s.authorize(c, permRead)
+	if !ok {
+		return
+	}
+
+	key := c.Param("key")
+	if !s.policyAllows(c, policy.Read, key) {
+		return
+	}
+	scope := scopeOf(c)
+	result, err := s.store.FindVariable(c.Request.Context(), store.VariableFilter{UserID: owner, Key: key, Scope: &scope})
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+		return
+	}
+	decryptedValue, err := s.decrypt(c.Request.Context(), result)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Decryption failed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"key": key, "value": decryptedValue})
+}
+
+// retrieveVariables returns every value in the scope the caller may read,
+// for running a process with them.
+func (s *Server) retrieveVariables(c *gin.Context) {
+	owner, ok := s.authorize(c, permRead)
+	if !ok {
+		return
+	}
+	values, ok := s.readableValues(c, owner)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"variables": values})
+}
+
+// readableValues decrypts the scope's variables the caller may read,
+// writing a response and returning false if that fails. Keys the caller
+// can't read are left out rather than failing the whole request.
+func (s *Server) readableValues(c *gin.Context, owner string) (map[string]string, bool) {
+	scope := scopeOf(c)
+	vars, err := s.store.ListVariables(c.Request.Context(), store.VariableFilter{UserID: owner, Scope: &scope})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch variables"})
+		return nil, false
+	}
+	bound, err := s.boundPolicies(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to evaluate policies"})
+		return nil, false
+	}
+
+	values := map[string]string{}
+	keys := []string{}
+	for _, v := range vars {
+		// Flat keys may repeat; the single retrieve returns the first one too
+		if _, seen := values[v.Key]; seen {
+			continue
+		}
+		if token := apiTokenOf(c); token != nil && !token.AllowsKey(v.Key) {
+			continue
+		}
+		if len(bound) > 0 && !policy.Evaluate(bound, policy.Read, policyPath(c, v.Key)).Allowed {
+			continue
+		}
+
+		value, err := s.decrypt(c.Request.Context(), v)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Decryption failed"})
+			return nil, false
+		}
+		values[v.Key] = value
+		keys = append(keys, v.Key)
+	}
+	sort.Strings(keys)
+	c.Set(auditDetailKey, "keys: "+strings.Join(keys, ", "))
+	return values, true
+}
+
+func (s *Server) storeVariablesBulk(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	owner, ok := s.authorize(c, permWrite)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Variables map[string]string `json:"variables"`
+		Note      string            `json:"note"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	keys := make([]string, 0, len(request.Variables))
+	for key := range request.Variables {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	c.Set(auditDetailKey, "keys: "+strings.Join(keys, ", "))
+	if !keyAllowed(c, keys...) || !s.policyAllows(c, policy.Write, keys...) {
+		return
+	}
+
+	// Unwrap the owner's data key once for the whole batch
+	dek, err := s.ownerDataKey(c.Request.Context(), owner)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Encryption failed"})
+		return
+	}
+
+	var variables []*store.Variable
+	scope := scopeOf(c)
+
+	for key, value := range request.Variables {
+		variable := &store.Variable{
+			UserID:    owner,
+			ProjectID: scope.ProjectID,
+			EnvID:     scope.EnvID,
+			Key:       key,
+			Version:   1,
+			CreatedAt: time.Now(),
+		}
+
+		encryptedValue, err := seal(dek, variable, value)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Encryption failed",
+				"text": err,
+			})
+			return
+		}
+
+		variable.Value = encryptedValue
+		variables = append(variables, variable)
+	}
+
+	err = s.store.CreateVariables(c.Request.Context(), variables...)
+	if errors.Is(err, store.ErrDuplicate) {
+		c.JSON(http.StatusConflict, gin.H{"error": "One or more keys already exist"})
+		return
+	}
+	if err == nil {
+		err = s.recordVersions(c.Request.Context(), userID, request.Note, variables...)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Variables stored successfully"})
+}
@@ -0,0 +1,289 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/David-mwas/SafeEnv/policy"
+	"github.com/David-mwas/SafeEnv/store"
+	"github.com/gin-gonic/gin"
+)
+
+// errConcurrentUpdate is returned when a variable changed between being
+// read and being written.
+var errConcurrentUpdate = errors.New("variable was modified concurrently")
+
+// newVersion builds the history record for v's current value.
+func newVersion(v *store.Variable, authorID, note string) *store.VariableVersion {
+	return &store.VariableVersion{
+		VariableID: v.ID,
+		Version:    v.Version,
+		UserID:     v.UserID,
+		ProjectID:  v.ProjectID,
+		EnvID:      v.EnvID,
+		Key:        v.Key,
+		Value:      v.Value,
+		AuthorID:   authorID,
+		Note:       note,
+		CreatedAt:  time.Now(),
+	}
+}
+
+// recordVersions saves the current value of each freshly written variable
+// as a version and drops versions beyond the retention limit.
+func (s *Server) recordVersions(ctx context.Context, authorID, note string, vars ...*store.Variable) error {
+	versions := make([]*store.VariableVersion, 0, len(vars))
+	for _, v := range vars {
+		versions = append(versions, newVersion(v, authorID, note))
+	}
+	if err := s.store.CreateVersions(ctx, versions...); err != nil {
+		return err
+	}
+
+	if s.retention > 0 {
+		for _, v := range vars {
+			if _, err := s.store.PruneVersions(ctx, v.ID, s.retention); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// snapshotUnversioned records the value of a variable written before
+// history existed as its version 1, so the first update does not lose it.
+func (s *Server) snapshotUnversioned(ctx context.Context, v *store.Variable) error {
+	plaintext, err := s.decrypt(ctx, v)
+	if err != nil {
+		return err
+	}
+
+	// Reseal with the data key so the snapshot survives master key rotation
+	snapshot := *v
+	snapshot.Version = 1
+	snapshot.Value, err = s.encrypt(ctx, &snapshot, plaintext)
+	if err != nil {
+		return err
+	}
+
+	version := newVersion(&snapshot, v.UserID, "")
+	version.CreatedAt = v.CreatedAt
+
+	// A concurrent writer may have taken the snapshot already
+	err = s.store.CreateVersions(ctx, version)
+	if err != nil && !errors.Is(err, store.ErrDuplicate) {
+		return err
+	}
+	return nil
+}
+
+// writeVariable stores plaintext as the next version of current, renaming
+// it to newKey when that is set. It returns the variable as written.
+func (s *Server) writeVariable(ctx context.Context, current *store.Variable, newKey, plaintext, authorID, note string) (*store.Variable, error) {
+	if current.Version == 0 {
+		if err := s.snapshotUnversioned(ctx, current); err != nil {
+			return nil, err
+		}
+		current.Version = 1
+	}
+
+	next := *current
+	if newKey != "" {
+		next.Key = newKey
+	}
+	next.Version = current.Version + 1
+
+	// The ciphertext is bound to the key name it will be stored under
+	sealed, err := s.encrypt(ctx, &next, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	next.Value = sealed
+
+	// Passphrase shares hold a copy of the value being replaced
+	if err := s.store.RevokeShareCopies(ctx, current.ID); err != nil {
+		return nil, err
+	}
+
+	// Matching on the old value makes sure no other write slipped in between
+	err = s.store.UpdateVariable(ctx,
+		store.VariableFilter{ID: current.ID, Value: current.Value},
+		store.VariableUpdate{Key: newKey, Value: sealed, Version: next.Version},
+	)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, errConcurrentUpdate
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.recordVersions(ctx, authorID, note, &next); err != nil {
+		return nil, err
+	}
+	return &next, nil
+}
+
+// findScopedVariable loads the variable named by :key in the request's
+// scope if the caller's role allows perm and their policies capability on
+// it, writing a response and returning false if it can't.
+func (s *Server) findScopedVariable(c *gin.Context, perm permission, capability policy.Capability) (*store.Variable, bool) {
+	owner, ok := s.authorize(c, perm)
+	if !ok || !s.policyAllows(c, capability, c.Param("key")) {
+		return nil, false
+	}
+
+	scope := scopeOf(c)
+	v, err := s.store.FindVariable(c.Request.Context(), store.VariableFilter{
+		UserID: owner,
+		Key:    c.Param("key"),
+		Scope:  &scope,
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Key not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch key"})
+		return nil, false
+	}
+	return v, true
+}
+
+// findVersion loads the version named by :version of v.
+func (s *Server) findVersion(c *gin.Context, v *store.Variable) (*store.VariableVersion, bool) {
+	number, err := strconv.Atoi(c.Param("version"))
+	if err != nil || number < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid version"})
+		return nil, false
+	}
+
+	version, err := s.store.GetVersion(c.Request.Context(), v.ID, number)
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch version"})
+		return nil, false
+	}
+	return version, true
+}
+
+// decryptVersion opens a version's value with the key name and scope it
+// was sealed under.
+func (s *Server) decryptVersion(ctx context.Context, version *store.VariableVersion) (string, error) {
+	return s.decrypt(ctx, &store.Variable{
+		UserID:    version.UserID,
+		ProjectID: version.ProjectID,
+		EnvID:     version.EnvID,
+		Key:       version.Key,
+		Value:     version.Value,
+	})
+}
+
+func (s *Server) listVersions(c *gin.Context) {
+	v, ok := s.findScopedVariable(c, permRead, policy.Read)
+	if !ok {
+		return
+	}
+
+	versions, err := s.store.ListVersions(c.Request.Context(), v.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch versions"})
+		return
+	}
+
+	// Values are only returned one version at a time
+	out := make([]gin.H, 0, len(versions))
+	for _, version := range versions {
+		out = append(out, gin.H{
+			"version":   version.Version,
+			"key":       version.Key,
+			"authorID":  version.AuthorID,
+			"note":      version.Note,
+			"createdAt": version.CreatedAt,
+			"current":   version.Version == v.Version,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"key": v.Key, "currentVersion": v.Version, "versions": out})
+}
+
+func (s *Server) getVersion(c *gin.Context) {
+	v, ok := s.findScopedVariable(c, permRead, policy.Read)
+	if !ok {
+		return
+	}
+	version, ok := s.findVersion(c, v)
+	if !ok {
+		return
+	}
+
+	value, err := s.decryptVersion(c.Request.Context(), version)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Decryption failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"key":       version.Key,
+		"version":   version.Version,
+		"value":     value,
+		"authorID":  version.AuthorID,
+		"note":      version.Note,
+		"createdAt": version.CreatedAt,
+	})
+}
+
+// rollbackVersion makes an old version's value current again. The rollback
+// is itself a new version, so it can be undone the same way.
+func (s *Server) rollbackVersion(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	v, ok := s.findScopedVariable(c, permWrite, policy.Write)
+	if !ok {
+		return
+	}
+	version, ok := s.findVersion(c, v)
+	if !ok {
+		return
+	}
+
+	var data struct {
+		Note string `json:"note"`
+	}
+	// An empty body is fine, the note is optional
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&data); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if data.Note == "" {
+		data.Note = fmt.Sprintf("Rolled back to version %d", version.Version)
+	}
+
+	value, err := s.decryptVersion(c.Request.Context(), version)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Decryption failed"})
+		return
+	}
+
+	written, err := s.writeVariable(c.Request.Context(), v, "", value, userID, data.Note)
+	if errors.Is(err, errConcurrentUpdate) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Key was modified by another request, try again"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to roll back key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Key rolled back successfully", "version": written.Version})
+}
@@ -0,0 +1,77 @@
+package store
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// API token access levels.
+const (
+	TokenRead  = "read"
+	TokenWrite = "write"
+)
+
+// APIToken is a machine credential acting for its owner, e.g. a CI job
+// that reads secrets. Only a hash of the token is stored.
+type APIToken struct {
+	ID        string `bson:"_id,omitempty" json:"id"`
+	UserID    string `bson:"userID" json:"userID"`
+	Name      string `bson:"name" json:"name"`
+	TokenHash string `bson:"tokenHash" json:"tokenHash"`
+	Prefix    string `bson:"prefix" json:"prefix"` // first characters, to tell tokens apart
+	Access    string `bson:"access" json:"access"` // TokenRead or TokenWrite
+	// ProjectIDs limits the token to these projects; empty allows every
+	// project and the unscoped variables.
+	ProjectIDs  []string  `bson:"projectIDs,omitempty" json:"projectIDs,omitempty"`
+	KeyPrefixes []string  `bson:"keyPrefixes,omitempty" json:"keyPrefixes,omitempty"`
+	Revoked     bool      `bson:"revoked" json:"revoked"`
+	MFA         bool      `bson:"mfa" json:"mfa"` // created from a session opened with a second factor
+	ExpiresAt   time.Time `bson:"expiresAt" json:"expiresAt"`
+	CreatedAt   time.Time `bson:"createdAt" json:"createdAt"`
+	LastUsedAt  time.Time `bson:"lastUsedAt,omitempty" json:"lastUsedAt,omitempty"`
+	LastUsedIP  string    `bson:"lastUsedIP,omitempty" json:"lastUsedIP,omitempty"`
+}
+
+// Active reports whether the token can still be used at now.
+func (t *APIToken) Active(now time.Time) bool {
+	return !t.Revoked && now.Before(t.ExpiresAt)
+}
+
+// AllowsProject reports whether the token may touch variables in the
+// project; an empty id means the unscoped variables.
+func (t *APIToken) AllowsProject(projectID string) bool {
+	if len(t.ProjectIDs) == 0 {
+		return true
+	}
+	for _, id := range t.ProjectIDs {
+		if id == projectID {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsKey reports whether key matches one of the token's key prefixes.
+func (t *APIToken) AllowsKey(key string) bool {
+	if len(t.KeyPrefixes) == 0 {
+		return true
+	}
+	for _, prefix := range t.KeyPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// APITokenStore persists API tokens.
+type APITokenStore interface {
+	CreateAPIToken(ctx context.Context, t *APIToken) error
+	GetAPITokenByHash(ctx context.Context, tokenHash string) (*APIToken, error)
+	// ListAPITokens returns the user's tokens, newest first.
+	ListAPITokens(ctx context.Context, userID string) ([]*APIToken, error)
+	RevokeAPIToken(ctx context.Context, userID, id string) error
+	// TouchAPIToken records that the token was used.
+	TouchAPIToken(ctx context.Context, id, ip string, now time.Time) error
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Audit outcomes.
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+	AuditDenied  = "denied"
+)
+
+// AuditEvent records one access to or change of a secret, or a sign-in.
+// Events form a hash chain per tenant: each one carries the hash of the
+// previous event in the same tenant, see package audit.
+type AuditEvent struct {
+	ID        string    `bson:"_id,omitempty" json:"id"`
+	Tenant    string    `bson:"tenant" json:"tenant"`
+	Seq       int64     `bson:"seq" json:"seq"` // position in the tenant's chain, from 1
+	PrevHash  string    `bson:"prevHash" json:"prevHash"`
+	Hash      string    `bson:"hash" json:"hash"`
+	ActorID   string    `bson:"actorID,omitempty" json:"actorID,omitempty"` // empty for failed logins of unknown users
+	OwnerID   string    `bson:"ownerID,omitempty" json:"ownerID,omitempty"` // owner of the resource, if any
+	Action    string    `bson:"action" json:"action"`                       // e.g. "variable.retrieve"
+	Resource  string    `bson:"resource" json:"resource"`                   // e.g. "projects/web/envs/prod/keys/DB_URL"
+	IP        string    `bson:"ip" json:"ip"`
+	UserAgent string    `bson:"userAgent" json:"userAgent"`
+	Outcome   string    `bson:"outcome" json:"outcome"`
+	Status    int       `bson:"status" json:"status"` // HTTP status returned to the caller
+	Detail    string    `bson:"detail,omitempty" json:"detail,omitempty"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// AuditFilter narrows audit queries. Empty fields are ignored.
+type AuditFilter struct {
+	Subject  string   // matches events where this user is the actor or the owner
+	Owners   []string // with Subject, also matches events these own, e.g. organizations
+	ActorID  string
+	Action   string
+	Outcome  string
+	Resource string // prefix match
+	Since    time.Time
+	Until    time.Time
+	Before   string // only events older than this event id, for paging
+	Limit    int
+}
+
+// AuditCheckpoint is a signed statement of a tenant's chain head, so a
+// chain rewritten from some point on no longer matches.
+type AuditCheckpoint struct {
+	ID        string    `bson:"_id,omitempty" json:"id"`
+	Tenant    string    `bson:"tenant" json:"tenant"`
+	Seq       int64     `bson:"seq" json:"seq"`
+	Hash      string    `bson:"hash" json:"hash"`
+	KeyID     string    `bson:"keyID" json:"keyID"`
+	Signature string    `bson:"signature" json:"signature"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// AuditStore is the append-only audit log. There is deliberately no way to
+// change or remove an event once written.
+type AuditStore interface {
+	// AppendAudit returns ErrDuplicate if e.Seq is already taken in e.Tenant.
+	AppendAudit(ctx context.Context, e *AuditEvent) error
+	// ListAudit returns matching events, newest first.
+	ListAudit(ctx context.Context, f AuditFilter) ([]*AuditEvent, error)
+	// LastAudit returns the head of a tenant's chain.
+	LastAudit(ctx context.Context, tenant string) (*AuditEvent, error)
+	// ScanAudit returns up to limit events of a tenant with Seq > afterSeq, in order.
+	ScanAudit(ctx context.Context, tenant string, afterSeq int64, limit int) ([]*AuditEvent, error)
+	AuditTenants(ctx context.Context) ([]string, error)
+
+	SaveAuditCheckpoint(ctx context.Context, cp *AuditCheckpoint) error
+	// ListAuditCheckpoints returns a tenant's checkpoints, oldest first.
+	ListAuditCheckpoints(ctx context.Context, tenant string) ([]*AuditCheckpoint, error)
+}
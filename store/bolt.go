@@ -0,0 +1,1976 @@
+package store
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	bucketUsers          = []byte("users")
+	bucketVariables      = []byte("variables")
+	bucketPasswordResets = []byte("password_resets")
+	bucketJobs           = []byte("jobs")
+	bucketProjects       = []byte("projects")
+	bucketEnvironments   = []byte("environments")
+	bucketVersions       = []byte("variable_versions")
+	bucketAudit          = []byte("audit_log")
+	bucketAuditChain     = []byte("audit_chain") // one sub-bucket per tenant: seq -> event id
+	bucketCheckpoints    = []byte("audit_checkpoints")
+	bucketShares         = []byte("shares")
+	bucketSessions       = []byte("sessions")
+	bucketAPITokens      = []byte("api_tokens")
+	bucketChallenges     = []byte("webauthn_challenges")
+	bucketOIDCLogins     = []byte("oidc_logins")
+	bucketOrgs           = []byte("organizations")
+	bucketMembers        = []byte("org_members")
+	bucketTeams          = []byte("teams")
+	bucketPolicies       = []byte("policies")
+	bucketInvites        = []byte("org_invites")
+)
+
+var boltBuckets = [][]byte{
+	bucketUsers,
+	bucketVariables,
+	bucketPasswordResets,
+	bucketJobs,
+	bucketProjects,
+	bucketEnvironments,
+	bucketVersions,
+	bucketAudit,
+	bucketAuditChain,
+	bucketCheckpoints,
+	bucketShares,
+	bucketSessions,
+	bucketAPITokens,
+	bucketChallenges,
+	bucketOIDCLogins,
+	bucketOrgs,
+	bucketMembers,
+	bucketTeams,
+	bucketPolicies,
+	bucketInvites,
+}
+
+// Bolt is an embedded, single-file Store for running SafeEnv without MongoDB.
+// Records are stored as JSON keyed by id; lookups on other fields scan the bucket.
+type Bolt struct {
+	db *bolt.DB
+}
+
+// OpenBolt opens (creating if needed) the database file at path.
+func OpenBolt(path string) (*Bolt, error) {
+	if path == "" {
+		path = "safeenv.db"
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("store: open bolt %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range boltBuckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Bolt{db: db}, nil
+}
+
+// Close closes the database file.
+func (b *Bolt) Close(ctx context.Context) error {
+	return b.db.Close()
+}
+
+// newID returns an id with the same shape as the MongoDB backend's ids.
+func newID() string {
+	return primitive.NewObjectID().Hex()
+}
+
+func putJSON(tx *bolt.Tx, bucket []byte, id string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(bucket).Put([]byte(id), data)
+}
+
+func getJSON(tx *bolt.Tx, bucket []byte, id string, v interface{}) error {
+	data := tx.Bucket(bucket).Get([]byte(id))
+	if data == nil {
+		return ErrNotFound
+	}
+	return json.Unmarshal(data, v)
+}
+
+// scanJSON decodes every record in bucket into a fresh T and calls fn with its id.
+// Returning errStop from fn ends the scan early.
+func scanJSON[T any](tx *bolt.Tx, bucket []byte, fn func(id string, v *T) error) error {
+	err := tx.Bucket(bucket).ForEach(func(k, data []byte) error {
+		v := new(T)
+		if err := json.Unmarshal(data, v); err != nil {
+			return err
+		}
+		return fn(string(k), v)
+	})
+	if err == errStop {
+		return nil
+	}
+	return err
+}
+
+var errStop = errors.New("stop")
+
+// scanAfter returns up to limit records with ids greater than afterID.
+// Ids are ObjectID hex strings, so byte order is id order.
+func scanAfter[T any](tx *bolt.Tx, bucket []byte, afterID string, limit int) ([]*T, error) {
+	out := []*T{}
+	c := tx.Bucket(bucket).Cursor()
+
+	k, data := c.First()
+	if afterID != "" {
+		k, data = c.Seek([]byte(afterID))
+		if k != nil && string(k) == afterID {
+			k, data = c.Next()
+		}
+	}
+
+	for ; k != nil && len(out) < limit; k, data = c.Next() {
+		v := new(T)
+		if err := json.Unmarshal(data, v); err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+func (b *Bolt) count(bucket []byte) (int64, error) {
+	var n int64
+	err := b.db.View(func(tx *bolt.Tx) error {
+		n = int64(tx.Bucket(bucket).Stats().KeyN)
+		return nil
+	})
+	return n, err
+}
+
+// users
+
+func (b *Bolt) CreateUser(ctx context.Context, u *User) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if _, err := b.findUser(tx, u.Email); err == nil {
+			return ErrDuplicate
+		} else if err != ErrNotFound {
+			return err
+		}
+		for _, id := range u.Identities {
+			if _, err := b.findUserByIdentity(tx, id); err == nil {
+				return ErrDuplicate
+			} else if err != ErrNotFound {
+				return err
+			}
+		}
+		u.ID = newID()
+		return putJSON(tx, bucketUsers, u.ID, u)
+	})
+}
+
+func (b *Bolt) GetUserByID(ctx context.Context, id string) (*User, error) {
+	var u User
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return getJSON(tx, bucketUsers, id, &u)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// findUser matches emails normalized, as accounts made before emails were
+// normalized on the way in are stored as typed.
+func (b *Bolt) findUser(tx *bolt.Tx, email string) (*User, error) {
+	email = NormalizeEmail(email)
+	var found *User
+	err := scanJSON(tx, bucketUsers, func(id string, u *User) error {
+		if NormalizeEmail(u.Email) == email {
+			found = u
+			return errStop
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, ErrNotFound
+	}
+	return found, nil
+}
+
+func (b *Bolt) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	var u *User
+	err := b.db.View(func(tx *bolt.Tx) (err error) {
+		u, err = b.findUser(tx, email)
+		return err
+	})
+	return u, err
+}
+
+func (b *Bolt) UpdateUserPassword(ctx context.Context, email, passwordHash string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		u, err := b.findUser(tx, email)
+		if err != nil {
+			return err
+		}
+		u.PasswordHash = passwordHash
+		return putJSON(tx, bucketUsers, u.ID, u)
+	})
+}
+
+func (b *Bolt) SetUserDataKey(ctx context.Context, userID, wrapped, expected string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		var u User
+		if err := getJSON(tx, bucketUsers, userID, &u); err != nil {
+			return err
+		}
+		if u.DataKey != expected {
+			return ErrNotFound
+		}
+		u.DataKey = wrapped
+		return putJSON(tx, bucketUsers, u.ID, &u)
+	})
+}
+
+func (b *Bolt) SetUserMFA(ctx context.Context, userID string, mfa *UserMFA) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		var u User
+		if err := getJSON(tx, bucketUsers, userID, &u); err != nil {
+			return err
+		}
+		u.MFA = *mfa
+		return putJSON(tx, bucketUsers, u.ID, &u)
+	})
+}
+
+func (b *Bolt) ClaimMFAAttempt(ctx context.Context, userID string, now time.Time, maxFailures int, lockout time.Duration) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		var u User
+		if err := getJSON(tx, bucketUsers, userID, &u); err != nil {
+			return err
+		}
+		if u.MFA.Failures >= maxFailures && now.Sub(u.MFA.LastFailureAt) < lockout {
+			return ErrNotFound
+		}
+		u.MFA.Failures++
+		u.MFA.LastFailureAt = now
+		return putJSON(tx, bucketUsers, u.ID, &u)
+	})
+}
+
+func (b *Bolt) ConsumeMFA(ctx context.Context, userID string, step int64, recoveryHash string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		var u User
+		if err := getJSON(tx, bucketUsers, userID, &u); err != nil {
+			return err
+		}
+
+		if step > 0 {
+			if step <= u.MFA.LastStep {
+				return ErrNotFound
+			}
+			u.MFA.LastStep = step
+		} else {
+			i := slices.Index(u.MFA.RecoveryCodes, recoveryHash)
+			if i < 0 {
+				return ErrNotFound
+			}
+			u.MFA.RecoveryCodes = slices.Delete(u.MFA.RecoveryCodes, i, i+1)
+		}
+
+		u.MFA.Failures = 0
+		return putJSON(tx, bucketUsers, u.ID, &u)
+	})
+}
+
+func (b *Bolt) ScanUsers(ctx context.Context, afterID string, limit int) ([]*User, error) {
+	var users []*User
+	err := b.db.View(func(tx *bolt.Tx) (err error) {
+		users, err = scanAfter[User](tx, bucketUsers, afterID, limit)
+		return err
+	})
+	return users, err
+}
+
+func (b *Bolt) CountUsers(ctx context.Context) (int64, error) {
+	return b.count(bucketUsers)
+}
+
+// variables
+
+func (f VariableFilter) matches(v *Variable) bool {
+	return (f.ID == "" || f.ID == v.ID) &&
+		(f.UserID == "" || f.UserID == v.UserID) &&
+		(f.Key == "" || f.Key == v.Key) &&
+		(f.Value == "" || f.Value == v.Value) &&
+		(f.Scope == nil || *f.Scope == v.Scope())
+}
+
+// checkUniqueKey returns ErrDuplicate if another variable in v's environment
+// already uses v's key. Unscoped variables are not checked.
+func (b *Bolt) checkUniqueKey(tx *bolt.Tx, v *Variable) error {
+	if v.ProjectID == "" {
+		return nil
+	}
+	sc := v.Scope()
+	existing, err := b.findVariable(tx, VariableFilter{Key: v.Key, Scope: &sc})
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if existing.ID != v.ID {
+		return ErrDuplicate
+	}
+	return nil
+}
+
+func (b *Bolt) CreateVariables(ctx context.Context, vars ...*Variable) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		for _, v := range vars {
+			v.ID = newID()
+			if err := b.checkUniqueKey(tx, v); err != nil {
+				return err
+			}
+			if err := putJSON(tx, bucketVariables, v.ID, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *Bolt) findVariable(tx *bolt.Tx, f VariableFilter) (*Variable, error) {
+	if f.ID != "" {
+		var v Variable
+		if err := getJSON(tx, bucketVariables, f.ID, &v); err != nil {
+			return nil, err
+		}
+		if !f.matches(&v) {
+			return nil, ErrNotFound
+		}
+		return &v, nil
+	}
+
+	var found *Variable
+	err := scanJSON(tx, bucketVariables, func(id string, v *Variable) error {
+		if f.matches(v) {
+			found = v
+			return errStop
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, ErrNotFound
+	}
+	return found, nil
+}
+
+func (b *Bolt) FindVariable(ctx context.Context, f VariableFilter) (*Variable, error) {
+	var v *Variable
+	err := b.db.View(func(tx *bolt.Tx) (err error) {
+		v, err = b.findVariable(tx, f)
+		return err
+	})
+	return v, err
+}
+
+func (b *Bolt) ListVariables(ctx context.Context, f VariableFilter) ([]*Variable, error) {
+	vars := []*Variable{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return scanJSON(tx, bucketVariables, func(id string, v *Variable) error {
+			if f.matches(v) {
+				vars = append(vars, v)
+			}
+			return nil
+		})
+	})
+	return vars, err
+}
+
+func (b *Bolt) UpdateVariable(ctx context.Context, f VariableFilter, upd VariableUpdate) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		v, err := b.findVariable(tx, f)
+		if err != nil {
+			return err
+		}
+		if upd.Key != "" && upd.Key != v.Key {
+			v.Key = upd.Key
+			if err := b.checkUniqueKey(tx, v); err != nil {
+				return err
+			}
+		}
+		if upd.Version != 0 {
+			v.Version = upd.Version
+		}
+		v.Value = upd.Value
+		return putJSON(tx, bucketVariables, v.ID, v)
+	})
+}
+
+func (b *Bolt) DeleteVariable(ctx context.Context, f VariableFilter) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		v, err := b.findVariable(tx, f)
+		if err != nil {
+			return err
+		}
+		err = deleteWhere(tx, bucketVersions, func(ver *VariableVersion) bool { return ver.VariableID == v.ID })
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(bucketVariables).Delete([]byte(v.ID))
+	})
+}
+
+func (b *Bolt) ScanVariables(ctx context.Context, afterID string, limit int) ([]*Variable, error) {
+	var vars []*Variable
+	err := b.db.View(func(tx *bolt.Tx) (err error) {
+		vars, err = scanAfter[Variable](tx, bucketVariables, afterID, limit)
+		return err
+	})
+	return vars, err
+}
+
+func (b *Bolt) CountVariables(ctx context.Context) (int64, error) {
+	return b.count(bucketVariables)
+}
+
+// password resets
+
+func (b *Bolt) CreatePasswordReset(ctx context.Context, r *PasswordReset) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx, bucketPasswordResets, r.Token, r)
+	})
+}
+
+func (b *Bolt) ConsumePasswordReset(ctx context.Context, token string) (*PasswordReset, error) {
+	var r PasswordReset
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		if err := getJSON(tx, bucketPasswordResets, token, &r); err != nil {
+			return err
+		}
+		return tx.Bucket(bucketPasswordResets).Delete([]byte(token))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (b *Bolt) DeletePasswordResets(ctx context.Context, email string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		var tokens []string
+		err := scanJSON(tx, bucketPasswordResets, func(id string, r *PasswordReset) error {
+			if r.Email == email {
+				tokens = append(tokens, id)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, t := range tokens {
+			if err := tx.Bucket(bucketPasswordResets).Delete([]byte(t)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// jobs
+
+func (b *Bolt) GetJob(ctx context.Context, id string) (*Job, error) {
+	var j Job
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return getJSON(tx, bucketJobs, id, &j)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+func (b *Bolt) SaveJob(ctx context.Context, j *Job) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx, bucketJobs, j.ID, j)
+	})
+}
+
+// deleteWhere deletes every record in bucket for which match returns true.
+func deleteWhere[T any](tx *bolt.Tx, bucket []byte, match func(v *T) bool) error {
+	var ids []string
+	err := scanJSON(tx, bucket, func(id string, v *T) error {
+		if match(v) {
+			ids = append(ids, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := tx.Bucket(bucket).Delete([]byte(id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// projects
+
+func (b *Bolt) findProject(tx *bolt.Tx, ownerID, name string) (*Project, error) {
+	var found *Project
+	err := scanJSON(tx, bucketProjects, func(id string, p *Project) error {
+		if p.OwnerID == ownerID && p.Name == name {
+			found = p
+			return errStop
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, ErrNotFound
+	}
+	return found, nil
+}
+
+func (b *Bolt) CreateProject(ctx context.Context, p *Project) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if _, err := b.findProject(tx, p.OwnerID, p.Name); err == nil {
+			return ErrDuplicate
+		}
+		p.ID = newID()
+		return putJSON(tx, bucketProjects, p.ID, p)
+	})
+}
+
+func (b *Bolt) GetProject(ctx context.Context, ownerID, name string) (*Project, error) {
+	var p *Project
+	err := b.db.View(func(tx *bolt.Tx) (err error) {
+		p, err = b.findProject(tx, ownerID, name)
+		return err
+	})
+	return p, err
+}
+
+func (b *Bolt) ListProjects(ctx context.Context, ownerID string) ([]*Project, error) {
+	projects := []*Project{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return scanJSON(tx, bucketProjects, func(id string, p *Project) error {
+			if p.OwnerID == ownerID {
+				projects = append(projects, p)
+			}
+			return nil
+		})
+	})
+	sort.Slice(projects, func(i, j int) bool { return projects[i].Name < projects[j].Name })
+	return projects, err
+}
+
+func (b *Bolt) UpdateProject(ctx context.Context, id string, upd ProjectUpdate) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		var p Project
+		if err := getJSON(tx, bucketProjects, id, &p); err != nil {
+			return err
+		}
+		if upd.Name != "" && upd.Name != p.Name {
+			if _, err := b.findProject(tx, p.OwnerID, upd.Name); err == nil {
+				return ErrDuplicate
+			}
+			p.Name = upd.Name
+		}
+		if upd.Description != nil {
+			p.Description = *upd.Description
+		}
+		return putJSON(tx, bucketProjects, p.ID, &p)
+	})
+}
+
+func (b *Bolt) DeleteProject(ctx context.Context, id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketProjects).Get([]byte(id)) == nil {
+			return ErrNotFound
+		}
+		err := deleteWhere(tx, bucketVersions, func(v *VariableVersion) bool { return v.ProjectID == id })
+		if err != nil {
+			return err
+		}
+		err = deleteWhere(tx, bucketVariables, func(v *Variable) bool { return v.ProjectID == id })
+		if err != nil {
+			return err
+		}
+		err = deleteWhere(tx, bucketEnvironments, func(e *Environment) bool { return e.ProjectID == id })
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(bucketProjects).Delete([]byte(id))
+	})
+}
+
+// environments
+
+func (b *Bolt) findEnvironment(tx *bolt.Tx, projectID, name string) (*Environment, error) {
+	var found *Environment
+	err := scanJSON(tx, bucketEnvironments, func(id string, e *Environment) error {
+		if e.ProjectID == projectID && e.Name == name {
+			found = e
+			return errStop
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, ErrNotFound
+	}
+	return found, nil
+}
+
+func (b *Bolt) CreateEnvironment(ctx context.Context, e *Environment) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if _, err := b.findEnvironment(tx, e.ProjectID, e.Name); err == nil {
+			return ErrDuplicate
+		}
+		e.ID = newID()
+		return putJSON(tx, bucketEnvironments, e.ID, e)
+	})
+}
+
+func (b *Bolt) GetEnvironment(ctx context.Context, projectID, name string) (*Environment, error) {
+	var e *Environment
+	err := b.db.View(func(tx *bolt.Tx) (err error) {
+		e, err = b.findEnvironment(tx, projectID, name)
+		return err
+	})
+	return e, err
+}
+
+func (b *Bolt) ListEnvironments(ctx context.Context, projectID string) ([]*Environment, error) {
+	envs := []*Environment{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return scanJSON(tx, bucketEnvironments, func(id string, e *Environment) error {
+			if e.ProjectID == projectID {
+				envs = append(envs, e)
+			}
+			return nil
+		})
+	})
+	sort.Slice(envs, func(i, j int) bool { return envs[i].Name < envs[j].Name })
+	return envs, err
+}
+
+func (b *Bolt) RenameEnvironment(ctx context.Context, id, name string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		var e Environment
+		if err := getJSON(tx, bucketEnvironments, id, &e); err != nil {
+			return err
+		}
+		if name == e.Name {
+			return nil
+		}
+		if _, err := b.findEnvironment(tx, e.ProjectID, name); err == nil {
+			return ErrDuplicate
+		}
+		e.Name = name
+		return putJSON(tx, bucketEnvironments, e.ID, &e)
+	})
+}
+
+func (b *Bolt) DeleteEnvironment(ctx context.Context, id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketEnvironments).Get([]byte(id)) == nil {
+			return ErrNotFound
+		}
+		err := deleteWhere(tx, bucketVersions, func(v *VariableVersion) bool { return v.EnvID == id })
+		if err != nil {
+			return err
+		}
+		err = deleteWhere(tx, bucketVariables, func(v *Variable) bool { return v.EnvID == id })
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(bucketEnvironments).Delete([]byte(id))
+	})
+}
+
+// versions
+
+// listVersions returns a variable's versions, newest first.
+func listVersions(tx *bolt.Tx, variableID string) ([]*VariableVersion, error) {
+	versions := []*VariableVersion{}
+	err := scanJSON(tx, bucketVersions, func(id string, v *VariableVersion) error {
+		if v.VariableID == variableID {
+			versions = append(versions, v)
+		}
+		return nil
+	})
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version > versions[j].Version })
+	return versions, err
+}
+
+func (b *Bolt) CreateVersions(ctx context.Context, versions ...*VariableVersion) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		for _, v := range versions {
+			existing, err := listVersions(tx, v.VariableID)
+			if err != nil {
+				return err
+			}
+			for _, e := range existing {
+				if e.Version == v.Version {
+					return ErrDuplicate
+				}
+			}
+
+			v.ID = newID()
+			if err := putJSON(tx, bucketVersions, v.ID, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *Bolt) ListVersions(ctx context.Context, variableID string) ([]*VariableVersion, error) {
+	var versions []*VariableVersion
+	err := b.db.View(func(tx *bolt.Tx) (err error) {
+		versions, err = listVersions(tx, variableID)
+		return err
+	})
+	return versions, err
+}
+
+func (b *Bolt) GetVersion(ctx context.Context, variableID string, version int) (*VariableVersion, error) {
+	var found *VariableVersion
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return scanJSON(tx, bucketVersions, func(id string, v *VariableVersion) error {
+			if v.VariableID == variableID && v.Version == version {
+				found = v
+				return errStop
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, ErrNotFound
+	}
+	return found, nil
+}
+
+func (b *Bolt) PruneVersions(ctx context.Context, variableID string, keep int) (int, error) {
+	if keep < 1 {
+		keep = 1
+	}
+	removed := 0
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		versions, err := listVersions(tx, variableID)
+		if err != nil || len(versions) <= keep {
+			return err
+		}
+		for _, v := range versions[keep:] {
+			if err := tx.Bucket(bucketVersions).Delete([]byte(v.ID)); err != nil {
+				return err
+			}
+			removed++
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// audit
+
+func (f AuditFilter) matches(e *AuditEvent) bool {
+	return (f.Subject == "" || f.Subject == e.ActorID || f.Subject == e.OwnerID || slices.Contains(f.Owners, e.OwnerID)) &&
+		(f.ActorID == "" || f.ActorID == e.ActorID) &&
+		(f.Action == "" || f.Action == e.Action) &&
+		(f.Outcome == "" || f.Outcome == e.Outcome) &&
+		strings.HasPrefix(e.Resource, f.Resource) &&
+		(f.Since.IsZero() || !e.CreatedAt.Before(f.Since)) &&
+		(f.Until.IsZero() || e.CreatedAt.Before(f.Until))
+}
+
+// seqKey encodes a chain position so byte order is numeric order.
+func seqKey(seq int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(seq))
+}
+
+// tenantChain returns the sub-bucket indexing a tenant's chain, or nil if
+// the tenant has no events yet. Bolt rejects empty bucket names, hence the prefix.
+func tenantChain(tx *bolt.Tx, tenant string) *bolt.Bucket {
+	return tx.Bucket(bucketAuditChain).Bucket([]byte("t/" + tenant))
+}
+
+func (b *Bolt) AppendAudit(ctx context.Context, e *AuditEvent) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		chain, err := tx.Bucket(bucketAuditChain).CreateBucketIfNotExists([]byte("t/" + e.Tenant))
+		if err != nil {
+			return err
+		}
+		if chain.Get(seqKey(e.Seq)) != nil {
+			return ErrDuplicate
+		}
+
+		e.ID = newID()
+		if err := chain.Put(seqKey(e.Seq), []byte(e.ID)); err != nil {
+			return err
+		}
+		return putJSON(tx, bucketAudit, e.ID, e)
+	})
+}
+
+func (b *Bolt) LastAudit(ctx context.Context, tenant string) (*AuditEvent, error) {
+	var e AuditEvent
+	err := b.db.View(func(tx *bolt.Tx) error {
+		chain := tenantChain(tx, tenant)
+		if chain == nil {
+			return ErrNotFound
+		}
+		_, id := chain.Cursor().Last()
+		if id == nil {
+			return ErrNotFound
+		}
+		return getJSON(tx, bucketAudit, string(id), &e)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+func (b *Bolt) ScanAudit(ctx context.Context, tenant string, afterSeq int64, limit int) ([]*AuditEvent, error) {
+	events := []*AuditEvent{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		chain := tenantChain(tx, tenant)
+		if chain == nil {
+			return nil
+		}
+
+		c := chain.Cursor()
+		for k, id := c.Seek(seqKey(afterSeq + 1)); k != nil && len(events) < limit; k, id = c.Next() {
+			var e AuditEvent
+			if err := getJSON(tx, bucketAudit, string(id), &e); err != nil {
+				return err
+			}
+			events = append(events, &e)
+		}
+		return nil
+	})
+	return events, err
+}
+
+func (b *Bolt) AuditTenants(ctx context.Context) ([]string, error) {
+	var tenants []string
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketAuditChain).ForEachBucket(func(k []byte) error {
+			tenants = append(tenants, strings.TrimPrefix(string(k), "t/"))
+			return nil
+		})
+	})
+	return tenants, err
+}
+
+func (b *Bolt) SaveAuditCheckpoint(ctx context.Context, cp *AuditCheckpoint) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		cp.ID = newID()
+		return putJSON(tx, bucketCheckpoints, cp.ID, cp)
+	})
+}
+
+func (b *Bolt) ListAuditCheckpoints(ctx context.Context, tenant string) ([]*AuditCheckpoint, error) {
+	checkpoints := []*AuditCheckpoint{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return scanJSON(tx, bucketCheckpoints, func(id string, cp *AuditCheckpoint) error {
+			if cp.Tenant == tenant {
+				checkpoints = append(checkpoints, cp)
+			}
+			return nil
+		})
+	})
+	sort.SliceStable(checkpoints, func(i, j int) bool { return checkpoints[i].Seq < checkpoints[j].Seq })
+	return checkpoints, err
+}
+
+// ListAudit walks the bucket backwards from the cursor; ids sort by time.
+func (b *Bolt) ListAudit(ctx context.Context, f AuditFilter) ([]*AuditEvent, error) {
+	events := []*AuditEvent{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketAudit).Cursor()
+
+		k, data := c.Last()
+		if f.Before != "" {
+			// Seek lands on the cursor id or the first id after it
+			k, data = c.Seek([]byte(f.Before))
+			if k == nil {
+				k, data = c.Last()
+			}
+			for k != nil && string(k) >= f.Before {
+				k, data = c.Prev()
+			}
+		}
+
+		for ; k != nil && (f.Limit <= 0 || len(events) < f.Limit); k, data = c.Prev() {
+			var e AuditEvent
+			if err := json.Unmarshal(data, &e); err != nil {
+				return err
+			}
+			if f.matches(&e) {
+				events = append(events, &e)
+			}
+		}
+		return nil
+	})
+	return events, err
+}
+
+// shares
+
+func (b *Bolt) CreateShare(ctx context.Context, sh *Share) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		sh.ID = newID()
+		return putJSON(tx, bucketShares, sh.ID, sh)
+	})
+}
+
+func (b *Bolt) GetShareByToken(ctx context.Context, tokenHash string) (*Share, error) {
+	var found *Share
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return scanJSON(tx, bucketShares, func(id string, sh *Share) error {
+			if sh.TokenHash == tokenHash {
+				found = sh
+				return errStop
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, ErrNotFound
+	}
+	return found, nil
+}
+
+func (b *Bolt) ListShares(ctx context.Context, ownerID string) ([]*Share, error) {
+	shares := []*Share{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return scanJSON(tx, bucketShares, func(id string, sh *Share) error {
+			if sh.OwnerID == ownerID {
+				shares = append(shares, sh)
+			}
+			return nil
+		})
+	})
+	// Ids sort by creation time
+	sort.Slice(shares, func(i, j int) bool { return shares[i].ID > shares[j].ID })
+	return shares, err
+}
+
+func (b *Bolt) RevokeShare(ctx context.Context, ownerID, id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		var sh Share
+		if err := getJSON(tx, bucketShares, id, &sh); err != nil {
+			return err
+		}
+		if sh.OwnerID != ownerID {
+			return ErrNotFound
+		}
+		sh.Revoked = true
+		sh.Ciphertext = ""
+		return putJSON(tx, bucketShares, sh.ID, &sh)
+	})
+}
+
+func (b *Bolt) RevokeShareCopies(ctx context.Context, variableID string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		var copies []*Share
+		err := scanJSON(tx, bucketShares, func(id string, sh *Share) error {
+			if sh.VariableID == variableID && !sh.OneTime && sh.Ciphertext != "" {
+				copies = append(copies, sh)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, sh := range copies {
+			sh.Revoked = true
+			sh.Ciphertext = ""
+			if err := putJSON(tx, bucketShares, sh.ID, sh); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *Bolt) UseShare(ctx context.Context, id string, now time.Time) (*Share, error) {
+	var sh Share
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		if err := getJSON(tx, bucketShares, id, &sh); err != nil {
+			return err
+		}
+		if !sh.Active(now) {
+			return ErrNotFound
+		}
+		sh.Views++
+		return putJSON(tx, bucketShares, sh.ID, &sh)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &sh, nil
+}
+
+func (b *Bolt) BurnShare(ctx context.Context, id string, now time.Time) (*Share, error) {
+	var sh Share
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		if err := getJSON(tx, bucketShares, id, &sh); err != nil {
+			return err
+		}
+		if !sh.OneTime || sh.Ciphertext == "" || !sh.Active(now) {
+			return ErrNotFound
+		}
+
+		burnt := sh
+		burnt.Views++
+		burnt.Ciphertext = ""
+		sh.Views++
+		return putJSON(tx, bucketShares, sh.ID, &burnt)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &sh, nil
+}
+
+func (b *Bolt) ClaimShareAttempt(ctx context.Context, id string, now time.Time) (*Share, error) {
+	var sh Share
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		if err := getJSON(tx, bucketShares, id, &sh); err != nil {
+			return err
+		}
+		if !sh.Active(now) {
+			return ErrNotFound
+		}
+		sh.FailedAttempts++
+		return putJSON(tx, bucketShares, sh.ID, &sh)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &sh, nil
+}
+
+func (b *Bolt) RefundShareAttempt(ctx context.Context, id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		var sh Share
+		if err := getJSON(tx, bucketShares, id, &sh); err != nil {
+			return err
+		}
+		if sh.FailedAttempts == 0 {
+			return nil
+		}
+		sh.FailedAttempts--
+		return putJSON(tx, bucketShares, sh.ID, &sh)
+	})
+}
+
+// sessions
+
+func (b *Bolt) CreateSession(ctx context.Context, s *Session) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		s.ID = newID()
+		return putJSON(tx, bucketSessions, s.ID, s)
+	})
+}
+
+func (b *Bolt) GetSession(ctx context.Context, id string) (*Session, error) {
+	var s Session
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return getJSON(tx, bucketSessions, id, &s)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (b *Bolt) ListSessions(ctx context.Context, userID string) ([]*Session, error) {
+	sessions := []*Session{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return scanJSON(tx, bucketSessions, func(id string, s *Session) error {
+			if s.UserID == userID {
+				sessions = append(sessions, s)
+			}
+			return nil
+		})
+	})
+	// Ids sort by creation time
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].ID > sessions[j].ID })
+	return sessions, err
+}
+
+func (b *Bolt) RotateSession(ctx context.Context, id, oldHash, newHash, ip, userAgent string, now time.Time) (*Session, error) {
+	var s Session
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		if err := getJSON(tx, bucketSessions, id, &s); err != nil {
+			return err
+		}
+		if s.RefreshHash != oldHash || !s.Active(now) {
+			return ErrNotFound
+		}
+		s.PrevRefreshHash, s.RefreshHash = oldHash, newHash
+		s.IP, s.UserAgent, s.LastUsedAt = ip, userAgent, now
+		return putJSON(tx, bucketSessions, s.ID, &s)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (b *Bolt) TouchSession(ctx context.Context, id, ip string, now time.Time) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		var s Session
+		if err := getJSON(tx, bucketSessions, id, &s); err != nil {
+			return err
+		}
+		s.IP, s.LastUsedAt = ip, now
+		return putJSON(tx, bucketSessions, s.ID, &s)
+	})
+}
+
+func (b *Bolt) RevokeSession(ctx context.Context, userID, id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		var s Session
+		if err := getJSON(tx, bucketSessions, id, &s); err != nil {
+			return err
+		}
+		if s.UserID != userID {
+			return ErrNotFound
+		}
+		s.Revoked = true
+		return putJSON(tx, bucketSessions, s.ID, &s)
+	})
+}
+
+func (b *Bolt) RevokeSessions(ctx context.Context, userID string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		var active []*Session
+		err := scanJSON(tx, bucketSessions, func(id string, s *Session) error {
+			if s.UserID == userID && !s.Revoked {
+				active = append(active, s)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, s := range active {
+			s.Revoked = true
+			if err := putJSON(tx, bucketSessions, s.ID, s); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// API tokens
+
+func (b *Bolt) CreateAPIToken(ctx context.Context, t *APIToken) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		t.ID = newID()
+		return putJSON(tx, bucketAPITokens, t.ID, t)
+	})
+}
+
+func (b *Bolt) GetAPITokenByHash(ctx context.Context, tokenHash string) (*APIToken, error) {
+	var found *APIToken
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return scanJSON(tx, bucketAPITokens, func(id string, t *APIToken) error {
+			if t.TokenHash == tokenHash {
+				found = t
+				return errStop
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, ErrNotFound
+	}
+	return found, nil
+}
+
+func (b *Bolt) ListAPITokens(ctx context.Context, userID string) ([]*APIToken, error) {
+	tokens := []*APIToken{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return scanJSON(tx, bucketAPITokens, func(id string, t *APIToken) error {
+			if t.UserID == userID {
+				tokens = append(tokens, t)
+			}
+			return nil
+		})
+	})
+	// Ids sort by creation time
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ID > tokens[j].ID })
+	return tokens, err
+}
+
+func (b *Bolt) RevokeAPIToken(ctx context.Context, userID, id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		var t APIToken
+		if err := getJSON(tx, bucketAPITokens, id, &t); err != nil {
+			return err
+		}
+		if t.UserID != userID {
+			return ErrNotFound
+		}
+		t.Revoked = true
+		return putJSON(tx, bucketAPITokens, t.ID, &t)
+	})
+}
+
+func (b *Bolt) TouchAPIToken(ctx context.Context, id, ip string, now time.Time) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		var t APIToken
+		if err := getJSON(tx, bucketAPITokens, id, &t); err != nil {
+			return err
+		}
+		t.LastUsedIP, t.LastUsedAt = ip, now
+		return putJSON(tx, bucketAPITokens, t.ID, &t)
+	})
+}
+
+// passkeys
+
+// findUserByPasskey scans for the user owning a credential.
+func (b *Bolt) findUserByPasskey(tx *bolt.Tx, credentialID string) (*User, error) {
+	var found *User
+	err := scanJSON(tx, bucketUsers, func(id string, u *User) error {
+		if u.Passkey(credentialID) != nil {
+			found = u
+			return errStop
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, ErrNotFound
+	}
+	return found, nil
+}
+
+func (b *Bolt) AddPasskey(ctx context.Context, userID string, p *Passkey) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if _, err := b.findUserByPasskey(tx, p.ID); err == nil {
+			return ErrDuplicate
+		} else if err != ErrNotFound {
+			return err
+		}
+
+		var u User
+		if err := getJSON(tx, bucketUsers, userID, &u); err != nil {
+			return err
+		}
+		u.Passkeys = append(u.Passkeys, *p)
+		return putJSON(tx, bucketUsers, u.ID, &u)
+	})
+}
+
+func (b *Bolt) GetUserByPasskey(ctx context.Context, credentialID string) (*User, error) {
+	var u *User
+	err := b.db.View(func(tx *bolt.Tx) (err error) {
+		u, err = b.findUserByPasskey(tx, credentialID)
+		return err
+	})
+	return u, err
+}
+
+func (b *Bolt) UpdatePasskeySignCount(ctx context.Context, userID, credentialID string, from, to uint32, now time.Time) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		var u User
+		if err := getJSON(tx, bucketUsers, userID, &u); err != nil {
+			return err
+		}
+		p := u.Passkey(credentialID)
+		if p == nil || p.SignCount != from {
+			return ErrNotFound
+		}
+		p.SignCount = to
+		p.LastUsedAt = now
+		return putJSON(tx, bucketUsers, u.ID, &u)
+	})
+}
+
+func (b *Bolt) DeletePasskey(ctx context.Context, userID, credentialID string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		var u User
+		if err := getJSON(tx, bucketUsers, userID, &u); err != nil {
+			return err
+		}
+		i := slices.IndexFunc(u.Passkeys, func(p Passkey) bool { return p.ID == credentialID })
+		if i < 0 {
+			return ErrNotFound
+		}
+		u.Passkeys = slices.Delete(u.Passkeys, i, i+1)
+		return putJSON(tx, bucketUsers, u.ID, &u)
+	})
+}
+
+func (b *Bolt) CreateWebAuthnChallenge(ctx context.Context, ch *WebAuthnChallenge) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		// Nothing expires challenges here, so drop abandoned ones as we go
+		var expired []string
+		err := scanJSON(tx, bucketChallenges, func(id string, c *WebAuthnChallenge) error {
+			if !c.ExpiresAt.After(time.Now()) {
+				expired = append(expired, id)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, id := range expired {
+			if err := tx.Bucket(bucketChallenges).Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+
+		ch.ID = newID()
+		return putJSON(tx, bucketChallenges, ch.ID, ch)
+	})
+}
+
+func (b *Bolt) ConsumeWebAuthnChallenge(ctx context.Context, id string) (*WebAuthnChallenge, error) {
+	var ch WebAuthnChallenge
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		if err := getJSON(tx, bucketChallenges, id, &ch); err != nil {
+			return err
+		}
+		return tx.Bucket(bucketChallenges).Delete([]byte(id))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ch, nil
+}
+
+// single sign-on
+
+func (b *Bolt) findUserByIdentity(tx *bolt.Tx, id Identity) (*User, error) {
+	var found *User
+	err := scanJSON(tx, bucketUsers, func(_ string, u *User) error {
+		if u.HasIdentity(id.Issuer, id.Subject) {
+			found = u
+			return errStop
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, ErrNotFound
+	}
+	return found, nil
+}
+
+func (b *Bolt) GetUserByIdentity(ctx context.Context, issuer, subject string) (*User, error) {
+	var u *User
+	err := b.db.View(func(tx *bolt.Tx) (err error) {
+		u, err = b.findUserByIdentity(tx, Identity{Issuer: issuer, Subject: subject})
+		return err
+	})
+	return u, err
+}
+
+func (b *Bolt) LinkIdentity(ctx context.Context, userID string, id Identity) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		owner, err := b.findUserByIdentity(tx, id)
+		if err == nil {
+			if owner.ID == userID {
+				return nil
+			}
+			return ErrDuplicate
+		}
+		if err != ErrNotFound {
+			return err
+		}
+
+		var u User
+		if err := getJSON(tx, bucketUsers, userID, &u); err != nil {
+			return err
+		}
+		u.Identities = append(u.Identities, id)
+		return putJSON(tx, bucketUsers, u.ID, &u)
+	})
+}
+
+func (b *Bolt) CreateOIDCLogin(ctx context.Context, l *OIDCLogin) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		// Nothing expires logins here, so drop abandoned ones as we go
+		var expired []string
+		err := scanJSON(tx, bucketOIDCLogins, func(id string, p *OIDCLogin) error {
+			if !p.ExpiresAt.After(time.Now()) {
+				expired = append(expired, id)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, id := range expired {
+			if err := tx.Bucket(bucketOIDCLogins).Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+
+		l.ID = newID()
+		return putJSON(tx, bucketOIDCLogins, l.ID, l)
+	})
+}
+
+func (b *Bolt) ConsumeOIDCLogin(ctx context.Context, stateHash string) (*OIDCLogin, error) {
+	var found *OIDCLogin
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		err := scanJSON(tx, bucketOIDCLogins, func(id string, l *OIDCLogin) error {
+			if l.StateHash == stateHash {
+				found = l
+				return errStop
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if found == nil {
+			return ErrNotFound
+		}
+		return tx.Bucket(bucketOIDCLogins).Delete([]byte(found.ID))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// organizations
+
+func (b *Bolt) findOrganization(tx *bolt.Tx, name string) (*Organization, error) {
+	var found *Organization
+	err := scanJSON(tx, bucketOrgs, func(id string, o *Organization) error {
+		if o.Name == name {
+			found = o
+			return errStop
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, ErrNotFound
+	}
+	return found, nil
+}
+
+func (b *Bolt) CreateOrganization(ctx context.Context, o *Organization) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if _, err := b.findOrganization(tx, o.Name); err == nil {
+			return ErrDuplicate
+		}
+		o.ID = newID()
+		return putJSON(tx, bucketOrgs, o.ID, o)
+	})
+}
+
+func (b *Bolt) GetOrganization(ctx context.Context, name string) (*Organization, error) {
+	var o *Organization
+	err := b.db.View(func(tx *bolt.Tx) (err error) {
+		o, err = b.findOrganization(tx, name)
+		return err
+	})
+	return o, err
+}
+
+func (b *Bolt) GetOrganizationByID(ctx context.Context, id string) (*Organization, error) {
+	var o Organization
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return getJSON(tx, bucketOrgs, id, &o)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+func (b *Bolt) SetOrganizationDataKey(ctx context.Context, orgID, wrapped, expected string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		var o Organization
+		if err := getJSON(tx, bucketOrgs, orgID, &o); err != nil {
+			return err
+		}
+		if o.DataKey != expected {
+			return ErrNotFound
+		}
+		o.DataKey = wrapped
+		return putJSON(tx, bucketOrgs, o.ID, &o)
+	})
+}
+
+func (b *Bolt) SetOrganizationRequireMFA(ctx context.Context, orgID string, require bool) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		var o Organization
+		if err := getJSON(tx, bucketOrgs, orgID, &o); err != nil {
+			return err
+		}
+		o.RequireMFA = require
+		return putJSON(tx, bucketOrgs, o.ID, &o)
+	})
+}
+
+func (b *Bolt) ScanOrganizations(ctx context.Context, afterID string, limit int) ([]*Organization, error) {
+	var orgs []*Organization
+	err := b.db.View(func(tx *bolt.Tx) (err error) {
+		orgs, err = scanAfter[Organization](tx, bucketOrgs, afterID, limit)
+		return err
+	})
+	return orgs, err
+}
+
+func (b *Bolt) CountOrganizations(ctx context.Context) (int64, error) {
+	return b.count(bucketOrgs)
+}
+
+// members
+
+func (b *Bolt) findMembers(tx *bolt.Tx, match func(m *Member) bool) ([]*Member, error) {
+	members := []*Member{}
+	err := scanJSON(tx, bucketMembers, func(id string, m *Member) error {
+		if match(m) {
+			members = append(members, m)
+		}
+		return nil
+	})
+	return members, err
+}
+
+func (b *Bolt) findMember(tx *bolt.Tx, orgID, userID string) (*Member, error) {
+	members, err := b.findMembers(tx, func(m *Member) bool { return m.OrgID == orgID && m.UserID == userID })
+	if err != nil {
+		return nil, err
+	}
+	if len(members) == 0 {
+		return nil, ErrNotFound
+	}
+	return members[0], nil
+}
+
+func (b *Bolt) AddMember(ctx context.Context, m *Member) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if _, err := b.findMember(tx, m.OrgID, m.UserID); err == nil {
+			return ErrDuplicate
+		}
+		m.ID = newID()
+		return putJSON(tx, bucketMembers, m.ID, m)
+	})
+}
+
+func (b *Bolt) GetMember(ctx context.Context, orgID, userID string) (*Member, error) {
+	var m *Member
+	err := b.db.View(func(tx *bolt.Tx) (err error) {
+		m, err = b.findMember(tx, orgID, userID)
+		return err
+	})
+	return m, err
+}
+
+func (b *Bolt) ListMembers(ctx context.Context, orgID string) ([]*Member, error) {
+	var members []*Member
+	err := b.db.View(func(tx *bolt.Tx) (err error) {
+		members, err = b.findMembers(tx, func(m *Member) bool { return m.OrgID == orgID })
+		return err
+	})
+	return members, err
+}
+
+func (b *Bolt) ListMemberships(ctx context.Context, userID string) ([]*Member, error) {
+	var members []*Member
+	err := b.db.View(func(tx *bolt.Tx) (err error) {
+		members, err = b.findMembers(tx, func(m *Member) bool { return m.UserID == userID })
+		return err
+	})
+	return members, err
+}
+
+func (b *Bolt) SetMemberRole(ctx context.Context, orgID, userID, role string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		m, err := b.findMember(tx, orgID, userID)
+		if err != nil {
+			return err
+		}
+		m.Role = role
+		return putJSON(tx, bucketMembers, m.ID, m)
+	})
+}
+
+func (b *Bolt) RemoveMember(ctx context.Context, orgID, userID string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		m, err := b.findMember(tx, orgID, userID)
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket(bucketMembers).Delete([]byte(m.ID)); err != nil {
+			return err
+		}
+
+		teams, err := b.findTeams(tx, func(t *Team) bool { return t.OrgID == orgID && slices.Contains(t.MemberIDs, userID) })
+		if err != nil {
+			return err
+		}
+		for _, t := range teams {
+			t.MemberIDs = slices.DeleteFunc(t.MemberIDs, func(id string) bool { return id == userID })
+			if err := putJSON(tx, bucketTeams, t.ID, t); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// teams
+
+func (b *Bolt) findTeams(tx *bolt.Tx, match func(t *Team) bool) ([]*Team, error) {
+	teams := []*Team{}
+	err := scanJSON(tx, bucketTeams, func(id string, t *Team) error {
+		if match(t) {
+			teams = append(teams, t)
+		}
+		return nil
+	})
+	sort.Slice(teams, func(i, j int) bool { return teams[i].Name < teams[j].Name })
+	return teams, err
+}
+
+func (b *Bolt) findTeam(tx *bolt.Tx, orgID, name string) (*Team, error) {
+	teams, err := b.findTeams(tx, func(t *Team) bool { return t.OrgID == orgID && t.Name == name })
+	if err != nil {
+		return nil, err
+	}
+	if len(teams) == 0 {
+		return nil, ErrNotFound
+	}
+	return teams[0], nil
+}
+
+func (b *Bolt) CreateTeam(ctx context.Context, t *Team) error {
+	if t.MemberIDs == nil {
+		t.MemberIDs = []string{}
+	}
+	if t.Grants == nil {
+		t.Grants = map[string]string{}
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if _, err := b.findTeam(tx, t.OrgID, t.Name); err == nil {
+			return ErrDuplicate
+		}
+		t.ID = newID()
+		return putJSON(tx, bucketTeams, t.ID, t)
+	})
+}
+
+func (b *Bolt) GetTeam(ctx context.Context, orgID, name string) (*Team, error) {
+	var t *Team
+	err := b.db.View(func(tx *bolt.Tx) (err error) {
+		t, err = b.findTeam(tx, orgID, name)
+		return err
+	})
+	return t, err
+}
+
+func (b *Bolt) ListTeams(ctx context.Context, orgID string) ([]*Team, error) {
+	var teams []*Team
+	err := b.db.View(func(tx *bolt.Tx) (err error) {
+		teams, err = b.findTeams(tx, func(t *Team) bool { return t.OrgID == orgID })
+		return err
+	})
+	return teams, err
+}
+
+func (b *Bolt) ListUserTeams(ctx context.Context, orgID, userID string) ([]*Team, error) {
+	var teams []*Team
+	err := b.db.View(func(tx *bolt.Tx) (err error) {
+		teams, err = b.findTeams(tx, func(t *Team) bool { return t.OrgID == orgID && slices.Contains(t.MemberIDs, userID) })
+		return err
+	})
+	return teams, err
+}
+
+// updateTeam loads a team, applies fn and saves it.
+func (b *Bolt) updateTeam(teamID string, fn func(t *Team)) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		var t Team
+		if err := getJSON(tx, bucketTeams, teamID, &t); err != nil {
+			return err
+		}
+		fn(&t)
+		return putJSON(tx, bucketTeams, t.ID, &t)
+	})
+}
+
+func (b *Bolt) AddTeamMember(ctx context.Context, teamID, userID string) error {
+	return b.updateTeam(teamID, func(t *Team) {
+		if !slices.Contains(t.MemberIDs, userID) {
+			t.MemberIDs = append(t.MemberIDs, userID)
+		}
+	})
+}
+
+func (b *Bolt) RemoveTeamMember(ctx context.Context, teamID, userID string) error {
+	return b.updateTeam(teamID, func(t *Team) {
+		t.MemberIDs = slices.DeleteFunc(t.MemberIDs, func(id string) bool { return id == userID })
+	})
+}
+
+func (b *Bolt) SetTeamGrant(ctx context.Context, teamID, projectID, role string) error {
+	return b.updateTeam(teamID, func(t *Team) {
+		if t.Grants == nil {
+			t.Grants = map[string]string{}
+		}
+		if role == "" {
+			delete(t.Grants, projectID)
+		} else {
+			t.Grants[projectID] = role
+		}
+	})
+}
+
+func (b *Bolt) DeleteTeam(ctx context.Context, id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketTeams).Get([]byte(id)) == nil {
+			return ErrNotFound
+		}
+		return tx.Bucket(bucketTeams).Delete([]byte(id))
+	})
+}
+
+// policies
+
+func (b *Bolt) findPolicies(tx *bolt.Tx, orgID string) ([]*Policy, error) {
+	policies := []*Policy{}
+	err := scanJSON(tx, bucketPolicies, func(id string, p *Policy) error {
+		if p.OrgID == orgID {
+			policies = append(policies, p)
+		}
+		return nil
+	})
+	sort.Slice(policies, func(i, j int) bool { return policies[i].Name < policies[j].Name })
+	return policies, err
+}
+
+func (b *Bolt) findPolicy(tx *bolt.Tx, orgID, name string) (*Policy, error) {
+	policies, err := b.findPolicies(tx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range policies {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (b *Bolt) CreatePolicy(ctx context.Context, p *Policy) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if _, err := b.findPolicy(tx, p.OrgID, p.Name); err == nil {
+			return ErrDuplicate
+		}
+		p.ID = newID()
+		return putJSON(tx, bucketPolicies, p.ID, p)
+	})
+}
+
+func (b *Bolt) GetPolicy(ctx context.Context, orgID, name string) (*Policy, error) {
+	var p *Policy
+	err := b.db.View(func(tx *bolt.Tx) (err error) {
+		p, err = b.findPolicy(tx, orgID, name)
+		return err
+	})
+	return p, err
+}
+
+func (b *Bolt) ListPolicies(ctx context.Context, orgID string) ([]*Policy, error) {
+	var policies []*Policy
+	err := b.db.View(func(tx *bolt.Tx) (err error) {
+		policies, err = b.findPolicies(tx, orgID)
+		return err
+	})
+	return policies, err
+}
+
+func (b *Bolt) UpdatePolicy(ctx context.Context, id, document string, updatedAt time.Time) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		var p Policy
+		if err := getJSON(tx, bucketPolicies, id, &p); err != nil {
+			return err
+		}
+		p.Document = document
+		p.UpdatedAt = updatedAt
+		return putJSON(tx, bucketPolicies, p.ID, &p)
+	})
+}
+
+func (b *Bolt) DeletePolicy(ctx context.Context, id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketPolicies).Get([]byte(id)) == nil {
+			return ErrNotFound
+		}
+		return tx.Bucket(bucketPolicies).Delete([]byte(id))
+	})
+}
+
+// invites
+
+func (b *Bolt) CreateInvite(ctx context.Context, inv *Invite) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		err := scanJSON(tx, bucketInvites, func(id string, other *Invite) error {
+			if other.OrgID == inv.OrgID && other.Email == inv.Email {
+				return ErrDuplicate
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		inv.ID = newID()
+		return putJSON(tx, bucketInvites, inv.ID, inv)
+	})
+}
+
+func (b *Bolt) GetInvite(ctx context.Context, id string) (*Invite, error) {
+	var inv Invite
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return getJSON(tx, bucketInvites, id, &inv)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+func (b *Bolt) GetInviteByToken(ctx context.Context, tokenHash string) (*Invite, error) {
+	var found *Invite
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return scanJSON(tx, bucketInvites, func(id string, inv *Invite) error {
+			if inv.TokenHash == tokenHash {
+				found = inv
+				return errStop
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, ErrNotFound
+	}
+	return found, nil
+}
+
+func (b *Bolt) ListInvites(ctx context.Context, orgID string) ([]*Invite, error) {
+	invites := []*Invite{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return scanJSON(tx, bucketInvites, func(id string, inv *Invite) error {
+			if inv.OrgID == orgID {
+				invites = append(invites, inv)
+			}
+			return nil
+		})
+	})
+	sort.Slice(invites, func(i, j int) bool { return invites[i].CreatedAt.After(invites[j].CreatedAt) })
+	return invites, err
+}
+
+func (b *Bolt) RenewInvite(ctx context.Context, id, tokenHash string, expiresAt time.Time) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		var inv Invite
+		if err := getJSON(tx, bucketInvites, id, &inv); err != nil {
+			return err
+		}
+		inv.TokenHash = tokenHash
+		inv.ExpiresAt = expiresAt
+		return putJSON(tx, bucketInvites, inv.ID, &inv)
+	})
+}
+
+func (b *Bolt) ConsumeInvite(ctx context.Context, id, tokenHash string) (*Invite, error) {
+	var inv Invite
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		if err := getJSON(tx, bucketInvites, id, &inv); err != nil {
+			return err
+		}
+		if inv.TokenHash != tokenHash {
+			return ErrNotFound
+		}
+		return tx.Bucket(bucketInvites).Delete([]byte(id))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+func (b *Bolt) DeleteInvite(ctx context.Context, id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketInvites).Get([]byte(id)) == nil {
+			return ErrNotFound
+		}
+		return tx.Bucket(bucketInvites).Delete([]byte(id))
+	})
+}
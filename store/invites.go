@@ -0,0 +1,37 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Invite asks someone by email to join an organization with a role. Only a
+// hash of the signed invite token is stored; resending replaces it, so
+// older links stop working.
+type Invite struct {
+	ID        string    `bson:"_id,omitempty" json:"id"`
+	OrgID     string    `bson:"orgID" json:"orgID"`
+	Email     string    `bson:"email" json:"email"`
+	Role      string    `bson:"role" json:"role"`
+	InvitedBy string    `bson:"invitedBy" json:"invitedBy"` // user id
+	TokenHash string    `bson:"tokenHash" json:"tokenHash"`
+	ExpiresAt time.Time `bson:"expiresAt" json:"expiresAt"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// InviteStore persists pending organization invites.
+type InviteStore interface {
+	// CreateInvite returns ErrDuplicate if the email already has an invite
+	// to the organization.
+	CreateInvite(ctx context.Context, inv *Invite) error
+	GetInvite(ctx context.Context, id string) (*Invite, error)
+	GetInviteByToken(ctx context.Context, tokenHash string) (*Invite, error)
+	// ListInvites returns the organization's invites, newest first.
+	ListInvites(ctx context.Context, orgID string) ([]*Invite, error)
+	// RenewInvite gives an invite a new token and expiry.
+	RenewInvite(ctx context.Context, id, tokenHash string, expiresAt time.Time) error
+	// ConsumeInvite deletes and returns the invite if its token hash still
+	// matches, or returns ErrNotFound, so an invite is only accepted once.
+	ConsumeInvite(ctx context.Context, id, tokenHash string) (*Invite, error)
+	DeleteInvite(ctx context.Context, id string) error
+}
@@ -0,0 +1,32 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// UserMFA is a user's two-factor setup. A TOTP secret is stored as soon
+// as enrollment starts but only counts once Enabled, after the user has
+// proven their authenticator produces valid codes.
+type UserMFA struct {
+	TOTPSecret    string    `bson:"totpSecret,omitempty" json:"totpSecret,omitempty"` // sealed with the user's data key
+	Enabled       bool      `bson:"enabled" json:"enabled"`
+	LastStep      int64     `bson:"lastStep" json:"lastStep"`                               // last TOTP time step used, so codes can't be replayed
+	RecoveryCodes []string  `bson:"recoveryCodes,omitempty" json:"recoveryCodes,omitempty"` // hashes of unused codes
+	Failures      int       `bson:"failures" json:"failures"`
+	LastFailureAt time.Time `bson:"lastFailureAt" json:"lastFailureAt"`
+}
+
+// MFAStore persists two-factor state on users.
+type MFAStore interface {
+	// SetUserMFA replaces the user's two-factor setup.
+	SetUserMFA(ctx context.Context, userID string, mfa *UserMFA) error
+	// ClaimMFAAttempt counts a code attempt before the code is checked. It
+	// returns ErrNotFound while the user has maxFailures failures, the last
+	// one less than lockout ago.
+	ClaimMFAAttempt(ctx context.Context, userID string, now time.Time, maxFailures int, lockout time.Duration) error
+	// ConsumeMFA spends a TOTP step (step > 0) or a recovery code hash and
+	// clears the failure count. It returns ErrNotFound if the step isn't
+	// newer than the last one used or the recovery code isn't there.
+	ConsumeMFA(ctx context.Context, userID string, step int64, recoveryHash string) error
+}
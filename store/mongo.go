@@ -0,0 +1,1941 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Mongo is the MongoDB backed Store.
+type Mongo struct {
+	client *mongo.Client
+	db     *mongo.Database
+}
+
+// OpenMongo connects to uri and uses the given database (default "safeenv").
+func OpenMongo(ctx context.Context, uri, database string) (*Mongo, error) {
+	if uri == "" {
+		uri = "mongodb://localhost:27017"
+	}
+	if database == "" {
+		database = "safeenv"
+	}
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		return nil, fmt.Errorf("store: connect to mongo: %w", err)
+	}
+
+	m := &Mongo{client: client, db: client.Database(database)}
+	if err := m.normalizeEmails(ctx); err != nil {
+		client.Disconnect(ctx)
+		return nil, fmt.Errorf("store: normalize user emails: %w", err)
+	}
+	if err := m.ensureIndexes(ctx); err != nil {
+		client.Disconnect(ctx)
+		return nil, fmt.Errorf("store: create mongo indexes: %w", err)
+	}
+	return m, nil
+}
+
+// normalizeEmails rewrites emails stored before they were normalized, so the
+// unique email index can be built. Accounts whose emails only differ in case
+// or spaces can't be merged automatically and stop startup until an operator
+// removes or renames one of them.
+func (m *Mongo) normalizeEmails(ctx context.Context) error {
+	cur, err := m.users().Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"email": 1}))
+	if err != nil {
+		return err
+	}
+	var users []struct {
+		ID    primitive.ObjectID `bson:"_id"`
+		Email string             `bson:"email"`
+	}
+	if err := cur.All(ctx, &users); err != nil {
+		return err
+	}
+
+	seen := make(map[string]string, len(users))
+	var dupes []string
+	for _, u := range users {
+		email := NormalizeEmail(u.Email)
+		if other, ok := seen[email]; ok {
+			dupes = append(dupes, fmt.Sprintf("%q and %q", other, u.Email))
+			continue
+		}
+		seen[email] = u.Email
+	}
+	if len(dupes) > 0 {
+		return fmt.Errorf("users %s have the same email; remove or rename the extra accounts before upgrading (see \"Upgrading\" in the README)", strings.Join(dupes, ", "))
+	}
+
+	for _, u := range users {
+		if email := NormalizeEmail(u.Email); email != u.Email {
+			if _, err := m.users().UpdateOne(ctx, bson.M{"_id": u.ID}, bson.M{"$set": bson.M{"email": email}}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ensureIndexes creates the indexes backing the store's uniqueness rules.
+func (m *Mongo) ensureIndexes(ctx context.Context) error {
+	indexes := map[*mongo.Collection][]mongo.IndexModel{
+		m.projects(): {{
+			Keys:    bson.D{{Key: "ownerID", Value: 1}, {Key: "name", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		}},
+		m.environments(): {{
+			Keys:    bson.D{{Key: "projectID", Value: 1}, {Key: "name", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		}},
+		m.variables(): {{
+			// Unscoped variables predate projects and may repeat keys
+			Keys: bson.D{{Key: "projectID", Value: 1}, {Key: "envID", Value: 1}, {Key: "key", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"projectID": bson.M{"$gt": ""}}),
+		}},
+		m.versions(): {{
+			Keys:    bson.D{{Key: "variableID", Value: 1}, {Key: "version", Value: -1}},
+			Options: options.Index().SetUnique(true),
+		}},
+		m.audit(): {
+			{Keys: bson.D{{Key: "actorID", Value: 1}, {Key: "_id", Value: -1}}},
+			{Keys: bson.D{{Key: "ownerID", Value: 1}, {Key: "_id", Value: -1}}},
+			{
+				// Events written before chaining have no seq
+				Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "seq", Value: 1}},
+				Options: options.Index().SetUnique(true).
+					SetPartialFilterExpression(bson.M{"seq": bson.M{"$gt": 0}}),
+			},
+		},
+		m.checkpoints(): {{
+			Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "seq", Value: 1}},
+		}},
+		m.shares(): {
+			{Keys: bson.D{{Key: "tokenHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "ownerID", Value: 1}, {Key: "_id", Value: -1}}},
+		},
+		m.sessions(): {{
+			Keys: bson.D{{Key: "userID", Value: 1}, {Key: "_id", Value: -1}},
+		}},
+		m.apiTokens(): {
+			{Keys: bson.D{{Key: "tokenHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "userID", Value: 1}, {Key: "_id", Value: -1}}},
+		},
+		m.users(): {{
+			Keys:    bson.D{{Key: "email", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		}, {
+			// A credential belongs to one user; most users have none
+			Keys: bson.D{{Key: "passkeys.id", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"passkeys.id": bson.M{"$exists": true}}),
+		}, {
+			Keys: bson.D{{Key: "identities.issuer", Value: 1}, {Key: "identities.subject", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"identities.issuer": bson.M{"$exists": true}}),
+		}},
+		m.passwordResets(): {
+			{Keys: bson.D{{Key: "token", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		m.oidcLogins(): {
+			{Keys: bson.D{{Key: "stateHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		m.organizations(): {{
+			Keys:    bson.D{{Key: "name", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		}},
+		m.members(): {
+			{Keys: bson.D{{Key: "orgID", Value: 1}, {Key: "userID", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "userID", Value: 1}}},
+		},
+		m.teams(): {
+			{Keys: bson.D{{Key: "orgID", Value: 1}, {Key: "name", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "orgID", Value: 1}, {Key: "memberIDs", Value: 1}}},
+		},
+		m.policies(): {{
+			Keys:    bson.D{{Key: "orgID", Value: 1}, {Key: "name", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		}},
+		m.invites(): {
+			{Keys: bson.D{{Key: "orgID", Value: 1}, {Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "tokenHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		m.challenges(): {{
+			// Mongo drops abandoned ceremonies on its own
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		}},
+	}
+
+	for coll, models := range indexes {
+		if _, err := coll.Indexes().CreateMany(ctx, models); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Mongo) users() *mongo.Collection          { return m.db.Collection("users") }
+func (m *Mongo) variables() *mongo.Collection      { return m.db.Collection("variables") }
+func (m *Mongo) passwordResets() *mongo.Collection { return m.db.Collection("password_resets") }
+func (m *Mongo) jobs() *mongo.Collection           { return m.db.Collection("jobs") }
+func (m *Mongo) projects() *mongo.Collection       { return m.db.Collection("projects") }
+func (m *Mongo) environments() *mongo.Collection   { return m.db.Collection("environments") }
+func (m *Mongo) versions() *mongo.Collection       { return m.db.Collection("variable_versions") }
+func (m *Mongo) audit() *mongo.Collection          { return m.db.Collection("audit_log") }
+func (m *Mongo) checkpoints() *mongo.Collection    { return m.db.Collection("audit_checkpoints") }
+func (m *Mongo) shares() *mongo.Collection         { return m.db.Collection("shares") }
+func (m *Mongo) sessions() *mongo.Collection       { return m.db.Collection("sessions") }
+func (m *Mongo) apiTokens() *mongo.Collection      { return m.db.Collection("api_tokens") }
+func (m *Mongo) challenges() *mongo.Collection     { return m.db.Collection("webauthn_challenges") }
+func (m *Mongo) oidcLogins() *mongo.Collection     { return m.db.Collection("oidc_logins") }
+func (m *Mongo) organizations() *mongo.Collection  { return m.db.Collection("organizations") }
+func (m *Mongo) members() *mongo.Collection        { return m.db.Collection("org_members") }
+func (m *Mongo) teams() *mongo.Collection          { return m.db.Collection("teams") }
+func (m *Mongo) policies() *mongo.Collection       { return m.db.Collection("policies") }
+func (m *Mongo) invites() *mongo.Collection        { return m.db.Collection("org_invites") }
+
+// Close disconnects the underlying client.
+func (m *Mongo) Close(ctx context.Context) error {
+	return m.client.Disconnect(ctx)
+}
+
+// objectID converts a hex id; malformed ids can never match so they map to ErrNotFound.
+func objectID(id string) (primitive.ObjectID, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return primitive.NilObjectID, ErrNotFound
+	}
+	return oid, nil
+}
+
+// notFound maps the driver's "no documents" error onto ErrNotFound.
+func notFound(err error) error {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// duplicate maps unique index violations onto ErrDuplicate.
+func duplicate(err error) error {
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+// scopeQuery adds an exact environment match to q.
+func scopeQuery(q bson.M, sc Scope) {
+	for field, value := range map[string]string{"projectID": sc.ProjectID, "envID": sc.EnvID} {
+		if value == "" {
+			q[field] = bson.M{"$in": bson.A{"", nil}} // nil also matches a missing field
+		} else {
+			q[field] = value
+		}
+	}
+}
+
+// users
+
+func (m *Mongo) CreateUser(ctx context.Context, u *User) error {
+	oid := primitive.NewObjectID()
+	doc := bson.M{
+		"_id":          oid,
+		"username":     u.Username,
+		"email":        u.Email,
+		"passwordHash": u.PasswordHash,
+		"createdAt":    u.CreatedAt,
+	}
+	if len(u.Identities) > 0 {
+		doc["identities"] = u.Identities
+	}
+	if _, err := m.users().InsertOne(ctx, doc); err != nil {
+		return duplicate(err)
+	}
+	u.ID = oid.Hex()
+	return nil
+}
+
+func (m *Mongo) GetUserByID(ctx context.Context, id string) (*User, error) {
+	oid, err := objectID(id)
+	if err != nil {
+		return nil, err
+	}
+	var u User
+	if err := m.users().FindOne(ctx, bson.M{"_id": oid}).Decode(&u); err != nil {
+		return nil, notFound(err)
+	}
+	return &u, nil
+}
+
+func (m *Mongo) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	var u User
+	if err := m.users().FindOne(ctx, bson.M{"email": NormalizeEmail(email)}).Decode(&u); err != nil {
+		return nil, notFound(err)
+	}
+	return &u, nil
+}
+
+func (m *Mongo) UpdateUserPassword(ctx context.Context, email, passwordHash string) error {
+	res, err := m.users().UpdateOne(ctx,
+		bson.M{"email": NormalizeEmail(email)},
+		bson.M{"$set": bson.M{"passwordHash": passwordHash}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m *Mongo) SetUserDataKey(ctx context.Context, userID, wrapped, expected string) error {
+	oid, err := objectID(userID)
+	if err != nil {
+		return err
+	}
+
+	q := bson.M{"_id": oid, "dataKey": expected}
+	if expected == "" {
+		q["dataKey"] = bson.M{"$in": bson.A{"", nil}} // nil also matches a missing field
+	}
+
+	res, err := m.users().UpdateOne(ctx, q, bson.M{"$set": bson.M{"dataKey": wrapped}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m *Mongo) SetUserMFA(ctx context.Context, userID string, mfa *UserMFA) error {
+	oid, err := objectID(userID)
+	if err != nil {
+		return err
+	}
+	res, err := m.users().UpdateOne(ctx, bson.M{"_id": oid}, bson.M{"$set": bson.M{"mfa": mfa}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m *Mongo) ClaimMFAAttempt(ctx context.Context, userID string, now time.Time, maxFailures int, lockout time.Duration) error {
+	oid, err := objectID(userID)
+	if err != nil {
+		return err
+	}
+
+	// Matches unless locked, so checking and counting are one step
+	q := bson.M{"_id": oid, "$or": bson.A{
+		bson.M{"mfa.failures": bson.M{"$exists": false}},
+		bson.M{"mfa.failures": bson.M{"$lt": maxFailures}},
+		bson.M{"mfa.lastFailureAt": bson.M{"$lte": now.Add(-lockout)}},
+	}}
+	update := bson.M{
+		"$inc": bson.M{"mfa.failures": 1},
+		"$set": bson.M{"mfa.lastFailureAt": now},
+	}
+
+	res, err := m.users().UpdateOne(ctx, q, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m *Mongo) ConsumeMFA(ctx context.Context, userID string, step int64, recoveryHash string) error {
+	oid, err := objectID(userID)
+	if err != nil {
+		return err
+	}
+
+	q := bson.M{"_id": oid}
+	update := bson.M{"$set": bson.M{"mfa.failures": 0}}
+	if step > 0 {
+		q["mfa.lastStep"] = bson.M{"$lt": step}
+		update["$set"].(bson.M)["mfa.lastStep"] = step
+	} else {
+		q["mfa.recoveryCodes"] = recoveryHash
+		update["$pull"] = bson.M{"mfa.recoveryCodes": recoveryHash}
+	}
+
+	res, err := m.users().UpdateOne(ctx, q, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// scan returns up to limit documents from coll with _id after afterID, in _id order.
+func scan[T any](ctx context.Context, coll *mongo.Collection, afterID string, limit int) ([]*T, error) {
+	q := bson.M{}
+	if afterID != "" {
+		oid, err := primitive.ObjectIDFromHex(afterID)
+		if err != nil {
+			return nil, fmt.Errorf("store: invalid cursor %q", afterID)
+		}
+		q["_id"] = bson.M{"$gt": oid}
+	}
+
+	opts := options.Find().SetSort(bson.M{"_id": 1}).SetLimit(int64(limit))
+	cursor, err := coll.Find(ctx, q, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	docs := []*T{}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+func (m *Mongo) ScanUsers(ctx context.Context, afterID string, limit int) ([]*User, error) {
+	return scan[User](ctx, m.users(), afterID, limit)
+}
+
+func (m *Mongo) CountUsers(ctx context.Context) (int64, error) {
+	return m.users().CountDocuments(ctx, bson.M{})
+}
+
+// variables
+
+func variableQuery(f VariableFilter) (bson.M, error) {
+	q := bson.M{}
+	if f.ID != "" {
+		oid, err := objectID(f.ID)
+		if err != nil {
+			return nil, err
+		}
+		q["_id"] = oid
+	}
+	if f.UserID != "" {
+		q["userID"] = f.UserID
+	}
+	if f.Key != "" {
+		q["key"] = f.Key
+	}
+	if f.Value != "" {
+		q["value"] = f.Value
+	}
+	if f.Scope != nil {
+		scopeQuery(q, *f.Scope)
+	}
+	return q, nil
+}
+
+func (m *Mongo) CreateVariables(ctx context.Context, vars ...*Variable) error {
+	if len(vars) == 0 {
+		return nil
+	}
+
+	// Check scoped keys up front so a conflicting batch writes nothing; the
+	// unique index still catches a concurrent insert.
+	if err := m.checkUniqueKeys(ctx, vars); err != nil {
+		return err
+	}
+
+	docs := make([]interface{}, 0, len(vars))
+	for _, v := range vars {
+		oid := primitive.NewObjectID()
+		doc := bson.M{
+			"_id":       oid,
+			"userID":    v.UserID,
+			"key":       v.Key,
+			"value":     v.Value,
+			"createdAt": v.CreatedAt,
+		}
+		if v.ProjectID != "" {
+			doc["projectID"] = v.ProjectID
+			doc["envID"] = v.EnvID
+		}
+		if v.Version != 0 {
+			doc["version"] = v.Version
+		}
+		docs = append(docs, doc)
+		v.ID = oid.Hex()
+	}
+
+	_, err := m.variables().InsertMany(ctx, docs)
+	return duplicate(err)
+}
+
+// checkUniqueKeys returns ErrDuplicate if any scoped variable in vars would
+// reuse a key, either within the batch or against what is already stored.
+func (m *Mongo) checkUniqueKeys(ctx context.Context, vars []*Variable) error {
+	keys := map[Scope][]string{}
+	seen := map[Scope]map[string]bool{}
+	for _, v := range vars {
+		if v.ProjectID == "" {
+			continue
+		}
+		sc := v.Scope()
+		if seen[sc] == nil {
+			seen[sc] = map[string]bool{}
+		}
+		if seen[sc][v.Key] {
+			return ErrDuplicate
+		}
+		seen[sc][v.Key] = true
+		keys[sc] = append(keys[sc], v.Key)
+	}
+
+	for sc, names := range keys {
+		n, err := m.variables().CountDocuments(ctx, bson.M{
+			"projectID": sc.ProjectID,
+			"envID":     sc.EnvID,
+			"key":       bson.M{"$in": names},
+		})
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return ErrDuplicate
+		}
+	}
+	return nil
+}
+
+func (m *Mongo) FindVariable(ctx context.Context, f VariableFilter) (*Variable, error) {
+	q, err := variableQuery(f)
+	if err != nil {
+		return nil, err
+	}
+	var v Variable
+	if err := m.variables().FindOne(ctx, q).Decode(&v); err != nil {
+		return nil, notFound(err)
+	}
+	return &v, nil
+}
+
+func (m *Mongo) ListVariables(ctx context.Context, f VariableFilter) ([]*Variable, error) {
+	q, err := variableQuery(f)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := m.variables().Find(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	vars := []*Variable{}
+	if err := cursor.All(ctx, &vars); err != nil {
+		return nil, err
+	}
+	return vars, nil
+}
+
+func (m *Mongo) UpdateVariable(ctx context.Context, f VariableFilter, upd VariableUpdate) error {
+	q, err := variableQuery(f)
+	if err != nil {
+		return err
+	}
+
+	set := bson.M{"value": upd.Value}
+	if upd.Key != "" {
+		set["key"] = upd.Key
+	}
+	if upd.Version != 0 {
+		set["version"] = upd.Version
+	}
+
+	res, err := m.variables().UpdateOne(ctx, q, bson.M{"$set": set})
+	if err != nil {
+		return duplicate(err)
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m *Mongo) DeleteVariable(ctx context.Context, f VariableFilter) error {
+	q, err := variableQuery(f)
+	if err != nil {
+		return err
+	}
+	var v Variable
+	if err := m.variables().FindOneAndDelete(ctx, q).Decode(&v); err != nil {
+		return notFound(err)
+	}
+	_, err = m.versions().DeleteMany(ctx, bson.M{"variableID": v.ID})
+	return err
+}
+
+func (m *Mongo) ScanVariables(ctx context.Context, afterID string, limit int) ([]*Variable, error) {
+	return scan[Variable](ctx, m.variables(), afterID, limit)
+}
+
+func (m *Mongo) CountVariables(ctx context.Context) (int64, error) {
+	return m.variables().CountDocuments(ctx, bson.M{})
+}
+
+// password resets
+
+func (m *Mongo) CreatePasswordReset(ctx context.Context, r *PasswordReset) error {
+	_, err := m.passwordResets().InsertOne(ctx, r)
+	return err
+}
+
+func (m *Mongo) ConsumePasswordReset(ctx context.Context, token string) (*PasswordReset, error) {
+	var r PasswordReset
+	if err := m.passwordResets().FindOneAndDelete(ctx, bson.M{"token": token}).Decode(&r); err != nil {
+		return nil, notFound(err)
+	}
+	return &r, nil
+}
+
+func (m *Mongo) DeletePasswordResets(ctx context.Context, email string) error {
+	_, err := m.passwordResets().DeleteMany(ctx, bson.M{"email": email})
+	return err
+}
+
+// jobs
+
+func (m *Mongo) GetJob(ctx context.Context, id string) (*Job, error) {
+	var j Job
+	if err := m.jobs().FindOne(ctx, bson.M{"_id": id}).Decode(&j); err != nil {
+		return nil, notFound(err)
+	}
+	return &j, nil
+}
+
+func (m *Mongo) SaveJob(ctx context.Context, j *Job) error {
+	_, err := m.jobs().ReplaceOne(ctx, bson.M{"_id": j.ID}, j, options.Replace().SetUpsert(true))
+	return err
+}
+
+// projects
+
+func (m *Mongo) CreateProject(ctx context.Context, p *Project) error {
+	oid := primitive.NewObjectID()
+	_, err := m.projects().InsertOne(ctx, bson.M{
+		"_id":         oid,
+		"ownerID":     p.OwnerID,
+		"name":        p.Name,
+		"description": p.Description,
+		"createdAt":   p.CreatedAt,
+	})
+	if err != nil {
+		return duplicate(err)
+	}
+	p.ID = oid.Hex()
+	return nil
+}
+
+func (m *Mongo) GetProject(ctx context.Context, ownerID, name string) (*Project, error) {
+	var p Project
+	if err := m.projects().FindOne(ctx, bson.M{"ownerID": ownerID, "name": name}).Decode(&p); err != nil {
+		return nil, notFound(err)
+	}
+	return &p, nil
+}
+
+func (m *Mongo) ListProjects(ctx context.Context, ownerID string) ([]*Project, error) {
+	cursor, err := m.projects().Find(ctx, bson.M{"ownerID": ownerID}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	projects := []*Project{}
+	if err := cursor.All(ctx, &projects); err != nil {
+		return nil, err
+	}
+	return projects, nil
+}
+
+func (m *Mongo) UpdateProject(ctx context.Context, id string, upd ProjectUpdate) error {
+	oid, err := objectID(id)
+	if err != nil {
+		return err
+	}
+
+	set := bson.M{}
+	if upd.Name != "" {
+		set["name"] = upd.Name
+	}
+	if upd.Description != nil {
+		set["description"] = *upd.Description
+	}
+	if len(set) == 0 {
+		return nil
+	}
+
+	res, err := m.projects().UpdateOne(ctx, bson.M{"_id": oid}, bson.M{"$set": set})
+	if err != nil {
+		return duplicate(err)
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteProject removes the project along with its environments and
+// variables. Children go first so an interrupted delete can be retried.
+func (m *Mongo) DeleteProject(ctx context.Context, id string) error {
+	oid, err := objectID(id)
+	if err != nil {
+		return err
+	}
+	if _, err := m.versions().DeleteMany(ctx, bson.M{"projectID": id}); err != nil {
+		return err
+	}
+	if _, err := m.variables().DeleteMany(ctx, bson.M{"projectID": id}); err != nil {
+		return err
+	}
+	if _, err := m.environments().DeleteMany(ctx, bson.M{"projectID": id}); err != nil {
+		return err
+	}
+	res, err := m.projects().DeleteOne(ctx, bson.M{"_id": oid})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// environments
+
+func (m *Mongo) CreateEnvironment(ctx context.Context, e *Environment) error {
+	oid := primitive.NewObjectID()
+	_, err := m.environments().InsertOne(ctx, bson.M{
+		"_id":       oid,
+		"projectID": e.ProjectID,
+		"name":      e.Name,
+		"createdAt": e.CreatedAt,
+	})
+	if err != nil {
+		return duplicate(err)
+	}
+	e.ID = oid.Hex()
+	return nil
+}
+
+func (m *Mongo) GetEnvironment(ctx context.Context, projectID, name string) (*Environment, error) {
+	var e Environment
+	if err := m.environments().FindOne(ctx, bson.M{"projectID": projectID, "name": name}).Decode(&e); err != nil {
+		return nil, notFound(err)
+	}
+	return &e, nil
+}
+
+func (m *Mongo) ListEnvironments(ctx context.Context, projectID string) ([]*Environment, error) {
+	cursor, err := m.environments().Find(ctx, bson.M{"projectID": projectID}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	envs := []*Environment{}
+	if err := cursor.All(ctx, &envs); err != nil {
+		return nil, err
+	}
+	return envs, nil
+}
+
+func (m *Mongo) RenameEnvironment(ctx context.Context, id, name string) error {
+	oid, err := objectID(id)
+	if err != nil {
+		return err
+	}
+	res, err := m.environments().UpdateOne(ctx, bson.M{"_id": oid}, bson.M{"$set": bson.M{"name": name}})
+	if err != nil {
+		return duplicate(err)
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m *Mongo) DeleteEnvironment(ctx context.Context, id string) error {
+	oid, err := objectID(id)
+	if err != nil {
+		return err
+	}
+	if _, err := m.versions().DeleteMany(ctx, bson.M{"envID": id}); err != nil {
+		return err
+	}
+	if _, err := m.variables().DeleteMany(ctx, bson.M{"envID": id}); err != nil {
+		return err
+	}
+	res, err := m.environments().DeleteOne(ctx, bson.M{"_id": oid})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// versions
+
+func (m *Mongo) CreateVersions(ctx context.Context, versions ...*VariableVersion) error {
+	if len(versions) == 0 {
+		return nil
+	}
+
+	docs := make([]interface{}, 0, len(versions))
+	for _, v := range versions {
+		oid := primitive.NewObjectID()
+		doc := bson.M{
+			"_id":        oid,
+			"variableID": v.VariableID,
+			"version":    v.Version,
+			"userID":     v.UserID,
+			"key":        v.Key,
+			"value":      v.Value,
+			"authorID":   v.AuthorID,
+			"createdAt":  v.CreatedAt,
+		}
+		if v.ProjectID != "" {
+			doc["projectID"] = v.ProjectID
+			doc["envID"] = v.EnvID
+		}
+		if v.Note != "" {
+			doc["note"] = v.Note
+		}
+		docs = append(docs, doc)
+		v.ID = oid.Hex()
+	}
+
+	_, err := m.versions().InsertMany(ctx, docs)
+	return duplicate(err)
+}
+
+func (m *Mongo) ListVersions(ctx context.Context, variableID string) ([]*VariableVersion, error) {
+	opts := options.Find().SetSort(bson.M{"version": -1})
+	cursor, err := m.versions().Find(ctx, bson.M{"variableID": variableID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	versions := []*VariableVersion{}
+	if err := cursor.All(ctx, &versions); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+func (m *Mongo) GetVersion(ctx context.Context, variableID string, version int) (*VariableVersion, error) {
+	var v VariableVersion
+	err := m.versions().FindOne(ctx, bson.M{"variableID": variableID, "version": version}).Decode(&v)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &v, nil
+}
+
+func (m *Mongo) PruneVersions(ctx context.Context, variableID string, keep int) (int, error) {
+	if keep < 1 {
+		keep = 1
+	}
+	// Find the oldest version worth keeping, then drop everything below it
+	opts := options.FindOne().SetSort(bson.M{"version": -1}).SetSkip(int64(keep - 1))
+	var oldest VariableVersion
+	err := m.versions().FindOne(ctx, bson.M{"variableID": variableID}, opts).Decode(&oldest)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := m.versions().DeleteMany(ctx, bson.M{
+		"variableID": variableID,
+		"version":    bson.M{"$lt": oldest.Version},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(res.DeletedCount), nil
+}
+
+// audit
+
+func (m *Mongo) AppendAudit(ctx context.Context, e *AuditEvent) error {
+	oid := primitive.NewObjectID()
+	_, err := m.audit().InsertOne(ctx, bson.M{
+		"_id":       oid,
+		"tenant":    e.Tenant,
+		"seq":       e.Seq,
+		"prevHash":  e.PrevHash,
+		"hash":      e.Hash,
+		"actorID":   e.ActorID,
+		"ownerID":   e.OwnerID,
+		"action":    e.Action,
+		"resource":  e.Resource,
+		"ip":        e.IP,
+		"userAgent": e.UserAgent,
+		"outcome":   e.Outcome,
+		"status":    e.Status,
+		"detail":    e.Detail,
+		"createdAt": e.CreatedAt,
+	})
+	if err != nil {
+		return duplicate(err)
+	}
+	e.ID = oid.Hex()
+	return nil
+}
+
+func (m *Mongo) ListAudit(ctx context.Context, f AuditFilter) ([]*AuditEvent, error) {
+	q := bson.M{}
+	if f.Subject != "" {
+		q["$or"] = bson.A{bson.M{"actorID": f.Subject}, bson.M{"ownerID": bson.M{"$in": append([]string{f.Subject}, f.Owners...)}}}
+	}
+	if f.ActorID != "" {
+		q["actorID"] = f.ActorID
+	}
+	if f.Action != "" {
+		q["action"] = f.Action
+	}
+	if f.Outcome != "" {
+		q["outcome"] = f.Outcome
+	}
+	if f.Resource != "" {
+		q["resource"] = bson.M{"$regex": "^" + regexp.QuoteMeta(f.Resource)}
+	}
+
+	created := bson.M{}
+	if !f.Since.IsZero() {
+		created["$gte"] = f.Since
+	}
+	if !f.Until.IsZero() {
+		created["$lt"] = f.Until
+	}
+	if len(created) > 0 {
+		q["createdAt"] = created
+	}
+
+	if f.Before != "" {
+		oid, err := primitive.ObjectIDFromHex(f.Before)
+		if err != nil {
+			return nil, fmt.Errorf("store: invalid cursor %q", f.Before)
+		}
+		q["_id"] = bson.M{"$lt": oid}
+	}
+
+	opts := options.Find().SetSort(bson.M{"_id": -1})
+	if f.Limit > 0 {
+		opts.SetLimit(int64(f.Limit))
+	}
+	cursor, err := m.audit().Find(ctx, q, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	events := []*AuditEvent{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (m *Mongo) LastAudit(ctx context.Context, tenant string) (*AuditEvent, error) {
+	opts := options.FindOne().SetSort(bson.M{"seq": -1})
+	var e AuditEvent
+	err := m.audit().FindOne(ctx, bson.M{"tenant": tenant, "seq": bson.M{"$gt": 0}}, opts).Decode(&e)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &e, nil
+}
+
+func (m *Mongo) ScanAudit(ctx context.Context, tenant string, afterSeq int64, limit int) ([]*AuditEvent, error) {
+	opts := options.Find().SetSort(bson.M{"seq": 1}).SetLimit(int64(limit))
+	cursor, err := m.audit().Find(ctx, bson.M{"tenant": tenant, "seq": bson.M{"$gt": afterSeq}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	events := []*AuditEvent{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (m *Mongo) AuditTenants(ctx context.Context) ([]string, error) {
+	values, err := m.audit().Distinct(ctx, "tenant", bson.M{"seq": bson.M{"$gt": 0}})
+	if err != nil {
+		return nil, err
+	}
+
+	tenants := make([]string, 0, len(values))
+	for _, v := range values {
+		if t, ok := v.(string); ok {
+			tenants = append(tenants, t)
+		}
+	}
+	sort.Strings(tenants)
+	return tenants, nil
+}
+
+func (m *Mongo) SaveAuditCheckpoint(ctx context.Context, cp *AuditCheckpoint) error {
+	oid := primitive.NewObjectID()
+	_, err := m.checkpoints().InsertOne(ctx, bson.M{
+		"_id":       oid,
+		"tenant":    cp.Tenant,
+		"seq":       cp.Seq,
+		"hash":      cp.Hash,
+		"keyID":     cp.KeyID,
+		"signature": cp.Signature,
+		"createdAt": cp.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	cp.ID = oid.Hex()
+	return nil
+}
+
+func (m *Mongo) ListAuditCheckpoints(ctx context.Context, tenant string) ([]*AuditCheckpoint, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "seq", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := m.checkpoints().Find(ctx, bson.M{"tenant": tenant}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	checkpoints := []*AuditCheckpoint{}
+	if err := cursor.All(ctx, &checkpoints); err != nil {
+		return nil, err
+	}
+	return checkpoints, nil
+}
+
+// shares
+
+func (m *Mongo) CreateShare(ctx context.Context, sh *Share) error {
+	oid := primitive.NewObjectID()
+	_, err := m.shares().InsertOne(ctx, bson.M{
+		"_id":        oid,
+		"tokenHash":  sh.TokenHash,
+		"ownerID":    sh.OwnerID,
+		"variableID": sh.VariableID,
+		"key":        sh.Key,
+		"maxViews":   sh.MaxViews,
+		"views":      sh.Views,
+		"revoked":    sh.Revoked,
+		"oneTime":    sh.OneTime,
+		"ciphertext": sh.Ciphertext,
+		"mfa":        sh.MFA,
+		"expiresAt":  sh.ExpiresAt,
+		"createdAt":  sh.CreatedAt,
+
+		"recipients":     sh.Recipients,
+		"passphraseHash": sh.PassphraseHash,
+		"maxAttempts":    sh.MaxAttempts,
+		"failedAttempts": sh.FailedAttempts,
+	})
+	if err != nil {
+		return duplicate(err)
+	}
+	sh.ID = oid.Hex()
+	return nil
+}
+
+func (m *Mongo) GetShareByToken(ctx context.Context, tokenHash string) (*Share, error) {
+	var sh Share
+	if err := m.shares().FindOne(ctx, bson.M{"tokenHash": tokenHash}).Decode(&sh); err != nil {
+		return nil, notFound(err)
+	}
+	return &sh, nil
+}
+
+func (m *Mongo) ListShares(ctx context.Context, ownerID string) ([]*Share, error) {
+	cursor, err := m.shares().Find(ctx, bson.M{"ownerID": ownerID}, options.Find().SetSort(bson.M{"_id": -1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	shares := []*Share{}
+	if err := cursor.All(ctx, &shares); err != nil {
+		return nil, err
+	}
+	return shares, nil
+}
+
+func (m *Mongo) RevokeShare(ctx context.Context, ownerID, id string) error {
+	oid, err := objectID(id)
+	if err != nil {
+		return err
+	}
+	res, err := m.shares().UpdateOne(ctx,
+		bson.M{"_id": oid, "ownerID": ownerID},
+		bson.M{"$set": bson.M{"revoked": true, "ciphertext": ""}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m *Mongo) RevokeShareCopies(ctx context.Context, variableID string) error {
+	_, err := m.shares().UpdateMany(ctx,
+		bson.M{"variableID": variableID, "oneTime": bson.M{"$ne": true}, "ciphertext": bson.M{"$nin": bson.A{"", nil}}},
+		bson.M{"$set": bson.M{"revoked": true, "ciphertext": ""}},
+	)
+	return err
+}
+
+// activeShare matches the share with id oid if Share.Active holds, so a
+// check and the update that follows it are one atomic step.
+func activeShare(oid primitive.ObjectID, now time.Time) bson.M {
+	return bson.M{
+		"_id":       oid,
+		"revoked":   false,
+		"expiresAt": bson.M{"$gt": now},
+		"$expr": bson.M{"$and": bson.A{
+			bson.M{"$or": bson.A{
+				bson.M{"$eq": bson.A{"$maxViews", 0}},
+				bson.M{"$lt": bson.A{"$views", "$maxViews"}},
+			}},
+			bson.M{"$or": bson.A{
+				bson.M{"$eq": bson.A{bson.M{"$ifNull": bson.A{"$maxAttempts", 0}}, 0}},
+				bson.M{"$lt": bson.A{"$failedAttempts", "$maxAttempts"}},
+			}},
+		}},
+	}
+}
+
+func (m *Mongo) UseShare(ctx context.Context, id string, now time.Time) (*Share, error) {
+	oid, err := objectID(id)
+	if err != nil {
+		return nil, err
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var sh Share
+	if err := m.shares().FindOneAndUpdate(ctx, activeShare(oid, now), bson.M{"$inc": bson.M{"views": 1}}, opts).Decode(&sh); err != nil {
+		return nil, notFound(err)
+	}
+	return &sh, nil
+}
+
+func (m *Mongo) BurnShare(ctx context.Context, id string, now time.Time) (*Share, error) {
+	oid, err := objectID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	q := activeShare(oid, now)
+	q["oneTime"] = true
+	q["ciphertext"] = bson.M{"$gt": ""}
+	update := bson.M{
+		"$set": bson.M{"ciphertext": ""},
+		"$inc": bson.M{"views": 1},
+	}
+
+	// Return the document as it was, the ciphertext is gone afterwards
+	var sh Share
+	if err := m.shares().FindOneAndUpdate(ctx, q, update).Decode(&sh); err != nil {
+		return nil, notFound(err)
+	}
+	sh.Views++
+	return &sh, nil
+}
+
+func (m *Mongo) ClaimShareAttempt(ctx context.Context, id string, now time.Time) (*Share, error) {
+	oid, err := objectID(id)
+	if err != nil {
+		return nil, err
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var sh Share
+	if err := m.shares().FindOneAndUpdate(ctx, activeShare(oid, now), bson.M{"$inc": bson.M{"failedAttempts": 1}}, opts).Decode(&sh); err != nil {
+		return nil, notFound(err)
+	}
+	return &sh, nil
+}
+
+func (m *Mongo) RefundShareAttempt(ctx context.Context, id string) error {
+	oid, err := objectID(id)
+	if err != nil {
+		return err
+	}
+	_, err = m.shares().UpdateOne(ctx,
+		bson.M{"_id": oid, "failedAttempts": bson.M{"$gt": 0}},
+		bson.M{"$inc": bson.M{"failedAttempts": -1}},
+	)
+	return err
+}
+
+// sessions
+
+func (m *Mongo) CreateSession(ctx context.Context, s *Session) error {
+	oid := primitive.NewObjectID()
+	_, err := m.sessions().InsertOne(ctx, bson.M{
+		"_id":         oid,
+		"userID":      s.UserID,
+		"refreshHash": s.RefreshHash,
+		"userAgent":   s.UserAgent,
+		"ip":          s.IP,
+		"revoked":     s.Revoked,
+		"mfa":         s.MFA,
+		"createdAt":   s.CreatedAt,
+		"lastUsedAt":  s.LastUsedAt,
+		"expiresAt":   s.ExpiresAt,
+	})
+	if err != nil {
+		return err
+	}
+	s.ID = oid.Hex()
+	return nil
+}
+
+func (m *Mongo) GetSession(ctx context.Context, id string) (*Session, error) {
+	oid, err := objectID(id)
+	if err != nil {
+		return nil, err
+	}
+	var s Session
+	if err := m.sessions().FindOne(ctx, bson.M{"_id": oid}).Decode(&s); err != nil {
+		return nil, notFound(err)
+	}
+	return &s, nil
+}
+
+func (m *Mongo) ListSessions(ctx context.Context, userID string) ([]*Session, error) {
+	cursor, err := m.sessions().Find(ctx, bson.M{"userID": userID}, options.Find().SetSort(bson.M{"_id": -1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	sessions := []*Session{}
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (m *Mongo) RotateSession(ctx context.Context, id, oldHash, newHash, ip, userAgent string, now time.Time) (*Session, error) {
+	oid, err := objectID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	q := bson.M{"_id": oid, "refreshHash": oldHash, "revoked": false, "expiresAt": bson.M{"$gt": now}}
+	update := bson.M{"$set": bson.M{
+		"refreshHash":     newHash,
+		"prevRefreshHash": oldHash,
+		"ip":              ip,
+		"userAgent":       userAgent,
+		"lastUsedAt":      now,
+	}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var s Session
+	if err := m.sessions().FindOneAndUpdate(ctx, q, update, opts).Decode(&s); err != nil {
+		return nil, notFound(err)
+	}
+	return &s, nil
+}
+
+func (m *Mongo) TouchSession(ctx context.Context, id, ip string, now time.Time) error {
+	oid, err := objectID(id)
+	if err != nil {
+		return err
+	}
+	_, err = m.sessions().UpdateOne(ctx, bson.M{"_id": oid}, bson.M{"$set": bson.M{"ip": ip, "lastUsedAt": now}})
+	return err
+}
+
+func (m *Mongo) RevokeSession(ctx context.Context, userID, id string) error {
+	oid, err := objectID(id)
+	if err != nil {
+		return err
+	}
+	res, err := m.sessions().UpdateOne(ctx,
+		bson.M{"_id": oid, "userID": userID},
+		bson.M{"$set": bson.M{"revoked": true}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m *Mongo) RevokeSessions(ctx context.Context, userID string) error {
+	_, err := m.sessions().UpdateMany(ctx, bson.M{"userID": userID}, bson.M{"$set": bson.M{"revoked": true}})
+	return err
+}
+
+// API tokens
+
+func (m *Mongo) CreateAPIToken(ctx context.Context, t *APIToken) error {
+	oid := primitive.NewObjectID()
+	_, err := m.apiTokens().InsertOne(ctx, bson.M{
+		"_id":         oid,
+		"userID":      t.UserID,
+		"name":        t.Name,
+		"tokenHash":   t.TokenHash,
+		"prefix":      t.Prefix,
+		"access":      t.Access,
+		"projectIDs":  t.ProjectIDs,
+		"keyPrefixes": t.KeyPrefixes,
+		"revoked":     t.Revoked,
+		"expiresAt":   t.ExpiresAt,
+		"createdAt":   t.CreatedAt,
+	})
+	if err != nil {
+		return duplicate(err)
+	}
+	t.ID = oid.Hex()
+	return nil
+}
+
+func (m *Mongo) GetAPITokenByHash(ctx context.Context, tokenHash string) (*APIToken, error) {
+	var t APIToken
+	if err := m.apiTokens().FindOne(ctx, bson.M{"tokenHash": tokenHash}).Decode(&t); err != nil {
+		return nil, notFound(err)
+	}
+	return &t, nil
+}
+
+func (m *Mongo) ListAPITokens(ctx context.Context, userID string) ([]*APIToken, error) {
+	cursor, err := m.apiTokens().Find(ctx, bson.M{"userID": userID}, options.Find().SetSort(bson.M{"_id": -1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	tokens := []*APIToken{}
+	if err := cursor.All(ctx, &tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (m *Mongo) RevokeAPIToken(ctx context.Context, userID, id string) error {
+	oid, err := objectID(id)
+	if err != nil {
+		return err
+	}
+	res, err := m.apiTokens().UpdateOne(ctx,
+		bson.M{"_id": oid, "userID": userID},
+		bson.M{"$set": bson.M{"revoked": true}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m *Mongo) TouchAPIToken(ctx context.Context, id, ip string, now time.Time) error {
+	oid, err := objectID(id)
+	if err != nil {
+		return err
+	}
+	_, err = m.apiTokens().UpdateOne(ctx, bson.M{"_id": oid}, bson.M{"$set": bson.M{"lastUsedIP": ip, "lastUsedAt": now}})
+	return err
+}
+
+func (m *Mongo) AddPasskey(ctx context.Context, userID string, p *Passkey) error {
+	oid, err := objectID(userID)
+	if err != nil {
+		return err
+	}
+	res, err := m.users().UpdateOne(ctx, bson.M{"_id": oid}, bson.M{"$push": bson.M{"passkeys": p}})
+	if err != nil {
+		return duplicate(err)
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m *Mongo) GetUserByPasskey(ctx context.Context, credentialID string) (*User, error) {
+	var u User
+	if err := m.users().FindOne(ctx, bson.M{"passkeys.id": credentialID}).Decode(&u); err != nil {
+		return nil, notFound(err)
+	}
+	return &u, nil
+}
+
+func (m *Mongo) UpdatePasskeySignCount(ctx context.Context, userID, credentialID string, from, to uint32, now time.Time) error {
+	oid, err := objectID(userID)
+	if err != nil {
+		return err
+	}
+	q := bson.M{"_id": oid, "passkeys": bson.M{"$elemMatch": bson.M{"id": credentialID, "signCount": from}}}
+	update := bson.M{"$set": bson.M{"passkeys.$.signCount": to, "passkeys.$.lastUsedAt": now}}
+
+	res, err := m.users().UpdateOne(ctx, q, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m *Mongo) DeletePasskey(ctx context.Context, userID, credentialID string) error {
+	oid, err := objectID(userID)
+	if err != nil {
+		return err
+	}
+	res, err := m.users().UpdateOne(ctx,
+		bson.M{"_id": oid, "passkeys.id": credentialID},
+		bson.M{"$pull": bson.M{"passkeys": bson.M{"id": credentialID}}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m *Mongo) CreateWebAuthnChallenge(ctx context.Context, ch *WebAuthnChallenge) error {
+	oid := primitive.NewObjectID()
+	_, err := m.challenges().InsertOne(ctx, bson.M{
+		"_id":       oid,
+		"userID":    ch.UserID,
+		"purpose":   ch.Purpose,
+		"challenge": ch.Challenge,
+		"expiresAt": ch.ExpiresAt,
+	})
+	if err != nil {
+		return err
+	}
+	ch.ID = oid.Hex()
+	return nil
+}
+
+func (m *Mongo) ConsumeWebAuthnChallenge(ctx context.Context, id string) (*WebAuthnChallenge, error) {
+	oid, err := objectID(id)
+	if err != nil {
+		return nil, err
+	}
+	var ch WebAuthnChallenge
+	if err := m.challenges().FindOneAndDelete(ctx, bson.M{"_id": oid}).Decode(&ch); err != nil {
+		return nil, notFound(err)
+	}
+	return &ch, nil
+}
+
+func (m *Mongo) GetUserByIdentity(ctx context.Context, issuer, subject string) (*User, error) {
+	q := bson.M{"identities": bson.M{"$elemMatch": bson.M{"issuer": issuer, "subject": subject}}}
+	var u User
+	if err := m.users().FindOne(ctx, q).Decode(&u); err != nil {
+		return nil, notFound(err)
+	}
+	return &u, nil
+}
+
+func (m *Mongo) LinkIdentity(ctx context.Context, userID string, id Identity) error {
+	oid, err := objectID(userID)
+	if err != nil {
+		return err
+	}
+	res, err := m.users().UpdateOne(ctx, bson.M{"_id": oid}, bson.M{"$addToSet": bson.M{"identities": id}})
+	if err != nil {
+		return duplicate(err)
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m *Mongo) CreateOIDCLogin(ctx context.Context, l *OIDCLogin) error {
+	oid := primitive.NewObjectID()
+	_, err := m.oidcLogins().InsertOne(ctx, bson.M{
+		"_id":       oid,
+		"stateHash": l.StateHash,
+		"nonce":     l.Nonce,
+		"verifier":  l.Verifier,
+		"userID":    l.UserID,
+		"expiresAt": l.ExpiresAt,
+	})
+	if err != nil {
+		return err
+	}
+	l.ID = oid.Hex()
+	return nil
+}
+
+func (m *Mongo) ConsumeOIDCLogin(ctx context.Context, stateHash string) (*OIDCLogin, error) {
+	var l OIDCLogin
+	if err := m.oidcLogins().FindOneAndDelete(ctx, bson.M{"stateHash": stateHash}).Decode(&l); err != nil {
+		return nil, notFound(err)
+	}
+	return &l, nil
+}
+
+// organizations
+
+func (m *Mongo) CreateOrganization(ctx context.Context, o *Organization) error {
+	oid := primitive.NewObjectID()
+	_, err := m.organizations().InsertOne(ctx, bson.M{
+		"_id":       oid,
+		"name":      o.Name,
+		"createdAt": o.CreatedAt,
+	})
+	if err != nil {
+		return duplicate(err)
+	}
+	o.ID = oid.Hex()
+	return nil
+}
+
+func (m *Mongo) GetOrganization(ctx context.Context, name string) (*Organization, error) {
+	var o Organization
+	if err := m.organizations().FindOne(ctx, bson.M{"name": name}).Decode(&o); err != nil {
+		return nil, notFound(err)
+	}
+	return &o, nil
+}
+
+func (m *Mongo) GetOrganizationByID(ctx context.Context, id string) (*Organization, error) {
+	oid, err := objectID(id)
+	if err != nil {
+		return nil, err
+	}
+	var o Organization
+	if err := m.organizations().FindOne(ctx, bson.M{"_id": oid}).Decode(&o); err != nil {
+		return nil, notFound(err)
+	}
+	return &o, nil
+}
+
+func (m *Mongo) SetOrganizationDataKey(ctx context.Context, orgID, wrapped, expected string) error {
+	oid, err := objectID(orgID)
+	if err != nil {
+		return err
+	}
+
+	q := bson.M{"_id": oid, "dataKey": expected}
+	if expected == "" {
+		q["dataKey"] = bson.M{"$in": bson.A{"", nil}}
+	}
+
+	res, err := m.organizations().UpdateOne(ctx, q, bson.M{"$set": bson.M{"dataKey": wrapped}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m *Mongo) SetOrganizationRequireMFA(ctx context.Context, orgID string, require bool) error {
+	oid, err := objectID(orgID)
+	if err != nil {
+		return err
+	}
+
+	res, err := m.organizations().UpdateOne(ctx, bson.M{"_id": oid}, bson.M{"$set": bson.M{"requireMFA": require}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m *Mongo) ScanOrganizations(ctx context.Context, afterID string, limit int) ([]*Organization, error) {
+	return scan[Organization](ctx, m.organizations(), afterID, limit)
+}
+
+func (m *Mongo) CountOrganizations(ctx context.Context) (int64, error) {
+	return m.organizations().CountDocuments(ctx, bson.M{})
+}
+
+func (m *Mongo) AddMember(ctx context.Context, mem *Member) error {
+	oid := primitive.NewObjectID()
+	_, err := m.members().InsertOne(ctx, bson.M{
+		"_id":       oid,
+		"orgID":     mem.OrgID,
+		"userID":    mem.UserID,
+		"role":      mem.Role,
+		"createdAt": mem.CreatedAt,
+	})
+	if err != nil {
+		return duplicate(err)
+	}
+	mem.ID = oid.Hex()
+	return nil
+}
+
+func (m *Mongo) GetMember(ctx context.Context, orgID, userID string) (*Member, error) {
+	var mem Member
+	if err := m.members().FindOne(ctx, bson.M{"orgID": orgID, "userID": userID}).Decode(&mem); err != nil {
+		return nil, notFound(err)
+	}
+	return &mem, nil
+}
+
+func (m *Mongo) findMembers(ctx context.Context, q bson.M) ([]*Member, error) {
+	cursor, err := m.members().Find(ctx, q, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	members := []*Member{}
+	if err := cursor.All(ctx, &members); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+func (m *Mongo) ListMembers(ctx context.Context, orgID string) ([]*Member, error) {
+	return m.findMembers(ctx, bson.M{"orgID": orgID})
+}
+
+func (m *Mongo) ListMemberships(ctx context.Context, userID string) ([]*Member, error) {
+	return m.findMembers(ctx, bson.M{"userID": userID})
+}
+
+func (m *Mongo) SetMemberRole(ctx context.Context, orgID, userID, role string) error {
+	res, err := m.members().UpdateOne(ctx,
+		bson.M{"orgID": orgID, "userID": userID},
+		bson.M{"$set": bson.M{"role": role}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m *Mongo) RemoveMember(ctx context.Context, orgID, userID string) error {
+	res, err := m.members().DeleteOne(ctx, bson.M{"orgID": orgID, "userID": userID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	_, err = m.teams().UpdateMany(ctx, bson.M{"orgID": orgID}, bson.M{"$pull": bson.M{"memberIDs": userID}})
+	return err
+}
+
+// teams
+
+func (m *Mongo) CreateTeam(ctx context.Context, t *Team) error {
+	oid := primitive.NewObjectID()
+	if t.MemberIDs == nil {
+		t.MemberIDs = []string{}
+	}
+	if t.Grants == nil {
+		t.Grants = map[string]string{}
+	}
+	_, err := m.teams().InsertOne(ctx, bson.M{
+		"_id":       oid,
+		"orgID":     t.OrgID,
+		"name":      t.Name,
+		"memberIDs": t.MemberIDs,
+		"grants":    t.Grants,
+		"createdAt": t.CreatedAt,
+	})
+	if err != nil {
+		return duplicate(err)
+	}
+	t.ID = oid.Hex()
+	return nil
+}
+
+func (m *Mongo) GetTeam(ctx context.Context, orgID, name string) (*Team, error) {
+	var t Team
+	if err := m.teams().FindOne(ctx, bson.M{"orgID": orgID, "name": name}).Decode(&t); err != nil {
+		return nil, notFound(err)
+	}
+	return &t, nil
+}
+
+func (m *Mongo) findTeams(ctx context.Context, q bson.M) ([]*Team, error) {
+	cursor, err := m.teams().Find(ctx, q, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	teams := []*Team{}
+	if err := cursor.All(ctx, &teams); err != nil {
+		return nil, err
+	}
+	return teams, nil
+}
+
+func (m *Mongo) ListTeams(ctx context.Context, orgID string) ([]*Team, error) {
+	return m.findTeams(ctx, bson.M{"orgID": orgID})
+}
+
+func (m *Mongo) ListUserTeams(ctx context.Context, orgID, userID string) ([]*Team, error) {
+	return m.findTeams(ctx, bson.M{"orgID": orgID, "memberIDs": userID})
+}
+
+func (m *Mongo) updateTeam(ctx context.Context, teamID string, update bson.M) error {
+	oid, err := objectID(teamID)
+	if err != nil {
+		return err
+	}
+	res, err := m.teams().UpdateOne(ctx, bson.M{"_id": oid}, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m *Mongo) AddTeamMember(ctx context.Context, teamID, userID string) error {
+	return m.updateTeam(ctx, teamID, bson.M{"$addToSet": bson.M{"memberIDs": userID}})
+}
+
+func (m *Mongo) RemoveTeamMember(ctx context.Context, teamID, userID string) error {
+	return m.updateTeam(ctx, teamID, bson.M{"$pull": bson.M{"memberIDs": userID}})
+}
+
+func (m *Mongo) SetTeamGrant(ctx context.Context, teamID, projectID, role string) error {
+	// Project ids are hex, so they are safe to use as field names
+	field := "grants." + projectID
+	if role == "" {
+		return m.updateTeam(ctx, teamID, bson.M{"$unset": bson.M{field: ""}})
+	}
+	return m.updateTeam(ctx, teamID, bson.M{"$set": bson.M{field: role}})
+}
+
+func (m *Mongo) DeleteTeam(ctx context.Context, id string) error {
+	oid, err := objectID(id)
+	if err != nil {
+		return err
+	}
+	res, err := m.teams().DeleteOne(ctx, bson.M{"_id": oid})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// policies
+
+func (m *Mongo) CreatePolicy(ctx context.Context, p *Policy) error {
+	oid := primitive.NewObjectID()
+	_, err := m.policies().InsertOne(ctx, bson.M{
+		"_id":       oid,
+		"orgID":     p.OrgID,
+		"name":      p.Name,
+		"document":  p.Document,
+		"createdAt": p.CreatedAt,
+		"updatedAt": p.UpdatedAt,
+	})
+	if err != nil {
+		return duplicate(err)
+	}
+	p.ID = oid.Hex()
+	return nil
+}
+
+func (m *Mongo) GetPolicy(ctx context.Context, orgID, name string) (*Policy, error) {
+	var p Policy
+	if err := m.policies().FindOne(ctx, bson.M{"orgID": orgID, "name": name}).Decode(&p); err != nil {
+		return nil, notFound(err)
+	}
+	return &p, nil
+}
+
+func (m *Mongo) ListPolicies(ctx context.Context, orgID string) ([]*Policy, error) {
+	cursor, err := m.policies().Find(ctx, bson.M{"orgID": orgID}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	policies := []*Policy{}
+	if err := cursor.All(ctx, &policies); err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
+
+func (m *Mongo) UpdatePolicy(ctx context.Context, id, document string, updatedAt time.Time) error {
+	oid, err := objectID(id)
+	if err != nil {
+		return err
+	}
+	res, err := m.policies().UpdateOne(ctx, bson.M{"_id": oid}, bson.M{"$set": bson.M{"document": document, "updatedAt": updatedAt}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m *Mongo) DeletePolicy(ctx context.Context, id string) error {
+	oid, err := objectID(id)
+	if err != nil {
+		return err
+	}
+	res, err := m.policies().DeleteOne(ctx, bson.M{"_id": oid})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// invites
+
+func (m *Mongo) CreateInvite(ctx context.Context, inv *Invite) error {
+	oid := primitive.NewObjectID()
+	_, err := m.invites().InsertOne(ctx, bson.M{
+		"_id":       oid,
+		"orgID":     inv.OrgID,
+		"email":     inv.Email,
+		"role":      inv.Role,
+		"invitedBy": inv.InvitedBy,
+		"tokenHash": inv.TokenHash,
+		"expiresAt": inv.ExpiresAt,
+		"createdAt": inv.CreatedAt,
+	})
+	if err != nil {
+		return duplicate(err)
+	}
+	inv.ID = oid.Hex()
+	return nil
+}
+
+func (m *Mongo) GetInvite(ctx context.Context, id string) (*Invite, error) {
+	oid, err := objectID(id)
+	if err != nil {
+		return nil, err
+	}
+	var inv Invite
+	if err := m.invites().FindOne(ctx, bson.M{"_id": oid}).Decode(&inv); err != nil {
+		return nil, notFound(err)
+	}
+	return &inv, nil
+}
+
+func (m *Mongo) GetInviteByToken(ctx context.Context, tokenHash string) (*Invite, error) {
+	var inv Invite
+	if err := m.invites().FindOne(ctx, bson.M{"tokenHash": tokenHash}).Decode(&inv); err != nil {
+		return nil, notFound(err)
+	}
+	return &inv, nil
+}
+
+func (m *Mongo) ListInvites(ctx context.Context, orgID string) ([]*Invite, error) {
+	cursor, err := m.invites().Find(ctx, bson.M{"orgID": orgID}, options.Find().SetSort(bson.M{"_id": -1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	invites := []*Invite{}
+	if err := cursor.All(ctx, &invites); err != nil {
+		return nil, err
+	}
+	return invites, nil
+}
+
+func (m *Mongo) RenewInvite(ctx context.Context, id, tokenHash string, expiresAt time.Time) error {
+	oid, err := objectID(id)
+	if err != nil {
+		return err
+	}
+	res, err := m.invites().UpdateOne(ctx, bson.M{"_id": oid}, bson.M{"$set": bson.M{"tokenHash": tokenHash, "expiresAt": expiresAt}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m *Mongo) ConsumeInvite(ctx context.Context, id, tokenHash string) (*Invite, error) {
+	oid, err := objectID(id)
+	if err != nil {
+		return nil, err
+	}
+	var inv Invite
+	if err := m.invites().FindOneAndDelete(ctx, bson.M{"_id": oid, "tokenHash": tokenHash}).Decode(&inv); err != nil {
+		return nil, notFound(err)
+	}
+	return &inv, nil
+}
+
+func (m *Mongo) DeleteInvite(ctx context.Context, id string) error {
+	oid, err := objectID(id)
+	if err != nil {
+		return err
+	}
+	res, err := m.invites().DeleteOne(ctx, bson.M{"_id": oid})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Identity links a user to an account at an OpenID provider.
+type Identity struct {
+	Issuer  string `bson:"issuer" json:"issuer"`
+	Subject string `bson:"subject" json:"subject"`
+}
+
+// HasIdentity reports whether the user is linked to the provider account.
+func (u *User) HasIdentity(issuer, subject string) bool {
+	for _, id := range u.Identities {
+		if id.Issuer == issuer && id.Subject == subject {
+			return true
+		}
+	}
+	return false
+}
+
+// OIDCLogin is a single sign-on login waiting for the provider to send
+// the user back. It is looked up by a hash of the state parameter.
+type OIDCLogin struct {
+	ID        string    `bson:"_id,omitempty" json:"id"`
+	StateHash string    `bson:"stateHash" json:"stateHash"`
+	Nonce     string    `bson:"nonce" json:"nonce"`
+	Verifier  string    `bson:"verifier" json:"verifier"`                 // PKCE code verifier
+	UserID    string    `bson:"userID,omitempty" json:"userID,omitempty"` // set when linking to a logged-in user
+	ExpiresAt time.Time `bson:"expiresAt" json:"expiresAt"`
+}
+
+// OIDCStore persists provider identities and pending SSO logins.
+type OIDCStore interface {
+	GetUserByIdentity(ctx context.Context, issuer, subject string) (*User, error)
+	// LinkIdentity returns ErrDuplicate if another user already has it.
+	LinkIdentity(ctx context.Context, userID string, id Identity) error
+
+	CreateOIDCLogin(ctx context.Context, l *OIDCLogin) error
+	// ConsumeOIDCLogin removes and returns a pending login, so each state
+	// can be used once.
+	ConsumeOIDCLogin(ctx context.Context, stateHash string) (*OIDCLogin, error)
+}
@@ -0,0 +1,91 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Organization roles, from most to least privileged.
+const (
+	RoleOwner     = "owner"
+	RoleAdmin     = "admin"
+	RoleDeveloper = "developer"
+	RoleViewer    = "viewer"
+)
+
+// ValidRole reports whether role is one of the organization roles.
+func ValidRole(role string) bool {
+	switch role {
+	case RoleOwner, RoleAdmin, RoleDeveloper, RoleViewer:
+		return true
+	}
+	return false
+}
+
+// Organization owns projects shared by its members. Its projects have the
+// organization's id as OwnerID and their variables are sealed with the
+// organization's data key.
+type Organization struct {
+	ID      string `bson:"_id,omitempty" json:"id"`
+	Name    string `bson:"name" json:"name"`
+	DataKey string `bson:"dataKey,omitempty" json:"dataKey,omitempty"` // DEK wrapped by a master key
+	// RequireMFA refuses members who didn't log in with a second factor.
+	RequireMFA bool      `bson:"requireMFA" json:"requireMFA"`
+	CreatedAt  time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// Member gives a user a role across an organization.
+type Member struct {
+	ID        string    `bson:"_id,omitempty" json:"id"`
+	OrgID     string    `bson:"orgID" json:"orgID"`
+	UserID    string    `bson:"userID" json:"userID"`
+	Role      string    `bson:"role" json:"role"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// Team is a group of members that can be given a higher role on some of
+// the organization's projects; its name is unique per organization.
+type Team struct {
+	ID        string            `bson:"_id,omitempty" json:"id"`
+	OrgID     string            `bson:"orgID" json:"orgID"`
+	Name      string            `bson:"name" json:"name"`
+	MemberIDs []string          `bson:"memberIDs" json:"memberIDs"`
+	Grants    map[string]string `bson:"grants" json:"grants"` // project id -> role
+	CreatedAt time.Time         `bson:"createdAt" json:"createdAt"`
+}
+
+// OrgStore persists organizations, their members and teams.
+type OrgStore interface {
+	// CreateOrganization returns ErrDuplicate if the name is taken.
+	CreateOrganization(ctx context.Context, o *Organization) error
+	GetOrganization(ctx context.Context, name string) (*Organization, error)
+	GetOrganizationByID(ctx context.Context, id string) (*Organization, error)
+	// SetOrganizationDataKey works like SetUserDataKey.
+	SetOrganizationDataKey(ctx context.Context, orgID, wrapped, expected string) error
+	SetOrganizationRequireMFA(ctx context.Context, orgID string, require bool) error
+	ScanOrganizations(ctx context.Context, afterID string, limit int) ([]*Organization, error)
+	CountOrganizations(ctx context.Context) (int64, error)
+
+	// AddMember returns ErrDuplicate if the user is already a member.
+	AddMember(ctx context.Context, m *Member) error
+	GetMember(ctx context.Context, orgID, userID string) (*Member, error)
+	ListMembers(ctx context.Context, orgID string) ([]*Member, error)
+	// ListMemberships returns every organization membership of a user.
+	ListMemberships(ctx context.Context, userID string) ([]*Member, error)
+	SetMemberRole(ctx context.Context, orgID, userID, role string) error
+	// RemoveMember also takes the user out of the organization's teams.
+	RemoveMember(ctx context.Context, orgID, userID string) error
+
+	// CreateTeam returns ErrDuplicate if the organization has a team by that name.
+	CreateTeam(ctx context.Context, t *Team) error
+	GetTeam(ctx context.Context, orgID, name string) (*Team, error)
+	ListTeams(ctx context.Context, orgID string) ([]*Team, error)
+	// ListUserTeams returns the organization's teams the user is in.
+	ListUserTeams(ctx context.Context, orgID, userID string) ([]*Team, error)
+	AddTeamMember(ctx context.Context, teamID, userID string) error
+	RemoveTeamMember(ctx context.Context, teamID, userID string) error
+	// SetTeamGrant gives the team a role on a project; an empty role
+	// removes the grant.
+	SetTeamGrant(ctx context.Context, teamID, projectID, role string) error
+	DeleteTeam(ctx context.Context, id string) error
+}
@@ -0,0 +1,61 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Passkey is a WebAuthn credential registered by a user. Passkeys live on
+// the user document.
+type Passkey struct {
+	ID         string    `bson:"id" json:"id"` // base64url credential id
+	Name       string    `bson:"name" json:"name"`
+	PublicKey  []byte    `bson:"publicKey" json:"publicKey"` // COSE_Key
+	SignCount  uint32    `bson:"signCount" json:"signCount"`
+	AAGUID     []byte    `bson:"aaguid,omitempty" json:"aaguid,omitempty"` // authenticator model, if it says
+	Transports []string  `bson:"transports,omitempty" json:"transports,omitempty"`
+	CreatedAt  time.Time `bson:"createdAt" json:"createdAt"`
+	LastUsedAt time.Time `bson:"lastUsedAt,omitempty" json:"lastUsedAt,omitempty"`
+}
+
+// Passkey returns the user's passkey with the credential id, or nil.
+func (u *User) Passkey(id string) *Passkey {
+	for i := range u.Passkeys {
+		if u.Passkeys[i].ID == id {
+			return &u.Passkeys[i]
+		}
+	}
+	return nil
+}
+
+// WebAuthn ceremony purposes
+const (
+	ChallengeRegister = "register"
+	ChallengeLogin    = "login"
+)
+
+// WebAuthnChallenge is an outstanding registration or login ceremony.
+type WebAuthnChallenge struct {
+	ID        string    `bson:"_id,omitempty" json:"id"`
+	UserID    string    `bson:"userID,omitempty" json:"userID,omitempty"` // empty for a login that starts without an email
+	Purpose   string    `bson:"purpose" json:"purpose"`
+	Challenge []byte    `bson:"challenge" json:"challenge"`
+	ExpiresAt time.Time `bson:"expiresAt" json:"expiresAt"`
+}
+
+// PasskeyStore persists passkeys and the challenges used to register and
+// log in with them.
+type PasskeyStore interface {
+	// AddPasskey returns ErrDuplicate if the credential is already registered.
+	AddPasskey(ctx context.Context, userID string, p *Passkey) error
+	GetUserByPasskey(ctx context.Context, credentialID string) (*User, error)
+	// UpdatePasskeySignCount records a login, but only while the stored
+	// sign count is still from. Otherwise it returns ErrNotFound.
+	UpdatePasskeySignCount(ctx context.Context, userID, credentialID string, from, to uint32, now time.Time) error
+	DeletePasskey(ctx context.Context, userID, credentialID string) error
+
+	CreateWebAuthnChallenge(ctx context.Context, ch *WebAuthnChallenge) error
+	// ConsumeWebAuthnChallenge removes and returns a challenge, so each
+	// one can be answered only once.
+	ConsumeWebAuthnChallenge(ctx context.Context, id string) (*WebAuthnChallenge, error)
+}
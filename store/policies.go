@@ -0,0 +1,29 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Policy is an organization's access policy. Document holds the YAML
+// source as written; the server parses it when evaluating requests.
+type Policy struct {
+	ID        string    `bson:"_id,omitempty" json:"id"`
+	OrgID     string    `bson:"orgID" json:"orgID"`
+	Name      string    `bson:"name" json:"name"`
+	Document  string    `bson:"document" json:"document"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+// PolicyStore persists access policies.
+type PolicyStore interface {
+	// CreatePolicy returns ErrDuplicate if the organization has a policy by
+	// that name.
+	CreatePolicy(ctx context.Context, p *Policy) error
+	GetPolicy(ctx context.Context, orgID, name string) (*Policy, error)
+	// ListPolicies returns the organization's policies sorted by name.
+	ListPolicies(ctx context.Context, orgID string) ([]*Policy, error)
+	UpdatePolicy(ctx context.Context, id, document string, updatedAt time.Time) error
+	DeletePolicy(ctx context.Context, id string) error
+}
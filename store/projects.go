@@ -0,0 +1,53 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Project groups environments; its name is unique per owner.
+type Project struct {
+	ID          string    `bson:"_id,omitempty" json:"id"`
+	OwnerID     string    `bson:"ownerID" json:"ownerID"` // a user, or an organization
+	Name        string    `bson:"name" json:"name"`
+	Description string    `bson:"description" json:"description"`
+	CreatedAt   time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// Environment is a namespace for variables inside a project, e.g. "dev" or
+// "prod"; its name is unique per project.
+type Environment struct {
+	ID        string    `bson:"_id,omitempty" json:"id"`
+	ProjectID string    `bson:"projectID" json:"projectID"`
+	Name      string    `bson:"name" json:"name"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// Scope identifies the environment a variable lives in. The zero Scope is
+// the unscoped namespace used by the original flat /api/v1 routes.
+type Scope struct {
+	ProjectID string
+	EnvID     string
+}
+
+// ProjectUpdate holds the fields changed by UpdateProject; empty fields are kept.
+type ProjectUpdate struct {
+	Name        string
+	Description *string
+}
+
+// ProjectStore persists projects and their environments. Deleting a project
+// or environment also deletes everything inside it.
+type ProjectStore interface {
+	CreateProject(ctx context.Context, p *Project) error
+	GetProject(ctx context.Context, ownerID, name string) (*Project, error)
+	ListProjects(ctx context.Context, ownerID string) ([]*Project, error)
+	UpdateProject(ctx context.Context, id string, upd ProjectUpdate) error
+	DeleteProject(ctx context.Context, id string) error
+
+	CreateEnvironment(ctx context.Context, e *Environment) error
+	GetEnvironment(ctx context.Context, projectID, name string) (*Environment, error)
+	ListEnvironments(ctx context.Context, projectID string) ([]*Environment, error)
+	RenameEnvironment(ctx context.Context, id, name string) error
+	DeleteEnvironment(ctx context.Context, id string) error
+}
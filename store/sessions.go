@@ -0,0 +1,46 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Session is one login on one device. The client holds a refresh token
+// naming the session; only a hash of its secret part is stored, and it
+// changes on every refresh. The previous hash is kept so a replayed old
+// token can be recognised as stolen.
+type Session struct {
+	ID              string    `bson:"_id,omitempty" json:"id"`
+	UserID          string    `bson:"userID" json:"userID"`
+	RefreshHash     string    `bson:"refreshHash" json:"refreshHash"`
+	PrevRefreshHash string    `bson:"prevRefreshHash,omitempty" json:"prevRefreshHash,omitempty"`
+	UserAgent       string    `bson:"userAgent" json:"userAgent"`
+	IP              string    `bson:"ip" json:"ip"`
+	Revoked         bool      `bson:"revoked" json:"revoked"`
+	MFA             bool      `bson:"mfa" json:"mfa"` // opened with a second factor
+	CreatedAt       time.Time `bson:"createdAt" json:"createdAt"`
+	LastUsedAt      time.Time `bson:"lastUsedAt" json:"lastUsedAt"`
+	ExpiresAt       time.Time `bson:"expiresAt" json:"expiresAt"`
+}
+
+// Active reports whether the session can still be used at now.
+func (s *Session) Active(now time.Time) bool {
+	return !s.Revoked && now.Before(s.ExpiresAt)
+}
+
+// SessionStore persists login sessions.
+type SessionStore interface {
+	CreateSession(ctx context.Context, s *Session) error
+	GetSession(ctx context.Context, id string) (*Session, error)
+	// ListSessions returns the user's sessions, newest first.
+	ListSessions(ctx context.Context, userID string) ([]*Session, error)
+	// RotateSession replaces the refresh hash with newHash and records the
+	// use, but only while oldHash is still current and the session active.
+	// Otherwise it returns ErrNotFound, so a token can only be spent once.
+	RotateSession(ctx context.Context, id, oldHash, newHash, ip, userAgent string, now time.Time) (*Session, error)
+	// TouchSession records that the session was used.
+	TouchSession(ctx context.Context, id, ip string, now time.Time) error
+	RevokeSession(ctx context.Context, userID, id string) error
+	// RevokeSessions ends every session of the user.
+	RevokeSessions(ctx context.Context, userID string) error
+}
@@ -0,0 +1,79 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Share is a link handing out one variable's value. Only a hash of its
+// token is stored, so the database alone can't be used to open shares.
+//
+// One-time shares instead carry a copy of the value encrypted under a key
+// that only exists in the link's URL fragment. The copy is wiped by the
+// first retrieval; the rest of the record stays so later visits can be told
+// the secret was already viewed.
+//
+// A share can also be limited to Recipients (emails or user ids) and
+// protected by a passphrase, stored as an Argon2id hash. MaxAttempts wrong
+// passphrases lock it for good. Passphrase shares keep their own copy of
+// the value, sealed under the passphrase, as it was when shared.
+type Share struct {
+	ID         string `bson:"_id,omitempty" json:"id"`
+	TokenHash  string `bson:"tokenHash" json:"tokenHash"`
+	OwnerID    string `bson:"ownerID" json:"ownerID"`
+	VariableID string `bson:"variableID" json:"variableID"`
+	Key        string `bson:"key" json:"key"`
+	MaxViews   int    `bson:"maxViews" json:"maxViews"` // 0 means unlimited
+	Views      int    `bson:"views" json:"views"`
+	Revoked    bool   `bson:"revoked" json:"revoked"`
+	OneTime    bool   `bson:"oneTime,omitempty" json:"oneTime,omitempty"`
+	Ciphertext string `bson:"ciphertext,omitempty" json:"ciphertext,omitempty"`
+	MFA        bool   `bson:"mfa" json:"mfa"` // created from a session opened with a second factor
+
+	Recipients     []string `bson:"recipients,omitempty" json:"recipients,omitempty"`
+	PassphraseHash string   `bson:"passphraseHash,omitempty" json:"passphraseHash,omitempty"`
+	MaxAttempts    int      `bson:"maxAttempts,omitempty" json:"maxAttempts,omitempty"`
+	FailedAttempts int      `bson:"failedAttempts" json:"failedAttempts"`
+
+	ExpiresAt time.Time `bson:"expiresAt" json:"expiresAt"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// Active reports whether the share can still be opened at now.
+func (sh *Share) Active(now time.Time) bool {
+	return !sh.Revoked && !sh.Locked() && now.Before(sh.ExpiresAt) && (sh.MaxViews == 0 || sh.Views < sh.MaxViews)
+}
+
+// Locked reports whether the share has used up its passphrase attempts.
+func (sh *Share) Locked() bool {
+	return sh.MaxAttempts > 0 && sh.FailedAttempts >= sh.MaxAttempts
+}
+
+// ShareStore persists share links.
+type ShareStore interface {
+	CreateShare(ctx context.Context, sh *Share) error
+	GetShareByToken(ctx context.Context, tokenHash string) (*Share, error)
+	// ListShares returns the owner's shares, newest first.
+	ListShares(ctx context.Context, ownerID string) ([]*Share, error)
+	RevokeShare(ctx context.Context, ownerID, id string) error
+	// RevokeShareCopies revokes the variable's shares that carry a copy of
+	// its value, so they don't hand out one that has since been replaced.
+	// One-time shares are left alone; their copy is what was meant to be sent.
+	RevokeShareCopies(ctx context.Context, variableID string) error
+	// UseShare counts a view of an active share and returns it updated. It
+	// returns ErrNotFound if the share is missing or no longer active, so
+	// concurrent opens can never exceed MaxViews.
+	UseShare(ctx context.Context, id string, now time.Time) (*Share, error)
+	// BurnShare opens an active one-time share: it counts the view and wipes
+	// the ciphertext in one atomic step and returns the share as it was
+	// before, ciphertext included. Like UseShare it returns ErrNotFound if
+	// the share can't be opened, which includes it having been opened.
+	BurnShare(ctx context.Context, id string, now time.Time) (*Share, error)
+	// ClaimShareAttempt counts a passphrase attempt on an active share
+	// before the passphrase is checked, so parallel guesses can't get past
+	// MaxAttempts, and returns the share updated. It returns ErrNotFound if
+	// the share is no longer active, locked included.
+	ClaimShareAttempt(ctx context.Context, id string, now time.Time) (*Share, error)
+	// RefundShareAttempt takes back a claimed attempt whose passphrase was right.
+	RefundShareAttempt(ctx context.Context, id string) error
+}
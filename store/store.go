@@ -0,0 +1,186 @@
+// Package store defines the persistence layer used by the SafeEnv API.
+//
+// Handlers never talk to a database directly; they receive a Store and the
+// concrete backend (MongoDB or an embedded BoltDB file) is picked from config.
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when a lookup matches no record.
+	ErrNotFound = errors.New("store: not found")
+	// ErrDuplicate is returned when a write would break a uniqueness rule.
+	ErrDuplicate = errors.New("store: already exists")
+)
+
+// User is a registered SafeEnv account.
+type User struct {
+	ID           string    `bson:"_id,omitempty" json:"id"`
+	Username     string    `bson:"username" json:"username"`
+	Email        string    `bson:"email" json:"email"`
+	PasswordHash string    `bson:"passwordHash" json:"passwordHash"`
+	DataKey      string    `bson:"dataKey,omitempty" json:"dataKey,omitempty"` // DEK wrapped by a master key
+	MFA          UserMFA   `bson:"mfa" json:"mfa"`
+	Passkeys     []Passkey `bson:"passkeys,omitempty" json:"passkeys,omitempty"`
+	CreatedAt    time.Time `bson:"createdAt" json:"createdAt"`
+	// Identities are the single sign-on accounts the user logs in with.
+	Identities []Identity `bson:"identities,omitempty" json:"identities,omitempty"`
+}
+
+// NormalizeEmail is the form emails are stored and looked up in, so an
+// address matches however it was typed.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Variable is a single encrypted environment variable owned by a user, or
+// by an organization for variables in its projects. Variables inside an
+// environment are unique per (project, env, key).
+type Variable struct {
+	ID        string    `bson:"_id,omitempty" json:"_id"`
+	UserID    string    `bson:"userID" json:"userID"` // owner, the project's OwnerID for scoped variables
+	ProjectID string    `bson:"projectID,omitempty" json:"projectID,omitempty"`
+	EnvID     string    `bson:"envID,omitempty" json:"envID,omitempty"`
+	Key       string    `bson:"key" json:"key"`
+	Value     string    `bson:"value" json:"value"`
+	Version   int       `bson:"version,omitempty" json:"version,omitempty"` // 0 for variables written before history existed
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// VariableFilter narrows variable lookups. Empty fields are ignored.
+type VariableFilter struct {
+	ID     string
+	UserID string
+	Key    string
+	Value  string // exact stored ciphertext, used for compare-and-swap updates
+	// Scope, when set, restricts matches to exactly that environment
+	// (the zero Scope matches only unscoped variables).
+	Scope *Scope
+}
+
+// Scope returns the environment v belongs to.
+func (v *Variable) Scope() Scope {
+	return Scope{ProjectID: v.ProjectID, EnvID: v.EnvID}
+}
+
+// VariableUpdate holds the fields changed by UpdateVariable.
+type VariableUpdate struct {
+	Key     string
+	Value   string
+	Version int // new current version, 0 keeps it
+}
+
+// PasswordReset is a pending password reset token.
+type PasswordReset struct {
+	Email     string    `bson:"email" json:"email"`
+	Token     string    `bson:"token" json:"token"` // hash of the reset token's nonce
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+	ExpiresAt time.Time `bson:"expiresAt" json:"expiresAt"`
+	Used      bool      `bson:"used" json:"used"`
+}
+
+// Job tracks a long running maintenance task so it can report progress and
+// resume after an interruption.
+type Job struct {
+	ID        string    `bson:"_id" json:"id"`
+	Status    string    `bson:"status" json:"status"` // running, completed or failed
+	Target    string    `bson:"target" json:"target"` // e.g. the key id being rotated to
+	Phase     string    `bson:"phase" json:"phase"`   // collection currently being walked
+	Cursor    string    `bson:"cursor" json:"cursor"` // last record id processed in Phase
+	Total     int64     `bson:"total" json:"total"`
+	Processed int64     `bson:"processed" json:"processed"`
+	Changed   int64     `bson:"changed" json:"changed"`
+	Error     string    `bson:"error,omitempty" json:"error,omitempty"`
+	StartedAt time.Time `bson:"startedAt" json:"startedAt"`
+	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+// Job statuses.
+const (
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+)
+
+// Store is implemented by every storage backend.
+type Store interface {
+	ProjectStore
+	VersionStore
+	AuditStore
+	ShareStore
+	SessionStore
+	APITokenStore
+	MFAStore
+	PasskeyStore
+	OIDCStore
+	OrgStore
+	PolicyStore
+	InviteStore
+
+	// CreateUser returns ErrDuplicate if the email is taken or one of the
+	// user's identities is already linked to someone else.
+	CreateUser(ctx context.Context, u *User) error
+	GetUserByID(ctx context.Context, id string) (*User, error)
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	UpdateUserPassword(ctx context.Context, email, passwordHash string) error
+	// SetUserDataKey replaces the user's wrapped data key only if it still
+	// equals expected ("" for a user without one). It returns ErrNotFound
+	// when the user is missing or the key was changed concurrently.
+	SetUserDataKey(ctx context.Context, userID, wrapped, expected string) error
+	ScanUsers(ctx context.Context, afterID string, limit int) ([]*User, error)
+	CountUsers(ctx context.Context) (int64, error)
+
+	// CreateVariables returns ErrDuplicate if a scoped key already exists.
+	CreateVariables(ctx context.Context, vars ...*Variable) error
+	FindVariable(ctx context.Context, f VariableFilter) (*Variable, error)
+	ListVariables(ctx context.Context, f VariableFilter) ([]*Variable, error)
+	UpdateVariable(ctx context.Context, f VariableFilter, upd VariableUpdate) error
+	// DeleteVariable also deletes the variable's version history.
+	DeleteVariable(ctx context.Context, f VariableFilter) error
+	// ScanVariables returns up to limit variables with ids greater than
+	// afterID, ordered by id, for batch jobs that walk the whole collection.
+	ScanVariables(ctx context.Context, afterID string, limit int) ([]*Variable, error)
+	CountVariables(ctx context.Context) (int64, error)
+
+	CreatePasswordReset(ctx context.Context, r *PasswordReset) error
+	// ConsumePasswordReset removes and returns a pending reset, so each
+	// reset link can be used once.
+	ConsumePasswordReset(ctx context.Context, token string) (*PasswordReset, error)
+	DeletePasswordResets(ctx context.Context, email string) error
+
+	GetJob(ctx context.Context, id string) (*Job, error)
+	SaveJob(ctx context.Context, j *Job) error
+
+	Close(ctx context.Context) error
+}
+
+// Config selects and configures a backend.
+type Config struct {
+	Driver        string // "mongo" (default) or "bolt"
+	MongoURI      string
+	MongoDatabase string
+	BoltPath      string
+}
+
+// Open connects to the backend described by cfg.
+func Open(ctx context.Context, cfg Config) (Store, error) {
+	switch cfg.Driver {
+	case "", "mongo":
+		return OpenMongo(ctx, cfg.MongoURI, cfg.MongoDatabase)
+	case "bolt":
+		return OpenBolt(cfg.BoltPath)
+	default:
+		return nil, fmt.Errorf("store: unknown driver %q", cfg.Driver)
+	}
+}
+
+var (
+	_ Store = (*Mongo)(nil)
+	_ Store = (*Bolt)(nil)
+)
@@ -0,0 +1,356 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The same suite runs against every backend. Bolt always runs; MongoDB runs
+// when SAFEENV_TEST_MONGO_URI points at a server, each test in a database
+// of its own that is dropped afterwards.
+
+func TestBolt(t *testing.T) {
+	testStore(t, func(t *testing.T) Store {
+		b, err := OpenBolt(filepath.Join(t.TempDir(), "safeenv.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { b.Close(context.Background()) })
+		return b
+	})
+}
+
+func TestMongo(t *testing.T) {
+	uri := os.Getenv("SAFEENV_TEST_MONGO_URI")
+	if uri == "" {
+		t.Skip("SAFEENV_TEST_MONGO_URI not set")
+	}
+	testStore(t, func(t *testing.T) Store {
+		ctx := context.Background()
+		m, err := OpenMongo(ctx, uri, "safeenv_test_"+newID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			m.db.Drop(ctx)
+			m.Close(ctx)
+		})
+		return m
+	})
+}
+
+func testStore(t *testing.T, open func(t *testing.T) Store) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, st Store)
+	}{
+		{"Users", testUsers},
+		{"UpdateVariableCAS", testUpdateVariableCAS},
+		{"SetUserDataKeyCAS", testSetUserDataKeyCAS},
+		{"UseShare", testUseShare},
+		{"BurnShare", testBurnShare},
+		{"ClaimShareAttempt", testClaimShareAttempt},
+		{"RevokeShareCopies", testRevokeShareCopies},
+		{"AuditSequence", testAuditSequence},
+		{"ConsumePasswordReset", testConsumePasswordReset},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, open(t))
+		})
+	}
+}
+
+// race runs fn from n goroutines at once and returns how many succeeded,
+// failing the test on errors other than allowed.
+func race(t *testing.T, n int, allowed error, fn func() error) int {
+	t.Helper()
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		wins  int
+		start = make(chan struct{})
+		errs  []error
+	)
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			err := fn()
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				wins++
+			case !errors.Is(err, allowed):
+				errs = append(errs, err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	for _, err := range errs {
+		t.Error(err)
+	}
+	return wins
+}
+
+func testUsers(t *testing.T, st Store) {
+	ctx := context.Background()
+	u := &User{Username: "alice", Email: "alice@example.com", CreatedAt: time.Now()}
+	if err := st.CreateUser(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	if u.ID == "" {
+		t.Fatal("CreateUser didn't set an id")
+	}
+
+	err := st.CreateUser(ctx, &User{Username: "mallory", Email: "alice@example.com"})
+	if !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("second user with the same email: %v", err)
+	}
+
+	got, err := st.GetUserByEmail(ctx, " Alice@Example.com")
+	if err != nil || got.ID != u.ID {
+		t.Fatalf("lookup with another case: %+v %v", got, err)
+	}
+	if _, err := st.GetUserByEmail(ctx, "bob@example.com"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("unknown email: %v", err)
+	}
+	if _, err := st.GetUserByID(ctx, "not an id"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("malformed id: %v", err)
+	}
+}
+
+func testUpdateVariableCAS(t *testing.T, st Store) {
+	ctx := context.Background()
+	v := &Variable{UserID: newID(), Key: "DB_URL", Value: "v1", CreatedAt: time.Now()}
+	if err := st.CreateVariables(ctx, v); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only one of several writers that read v1 gets to replace it
+	wins := race(t, 8, ErrNotFound, func() error {
+		return st.UpdateVariable(ctx, VariableFilter{ID: v.ID, UserID: v.UserID, Value: "v1"}, VariableUpdate{Value: "v2", Version: 2})
+	})
+	if wins != 1 {
+		t.Fatalf("%d writers replaced the same value", wins)
+	}
+
+	got, err := st.FindVariable(ctx, VariableFilter{ID: v.ID})
+	if err != nil || got.Value != "v2" || got.Version != 2 {
+		t.Fatalf("after update: %+v %v", got, err)
+	}
+	err = st.UpdateVariable(ctx, VariableFilter{ID: v.ID, UserID: newID()}, VariableUpdate{Value: "v3"})
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("update by another owner: %v", err)
+	}
+}
+
+func testSetUserDataKeyCAS(t *testing.T, st Store) {
+	ctx := context.Background()
+	u := &User{Username: "alice", Email: "alice@example.com", CreatedAt: time.Now()}
+	if err := st.CreateUser(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+
+	wins := race(t, 8, ErrNotFound, func() error {
+		return st.SetUserDataKey(ctx, u.ID, "key-"+newID(), "")
+	})
+	if wins != 1 {
+		t.Fatalf("%d writers set the first data key", wins)
+	}
+
+	got, err := st.GetUserByID(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.SetUserDataKey(ctx, u.ID, "rewrapped", got.DataKey); err != nil {
+		t.Fatalf("rewrap: %v", err)
+	}
+	if err := st.SetUserDataKey(ctx, u.ID, "stale", got.DataKey); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("rewrap from a stale key: %v", err)
+	}
+}
+
+func newShare(t *testing.T, st Store, sh *Share) *Share {
+	t.Helper()
+	sh.TokenHash = newID()
+	sh.OwnerID = newID()
+	sh.Key = "DB_URL"
+	sh.ExpiresAt = time.Now().Add(time.Hour)
+	sh.CreatedAt = time.Now()
+	if err := st.CreateShare(context.Background(), sh); err != nil {
+		t.Fatal(err)
+	}
+	return sh
+}
+
+func testUseShare(t *testing.T, st Store) {
+	ctx := context.Background()
+	sh := newShare(t, st, &Share{MaxViews: 3})
+
+	if wins := race(t, 10, ErrNotFound, func() error {
+		_, err := st.UseShare(ctx, sh.ID, time.Now())
+		return err
+	}); wins != 3 {
+		t.Fatalf("share with 3 views opened %d times", wins)
+	}
+
+	expired := newShare(t, st, &Share{})
+	if _, err := st.UseShare(ctx, expired.ID, time.Now().Add(2*time.Hour)); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expired share: %v", err)
+	}
+}
+
+func testBurnShare(t *testing.T, st Store) {
+	ctx := context.Background()
+	sh := newShare(t, st, &Share{OneTime: true, MaxViews: 1, Ciphertext: "sealed"})
+
+	var (
+		mu     sync.Mutex
+		opened []*Share
+	)
+	wins := race(t, 10, ErrNotFound, func() error {
+		got, err := st.BurnShare(ctx, sh.ID, time.Now())
+		if err == nil {
+			mu.Lock()
+			opened = append(opened, got)
+			mu.Unlock()
+		}
+		return err
+	})
+	if wins != 1 {
+		t.Fatalf("one-time share opened %d times", wins)
+	}
+	if opened[0].Ciphertext != "sealed" {
+		t.Fatalf("burn returned ciphertext %q", opened[0].Ciphertext)
+	}
+
+	after, err := st.GetShareByToken(ctx, sh.TokenHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Ciphertext != "" || after.Views != 1 {
+		t.Fatalf("after burn: views %d, ciphertext %q", after.Views, after.Ciphertext)
+	}
+
+	plain := newShare(t, st, &Share{})
+	if _, err := st.BurnShare(ctx, plain.ID, time.Now()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("burning a share that isn't one-time: %v", err)
+	}
+}
+
+func testClaimShareAttempt(t *testing.T, st Store) {
+	ctx := context.Background()
+	sh := newShare(t, st, &Share{PassphraseHash: "hash", MaxAttempts: 3})
+
+	if wins := race(t, 10, ErrNotFound, func() error {
+		_, err := st.ClaimShareAttempt(ctx, sh.ID, time.Now())
+		return err
+	}); wins != 3 {
+		t.Fatalf("%d guesses allowed, want 3", wins)
+	}
+	if _, err := st.UseShare(ctx, sh.ID, time.Now()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("opening a locked share: %v", err)
+	}
+
+	// A right passphrase gives its attempt back
+	other := newShare(t, st, &Share{PassphraseHash: "hash", MaxAttempts: 1})
+	claimed, err := st.ClaimShareAttempt(ctx, other.ID, time.Now())
+	if err != nil || claimed.FailedAttempts != 1 {
+		t.Fatalf("claim: %+v %v", claimed, err)
+	}
+	if err := st.RefundShareAttempt(ctx, other.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.UseShare(ctx, other.ID, time.Now()); err != nil {
+		t.Fatalf("opening after a refund: %v", err)
+	}
+}
+
+func testRevokeShareCopies(t *testing.T, st Store) {
+	ctx := context.Background()
+	variableID := newID()
+	copied := newShare(t, st, &Share{VariableID: variableID, PassphraseHash: "hash", Ciphertext: "sealed"})
+	plain := newShare(t, st, &Share{VariableID: variableID})
+	oneTime := newShare(t, st, &Share{VariableID: variableID, OneTime: true, MaxViews: 1, Ciphertext: "sealed"})
+	other := newShare(t, st, &Share{VariableID: newID(), PassphraseHash: "hash", Ciphertext: "sealed"})
+
+	if err := st.RevokeShareCopies(ctx, variableID); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name    string
+		sh      *Share
+		revoked bool
+	}{
+		{"copy of the value", copied, true},
+		{"without a copy", plain, false},
+		{"one-time", oneTime, false},
+		{"other variable", other, false},
+	} {
+		got, err := st.GetShareByToken(ctx, tt.sh.TokenHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Revoked != tt.revoked || (tt.revoked && got.Ciphertext != "") {
+			t.Errorf("%s: revoked %v, ciphertext %q", tt.name, got.Revoked, got.Ciphertext)
+		}
+	}
+}
+
+func testAuditSequence(t *testing.T, st Store) {
+	ctx := context.Background()
+	if _, err := st.LastAudit(ctx, "alice"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("empty chain: %v", err)
+	}
+
+	// Concurrent writers claiming the same position: one wins
+	for seq := int64(1); seq <= 3; seq++ {
+		wins := race(t, 5, ErrDuplicate, func() error {
+			return st.AppendAudit(ctx, &AuditEvent{Tenant: "alice", Seq: seq, Hash: fmt.Sprint("hash-", seq), CreatedAt: time.Now()})
+		})
+		if wins != 1 {
+			t.Fatalf("seq %d taken %d times", seq, wins)
+		}
+	}
+	err := st.AppendAudit(ctx, &AuditEvent{Tenant: "bob", Seq: 1, CreatedAt: time.Now()})
+	if err != nil {
+		t.Fatalf("chains are per tenant: %v", err)
+	}
+
+	last, err := st.LastAudit(ctx, "alice")
+	if err != nil || last.Seq != 3 || last.Hash != "hash-3" {
+		t.Fatalf("last: %+v %v", last, err)
+	}
+	events, err := st.ScanAudit(ctx, "alice", 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Seq != 2 || events[1].Seq != 3 {
+		t.Fatalf("scan after seq 1: %+v", events)
+	}
+}
+
+func testConsumePasswordReset(t *testing.T, st Store) {
+	ctx := context.Background()
+	err := st.CreatePasswordReset(ctx, &PasswordReset{Email: "alice@example.com", Token: "token-hash", CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if wins := race(t, 5, ErrNotFound, func() error {
+		_, err := st.ConsumePasswordReset(ctx, "token-hash")
+		return err
+	}); wins != 1 {
+		t.Fatalf("reset consumed %d times", wins)
+	}
+}
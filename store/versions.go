@@ -0,0 +1,38 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// VariableVersion is an immutable snapshot of a variable's value, written
+// every time the variable is stored, updated or rolled back. It keeps the
+// owner, scope and key name the value was sealed under, so old versions stay
+// readable after the variable is renamed.
+type VariableVersion struct {
+	ID         string    `bson:"_id,omitempty" json:"id"`
+	VariableID string    `bson:"variableID" json:"variableID"`
+	Version    int       `bson:"version" json:"version"`
+	UserID     string    `bson:"userID" json:"userID"`
+	ProjectID  string    `bson:"projectID,omitempty" json:"projectID,omitempty"`
+	EnvID      string    `bson:"envID,omitempty" json:"envID,omitempty"`
+	Key        string    `bson:"key" json:"key"`
+	Value      string    `bson:"value" json:"value"`
+	AuthorID   string    `bson:"authorID" json:"authorID"`
+	Note       string    `bson:"note,omitempty" json:"note,omitempty"`
+	CreatedAt  time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// VersionStore persists variable history. Versions are never modified;
+// they are only removed by PruneVersions or when their variable, environment
+// or project is deleted.
+type VersionStore interface {
+	// CreateVersions returns ErrDuplicate if a version number is taken.
+	CreateVersions(ctx context.Context, versions ...*VariableVersion) error
+	// ListVersions returns a variable's versions, newest first.
+	ListVersions(ctx context.Context, variableID string) ([]*VariableVersion, error)
+	GetVersion(ctx context.Context, variableID string, version int) (*VariableVersion, error)
+	// PruneVersions deletes all but the newest keep versions of a variable
+	// and reports how many were removed. At least one version is kept.
+	PruneVersions(ctx context.Context, variableID string, keep int) (int, error)
+}
@@ -0,0 +1,151 @@
+package webauthn
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Just enough CBOR (RFC 8949) to read attestation objects and COSE keys.
+// Authenticators emit definite lengths only, so indefinite lengths, tags
+// and floats are rejected.
+
+const maxCBORDepth = 16
+
+var errCBOR = errors.New("webauthn: malformed CBOR")
+
+// decodeCBOR decodes the first item in data and returns it with the
+// number of bytes it took. Integers come back as int64, maps as
+// map[any]any keyed by int64 or string.
+func decodeCBOR(data []byte) (any, int, error) {
+	d := &cborDecoder{data: data}
+	v, err := d.item(0)
+	if err != nil {
+		return nil, 0, err
+	}
+	return v, d.pos, nil
+}
+
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *cborDecoder) next(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, errCBOR
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+// head reads an item's major type and its argument.
+func (d *cborDecoder) head() (byte, uint64, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, 0, err
+	}
+	major, info := b[0]>>5, b[0]&0x1f
+
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info <= 27:
+		arg, err := d.next(1 << (info - 24))
+		if err != nil {
+			return 0, 0, err
+		}
+		var n uint64
+		for _, c := range arg {
+			n = n<<8 | uint64(c)
+		}
+		return major, n, nil
+	default:
+		return 0, 0, fmt.Errorf("%w: unsupported length encoding", errCBOR)
+	}
+}
+
+func (d *cborDecoder) item(depth int) (any, error) {
+	if depth > maxCBORDepth {
+		return nil, fmt.Errorf("%w: nested too deeply", errCBOR)
+	}
+	major, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, fmt.Errorf("%w: integer overflow", errCBOR)
+		}
+		return int64(arg), nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, fmt.Errorf("%w: integer overflow", errCBOR)
+		}
+		return -1 - int64(arg), nil
+	case 2:
+		b, err := d.next(arg)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), b...), nil
+	case 3:
+		b, err := d.next(arg)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case 4:
+		// each item takes at least a byte, which also bounds the allocation
+		if arg > uint64(len(d.data)-d.pos) {
+			return nil, errCBOR
+		}
+		items := make([]any, 0, arg)
+		for range arg {
+			v, err := d.item(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v)
+		}
+		return items, nil
+	case 5:
+		if arg > uint64(len(d.data)-d.pos) {
+			return nil, errCBOR
+		}
+		m := make(map[any]any, arg)
+		for range arg {
+			k, err := d.item(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, fmt.Errorf("%w: unsupported map key", errCBOR)
+			}
+			if _, dup := m[k]; dup {
+				return nil, fmt.Errorf("%w: duplicate map key", errCBOR)
+			}
+			v, err := d.item(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = v
+		}
+		return m, nil
+	case 7:
+		switch arg {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22, 23:
+			return nil, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: unsupported item type %d", errCBOR, major)
+}
@@ -0,0 +1,116 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithms (RFC 9053) accepted for credentials, in order of preference.
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// Algorithms is what registration offers as pubKeyCredParams.
+var Algorithms = []int{AlgES256, AlgEdDSA, AlgRS256}
+
+// COSE key labels and values
+const (
+	coseKty = 1
+	coseAlg = 3
+	coseCrv = -1
+	coseX   = -2 // also the RSA modulus
+	coseY   = -3 // also the RSA exponent
+
+	ktyOKP = 1
+	ktyEC2 = 2
+	ktyRSA = 3
+
+	crvP256    = 1
+	crvEd25519 = 6
+)
+
+var errUnsupportedKey = errors.New("webauthn: unsupported public key")
+
+// PublicKey is a credential public key parsed from its COSE_Key encoding.
+type PublicKey struct {
+	Alg int
+	key crypto.PublicKey
+}
+
+// ParsePublicKey parses a COSE_Key, rejecting algorithms not in Algorithms.
+func ParsePublicKey(coseKey []byte) (*PublicKey, error) {
+	v, n, err := decodeCBOR(coseKey)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(map[any]any)
+	if !ok || n != len(coseKey) {
+		return nil, fmt.Errorf("%w: not a COSE key", errUnsupportedKey)
+	}
+
+	kty, _ := m[int64(coseKty)].(int64)
+	alg, _ := m[int64(coseAlg)].(int64)
+	crv, _ := m[int64(coseCrv)].(int64)
+	x, _ := m[int64(coseX)].([]byte)
+	y, _ := m[int64(coseY)].([]byte)
+
+	switch {
+	case alg == AlgES256 && kty == ktyEC2 && crv == crvP256:
+		if len(x) != 32 || len(y) != 32 {
+			return nil, errUnsupportedKey
+		}
+		// ecdh checks the point is on the curve
+		point := append(append([]byte{4}, x...), y...)
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, fmt.Errorf("%w: %v", errUnsupportedKey, err)
+		}
+		return &PublicKey{Alg: AlgES256, key: &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}}, nil
+
+	case alg == AlgEdDSA && kty == ktyOKP && crv == crvEd25519:
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errUnsupportedKey
+		}
+		return &PublicKey{Alg: AlgEdDSA, key: ed25519.PublicKey(x)}, nil
+
+	case alg == AlgRS256 && kty == ktyRSA:
+		n, e := new(big.Int).SetBytes(x), new(big.Int).SetBytes(y)
+		if n.BitLen() < 2048 || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errUnsupportedKey
+		}
+		return &PublicKey{Alg: AlgRS256, key: &rsa.PublicKey{N: n, E: int(e.Int64())}}, nil
+	}
+	return nil, fmt.Errorf("%w: kty %d alg %d", errUnsupportedKey, kty, alg)
+}
+
+// Verify checks sig over data.
+func (k *PublicKey) Verify(data, sig []byte) error {
+	ok := false
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		sum := sha256.Sum256(data)
+		ok = ecdsa.VerifyASN1(key, sum[:], sig)
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(key, data, sig)
+	case *rsa.PublicKey:
+		sum := sha256.Sum256(data)
+		ok = rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig) == nil
+	}
+	if !ok {
+		return ErrSignature
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+// Package webauthn verifies WebAuthn (FIDO2 / passkey) registration and
+// authentication ceremonies for a relying party.
+//
+// Only the checks a relying party needs are implemented: client data,
+// authenticator data, signatures and sign counters. Registration asks for
+// no attestation, so the authenticator's make and model aren't verified.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+const challengeSize = 32
+
+// Authenticator data flags
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+	flagExtensions   = 0x80
+)
+
+var (
+	// ErrSignature means an assertion or attestation signature didn't verify.
+	ErrSignature = errors.New("webauthn: invalid signature")
+	// ErrSignCount means an authenticator's counter didn't move forward,
+	// which suggests the credential was cloned.
+	ErrSignCount = errors.New("webauthn: sign count did not increase")
+)
+
+// Bytes is binary data that travels as unpadded base64url in JSON, the way
+// browsers' PublicKeyCredential.toJSON() encodes it.
+type Bytes []byte
+
+func (b Bytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *Bytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	// some clients pad, some don't
+	decoded, err := base64.RawURLEncoding.DecodeString(trimPadding(s))
+	if err != nil {
+		return fmt.Errorf("webauthn: invalid base64url: %w", err)
+	}
+	*b = decoded
+	return nil
+}
+
+func trimPadding(s string) string {
+	for len(s) > 0 && s[len(s)-1] == '=' {
+		s = s[:len(s)-1]
+	}
+	return s
+}
+
+// NewChallenge returns a random challenge for a ceremony.
+func NewChallenge() ([]byte, error) {
+	b := make([]byte, challengeSize)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// RelyingParty is the site credentials are scoped to.
+type RelyingParty struct {
+	ID      string   // domain, e.g. "safeenv.example.com"
+	Name    string   // shown by the authenticator
+	Origins []string // origins the browser may report, e.g. "https://safeenv.example.com"
+}
+
+// Credential is a newly registered credential.
+type Credential struct {
+	ID           []byte
+	PublicKey    []byte // COSE_Key
+	SignCount    uint32
+	AAGUID       []byte
+	UserVerified bool
+}
+
+// Assertion is the result of a successful authentication.
+type Assertion struct {
+	SignCount    uint32
+	UserVerified bool
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+type authenticatorData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	aaguid       []byte
+	credentialID []byte
+	publicKey    []byte
+}
+
+// VerifyRegistration checks the response to navigator.credentials.create()
+// against the challenge it was given and returns the new credential.
+func (rp *RelyingParty) VerifyRegistration(challenge, clientDataJSON, attestationObject []byte) (*Credential, error) {
+	if err := rp.checkClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	v, n, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return nil, err
+	}
+	att, ok := v.(map[any]any)
+	if !ok || n != len(attestationObject) {
+		return nil, errors.New("webauthn: malformed attestation object")
+	}
+	rawAuthData, _ := att["authData"].([]byte)
+
+	authData, err := rp.parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if authData.flags&flagAttested == 0 {
+		return nil, errors.New("webauthn: no credential in authenticator data")
+	}
+	if _, err := ParsePublicKey(authData.publicKey); err != nil {
+		return nil, err
+	}
+
+	return &Credential{
+		ID:           authData.credentialID,
+		PublicKey:    authData.publicKey,
+		SignCount:    authData.signCount,
+		AAGUID:       authData.aaguid,
+		UserVerified: authData.flags&flagUserVerified != 0,
+	}, nil
+}
+
+// VerifyAssertion checks the response to navigator.credentials.get() made
+// with a stored credential. storedCount is the last sign count seen; if
+// either it or the new count is non-zero the new one must be larger.
+func (rp *RelyingParty) VerifyAssertion(publicKey []byte, storedCount uint32, challenge, clientDataJSON, rawAuthData, signature []byte) (*Assertion, error) {
+	key, err := ParsePublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.checkClientData(clientDataJSON, "webauthn.get", challenge); err != nil {
+		return nil, err
+	}
+	authData, err := rp.parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	if err := key.Verify(append(slices.Clip(rawAuthData), clientDataHash[:]...), signature); err != nil {
+		return nil, err
+	}
+
+	// Synced passkeys always report 0, so a counter only means something
+	// once an authenticator has started using it
+	if (authData.signCount != 0 || storedCount != 0) && authData.signCount <= storedCount {
+		return nil, ErrSignCount
+	}
+
+	return &Assertion{
+		SignCount:    authData.signCount,
+		UserVerified: authData.flags&flagUserVerified != 0,
+	}, nil
+}
+
+func (rp *RelyingParty) checkClientData(raw []byte, typ string, challenge []byte) error {
+	var cd clientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return fmt.Errorf("webauthn: malformed client data: %w", err)
+	}
+	if cd.Type != typ {
+		return fmt.Errorf("webauthn: client data type %q, want %q", cd.Type, typ)
+	}
+
+	got, err := base64.RawURLEncoding.DecodeString(trimPadding(cd.Challenge))
+	if err != nil || subtle.ConstantTimeCompare(got, challenge) != 1 {
+		return errors.New("webauthn: challenge mismatch")
+	}
+	if !slices.Contains(rp.Origins, cd.Origin) {
+		return fmt.Errorf("webauthn: unexpected origin %q", cd.Origin)
+	}
+	return nil
+}
+
+// parseAuthenticatorData parses and checks authenticator data, see
+// https://www.w3.org/TR/webauthn-3/#sctn-authenticator-data
+func (rp *RelyingParty) parseAuthenticatorData(b []byte) (*authenticatorData, error) {
+	if len(b) < 37 {
+		return nil, errors.New("webauthn: authenticator data too short")
+	}
+	d := &authenticatorData{
+		rpIDHash:  b[:32],
+		flags:     b[32],
+		signCount: binary.BigEndian.Uint32(b[33:37]),
+	}
+
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(d.rpIDHash, rpIDHash[:]) {
+		return nil, errors.New("webauthn: credential is for a different relying party")
+	}
+	if d.flags&flagUserPresent == 0 {
+		return nil, errors.New("webauthn: user was not present")
+	}
+
+	rest := b[37:]
+	if d.flags&flagAttested != 0 {
+		if len(rest) < 18 {
+			return nil, errors.New("webauthn: attested credential data too short")
+		}
+		d.aaguid = rest[:16]
+		idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if idLen == 0 || idLen > 1023 || len(rest) < idLen {
+			return nil, errors.New("webauthn: bad credential id")
+		}
+		d.credentialID, rest = rest[:idLen], rest[idLen:]
+
+		// the key's length is only known by decoding it
+		_, n, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, err
+		}
+		d.publicKey, rest = rest[:n], rest[n:]
+	}
+	if d.flags&flagExtensions != 0 {
+		_, n, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, err
+		}
+		rest = rest[n:]
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("webauthn: trailing bytes in authenticator data")
+	}
+	return d, nil
+}